@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+// EncryptFile encrypts inputPath into outputPath with a raw key
+func EncryptFile(inputPath, outputPath string, key []byte) error {
+	c, err := cypher.NewCypherFromKey(key)
+	if err != nil {
+		return err
+	}
+	_, err = c.EncryptFileToPath(context.Background(), inputPath, outputPath)
+	return err
+}
+
+// DecryptFile decrypts inputPath into outputPath with a raw key
+func DecryptFile(inputPath, outputPath string, key []byte) error {
+	c, err := cypher.NewCypherFromKey(key)
+	if err != nil {
+		return err
+	}
+	_, err = c.DecryptFileToPath(context.Background(), inputPath, outputPath)
+	return err
+}
+
+// GenerateKey returns a new random raw key
+func GenerateKey() ([]byte, error) {
+	return cypher.GenerateKey()
+}
+
+// MD5Hash returns the hex encoded MD5 digest of a file
+func MD5Hash(path string) (string, error) {
+	return cypher.MD5HashFromFile(path)
+}
+
+// keyFileEnv names the key file used when no key flag is given, so
+// orchestrators can mount a secret without putting it in argv or the
+// environment
+const keyFileEnv = "GOCYPHER_KEY_FILE"
+
+// keyFlags are the flags shared by commands that need a key
+type keyFlags struct {
+	keyFile    string
+	keyFD      int
+	passphrase string
+	keyfile    string
+}
+
+func (k *keyFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&k.keyFile, "key-file", "", "file holding a hex encoded key created by keygen (default: $"+keyFileEnv+")")
+	fs.IntVar(&k.keyFD, "key-fd", -1, "file descriptor to read a hex encoded key from, such as 3")
+	fs.StringVar(&k.passphrase, "passphrase", "", "passphrase to derive the key from")
+	fs.StringVar(&k.keyfile, "passphrase-keyfile", "", "keyfile required together with -passphrase, any file of at least 32 bytes")
+}
+
+func (k *keyFlags) cypher() (*cypher.Cypher, error) {
+	if k.keyFile == "" && k.keyFD < 0 && k.passphrase == "" {
+		k.keyFile = os.Getenv(keyFileEnv)
+	}
+
+	switch {
+	case k.keyFD >= 0 && (k.keyFile != "" || k.passphrase != ""):
+		return nil, errors.New("-key-fd, -key-file and -passphrase are mutually exclusive")
+	case k.keyFile != "" && k.passphrase != "":
+		return nil, errors.New("-key-file and -passphrase are mutually exclusive")
+	case k.keyfile != "" && k.passphrase == "":
+		return nil, errors.New("-passphrase-keyfile requires -passphrase")
+	case k.keyFD >= 0:
+		key, err := readKeyFD(k.keyFD)
+		if err != nil {
+			return nil, err
+		}
+		return cypher.NewCypherFromKey(key)
+	case k.keyFile != "":
+		key, err := readKeyFile(k.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return cypher.NewCypherFromKey(key)
+	case k.keyfile != "":
+		return cypher.NewCypherWithKeyfile(k.passphrase, k.keyfile)
+	case k.passphrase != "":
+		return cypher.NewCypher(k.passphrase), nil
+	default:
+		return nil, errors.New("a key is required: use -key-file, -key-fd or -passphrase")
+	}
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key file: %w", err)
+	}
+	return key, nil
+}
+
+// readKeyFD reads a key like readKeyFile from an inherited file descriptor,
+// which it closes
+func readKeyFD(fd int) ([]byte, error) {
+	file := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+	if file == nil {
+		return nil, fmt.Errorf("invalid key file descriptor %d", fd)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key from fd %d: %w", fd, err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key from fd %d: %w", fd, err)
+	}
+	return key, nil
+}
+
+func cmdEncrypt(args []string) error {
+	return runFileCommand("encrypt", args, ".encrypted", cypher.Cypher.EncryptFileToPath)
+}
+
+func cmdDecrypt(args []string) error {
+	return runFileCommand("decrypt", args, ".decrypted", cypher.Cypher.DecryptFileToPath)
+}
+
+func runFileCommand(name string, args []string, suffix string, run func(cypher.Cypher, context.Context, string, string) (*cypher.Result, error)) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var keys keyFlags
+	keys.register(fs)
+	output := fs.String("o", "", "output file (default: input file with "+suffix+" appended)")
+	var sshFiles listFlag
+	if name == "encrypt" {
+		fs.Var(&sshFiles, "R", "file of SSH public keys to encrypt to instead of a key, such as ~/.ssh/id_ed25519.pub or a saved https://github.com/<user>.keys; repeatable")
+	} else {
+		fs.Var(&sshFiles, "i", "SSH private key to decrypt with instead of a key, such as ~/.ssh/id_ed25519; repeatable")
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gocypher %s [flags] <input>\n", name)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	input := fs.Arg(0)
+	if *output == "" {
+		*output = input + suffix
+	}
+	if len(sshFiles) > 0 {
+		return runSSHCommand(name, input, *output, sshFiles)
+	}
+
+	c, err := keys.cypher()
+	if err != nil {
+		return err
+	}
+
+	result, err := run(*c, context.Background(), input, *output)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	fmt.Printf("%s -> %s (%v, %.2f MB/s)\n", input, result.OutputPath, result.Stats.Duration, result.Stats.Throughput())
+	return nil
+}
+
+func cmdKeygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	output := fs.String("o", "", "key file to create (default: print to stdout)")
+	fs.Parse(args)
+
+	key, err := GenerateKey()
+	if err != nil {
+		return err
+	}
+	encoded := hex.EncodeToString(key) + "\n"
+
+	if *output == "" {
+		fmt.Print(encoded)
+		return nil
+	}
+
+	file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create key file: %w", err)
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(encoded)
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nikola43/gocypher/cypher/columns"
+)
+
+func cmdCSV(args []string) error {
+	if len(args) == 0 || (args[0] != "encrypt" && args[0] != "decrypt") {
+		return errors.New(`usage: gocypher csv encrypt|decrypt [flags] <input> <output>`)
+	}
+	name := "csv " + args[0]
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var keys keyFlags
+	keys.register(fs)
+	cols := fs.String("columns", "", "comma separated names of the columns to "+args[0]+", or their numbers with -no-header")
+	tsv := fs.Bool("tsv", false, "read and write tab separated values")
+	noHeader := fs.Bool("no-header", false, "the first row is data")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gocypher %s [flags] <input> <output>\n", name)
+		fmt.Fprintln(fs.Output(), "Copies a CSV file with the selected columns transformed, - is stdin or stdout.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() != 2 || *cols == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var opts []columns.Option
+	if *tsv {
+		opts = append(opts, columns.WithComma('\t'))
+	}
+	if *noHeader {
+		opts = append(opts, columns.WithoutHeader())
+	}
+	run := columns.Encrypt
+	if args[0] == "decrypt" {
+		run = columns.Decrypt
+	}
+
+	c, err := keys.cypher()
+	if err != nil {
+		return err
+	}
+	var in io.Reader = os.Stdin
+	if path := fs.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+	var out io.Writer = os.Stdout
+	if path := fs.Arg(1); path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	rows, err := run(context.Background(), c, in, out, strings.Split(*cols, ","), opts...)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	fmt.Fprintf(os.Stderr, "%d rows\n", rows)
+	return nil
+}
@@ -0,0 +1,161 @@
+package cypher
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// adaptInterval is how often the chunks in flight of an adaptive operation
+// are adjusted
+var adaptInterval = 50 * time.Millisecond
+
+// WithAdaptiveWorkers makes operations watch whether reading, writing or
+// the workers hold them back, and scale the chunks in flight between 1 and
+// NumWorkers accordingly. When a slow network filesystem is the bottleneck,
+// only a worker or two keep a chunk instead of all of them sitting on full
+// buffers; when the CPU is, workers are added. Stats.ActiveWorkers reports
+// where an operation ended.
+func (c *Cypher) WithAdaptiveWorkers() *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.adaptive = true
+	return c
+}
+
+// workerScaler bounds the chunks in flight of an operation, from the read
+// to the write of each, and moves the bound with the bottleneck. A nil
+// scaler doesn't bound them.
+type workerScaler struct {
+	maxLimit int
+
+	mu     sync.Mutex
+	limit  int
+	used   int
+	change chan struct{}
+
+	// time spent reading frames, transforming chunks and writing them
+	// since the last adjustment
+	read, busy, write atomic.Int64
+}
+
+func newWorkerScaler(initial, maxLimit int) *workerScaler {
+	return &workerScaler{maxLimit: maxLimit, limit: min(max(initial, 1), maxLimit), change: make(chan struct{})}
+}
+
+// acquire waits for room for one more chunk in flight
+func (s *workerScaler) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	for {
+		s.mu.Lock()
+		if s.used < s.limit {
+			s.used++
+			s.mu.Unlock()
+			return nil
+		}
+		change := s.change
+		s.mu.Unlock()
+		select {
+		case <-change:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release is called once a chunk was written
+func (s *workerScaler) release() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.used--
+	s.notify()
+	s.mu.Unlock()
+}
+
+// notify wakes the waiting reader, s.mu is held
+func (s *workerScaler) notify() {
+	close(s.change)
+	s.change = make(chan struct{})
+}
+
+func (s *workerScaler) addRead(d time.Duration) {
+	if s != nil {
+		s.read.Add(int64(d))
+	}
+}
+
+func (s *workerScaler) addBusy(d time.Duration) {
+	if s != nil {
+		s.busy.Add(int64(d))
+	}
+}
+
+func (s *workerScaler) addWrite(d time.Duration) {
+	if s != nil {
+		s.write.Add(int64(d))
+	}
+}
+
+// adjust sets the bound after an interval of elapsed. An input or output
+// busy nearly all along is the bottleneck: keep a chunk more than the
+// workers use. Otherwise workers are what the operation waits for, add some.
+func (s *workerScaler) adjust(elapsed time.Duration) (limit int, changed bool) {
+	read, busy, write := s.read.Swap(0), s.busy.Swap(0), s.write.Swap(0)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.limit
+	if float64(max(read, write)) >= 0.9*float64(elapsed) {
+		used := int((busy + int64(elapsed) - 1) / int64(elapsed))
+		s.limit = min(s.limit, used+1)
+	} else if float64(busy) >= 0.8*float64(elapsed)*float64(s.limit) {
+		s.limit = min(s.limit*2, s.maxLimit)
+	}
+	if s.limit != previous {
+		s.notify()
+	}
+	return s.limit, s.limit != previous
+}
+
+// activeWorkers returns the current bound
+func (s *workerScaler) activeWorkers() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limit
+}
+
+// startScaling adjusts s every adaptInterval until ctx is done or the
+// returned function, which waits for the adjustments to stop, is called
+func (c Cypher) startScaling(ctx context.Context, s *workerScaler, op string) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	ticker := time.NewTicker(adaptInterval)
+	go func() {
+		defer close(done)
+		defer ticker.Stop()
+		last := time.Now()
+		for {
+			select {
+			case now := <-ticker.C:
+				if limit, changed := s.adjust(now.Sub(last)); changed {
+					c.log().Debug("scaled workers", "op", op, "active", limit)
+				}
+				last = now
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,138 @@
+package cypher
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/sys/cpu"
+)
+
+// Algorithm is the AEAD chunks are sealed with
+type Algorithm byte
+
+const (
+	// AlgorithmAuto picks AES-256-GCM when the CPU accelerates it and
+	// ChaCha20-Poly1305 otherwise
+	AlgorithmAuto Algorithm = iota
+	AlgorithmAES256GCM
+	AlgorithmChaCha20Poly1305
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case AlgorithmAuto:
+		return "auto"
+	case AlgorithmAES256GCM:
+		return "aes-256-gcm"
+	case AlgorithmChaCha20Poly1305:
+		return "chacha20-poly1305"
+	}
+	return fmt.Sprintf("algorithm(%d)", byte(a))
+}
+
+// ParseAlgorithm returns the Algorithm named name, as returned by String
+func ParseAlgorithm(name string) (Algorithm, error) {
+	for _, algorithm := range []Algorithm{AlgorithmAuto, AlgorithmAES256GCM, AlgorithmChaCha20Poly1305} {
+		if strings.EqualFold(name, algorithm.String()) {
+			return algorithm, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown algorithm %q", name)
+}
+
+// hasAESHardware reports whether AES and GCM's carry-less multiplication run
+// in hardware (AES-NI and PCLMULQDQ on x86, AES and PMULL on ARM64). Without
+// them AES-GCM is several times slower than ChaCha20-Poly1305.
+var hasAESHardware = func() bool {
+	switch runtime.GOARCH {
+	case "amd64", "386":
+		return cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ
+	case "arm64":
+		return cpu.ARM64.HasAES && cpu.ARM64.HasPMULL
+	case "s390x":
+		return cpu.S390X.HasAES && cpu.S390X.HasGHASH
+	case "ppc64le":
+		// POWER8 and later have AES and GCM instructions
+		return true
+	}
+	return false
+}()
+
+// WithAlgorithm sets the AEAD new data is encrypted with (default:
+// AlgorithmAuto). The choice is recorded in the header, so decryption
+// always uses the algorithm the data was written with.
+func (c *Cypher) WithAlgorithm(algorithm Algorithm) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.algorithm = algorithm
+	return c
+}
+
+// encryptAlgorithm resolves AlgorithmAuto for this CPU
+func (c Cypher) encryptAlgorithm() Algorithm {
+	if c.algorithm != AlgorithmAuto {
+		return c.algorithm
+	}
+	if hasAESHardware {
+		return AlgorithmAES256GCM
+	}
+	return AlgorithmChaCha20Poly1305
+}
+
+// Backend creates the AEADs chunks are sealed and opened with. It lets
+// experimental accelerators, such as a GPU generating AES-CTR keystream for
+// large batches as the gpu package does, plug into the pipeline; they must
+// implement exactly the algorithms of the software backend so data stays
+// portable.
+type Backend interface {
+	// NewAEAD returns the AEAD of algorithm keyed with key. key is wiped
+	// when NewAEAD returns, implementations must copy it.
+	NewAEAD(algorithm Algorithm, key []byte) (cipher.AEAD, error)
+}
+
+// WithBackend seals and opens chunks with AEADs created by backend instead
+// of the standard library. Data without a header always uses the standard
+// library.
+func (c *Cypher) WithBackend(backend Backend) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.backend = backend
+	return c
+}
+
+// fileAEAD returns the AEAD of algorithm keyed with key from the backend
+func (c Cypher) fileAEAD(algorithm Algorithm, key []byte) (cipher.AEAD, error) {
+	if c.backend != nil {
+		return c.backend.NewAEAD(algorithm, key)
+	}
+	return newAEAD(algorithm, key)
+}
+
+// newAEAD returns the AEAD of algorithm keyed with key
+func newAEAD(algorithm Algorithm, key []byte) (cipher.AEAD, error) {
+	switch algorithm {
+	case AlgorithmAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher: %w", err)
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCM: %w", err)
+		}
+		return gcm, nil
+	case AlgorithmChaCha20Poly1305:
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher: %w", err)
+		}
+		return aead, nil
+	}
+	return nil, fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidHeader, algorithm)
+}
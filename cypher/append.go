@@ -0,0 +1,327 @@
+package cypher
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrAppendClosed is returned by the writes of a closed AppendWriter
+var ErrAppendClosed = errors.New("append writer is closed")
+
+// AppendWriter encrypts what is written to it as new chunks at the end of an
+// existing encrypted file, see OpenAppend
+type AppendWriter struct {
+	file      *os.File
+	op        *operation
+	chunkSize int
+	// position is the position of the next chunk, offset where it goes
+	position int
+	offset   int64
+	// trailer returns the stream trailer ending the chunks, nil for data
+	// written without one
+	trailer func(chunks int, size int64) []byte
+	// headerSize is the size of the header before the chunks
+	headerSize int64
+	pending    []byte
+	closed     bool
+}
+
+// OpenAppend opens the encrypted file at path to append to it. Plaintext
+// written to the returned writer is sealed under the existing header as
+// chunks continuing its positions, and nonce counter WithNonceCounter, so
+// growing logs or datasets don't rewrite what is already there: existing
+// chunks are never modified, only the trailer ending them is replaced by
+// every new chunk. The chunk and its trailer are staged past the end and
+// synced first, so when a crash interrupts an append the next OpenAppend
+// completes or rolls back its last chunk. Every Flush and the Close ends the chunk being
+// filled, so flushing often makes many short chunks. Files written with
+// delta friendly output, holes, compression without chunk flags or the
+// legacy format can't be appended to.
+func (c Cypher) OpenAppend(path string) (*AppendWriter, error) {
+	c.markUsed()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open encrypted file: %w", err)
+	}
+	if err := c.lockFile(context.Background(), file, true); err != nil {
+		file.Close()
+		return nil, err
+	}
+	w, err := c.prepareAppend(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// prepareAppend reads the header and the chunk lengths of file, checks its
+// trailer and leaves it at the end of the chunks
+func (c Cypher) prepareAppend(file *os.File) (*AppendWriter, error) {
+	magic := make([]byte, len(FormatMagic))
+	if _, err := io.ReadFull(file, magic); err != nil || string(magic) != FormatMagic {
+		return nil, fmt.Errorf("%w: can't append to data without a header", ErrInvalidHeader)
+	}
+	h, err := readHeader(file, c.parseMode)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case h.deterministic:
+		return nil, errors.New("can't append to delta friendly data, its trailer covers every chunk")
+	case len(h.holes) > 0:
+		return nil, errors.New("can't append to a sparse file")
+	case h.compression != CompressionNone && !h.chunkFlags:
+		return nil, errors.New("can't append to data compressed without chunk flags")
+	case h.counter != nil && c.nonceCounter == nil:
+		return nil, errors.New("data encrypted with a nonce counter needs WithNonceCounter to append")
+	}
+	gcm, err := c.fileGCM(h)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &operation{gcm: gcm, aad: headerAAD(h), random: c.random()}
+	if op.usage, err = c.usage(); err != nil {
+		return nil, err
+	}
+	if c.nonceCounter != nil {
+		op.nonce = c.nonceCounter.nonce
+	}
+	if h.compression != CompressionNone {
+		if op.compress, err = compressor(h.compression, c.compressionLevel); err != nil {
+			return nil, err
+		}
+	}
+
+	// Count the chunks to continue their positions
+	maxFrame := int64(h.chunkSize) + int64(gcm.NonceSize()+gcm.Overhead()) + 1
+	var key []byte
+	if h.trailer {
+		if key, err = c.streamTrailerKey(h); err != nil {
+			return nil, err
+		}
+	}
+	offset, position, trailerEnd, err := appendChunks(file, h, maxFrame, key, op.aad)
+	if err != nil && h.trailer {
+		// A crash while appending leaves a chunk staged past the end or part
+		// of one after the trailer
+		recovered, recoverErr := recoverAppend(file, op, int64(len(h.raw)), key, trailerEnd)
+		if recoverErr != nil {
+			return nil, recoverErr
+		} else if !recovered {
+			return nil, err
+		}
+		if offset, position, _, err = appendChunks(file, h, maxFrame, key, op.aad); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	w := &AppendWriter{
+		file:       file,
+		op:         op,
+		chunkSize:  int(h.chunkSize),
+		position:   position,
+		offset:     offset,
+		headerSize: int64(len(h.raw)),
+		pending:    make([]byte, 0, h.chunkSize),
+	}
+	if h.trailer {
+		w.trailer = func(chunks int, size int64) []byte {
+			return encodeStreamTrailer(key, op.aad, chunks, size)
+		}
+	}
+	return w, nil
+}
+
+// appendChunks walks the chunk lengths of file up to its trailer and returns
+// where the chunks end and how many there are. When a good trailer is
+// followed by more data, trailerEnd is where the trailer ends.
+func appendChunks(file *os.File, h *header, maxFrame int64, key, aad []byte) (offset int64, position int, trailerEnd int64, err error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	offset = int64(len(h.raw))
+	var prefix [FrameLengthSize]byte
+	for offset < info.Size() {
+		if _, err := file.ReadAt(prefix[:], offset); err != nil {
+			return offset, position, 0, fmt.Errorf("truncated chunk length at %d: %w", offset, err)
+		}
+		if h.trailer && isTrailer(prefix[:]) {
+			trailer := make([]byte, streamTrailerSize)
+			if _, err := file.ReadAt(trailer, offset); err != nil {
+				return offset, position, 0, fmt.Errorf("%w: bad trailer at %d", ErrChunksModified, offset)
+			}
+			if err := checkStreamTrailer(trailer, key, aad, position, offset-int64(len(h.raw))); err != nil {
+				return offset, position, 0, err
+			}
+			if offset+streamTrailerSize != info.Size() {
+				return offset, position, offset + streamTrailerSize, fmt.Errorf("%w: data after the trailer", ErrChunksModified)
+			}
+			return offset, position, 0, nil
+		}
+		size := int64(binary.BigEndian.Uint32(prefix[:]))
+		if size > maxFrame || offset+FrameLengthSize+size > info.Size() {
+			return offset, position, 0, fmt.Errorf("truncated chunk at %d", offset)
+		}
+		offset += FrameLengthSize + size
+		position++
+	}
+	if h.trailer {
+		return offset, position, 0, fmt.Errorf("%w: can't append to data without its trailer", ErrTruncated)
+	}
+	return offset, position, 0, nil
+}
+
+// recoverAppend finishes or rolls back the seal an AppendWriter was in when
+// it stopped. A staged copy of the last chunk and trailer, which ends the
+// file, is moved into place when the trailer authenticates it and the chunk
+// opens. Otherwise what follows a good trailer ending at trailerEnd is cut.
+// It reports whether there was anything to recover.
+func recoverAppend(file *os.File, op *operation, headerSize int64, key []byte, trailerEnd int64) (bool, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	size := info.Size()
+	trailer := make([]byte, streamTrailerSize)
+	if size-headerSize >= 2*streamTrailerSize {
+		if _, err := file.ReadAt(trailer, size-streamTrailerSize); err != nil {
+			return false, err
+		}
+	}
+	if isStreamTrailer(trailer) {
+		chunks, total := binary.BigEndian.Uint64(trailer[FrameLengthSize:]), binary.BigEndian.Uint64(trailer[FrameLengthSize+8:])
+		// The staged copy follows the place of the chunk and trailer, see
+		// AppendWriter.stage
+		end := headerSize + int64(total)
+		frameSize := size - end - 2*streamTrailerSize
+		if checkStreamTrailer(trailer, key, op.aad, int(chunks), int64(total)) == nil && chunks > 0 &&
+			frameSize > FrameLengthSize && end-frameSize >= headerSize {
+			tail := make([]byte, frameSize+streamTrailerSize)
+			if _, err := file.ReadAt(tail, size-int64(len(tail))); err != nil {
+				return false, err
+			}
+			frame := tail[FrameLengthSize:frameSize]
+			if int64(binary.BigEndian.Uint32(tail)) == frameSize-FrameLengthSize && len(frame) >= op.gcm.NonceSize() {
+				nonce := frame[:op.gcm.NonceSize()]
+				if _, err := op.gcm.Open(nil, nonce, frame[len(nonce):], op.chunkAAD(int(chunks)-1)); err == nil {
+					return true, place(file, tail, end-frameSize)
+				}
+			}
+		}
+	}
+	if trailerEnd == 0 {
+		return false, nil
+	}
+	if err := file.Truncate(trailerEnd); err != nil {
+		return false, err
+	}
+	return true, file.Sync()
+}
+
+// place writes tail at offset, syncs it and cuts the file after it
+func place(file *os.File, tail []byte, offset int64) error {
+	if _, err := file.WriteAt(tail, offset); err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return file.Truncate(offset + int64(len(tail)))
+}
+
+// Write encrypts p, appending every chunk it fills
+func (w *AppendWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrAppendClosed
+	}
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), w.chunkSize-len(w.pending))
+		w.pending = append(w.pending, p[:n]...)
+		p = p[n:]
+		if len(w.pending) == w.chunkSize {
+			if err := w.seal(); err != nil {
+				return written, err
+			}
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// seal appends the pending plaintext as a chunk. With a trailer, the chunk
+// replaces the old trailer and is followed by the new one, see stage.
+func (w *AppendWriter) seal() error {
+	frame, err := sealFrame(w.op, w.op.chunkAAD(w.position), w.pending)
+	wipe(w.pending)
+	w.pending = w.pending[:0]
+	if err != nil {
+		return err
+	}
+	if w.trailer == nil {
+		if _, err := w.file.WriteAt(frame, w.offset); err != nil {
+			return fmt.Errorf("failed to write chunk: %w", err)
+		}
+	} else if err := w.stage(append(frame, w.trailer(w.position+1, w.offset+int64(len(frame))-w.headerSize)...)); err != nil {
+		return err
+	}
+	w.position++
+	w.offset += int64(len(frame))
+	return nil
+}
+
+// stage writes tail, the new chunk and trailer, over the old trailer. It is
+// first written past the place it goes by its own size and synced, so the
+// old trailer stays intact until a full copy exists: whichever write a crash
+// interrupts, recoverAppend finds either the old trailer and part of the
+// copy after it, or the whole copy at the end.
+func (w *AppendWriter) stage(tail []byte) error {
+	if _, err := w.file.WriteAt(tail, w.offset+int64(len(tail))); err != nil {
+		return fmt.Errorf("failed to stage chunk: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	return place(w.file, tail, w.offset)
+}
+
+// Flush appends the plaintext written since the last chunk as a chunk of its
+// own and syncs the file
+func (w *AppendWriter) Flush() error {
+	if w.closed {
+		return ErrAppendClosed
+	}
+	if len(w.pending) > 0 {
+		if err := w.seal(); err != nil {
+			return err
+		}
+	}
+	return w.file.Sync()
+}
+
+// Close flushes the writer and closes the file
+func (w *AppendWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	err := w.Flush()
+	w.closed = true
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
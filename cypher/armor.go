@@ -0,0 +1,184 @@
+package cypher
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Armored data is base64 between a header and a footer line. Every line but
+// the last ends with a continuation marker, so a cut off paste is noticed:
+//
+//	-----BEGIN GOCYPHER ENCRYPTED DATA-----
+//	R09DWQEB...\
+//	...
+//	-----END GOCYPHER ENCRYPTED DATA-----
+const (
+	ArmorHeader = "-----BEGIN GOCYPHER ENCRYPTED DATA-----"
+	ArmorFooter = "-----END GOCYPHER ENCRYPTED DATA-----"
+	// DefaultArmorWidth is the line width of armored base64
+	DefaultArmorWidth = 64
+
+	armorContinuation = '\\'
+)
+
+// ErrInvalidArmor is returned for armored data that is malformed or cut off
+var ErrInvalidArmor = errors.New("invalid armor")
+
+// ArmorWriter writes what is written to it as armored base64 lines, for
+// encrypted payloads embedded in email bodies, YAML or terminal copy and
+// paste. Close writes the footer.
+type ArmorWriter struct {
+	dst     io.Writer
+	encoder io.WriteCloser
+	lines   *armorLines
+	started bool
+}
+
+// NewArmorWriter returns a writer armoring into dst with lines of width
+// base64 characters, DefaultArmorWidth when width isn't positive
+func NewArmorWriter(dst io.Writer, width int) *ArmorWriter {
+	if width <= 0 {
+		width = DefaultArmorWidth
+	}
+	lines := &armorLines{dst: dst, line: make([]byte, 0, width+2)}
+	return &ArmorWriter{dst: dst, encoder: base64.NewEncoder(base64.StdEncoding, lines), lines: lines}
+}
+
+// start writes the header before the first line
+func (w *ArmorWriter) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	_, err := io.WriteString(w.dst, ArmorHeader+"\n")
+	return err
+}
+
+func (w *ArmorWriter) Write(p []byte) (int, error) {
+	if err := w.start(); err != nil {
+		return 0, err
+	}
+	return w.encoder.Write(p)
+}
+
+// Close writes the last line and the footer
+func (w *ArmorWriter) Close() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	if err := w.encoder.Close(); err != nil {
+		return err
+	}
+	if err := w.lines.close(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.dst, ArmorFooter+"\n")
+	return err
+}
+
+// armorLines breaks base64 into lines, holding back the last one until it
+// knows whether another follows
+type armorLines struct {
+	dst  io.Writer
+	line []byte
+}
+
+func (l *armorLines) Write(p []byte) (int, error) {
+	width := cap(l.line) - 2
+	written := 0
+	for len(p) > 0 {
+		if len(l.line) == width {
+			if _, err := l.dst.Write(append(l.line, armorContinuation, '\n')); err != nil {
+				return written, err
+			}
+			l.line = l.line[:0]
+		}
+		n := min(len(p), width-len(l.line))
+		l.line = append(l.line, p[:n]...)
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (l *armorLines) close() error {
+	if len(l.line) == 0 {
+		return nil
+	}
+	_, err := l.dst.Write(append(l.line, '\n'))
+	return err
+}
+
+// NewArmorReader returns a reader of the data armored in src by an
+// ArmorWriter. Text before the header, such as the rest of an email, is
+// skipped, and so is the indentation of lines, so armor pasted in YAML
+// blocks reads back. A missing footer or last line fails with
+// ErrInvalidArmor.
+func NewArmorReader(src io.Reader) io.Reader {
+	return base64.NewDecoder(base64.StdEncoding, &armorText{src: bufio.NewReader(src)})
+}
+
+// armorText reads the base64 of armored lines
+type armorText struct {
+	src *bufio.Reader
+	// pending is the rest of the current line
+	pending string
+	started bool
+	// lines counts the lines read, last is set after the one without a
+	// continuation marker
+	lines int
+	last  bool
+	done  bool
+}
+
+func (t *armorText) Read(p []byte) (int, error) {
+	for t.pending == "" {
+		if t.done {
+			return 0, io.EOF
+		}
+		if err := t.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+// next reads the next line
+func (t *armorText) next() error {
+	line, err := t.src.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		if err == io.EOF {
+			if !t.started {
+				return fmt.Errorf("%w: no header", ErrInvalidArmor)
+			}
+			return fmt.Errorf("%w: no footer", ErrInvalidArmor)
+		}
+		return err
+	}
+	line = strings.TrimSpace(line)
+	switch {
+	case !t.started:
+		t.started = line == ArmorHeader
+	case line == ArmorFooter:
+		if t.lines > 0 && !t.last {
+			return fmt.Errorf("%w: last line missing", ErrInvalidArmor)
+		}
+		t.done = true
+	case line == "":
+	case t.last:
+		return fmt.Errorf("%w: line after the last one", ErrInvalidArmor)
+	case line[len(line)-1] == armorContinuation:
+		t.pending = line[:len(line)-1]
+		t.lines++
+	default:
+		t.pending, t.last = line, true
+		t.lines++
+	}
+	return nil
+}
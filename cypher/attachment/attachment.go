@@ -0,0 +1,202 @@
+// Package attachment builds and parses MIME parts carrying encrypted files,
+// so secure file delivery over email can be automated.
+//
+// A part holds the output of EncryptStream in base64, with the original
+// filename and the key fingerprint in its headers. Recipients pick the key
+// by fingerprint and decrypt the part while it is read, a message of any
+// size needs little memory.
+package attachment
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+const (
+	// ContentType is the media type of encrypted parts
+	ContentType = "application/x-gocypher"
+	// HeaderFilename holds the name of the file before encryption
+	HeaderFilename = "X-Gocypher-Filename"
+	// HeaderFingerprint holds the fingerprint of the encryption key
+	HeaderFingerprint = "X-Gocypher-Key-Fingerprint"
+
+	extension  = ".gocypher"
+	lineLength = 76
+	// maxDepth bounds the nesting of multipart messages
+	maxDepth = 10
+)
+
+var (
+	// ErrNotEncrypted is returned when parsing a part that isn't an
+	// encrypted attachment
+	ErrNotEncrypted = errors.New("part is not an encrypted attachment")
+	// ErrWrongKey is returned when decrypting with a key that doesn't match
+	// the fingerprint of the attachment
+	ErrWrongKey = errors.New("attachment was encrypted with another key")
+)
+
+// Attachment is an encrypted part found by Parse or Walk
+type Attachment struct {
+	// Filename is the name of the file before encryption
+	Filename string
+	// Fingerprint identifies the key used for encryption
+	Fingerprint string
+	// Header holds all the headers of the part
+	Header textproto.MIMEHeader
+	body   io.Reader
+}
+
+// Header returns the headers of a part holding filename encrypted with c
+func Header(c *cypher.Cypher, filename string) textproto.MIMEHeader {
+	name := filepath.Base(filename)
+	sealedName := name + extension
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", mime.FormatMediaType(ContentType, map[string]string{"name": sealedName}))
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": sealedName}))
+	h.Set("Content-Transfer-Encoding", "base64")
+	h.Set(HeaderFilename, mime.QEncoding.Encode("utf-8", name))
+	h.Set(HeaderFingerprint, c.KeyFingerprint())
+	return h
+}
+
+// Write encrypts src with c into a new part of mw named after filename
+func Write(ctx context.Context, c *cypher.Cypher, mw *multipart.Writer, filename string, src io.Reader) (*cypher.Stats, error) {
+	part, err := mw.CreatePart(Header(c, filename))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create part: %w", err)
+	}
+	return Encode(ctx, c, part, src)
+}
+
+// Encode writes the body of an encrypted part, src encrypted with c in base64
+// lines, to dst. Use it with Header to build parts with other MIME writers.
+func Encode(ctx context.Context, c *cypher.Cypher, dst io.Writer, src io.Reader) (*cypher.Stats, error) {
+	lines := &lineWriter{dst: dst}
+	encoder := base64.NewEncoder(base64.StdEncoding, lines)
+	stats, err := c.EncryptStream(ctx, src, encoder)
+	if err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	if err := lines.close(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Parse returns the attachment held by a part with header h and body r
+func Parse(h textproto.MIMEHeader, r io.Reader) (*Attachment, error) {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil || mediaType != ContentType {
+		return nil, ErrNotEncrypted
+	}
+	if !strings.EqualFold(strings.TrimSpace(h.Get("Content-Transfer-Encoding")), "base64") {
+		return nil, fmt.Errorf("%w: unsupported transfer encoding %q", ErrNotEncrypted, h.Get("Content-Transfer-Encoding"))
+	}
+	filename, err := new(mime.WordDecoder).DecodeHeader(h.Get(HeaderFilename))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode filename: %w", err)
+	}
+	return &Attachment{
+		Filename:    filepath.Base(filename),
+		Fingerprint: h.Get(HeaderFingerprint),
+		Header:      h,
+		body:        base64.NewDecoder(base64.StdEncoding, r),
+	}, nil
+}
+
+// Decrypt decrypts the attachment with c into dst. Attachments can only be
+// decrypted once, while their part is being read.
+func (a *Attachment) Decrypt(ctx context.Context, c *cypher.Cypher, dst io.Writer) (*cypher.Stats, error) {
+	if a.Fingerprint != "" && !c.HasFingerprint(a.Fingerprint) {
+		return nil, fmt.Errorf("%w: %s", ErrWrongKey, a.Fingerprint)
+	}
+	return c.DecryptStream(ctx, a.body, dst)
+}
+
+// Walk reads the email message r and calls fn for each encrypted attachment,
+// including those of nested multipart parts
+func Walk(r io.Reader, fn func(*Attachment) error) error {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return fmt.Errorf("failed to read message: %w", err)
+	}
+	return walk(textproto.MIMEHeader(msg.Header), msg.Body, fn, 0)
+}
+
+func walk(h textproto.MIMEHeader, body io.Reader, fn func(*Attachment) error, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return nil
+	}
+	if mediaType == ContentType {
+		a, err := Parse(h, body)
+		if err != nil {
+			return err
+		}
+		return fn(a)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") || depth >= maxDepth {
+		return nil
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read part: %w", err)
+		}
+		if err := walk(part.Header, part, fn, depth+1); err != nil {
+			return err
+		}
+	}
+}
+
+// lineWriter breaks the base64 it writes into lines of lineLength
+type lineWriter struct {
+	dst  io.Writer
+	used int
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), lineLength-w.used)
+		if _, err := w.dst.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		w.used += n
+		p = p[n:]
+		if w.used == lineLength {
+			if _, err := io.WriteString(w.dst, "\r\n"); err != nil {
+				return written, err
+			}
+			w.used = 0
+		}
+	}
+	return written, nil
+}
+
+func (w *lineWriter) close() error {
+	if w.used == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.dst, "\r\n")
+	return err
+}
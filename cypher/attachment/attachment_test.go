@@ -0,0 +1,61 @@
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+func TestWriteAndWalk(t *testing.T) {
+	c := cypher.NewCypher("secret")
+	report := bytes.Repeat([]byte("quarterly figures\n"), 10000)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	text, _ := mw.CreatePart(map[string][]string{"Content-Type": {"text/plain"}})
+	text.Write([]byte("The report is attached."))
+	if _, err := Write(context.Background(), c, mw, "/tmp/Q3 résumé.pdf", bytes.NewReader(report)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	mw.Close()
+	for _, line := range bytes.Split(body.Bytes(), []byte("\r\n")) {
+		if len(line) > 998 {
+			t.Fatalf("Line of %d bytes exceeds the email limit", len(line))
+		}
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: a@example.com\r\nSubject: report\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+	msg.Write(body.Bytes())
+
+	var found []string
+	err := Walk(bytes.NewReader(msg.Bytes()), func(a *Attachment) error {
+		found = append(found, a.Filename)
+		if _, err := a.Decrypt(context.Background(), cypher.NewCypher("other"), &bytes.Buffer{}); !errors.Is(err, ErrWrongKey) {
+			t.Errorf("Expected ErrWrongKey, got %v", err)
+		}
+		var plain bytes.Buffer
+		if _, err := a.Decrypt(context.Background(), c, &plain); err != nil {
+			return err
+		}
+		if !bytes.Equal(plain.Bytes(), report) {
+			t.Error("Expected the report")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if len(found) != 1 || found[0] != "Q3 résumé.pdf" {
+		t.Errorf("Unexpected attachments %q", found)
+	}
+
+	if _, err := Parse(map[string][]string{"Content-Type": {"text/plain"}}, &body); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("Expected ErrNotEncrypted, got %v", err)
+	}
+}
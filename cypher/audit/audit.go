@@ -0,0 +1,163 @@
+// Package audit implements an append-only, HMAC-chained log of cypher
+// operations. Every entry carries the MAC of the previous one, so removing,
+// reordering or editing entries breaks the chain and is detected by Verify.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+// ErrTampered is returned when the log chain doesn't verify
+var ErrTampered = errors.New("audit log has been tampered with")
+
+// Entry is a single record of the log
+type Entry struct {
+	Seq uint64 `json:"seq"`
+	cypher.AuditEvent
+	Principal string `json:"principal,omitempty"`
+	// Prev is the MAC of the previous entry, empty for the first one
+	Prev string `json:"prev"`
+	MAC  string `json:"mac"`
+}
+
+// Log appends entries to a file. It implements cypher.Auditor and is safe for
+// concurrent use.
+type Log struct {
+	mu        sync.Mutex
+	file      *os.File
+	key       []byte
+	principal string
+	seq       uint64
+	last      string
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a context whose operations are attributed to
+// principal instead of the log's default
+func ContextWithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// Open verifies the existing log at path (if any) and opens it for
+// appending. key authenticates the chain, principal is recorded on entries
+// whose context doesn't carry one.
+func Open(path string, key []byte, principal string) (*Log, error) {
+	entries, err := verify(path, key)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	l := &Log{file: file, key: append([]byte(nil), key...), principal: principal}
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		l.seq, l.last = last.Seq, last.MAC
+	}
+	return l, nil
+}
+
+// Record appends event to the log and syncs it to disk
+func (l *Log) Record(ctx context.Context, event cypher.AuditEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	principal := l.principal
+	if p, ok := ctx.Value(principalKey{}).(string); ok {
+		principal = p
+	}
+
+	entry := Entry{Seq: l.seq + 1, AuditEvent: event, Principal: principal, Prev: l.last}
+	mac, err := sign(l.key, entry)
+	if err != nil {
+		return err
+	}
+	entry.MAC = mac
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+
+	l.seq, l.last = entry.Seq, entry.MAC
+	return nil
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Verify checks the chain of the log at path and returns its entries
+func Verify(path string, key []byte) ([]Entry, error) {
+	return verify(path, key)
+}
+
+func verify(path string, key []byte) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	prev := ""
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%w: entry %d is malformed", ErrTampered, len(entries)+1)
+		}
+
+		expected, err := sign(key, entry)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Seq != uint64(len(entries)+1) || !cypher.EqualString(entry.Prev, prev) || !cypher.EqualString(entry.MAC, expected) {
+			return nil, fmt.Errorf("%w: chain breaks at entry %d", ErrTampered, len(entries)+1)
+		}
+
+		entries = append(entries, entry)
+		prev = entry.MAC
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// sign returns the MAC of entry, computed over its encoding with an empty MAC
+func sign(key []byte, entry Entry) (string, error) {
+	entry.MAC = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
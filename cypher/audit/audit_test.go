@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	key := []byte("audit-key")
+
+	log, err := Open(path, key, "service")
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+
+	c := cypher.NewCypher("test-key").WithAuditor(log)
+	encrypted, err := c.Encrypt([]byte("your data"))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	if _, err := c.Decrypt(encrypted); err != nil {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+	log.Close()
+
+	// Reopening continues the chain
+	log, err = Open(path, key, "service")
+	if err != nil {
+		t.Fatalf("Failed to reopen audit log: %v", err)
+	}
+	if err := log.Record(ContextWithPrincipal(context.Background(), "alice"), cypher.AuditEvent{Op: "encrypt"}); err != nil {
+		t.Fatalf("Failed to record event: %v", err)
+	}
+	log.Close()
+
+	entries, err := Verify(path, key)
+	if err != nil {
+		t.Fatalf("Verification failed: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	if entries[0].KeyFingerprint != c.KeyFingerprint() || entries[0].Principal != "service" {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+	if entries[0].OutputHash != entries[1].InputHash {
+		t.Error("Expected decrypt input hash to match encrypt output hash")
+	}
+	if entries[2].Principal != "alice" {
+		t.Errorf("Expected principal from context, got %q", entries[2].Principal)
+	}
+
+	// Tampering with any entry breaks the chain
+	data, _ := os.ReadFile(path)
+	if err := os.WriteFile(path, bytes.Replace(data, []byte(`"op":"decrypt"`), []byte(`"op":"encrypt"`), 1), 0600); err != nil {
+		t.Fatalf("Failed to rewrite audit log: %v", err)
+	}
+	if _, err := Verify(path, key); !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected ErrTampered, got %v", err)
+	}
+}
@@ -0,0 +1,506 @@
+// Package backup implements incremental encrypted backups. Every snapshot of
+// a directory is recorded in an encrypted manifest; files whose content
+// didn't change since the previous snapshot are not encrypted again, and any
+// snapshot can be restored, including the latest one taken before a point in
+// time.
+//
+// A repository is a directory holding
+//
+//	chunks/                     a cas.Store of encrypted file chunks
+//	manifests/<id>.encrypted    one encrypted manifest per snapshot
+//
+// Files are split into content defined chunks, so chunks shared by any files
+// or versions are stored once. Snapshots taken before chunking recorded one
+// object per file below objects/, they are still restored.
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher"
+	"github.com/nikola43/gocypher/cypher/cas"
+)
+
+const (
+	chunksDir    = "chunks"
+	objectsDir   = "objects"
+	manifestsDir = "manifests"
+	suffix       = ".encrypted"
+	tempSuffix   = ".tmp"
+	// idFormat sorts manifests chronologically by name
+	idFormat = "20060102T150405.000000000Z"
+
+	defaultMinChunk = 256 * 1024
+	defaultAvgChunk = 1024 * 1024
+	defaultMaxChunk = 4 * 1024 * 1024
+)
+
+var (
+	// ErrNoSnapshot is returned when no snapshot matches a restore request
+	ErrNoSnapshot = errors.New("no matching snapshot")
+	// ErrSnapshotExists is returned when a snapshot name is already taken
+	ErrSnapshotExists = errors.New("snapshot name already exists")
+)
+
+// Entry is a file recorded in a manifest
+type Entry struct {
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	// Hash is the hex encoded SHA-256 of the content
+	Hash string `json:"hash"`
+	// Chunks lists the IDs of the content's chunks in order
+	Chunks []string `json:"chunks,omitempty"`
+	// Object names the whole-file object of snapshots taken before chunking
+	Object string `json:"object,omitempty"`
+}
+
+// Manifest describes a snapshot
+type Manifest struct {
+	ID string `json:"id"`
+	// Name is an optional label unique within the repository
+	Name    string    `json:"name,omitempty"`
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Entries []Entry   `json:"entries"`
+}
+
+// Summary reports what a backup did
+type Summary struct {
+	Manifest *Manifest
+	// New counts files with at least one new chunk, Unchanged those made
+	// entirely of chunks already stored
+	New       int
+	Unchanged int
+	// NewChunks and ReusedChunks count the chunks of the files that were read
+	NewChunks    int
+	ReusedChunks int
+	// BytesEncrypted is the plaintext size of the new chunks
+	BytesEncrypted int64
+}
+
+// Repository is a directory of encrypted snapshots
+type Repository struct {
+	cypher *cypher.Cypher
+	dir    string
+	chunks *cas.Store
+	now    func() time.Time
+
+	minChunk, avgChunk, maxChunk int
+	include, exclude             []string
+}
+
+type Option func(*Repository)
+
+// WithClock sets the function returning the time snapshots are taken at
+func WithClock(now func() time.Time) Option {
+	return func(r *Repository) { r.now = now }
+}
+
+// WithChunkSizes sets the minimum, average and maximum size of file chunks
+// (default: 256 KB, 1 MB and 4 MB)
+func WithChunkSizes(min, avg, max int) Option {
+	return func(r *Repository) { r.minChunk, r.avgChunk, r.maxChunk = min, avg, max }
+}
+
+// WithInclude only backs up files matching one of patterns, gitignore style
+// patterns relative to the source directory
+func WithInclude(patterns ...string) Option {
+	return func(r *Repository) { r.include = append(r.include, patterns...) }
+}
+
+// WithExclude skips paths matching one of patterns, in addition to those
+// listed in cypher.IgnoreFile files of the source directory
+func WithExclude(patterns ...string) Option {
+	return func(r *Repository) { r.exclude = append(r.exclude, patterns...) }
+}
+
+// Open opens the repository in dir, creating it when missing
+func Open(c *cypher.Cypher, dir string, opts ...Option) (*Repository, error) {
+	if err := os.MkdirAll(filepath.Join(dir, manifestsDir), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create repository: %w", err)
+	}
+
+	chunks, err := cas.Open(c, filepath.Join(dir, chunksDir))
+	if err != nil {
+		return nil, err
+	}
+	r := &Repository{
+		cypher:   c,
+		dir:      dir,
+		chunks:   chunks,
+		now:      time.Now,
+		minChunk: defaultMinChunk,
+		avgChunk: defaultAvgChunk,
+		maxChunk: defaultMaxChunk,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	// reject invalid chunk sizes before the first backup
+	if _, err := cypher.NewChunker(nil, r.minChunk, r.avgChunk, r.maxChunk); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Backup takes a snapshot of srcDir. Files whose size and modification time
+// match the latest snapshot are not read again; other files are chunked and
+// only the chunks not stored yet are encrypted.
+func (r *Repository) Backup(ctx context.Context, srcDir string) (*Summary, error) {
+	return r.BackupNamed(ctx, "", srcDir)
+}
+
+// BackupNamed is like Backup and labels the snapshot with name, so it can be
+// restored by name
+func (r *Repository) BackupNamed(ctx context.Context, name, srcDir string) (*Summary, error) {
+	if name != "" {
+		if _, err := r.Resolve(name); err == nil {
+			return nil, fmt.Errorf("%w: %s", ErrSnapshotExists, name)
+		} else if !errors.Is(err, ErrNoSnapshot) {
+			return nil, err
+		}
+	}
+
+	previous := make(map[string]Entry)
+	if latest, err := r.Latest(); err == nil {
+		for _, entry := range latest.Entries {
+			previous[entry.Path] = entry
+		}
+	} else if !errors.Is(err, ErrNoSnapshot) {
+		return nil, err
+	}
+
+	filter, err := cypher.NewFilter(r.include, r.exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	now := r.now().UTC()
+	manifest := &Manifest{ID: now.Format(idFormat), Name: name, Time: now, Source: srcDir}
+	summary := &Summary{Manifest: manifest}
+
+	err = filter.Walk(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		entry := Entry{Path: cypher.PortableName(filepath.ToSlash(rel)), Size: info.Size(), Mode: info.Mode().Perm(), ModTime: info.ModTime()}
+
+		if prev, ok := previous[entry.Path]; ok && prev.Size == entry.Size && prev.ModTime.Equal(entry.ModTime) && r.hasContent(prev) {
+			entry.Hash, entry.Chunks, entry.Object = prev.Hash, prev.Chunks, prev.Object
+			summary.Unchanged++
+		} else if err := r.store(ctx, path, &entry, summary); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		manifest.Entries = append(manifest.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("backup failed: %w", err)
+	}
+
+	if err := r.writeManifest(manifest); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// store splits the file at path into chunks and stores those that are new
+func (r *Repository) store(ctx context.Context, path string, entry *Entry, summary *Summary) error {
+	file, err := os.Open(cypher.LongPath(path))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	chunker, err := cypher.NewChunker(io.TeeReader(file, hash), r.minChunk, r.avgChunk, r.maxChunk)
+	if err != nil {
+		return err
+	}
+
+	stored := false
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		id, isNew, err := r.chunks.Put(chunk)
+		if err != nil {
+			return err
+		}
+		entry.Chunks = append(entry.Chunks, id)
+		if isNew {
+			stored = true
+			summary.NewChunks++
+			summary.BytesEncrypted += int64(len(chunk))
+		} else {
+			summary.ReusedChunks++
+		}
+	}
+	entry.Hash = hex.EncodeToString(hash.Sum(nil))
+
+	if stored {
+		summary.New++
+	} else {
+		summary.Unchanged++
+	}
+	return nil
+}
+
+// Restore writes the files of the snapshot with the given id or name below
+// dstDir
+func (r *Repository) Restore(ctx context.Context, ref, dstDir string) error {
+	manifest, err := r.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	return r.restore(ctx, manifest, dstDir)
+}
+
+// RestoreAt restores the latest snapshot taken at or before t
+func (r *Repository) RestoreAt(ctx context.Context, t time.Time, dstDir string) (*Manifest, error) {
+	ids, err := r.manifestIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(ids) - 1; i >= 0; i-- {
+		manifest, err := r.Manifest(ids[i])
+		if err != nil {
+			return nil, err
+		}
+		if !manifest.Time.After(t) {
+			return manifest, r.restore(ctx, manifest, dstDir)
+		}
+	}
+	return nil, fmt.Errorf("%w: none taken before %s", ErrNoSnapshot, t.Format(time.RFC3339))
+}
+
+func (r *Repository) restore(ctx context.Context, manifest *Manifest, dstDir string) error {
+	for _, entry := range manifest.Entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		dst, err := destination(dstDir, entry.Path)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+		if err := r.restoreFile(ctx, entry, dst+tempSuffix); err != nil {
+			os.Remove(dst + tempSuffix)
+			return fmt.Errorf("%s: %w", entry.Path, err)
+		}
+		if err := os.Chmod(dst+tempSuffix, entry.Mode); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dst+tempSuffix, entry.ModTime, entry.ModTime); err != nil {
+			return err
+		}
+		if err := os.Rename(dst+tempSuffix, dst); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", entry.Path, err)
+		}
+	}
+	return nil
+}
+
+// restoreFile writes the content of entry to path
+func (r *Repository) restoreFile(ctx context.Context, entry Entry, path string) error {
+	if entry.Object != "" {
+		_, err := r.cypher.DecryptFileToPath(ctx, r.objectPath(entry.Object), path)
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, id := range entry.Chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		chunk, err := r.chunks.Get(id)
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// destination joins a manifest path, escaped for this platform, to dstDir,
+// refusing paths that escape it
+func destination(dstDir, path string) (string, error) {
+	rel := filepath.FromSlash(cypher.LocalName(path))
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("refusing to restore %q outside the destination", path)
+	}
+	return cypher.LongPath(filepath.Join(dstDir, rel)), nil
+}
+
+// Manifests returns all snapshots, oldest first
+func (r *Repository) Manifests() ([]*Manifest, error) {
+	ids, err := r.manifestIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := make([]*Manifest, 0, len(ids))
+	for _, id := range ids {
+		manifest, err := r.Manifest(id)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
+
+// Resolve returns the snapshot with the given id or name
+func (r *Repository) Resolve(ref string) (*Manifest, error) {
+	if manifest, err := r.Manifest(ref); !errors.Is(err, ErrNoSnapshot) {
+		return manifest, err
+	}
+
+	manifests, err := r.Manifests()
+	if err != nil {
+		return nil, err
+	}
+	for _, manifest := range manifests {
+		if manifest.Name == ref {
+			return manifest, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNoSnapshot, ref)
+}
+
+// Latest returns the most recent snapshot
+func (r *Repository) Latest() (*Manifest, error) {
+	ids, err := r.manifestIDs()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, ErrNoSnapshot
+	}
+	return r.Manifest(ids[len(ids)-1])
+}
+
+// Manifest reads and decrypts the manifest of the snapshot with id
+func (r *Repository) Manifest(id string) (*Manifest, error) {
+	if strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("%w: %s", ErrNoSnapshot, id)
+	}
+	encrypted, err := os.ReadFile(filepath.Join(r.dir, manifestsDir, id+suffix))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNoSnapshot, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	data, err := r.cypher.Decrypt(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt manifest %s: %w", id, err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", id, err)
+	}
+	return &manifest, nil
+}
+
+func (r *Repository) writeManifest(manifest *Manifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	encrypted, err := r.cypher.Encrypt(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt manifest: %w", err)
+	}
+
+	path := filepath.Join(r.dir, manifestsDir, manifest.ID+suffix)
+	if err := os.WriteFile(path+tempSuffix, encrypted, 0600); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return os.Rename(path+tempSuffix, path)
+}
+
+// manifestIDs returns the snapshot ids, oldest first
+func (r *Repository) manifestIDs() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.dir, manifestsDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list manifests: %w", err)
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasSuffix(name, suffix) {
+			ids = append(ids, strings.TrimSuffix(name, suffix))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (r *Repository) objectPath(name string) string {
+	return filepath.Join(r.dir, objectsDir, name+suffix)
+}
+
+// hasContent reports whether everything needed to restore entry is stored
+func (r *Repository) hasContent(entry Entry) bool {
+	if entry.Object != "" {
+		_, err := os.Stat(r.objectPath(entry.Object))
+		return err == nil
+	}
+	for _, id := range entry.Chunks {
+		if !r.chunks.Has(id) {
+			return false
+		}
+	}
+	return true
+}
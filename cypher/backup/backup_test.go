@@ -0,0 +1,197 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestBackupRestore(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "a.txt"), "first")
+	writeFile(t, filepath.Join(src, "dir", "b.txt"), "unchanged")
+	writeFile(t, filepath.Join(src, "copy.txt"), "unchanged")
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo, err := Open(cypher.NewCypher("test-key"), t.TempDir(), WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	first, err := repo.Backup(context.Background(), src)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if first.New != 2 || first.Unchanged != 1 {
+		t.Errorf("Expected 2 new and 1 deduplicated file, got %d and %d", first.New, first.Unchanged)
+	}
+
+	now = now.Add(time.Hour)
+	writeFile(t, filepath.Join(src, "a.txt"), "second")
+	writeFile(t, filepath.Join(src, "c.txt"), "added")
+	second, err := repo.Backup(context.Background(), src)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if second.New != 2 || second.Unchanged != 2 || second.BytesEncrypted != int64(len("second")+len("added")) {
+		t.Errorf("Expected only the changed files to be encrypted, got %+v", second)
+	}
+
+	dst := t.TempDir()
+	manifest, err := repo.RestoreAt(context.Background(), now.Add(-time.Minute), dst)
+	if err != nil {
+		t.Fatalf("RestoreAt failed: %v", err)
+	}
+	if manifest.ID != first.Manifest.ID {
+		t.Errorf("Expected the first snapshot, got %s", manifest.ID)
+	}
+	if got := readFile(t, filepath.Join(dst, "a.txt")); got != "first" {
+		t.Errorf("Expected first version of a.txt, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "c.txt")); !os.IsNotExist(err) {
+		t.Error("Expected c.txt to be missing from the first snapshot")
+	}
+
+	dst = t.TempDir()
+	if err := repo.Restore(context.Background(), second.Manifest.ID, dst); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	for path, want := range map[string]string{"a.txt": "second", "dir/b.txt": "unchanged", "c.txt": "added"} {
+		if got := readFile(t, filepath.Join(dst, path)); got != want {
+			t.Errorf("Expected %s to hold %q, got %q", path, want, got)
+		}
+	}
+
+	manifests, err := repo.Manifests()
+	if err != nil || len(manifests) != 2 {
+		t.Errorf("Expected 2 manifests, got %d: %v", len(manifests), err)
+	}
+	if _, err := repo.RestoreAt(context.Background(), now.Add(-48*time.Hour), t.TempDir()); err == nil {
+		t.Error("Expected error restoring before the first snapshot, got nil")
+	}
+}
+
+func TestBackupSharesChunksAcrossFiles(t *testing.T) {
+	src := t.TempDir()
+	shared := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(shared)
+	writeFile(t, filepath.Join(src, "a.bin"), string(shared)+"tail of a")
+	writeFile(t, filepath.Join(src, "b.bin"), string(shared)+"b has a different tail")
+
+	repo, err := Open(cypher.NewCypher("test-key"), t.TempDir(), WithChunkSizes(4*1024, 16*1024, 64*1024))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	summary, err := repo.Backup(context.Background(), src)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if summary.ReusedChunks == 0 || summary.BytesEncrypted >= int64(2*len(shared)) {
+		t.Errorf("Expected the shared content to be stored once, got %+v", summary)
+	}
+
+	dst := t.TempDir()
+	if err := repo.Restore(context.Background(), summary.Manifest.ID, dst); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if got := readFile(t, filepath.Join(dst, "b.bin")); got != string(shared)+"b has a different tail" {
+		t.Error("Restored b.bin doesn't match")
+	}
+}
+
+func TestNamedSnapshotsPrune(t *testing.T) {
+	src := t.TempDir()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo, err := Open(cypher.NewCypher("test-key"), t.TempDir(), WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	for i, name := range []string{"v1", "", "v3", ""} {
+		writeFile(t, filepath.Join(src, "file.txt"), "version "+string(rune('1'+i)))
+		if _, err := repo.BackupNamed(context.Background(), name, src); err != nil {
+			t.Fatalf("Backup %d failed: %v", i, err)
+		}
+		now = now.Add(time.Hour)
+	}
+	if _, err := repo.BackupNamed(context.Background(), "v1", src); !errors.Is(err, ErrSnapshotExists) {
+		t.Errorf("Expected ErrSnapshotExists, got %v", err)
+	}
+
+	dst := t.TempDir()
+	if err := repo.Restore(context.Background(), "v1", dst); err != nil {
+		t.Fatalf("Restore by name failed: %v", err)
+	}
+	if got := readFile(t, filepath.Join(dst, "file.txt")); got != "version 1" {
+		t.Errorf("Expected version 1, got %q", got)
+	}
+
+	result, err := repo.Prune(context.Background(), PrunePolicy{KeepNamed: true})
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if len(result.Removed) != 1 || result.ChunksRemoved != 1 {
+		t.Errorf("Expected the unnamed older snapshot and its chunk to be removed, got %+v", result)
+	}
+
+	result, err = repo.Delete(context.Background(), "v3")
+	if err != nil || result.ChunksRemoved != 1 {
+		t.Fatalf("Expected Delete to remove v3 and its chunk, got %+v: %v", result, err)
+	}
+	manifests, err := repo.Manifests()
+	if err != nil || len(manifests) != 2 || manifests[0].Name != "v1" {
+		t.Fatalf("Expected v1 and the latest snapshot to remain, got %d: %v", len(manifests), err)
+	}
+	dst = t.TempDir()
+	if err := repo.Restore(context.Background(), manifests[1].ID, dst); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if got := readFile(t, filepath.Join(dst, "file.txt")); got != "version 4" {
+		t.Errorf("Expected version 4, got %q", got)
+	}
+}
+
+func TestBackupExclude(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, cypher.IgnoreFile), "cache/\n")
+	writeFile(t, filepath.Join(src, "a.txt"), "a")
+	writeFile(t, filepath.Join(src, "cache", "b.txt"), "b")
+	writeFile(t, filepath.Join(src, "c.tmp"), "c")
+
+	repo, err := Open(cypher.NewCypher("test-key"), t.TempDir(), WithExclude("*.tmp", cypher.IgnoreFile))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	summary, err := repo.Backup(context.Background(), src)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if entries := summary.Manifest.Entries; len(entries) != 1 || entries[0].Path != "a.txt" {
+		t.Errorf("Expected only a.txt to be backed up, got %+v", entries)
+	}
+}
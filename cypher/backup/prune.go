@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PrunePolicy selects the snapshots Prune keeps. A snapshot is kept when any
+// rule keeps it, and the latest snapshot is always kept.
+type PrunePolicy struct {
+	// KeepLast keeps the most recent snapshots
+	KeepLast int
+	// KeepWithin keeps snapshots taken less than this long ago
+	KeepWithin time.Duration
+	// KeepNamed keeps every named snapshot
+	KeepNamed bool
+}
+
+// PruneResult reports what Prune or Delete removed
+type PruneResult struct {
+	// Removed lists the IDs of the removed snapshots
+	Removed       []string
+	ChunksRemoved int
+	BytesFreed    int64
+}
+
+// Prune removes the snapshots policy doesn't keep and then the chunks no
+// remaining snapshot refers to. It must not run concurrently with a backup.
+func (r *Repository) Prune(ctx context.Context, policy PrunePolicy) (*PruneResult, error) {
+	manifests, err := r.Manifests()
+	if err != nil {
+		return nil, err
+	}
+
+	now := r.now()
+	result := &PruneResult{}
+	for i, manifest := range manifests {
+		age := len(manifests) - 1 - i
+		keep := age == 0 ||
+			age < policy.KeepLast ||
+			(policy.KeepWithin > 0 && now.Sub(manifest.Time) < policy.KeepWithin) ||
+			(policy.KeepNamed && manifest.Name != "")
+		if keep {
+			continue
+		}
+		if err := r.removeManifest(manifest.ID); err != nil {
+			return result, err
+		}
+		result.Removed = append(result.Removed, manifest.ID)
+	}
+	return result, r.collectGarbage(ctx, result)
+}
+
+// Delete removes the snapshot with the given id or name and the chunks no
+// other snapshot refers to. It must not run concurrently with a backup.
+func (r *Repository) Delete(ctx context.Context, ref string) (*PruneResult, error) {
+	manifest, err := r.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.removeManifest(manifest.ID); err != nil {
+		return nil, err
+	}
+	result := &PruneResult{Removed: []string{manifest.ID}}
+	return result, r.collectGarbage(ctx, result)
+}
+
+func (r *Repository) removeManifest(id string) error {
+	if err := os.Remove(filepath.Join(r.dir, manifestsDir, id+suffix)); err != nil {
+		return fmt.Errorf("failed to remove snapshot %s: %w", id, err)
+	}
+	return nil
+}
+
+// collectGarbage removes the chunks and objects of no remaining snapshot
+func (r *Repository) collectGarbage(ctx context.Context, result *PruneResult) error {
+	manifests, err := r.Manifests()
+	if err != nil {
+		return err
+	}
+	used := make(map[string]bool)
+	for _, manifest := range manifests {
+		for _, entry := range manifest.Entries {
+			for _, id := range entry.Chunks {
+				used[id] = true
+			}
+			if entry.Object != "" {
+				used[entry.Object] = true
+			}
+		}
+	}
+
+	err = r.chunks.Walk(func(id string, size int64) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if used[id] {
+			return nil
+		}
+		if err := r.chunks.Delete(id); err != nil {
+			return err
+		}
+		result.ChunksRemoved++
+		result.BytesFreed += size
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove chunks: %w", err)
+	}
+
+	objects, err := os.ReadDir(filepath.Join(r.dir, objectsDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list objects: %w", err)
+	}
+	for _, object := range objects {
+		name, ok := strings.CutSuffix(object.Name(), suffix)
+		if !ok || used[name] {
+			continue
+		}
+		info, err := object.Info()
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(r.objectPath(name)); err != nil {
+			return fmt.Errorf("failed to remove object: %w", err)
+		}
+		result.BytesFreed += info.Size()
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+// Package cache caches values in a Store encrypted and authenticated with a
+// cypher.Cypher, so PII bearing responses never reach cache storage in the
+// clear. Entry names are HMACs of the cache keys, values are sealed together
+// with their name and expiry: a store can't read, swap or extend entries.
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+// ErrTampered is returned for entries that don't belong to the key they were
+// read for
+var ErrTampered = errors.New("cache entry has been tampered with")
+
+// nameSize is the length of hex encoded entry names
+const nameSize = 2 * sha256.Size
+
+// Cache maps string keys to values of type V, encoded as JSON. It is safe
+// for concurrent use.
+type Cache[V any] struct {
+	cypher     *cypher.Cypher
+	store      Store
+	nameKey    []byte
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu  sync.Mutex
+	lru *list.List
+	// entries indexes lru by entry name
+	entries map[string]*list.Element
+}
+
+// Option configures a Cache
+type Option func(*options)
+
+type options struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+}
+
+// WithTTL sets how long entries stay valid (default: 1 hour)
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) { o.ttl = ttl }
+}
+
+// WithMaxEntries evicts the least recently used entries this Cache wrote
+// once it holds more than n (default: unbounded)
+func WithMaxEntries(n int) Option {
+	return func(o *options) { o.maxEntries = n }
+}
+
+// WithClock sets the clock expiry is checked against, for tests
+func WithClock(now func() time.Time) Option {
+	return func(o *options) { o.now = now }
+}
+
+// New returns a cache sealing entries with c into store
+func New[V any](c *cypher.Cypher, store Store, opts ...Option) (*Cache[V], error) {
+	o := options{ttl: time.Hour, now: time.Now}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.ttl <= 0 {
+		return nil, fmt.Errorf("invalid cache TTL %v", o.ttl)
+	}
+	nameKey, err := c.DeriveSecret("cache entry names")
+	if err != nil {
+		return nil, err
+	}
+	return &Cache[V]{
+		cypher:     c,
+		store:      store,
+		nameKey:    nameKey,
+		ttl:        o.ttl,
+		maxEntries: o.maxEntries,
+		now:        o.now,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}, nil
+}
+
+// name returns the entry name of key
+func (c *Cache[V]) name(key string) string {
+	mac := hmac.New(sha256.New, c.nameKey)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Get returns the value cached for key. ok is false for missing and
+// expired entries.
+func (c *Cache[V]) Get(ctx context.Context, key string) (value V, ok bool, err error) {
+	name := c.name(key)
+	sealed, err := c.store.Get(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		c.forget(name)
+		return value, false, nil
+	}
+	if err != nil {
+		return value, false, fmt.Errorf("failed to read cache entry: %w", err)
+	}
+
+	plain, err := c.cypher.Decrypt(sealed)
+	if err != nil {
+		return value, false, fmt.Errorf("%w: %w", ErrTampered, err)
+	}
+	// name | expiry | JSON value
+	if len(plain) < nameSize+8 || !hmac.Equal(plain[:nameSize], []byte(name)) {
+		return value, false, ErrTampered
+	}
+	expiry := time.Unix(0, int64(binary.BigEndian.Uint64(plain[nameSize:])))
+	if !c.now().Before(expiry) {
+		c.forget(name)
+		return value, false, c.store.Delete(ctx, name)
+	}
+	if err := json.Unmarshal(plain[nameSize+8:], &value); err != nil {
+		return value, false, fmt.Errorf("failed to decode cache entry: %w", err)
+	}
+	c.touch(ctx, name)
+	return value, true, nil
+}
+
+// Set caches value for key
+func (c *Cache[V]) Set(ctx context.Context, key string, value V) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+
+	name := c.name(key)
+	var plain bytes.Buffer
+	plain.WriteString(name)
+	binary.Write(&plain, binary.BigEndian, uint64(c.now().Add(c.ttl).UnixNano()))
+	plain.Write(encoded)
+	sealed, err := c.cypher.Encrypt(plain.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to encrypt cache entry: %w", err)
+	}
+	if err := c.store.Set(ctx, name, sealed, c.ttl); err != nil {
+		return fmt.Errorf("failed to store cache entry: %w", err)
+	}
+	c.touch(ctx, name)
+	return nil
+}
+
+// Delete removes the value cached for key
+func (c *Cache[V]) Delete(ctx context.Context, key string) error {
+	name := c.name(key)
+	c.forget(name)
+	return c.store.Delete(ctx, name)
+}
+
+// touch marks name as the most recently used entry, evicting the least
+// recently used ones over the limit
+func (c *Cache[V]) touch(ctx context.Context, name string) {
+	if c.maxEntries <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	if element, ok := c.entries[name]; ok {
+		c.lru.MoveToFront(element)
+	} else {
+		c.entries[name] = c.lru.PushFront(name)
+	}
+	var evicted []string
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Remove(c.lru.Back()).(string)
+		delete(c.entries, oldest)
+		evicted = append(evicted, oldest)
+	}
+	c.mu.Unlock()
+
+	// Eviction is best effort, the store drops entries after their TTL
+	for _, name := range evicted {
+		c.store.Delete(ctx, name)
+	}
+}
+
+func (c *Cache[V]) forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[name]; ok {
+		c.lru.Remove(element)
+		delete(c.entries, name)
+	}
+}
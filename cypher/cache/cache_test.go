@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+type profile struct {
+	Email string
+	Age   int
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewDirStore(dir)
+	if err != nil {
+		t.Fatalf("NewDirStore failed: %v", err)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c, err := New[profile](cypher.NewCypher("test-key"), store, WithTTL(time.Minute), WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	want := profile{Email: "alice@example.com", Age: 42}
+	if err := c.Set(ctx, "user:alice@example.com", want); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 stored entry, got %d", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	if bytes.Contains(data, []byte("alice")) || bytes.Contains([]byte(files[0]), []byte("alice")) {
+		t.Error("Expected the entry and its name to be encrypted")
+	}
+
+	got, ok, err := c.Get(ctx, "user:alice@example.com")
+	if err != nil || !ok || got != want {
+		t.Fatalf("Expected %+v, got %+v, %v, %v", want, got, ok, err)
+	}
+
+	// Entries can't be moved to another key
+	other := filepath.Join(dir, c.name("user:mallory")+".entry")
+	if err := os.WriteFile(other, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Get(ctx, "user:mallory"); !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected ErrTampered, got %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok, err := c.Get(ctx, "user:alice@example.com"); ok || err != nil {
+		t.Errorf("Expected the entry to expire, got %v, %v", ok, err)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDirStore failed: %v", err)
+	}
+	c, err := New[int](cypher.NewCypher("test-key"), store, WithMaxEntries(2))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	c.Set(ctx, "a", 1)
+	c.Set(ctx, "b", 2)
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", 3)
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok, err := c.Get(ctx, key); ok != want || err != nil {
+			t.Errorf("Expected %s cached = %v, got %v: %v", key, want, ok, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNotFound is returned by stores for missing entries
+var ErrNotFound = errors.New("cache entry not found")
+
+// Store holds the sealed entries of a Cache. Names are opaque hex strings
+// and values are encrypted, so stores never see plaintext. Implementations
+// must be safe for concurrent use; a Redis store would map Set to SET with
+// an expiry of ttl.
+type Store interface {
+	// Get returns the value stored under name or ErrNotFound
+	Get(ctx context.Context, name string) ([]byte, error)
+	// Set stores value under name. ttl is how long the entry is valid,
+	// stores may drop it any time after that.
+	Set(ctx context.Context, name string, value []byte, ttl time.Duration) error
+	// Delete removes name, missing names are not an error
+	Delete(ctx context.Context, name string) error
+}
+
+// DirStore keeps entries as files of a directory. Expired entries are
+// removed when they are read.
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore returns a store in dir, creating it when missing
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+func (s *DirStore) path(name string) string {
+	return filepath.Join(s.dir, name+".entry")
+}
+
+// Get implements Store
+func (s *DirStore) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Set implements Store
+func (s *DirStore) Set(ctx context.Context, name string, value []byte, ttl time.Duration) error {
+	path := s.path(name)
+	temp, err := os.CreateTemp(s.dir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to store cache entry: %w", err)
+	}
+	if _, err := temp.Write(value); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to store cache entry: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to store cache entry: %w", err)
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to store cache entry: %w", err)
+	}
+	return nil
+}
+
+// Delete implements Store
+func (s *DirStore) Delete(ctx context.Context, name string) error {
+	if err := os.Remove(s.path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Package cas stores encrypted chunks addressed by content. A chunk's ID is
+// the HMAC of its plaintext under a key derived from the Cypher, so equal
+// chunks are stored once while IDs reveal nothing about the content to
+// anyone without the key.
+package cas
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+const (
+	suffix     = ".chunk"
+	tempSuffix = ".tmp"
+)
+
+var (
+	// ErrNotFound is returned for chunks missing from the store
+	ErrNotFound = errors.New("chunk not found")
+	// ErrCorrupt is returned when a chunk doesn't match its ID
+	ErrCorrupt = errors.New("chunk doesn't match its ID")
+)
+
+// Store is a directory of encrypted chunks
+type Store struct {
+	cypher *cypher.Cypher
+	dir    string
+	idKey  []byte
+}
+
+// Open opens the store in dir, creating it when missing
+func Open(c *cypher.Cypher, dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create chunk store: %w", err)
+	}
+	idKey, err := c.DeriveSecret("cas chunk ids")
+	if err != nil {
+		return nil, err
+	}
+	return &Store{cypher: c, dir: dir, idKey: idKey}, nil
+}
+
+// ID returns the ID data is stored under
+func (s *Store) ID(data []byte) string {
+	mac := hmac.New(sha256.New, s.idKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Put encrypts and stores data unless a chunk with the same content exists.
+// It reports whether the chunk was new.
+func (s *Store) Put(data []byte) (id string, stored bool, err error) {
+	id = s.ID(data)
+	if s.Has(id) {
+		return id, false, nil
+	}
+
+	encrypted, err := s.cypher.Encrypt(data)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to encrypt chunk: %w", err)
+	}
+
+	path := s.path(id)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", false, fmt.Errorf("failed to store chunk: %w", err)
+	}
+	if err := os.WriteFile(path+tempSuffix, encrypted, 0600); err != nil {
+		os.Remove(path + tempSuffix)
+		return "", false, fmt.Errorf("failed to store chunk: %w", err)
+	}
+	if err := os.Rename(path+tempSuffix, path); err != nil {
+		return "", false, fmt.Errorf("failed to store chunk: %w", err)
+	}
+	return id, true, nil
+}
+
+// Get returns the plaintext of the chunk with id, verifying that it matches
+// the ID so chunks can't be swapped
+func (s *Store) Get(id string) ([]byte, error) {
+	if !validID(id) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	encrypted, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk: %w", err)
+	}
+
+	data, err := s.cypher.Decrypt(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt chunk %s: %w", id, err)
+	}
+	if !cypher.EqualString(s.ID(data), id) {
+		return nil, fmt.Errorf("%w: %s", ErrCorrupt, id)
+	}
+	return data, nil
+}
+
+// Has reports whether the chunk with id is stored
+func (s *Store) Has(id string) bool {
+	if !validID(id) {
+		return false
+	}
+	_, err := os.Stat(s.path(id))
+	return err == nil
+}
+
+// Delete removes the chunk with id
+func (s *Store) Delete(id string) error {
+	if !validID(id) {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err := os.Remove(s.path(id)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		return err
+	}
+	return nil
+}
+
+// Walk calls fn with the ID and encrypted size of every stored chunk
+func (s *Store) Walk(fn func(id string, size int64) error) error {
+	return filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() || !strings.HasSuffix(name, suffix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(strings.TrimSuffix(name, suffix), info.Size())
+	})
+}
+
+// path spreads chunks over 256 directories by the first byte of their ID
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id[:2], id+suffix)
+}
+
+func validID(id string) bool {
+	if len(id) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,60 @@
+package cas
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+func TestPutGet(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(cypher.NewCypher("test-key"), dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	data := []byte("chunk content")
+	id, stored, err := s.Put(data)
+	if err != nil || !stored {
+		t.Fatalf("Expected Put to store the chunk, got %v %v", stored, err)
+	}
+	if _, stored, _ := s.Put(data); stored {
+		t.Error("Expected an equal chunk to be deduplicated")
+	}
+
+	got, err := s.Get(id)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Expected %q, got %q: %v", data, got, err)
+	}
+
+	other, err := Open(cypher.NewCypher("other-key"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID(data) == id {
+		t.Error("Expected IDs to depend on the key")
+	}
+
+	// A chunk moved under another ID must not be returned
+	otherID, _, err := s.Put([]byte("other content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(s.path(id), s.path(otherID)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(otherID); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt, got %v", err)
+	}
+	if _, err := s.Get(id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	count := 0
+	if err := s.Walk(func(string, int64) error { count++; return nil }); err != nil || count != 1 {
+		t.Errorf("Expected 1 chunk, got %d: %v", count, err)
+	}
+}
@@ -0,0 +1,128 @@
+package cypher
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// cdcParams are the chunk size bounds of content defined chunking
+type cdcParams struct {
+	min, avg, max int
+}
+
+// WithContentDefinedChunking cuts chunks where the content says so instead
+// of every ChunkSize bytes, using FastCDC with chunks of min to max bytes and
+// avg bytes on average. Inserting or removing bytes then only changes the
+// chunks around the edit, which keeps deduplicating and delta syncing
+// storage backends efficient. ChunkSize is set to max.
+func (c *Cypher) WithContentDefinedChunking(min, avg, max int) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.chunking = &cdcParams{min: min, avg: avg, max: max}
+	c.ChunkSize = max
+	return c
+}
+
+func (p *cdcParams) validate() error {
+	if p.min <= 0 || p.min >= p.avg || p.avg >= p.max || p.max > MaxChunkSize {
+		return fmt.Errorf("invalid content defined chunking sizes %d/%d/%d: need 0 < min < avg < max <= %d", p.min, p.avg, p.max, MaxChunkSize)
+	}
+	return nil
+}
+
+// gear maps every byte to a random value for the rolling hash. It is fixed
+// so equal content is always cut at the same places.
+var gear = func() (table [256]uint64) {
+	// splitmix64
+	state := uint64(0x676f637970686572)
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// cdcFrames reads content defined chunks. The hash is shifted left so its
+// top bits depend on the most bytes; below avg a stricter mask makes cuts
+// less likely and above it a looser one makes them more likely, which keeps
+// chunk sizes close to avg (FastCDC normalized chunking).
+func cdcFrames(p cdcParams, buffers *bufferPool) frameReader {
+	avgBits := bits.Len(uint(p.avg)) - 1
+	maskS := ^uint64(0) << (64 - (avgBits + 1))
+	maskL := ^uint64(0) << (64 - (avgBits - 1))
+
+	var buffered *bufio.Reader
+	return func(src io.Reader) ([]byte, int, error) {
+		if buffered == nil {
+			buffered = bufio.NewReaderSize(src, p.max)
+		}
+
+		window, err := buffered.Peek(p.max)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, 0, err
+		}
+		if len(window) == 0 {
+			return nil, 0, io.EOF
+		}
+
+		size := cutPoint(window, p, maskS, maskL)
+		frame := buffers.get(size)
+		copy(frame, window[:size])
+		if _, err := buffered.Discard(size); err != nil {
+			return nil, 0, err
+		}
+		return frame, size, nil
+	}
+}
+
+// cutPoint returns the length of the chunk at the start of data
+func cutPoint(data []byte, p cdcParams, maskS, maskL uint64) int {
+	if len(data) <= p.min {
+		return len(data)
+	}
+
+	var hash uint64
+	normal := min(p.avg, len(data))
+	for i := p.min; i < normal; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&maskS == 0 {
+			return i + 1
+		}
+	}
+	for i := normal; i < len(data); i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&maskL == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
+
+// Chunker splits a stream into content defined chunks, with the same
+// boundaries WithContentDefinedChunking uses
+type Chunker struct {
+	src  io.Reader
+	next frameReader
+}
+
+// NewChunker returns a Chunker reading chunks of min to max bytes, avg bytes
+// on average, from r
+func NewChunker(r io.Reader, min, avg, max int) (*Chunker, error) {
+	params := cdcParams{min: min, avg: avg, max: max}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	return &Chunker{src: r, next: cdcFrames(params, nil)}, nil
+}
+
+// Next returns the next chunk, or io.EOF after the last one
+func (ch *Chunker) Next() ([]byte, error) {
+	chunk, _, err := ch.next(ch.src)
+	return chunk, err
+}
@@ -0,0 +1,62 @@
+package columns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	c := cypher.NewCypher("test-key")
+	ctx := context.Background()
+	input := "name,email,country\nalice,alice@example.com,ES\n\"bob, jr\",bob@example.com,FR\n"
+
+	var sealed bytes.Buffer
+	rows, err := Encrypt(ctx, c, strings.NewReader(input), &sealed, []string{"name", "email"})
+	if err != nil || rows != 2 {
+		t.Fatalf("Expected 2 rows, got %d: %v", rows, err)
+	}
+	lines := strings.Split(sealed.String(), "\n")
+	if lines[0] != "name,email,country" || !strings.HasSuffix(lines[1], ",ES") || strings.Contains(sealed.String(), "example.com") {
+		t.Errorf("Expected the header and country to be kept and the rest encrypted, got\n%s", sealed.String())
+	}
+
+	var plain bytes.Buffer
+	if _, err := Decrypt(ctx, c, bytes.NewReader(sealed.Bytes()), &plain, []string{"name", "email"}); err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if plain.String() != input {
+		t.Errorf("Expected the original file, got\n%s", plain.String())
+	}
+
+	// Cells are bound to their column
+	swapped := strings.Replace(sealed.String(), "name,email", "email,name", 1)
+	if _, err := Decrypt(ctx, c, strings.NewReader(swapped), &plain, []string{"name", "email"}); !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected ErrTampered, got %v", err)
+	}
+	if _, err := Encrypt(ctx, c, strings.NewReader(input), &sealed, []string{"phone"}); !errors.Is(err, ErrUnknownColumn) {
+		t.Errorf("Expected ErrUnknownColumn, got %v", err)
+	}
+}
+
+func TestTSVWithoutHeader(t *testing.T) {
+	c := cypher.NewCypher("test-key")
+	ctx := context.Background()
+	input := "1\tsecret\tpublic\n2\tother secret\tpublic\n"
+
+	var sealed, plain bytes.Buffer
+	opts := []Option{WithComma('\t'), WithoutHeader()}
+	if _, err := Encrypt(ctx, c, strings.NewReader(input), &sealed, []string{"2"}, opts...); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if strings.Contains(sealed.String(), "secret") || strings.Count(sealed.String(), "\tpublic\n") != 2 {
+		t.Errorf("Expected only the second column to be encrypted, got\n%s", sealed.String())
+	}
+	if _, err := Decrypt(ctx, c, &sealed, &plain, []string{"2"}, opts...); err != nil || plain.String() != input {
+		t.Errorf("Expected the original file, got %q: %v", plain.String(), err)
+	}
+}
@@ -0,0 +1,75 @@
+package cypher
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"strings"
+)
+
+// Equal reports whether a and b are equal in time that depends only on their
+// lengths. Use it instead of bytes.Equal for MACs, hashes and other
+// verification values.
+func Equal(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// EqualString is like Equal for strings, such as hex encoded digests
+func EqualString(a, b string) bool {
+	return Equal([]byte(a), []byte(b))
+}
+
+// EqualHex compares two hex encoded values, ignoring case, in constant time.
+// It returns false when either is not valid hex.
+func EqualHex(a, b string) bool {
+	x, err := hex.DecodeString(strings.TrimSpace(a))
+	if err != nil {
+		return false
+	}
+	y, err := hex.DecodeString(strings.TrimSpace(b))
+	if err != nil {
+		return false
+	}
+	return Equal(x, y)
+}
+
+// EqualFingerprint reports whether two key fingerprints, as returned by
+// KeyFingerprint, identify the same key
+func EqualFingerprint(a, b string) bool {
+	return a != "" && b != "" && EqualHex(a, b)
+}
+
+// HasFingerprint reports whether c uses the key identified by fingerprint
+func (c Cypher) HasFingerprint(fingerprint string) bool {
+	return EqualFingerprint(c.KeyFingerprint(), fingerprint)
+}
+
+// SamePlaintext reports whether encA and encB, encrypted with the key of c,
+// decrypt to the same plaintext, so dedup and sync tools can match copies
+// encrypted with other salts, chunk sizes or compression. The format stores
+// no digest of the plaintext to compare, the header and ContentsFile only
+// cover the encrypted bytes, so both are decrypted a chunk at a time into a
+// SHA-256 digest; nothing is written out, but the cost is that of decrypting
+// both.
+func (c Cypher) SamePlaintext(ctx context.Context, encA, encB io.Reader) (bool, error) {
+	a, err := c.plaintextDigest(ctx, encA)
+	if err != nil {
+		return false, err
+	}
+	b, err := c.plaintextDigest(ctx, encB)
+	if err != nil {
+		return false, err
+	}
+	return Equal(a, b), nil
+}
+
+// plaintextDigest returns the SHA-256 digest of the decryption of src
+func (c Cypher) plaintextDigest(ctx context.Context, src io.Reader) ([]byte, error) {
+	digest := sha256.New()
+	if _, err := c.DecryptStream(ctx, src, digest); err != nil {
+		return nil, err
+	}
+	return digest.Sum(nil), nil
+}
@@ -0,0 +1,221 @@
+package cypher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the algorithm chunks are compressed with before sealing
+type Compression uint8
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZstd
+)
+
+func (a Compression) String() string {
+	switch a {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Compression(%d)", uint8(a))
+	}
+}
+
+// compressionChunkFlags in the compression record marks data whose chunks
+// start with a chunkRaw or chunkCompressed flag
+const compressionChunkFlags = 0x01
+
+const (
+	chunkRaw        = 0x00
+	chunkCompressed = 0x01
+)
+
+// compressionSample is how much of a chunk is compressed first to decide
+// whether compressing the rest is worth it
+const compressionSample = 64 * 1024
+
+// ErrChunkTooLarge is returned when a chunk decompresses to more than the
+// chunk size recorded in the header
+var ErrChunkTooLarge = errors.New("decompressed chunk exceeds the chunk size")
+
+// WithCompression compresses every chunk with algo before it is sealed. The
+// algorithm is recorded in the header so decryption decompresses
+// transparently. A level of 0 selects the default level of the algorithm.
+func (c *Cypher) WithCompression(algo Compression, level int) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.compression, c.compressionLevel = algo, level
+	return c
+}
+
+// compressor returns the function compressing chunks for algo
+func compressor(algo Compression, level int) (func([]byte) ([]byte, error), error) {
+	switch algo {
+	case CompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+			return nil, err
+		}
+		return func(data []byte) ([]byte, error) {
+			var buf bytes.Buffer
+			w, _ := gzip.NewWriterLevel(&buf, level)
+			if _, err := w.Write(data); err != nil {
+				return nil, err
+			}
+			if err := w.Close(); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		}, nil
+	case CompressionZstd:
+		encoder, err := zstdEncoder(level)
+		if err != nil {
+			return nil, err
+		}
+		return func(data []byte) ([]byte, error) {
+			return encoder.EncodeAll(data, nil), nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %v", algo)
+	}
+}
+
+// decompressor returns the function decompressing chunks for algo, refusing
+// chunks that grow past maxSize
+func decompressor(algo Compression, maxSize int) (func([]byte) ([]byte, error), error) {
+	switch algo {
+	case CompressionGzip:
+		return func(data []byte) ([]byte, error) {
+			r, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress chunk: %w", err)
+			}
+			plaintext, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress chunk: %w", err)
+			}
+			if len(plaintext) > maxSize {
+				wipe(plaintext)
+				return nil, ErrChunkTooLarge
+			}
+			return plaintext, nil
+		}, nil
+	case CompressionZstd:
+		decoder, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		return func(data []byte) ([]byte, error) {
+			plaintext, err := decoder.DecodeAll(data, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress chunk: %w", err)
+			}
+			if len(plaintext) > maxSize {
+				wipe(plaintext)
+				return nil, ErrChunkTooLarge
+			}
+			return plaintext, nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported compression %v", ErrInvalidHeader, algo)
+	}
+}
+
+// zstd encoders and the decoder are safe for concurrent EncodeAll and
+// DecodeAll calls, so they are shared by all operations
+var (
+	zstdMu       sync.Mutex
+	zstdEncoders = make(map[zstd.EncoderLevel]*zstd.Encoder)
+	zstdDecoder  = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxChunkSize))
+	})
+)
+
+func zstdEncoder(level int) (*zstd.Encoder, error) {
+	encoderLevel := zstd.SpeedDefault
+	if level != 0 {
+		encoderLevel = zstd.EncoderLevelFromZstd(level)
+	}
+
+	zstdMu.Lock()
+	defer zstdMu.Unlock()
+
+	if encoder, ok := zstdEncoders[encoderLevel]; ok {
+		return encoder, nil
+	}
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(encoderLevel))
+	if err != nil {
+		return nil, err
+	}
+	zstdEncoders[encoderLevel] = encoder
+	return encoder, nil
+}
+
+// compressChunk returns data prefixed with a flag, compressed when that makes
+// it smaller. Chunks whose first compressionSample bytes barely compress,
+// such as media or archives, are stored raw without compressing the rest.
+func compressChunk(compress func([]byte) ([]byte, error), data []byte) ([]byte, error) {
+	if len(data) > compressionSample {
+		sample, err := compress(data[:compressionSample])
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress chunk: %w", err)
+		}
+		wipe(sample)
+		if len(sample) >= compressionSample*95/100 {
+			return flagChunk(chunkRaw, data), nil
+		}
+	}
+
+	compressed, err := compress(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress chunk: %w", err)
+	}
+	defer wipe(compressed)
+	if len(compressed) >= len(data) {
+		return flagChunk(chunkRaw, data), nil
+	}
+	return flagChunk(chunkCompressed, compressed), nil
+}
+
+func flagChunk(flag byte, data []byte) []byte {
+	chunk := make([]byte, 1+len(data))
+	chunk[0] = flag
+	copy(chunk[1:], data)
+	return chunk
+}
+
+// decompressChunk undoes compressChunk on an opened chunk and wipes it
+func decompressChunk(op *operation, plaintext []byte) ([]byte, error) {
+	defer op.buffers.put(plaintext)
+	defer wipe(plaintext)
+	if !op.chunkFlags {
+		return op.decompress(plaintext)
+	}
+
+	if len(plaintext) == 0 {
+		return nil, errors.New("compressed chunk is missing its flag")
+	}
+	switch plaintext[0] {
+	case chunkRaw:
+		return bytes.Clone(plaintext[1:]), nil
+	case chunkCompressed:
+		return op.decompress(plaintext[1:])
+	default:
+		return nil, fmt.Errorf("unknown chunk flag %d", plaintext[0])
+	}
+}
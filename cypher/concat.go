@@ -0,0 +1,318 @@
+package cypher
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// A concatenation of encrypted parts starts with an index of their headers:
+//
+//	magic "GOCC" | version | part count (4 bytes) |
+//	per part: chunk count (8 bytes) | header length (4 bytes) | header
+//
+// followed by the chunks of every part, in order. Each chunk keeps the
+// additional data of its part: the hash of the part header and its position
+// within the part.
+const (
+	ConcatMagic   = "GOCC"
+	ConcatVersion = 1
+
+	// maxConcatParts bounds the parts of a concatenation
+	maxConcatParts = 1 << 20
+	// maxHeaderSize bounds the size of a part header
+	maxHeaderSize = 1 << 20
+)
+
+// ConcatEncrypted writes parts, each encrypted on its own, to dst as a single
+// container that decrypts to their plaintexts one after the other. It needs
+// no key: the chunks are copied as they are, and the header of every part,
+// with its salt and key commitment, goes to the index at the start of dst,
+// so a server can assemble sharded uploads without access to the plaintext.
+// The parts have to be encrypted under the same key, with the same
+// compression, and without delta friendly output or holes.
+//
+// Every chunk stays bound to its part and position within it, so chunks
+// can't be moved between parts or reordered, and the trailer of every part
+// is copied along to detect its truncation. The index itself isn't
+// authenticated: reordering or dropping whole parts goes unnoticed.
+func ConcatEncrypted(dst io.Writer, parts ...io.ReadSeeker) error {
+	index := bytes.NewBufferString(ConcatMagic)
+	index.WriteByte(ConcatVersion)
+	index.Write(binary.BigEndian.AppendUint32(nil, uint32(len(parts))))
+	if len(parts) > maxConcatParts {
+		return fmt.Errorf("can't concatenate more than %d parts", maxConcatParts)
+	}
+
+	sizes := make([]int64, len(parts))
+	var first *Header
+	for i, part := range parts {
+		h, chunks, size, err := scanPart(part)
+		if err != nil {
+			return fmt.Errorf("part %d: %w", i, err)
+		}
+		if first == nil {
+			first = h
+		} else if h.Compression != first.Compression || h.ChunkFlags != first.ChunkFlags {
+			return fmt.Errorf("part %d: compressed unlike part 0", i)
+		}
+		index.Write(binary.BigEndian.AppendUint64(nil, uint64(chunks)))
+		index.Write(binary.BigEndian.AppendUint32(nil, uint32(len(h.Raw))))
+		index.Write(h.Raw)
+		sizes[i] = size
+	}
+	if _, err := dst.Write(index.Bytes()); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+
+	for i, part := range parts {
+		if _, err := io.CopyN(dst, part, sizes[i]); err != nil {
+			return fmt.Errorf("failed to copy part %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// scanPart parses the header of part and counts its chunks, leaving part at
+// the first of them. It returns their size, with the trailer after them.
+func scanPart(part io.ReadSeeker) (h *Header, chunks, size int64, err error) {
+	start, err := part.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if h, err = ParseHeader(part, ParseLenient); err != nil {
+		return nil, 0, 0, err
+	}
+	switch {
+	case h.Deterministic:
+		return nil, 0, 0, errors.New("can't concatenate delta friendly data, its trailer covers every chunk")
+	case len(h.Holes) > 0:
+		return nil, 0, 0, errors.New("can't concatenate sparse files")
+	case len(h.Raw) > maxHeaderSize:
+		return nil, 0, 0, fmt.Errorf("%w: header too large", ErrInvalidHeader)
+	}
+
+	chunksStart := start + int64(len(h.Raw))
+	trailed := false
+	var prefix [FrameLengthSize]byte
+	for !trailed {
+		if _, err := io.ReadFull(part, prefix[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, 0, fmt.Errorf("truncated chunk length: %w", err)
+		}
+		frame := int64(binary.BigEndian.Uint32(prefix[:]))
+		if h.Trailer && isTrailer(prefix[:]) {
+			// The key is needed to check it, decryption does
+			frame, trailed = streamTrailerSize-FrameLengthSize, true
+		} else {
+			chunks++
+		}
+		if _, err := part.Seek(frame, io.SeekCurrent); err != nil {
+			return nil, 0, 0, err
+		}
+		size += FrameLengthSize + frame
+	}
+	if h.Trailer && !trailed {
+		return nil, 0, 0, ErrTruncated
+	}
+	// Seeking past the end doesn't fail, the size has to match
+	end, err := part.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if actual, err := part.Seek(0, io.SeekEnd); err != nil || actual < end {
+		return nil, 0, 0, errors.New("truncated chunk")
+	} else if actual > end {
+		return nil, 0, 0, fmt.Errorf("%w: data after the trailer", ErrChunksModified)
+	}
+	if _, err := part.Seek(chunksStart, io.SeekStart); err != nil {
+		return nil, 0, 0, err
+	}
+	return h, chunks, size, nil
+}
+
+// prepareConcatDecrypt reads the index of a concatenation, whose magic has
+// been consumed, and sets op up to open the chunks of every part with its
+// own key
+func (c Cypher) prepareConcatDecrypt(op *operation, src io.Reader) (io.Reader, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(src, prefix[:]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	if prefix[0] != ConcatVersion {
+		return nil, fmt.Errorf("%w: unsupported concatenation version %d", ErrInvalidHeader, prefix[0])
+	}
+	count := binary.BigEndian.Uint32(prefix[1:])
+	if count > maxConcatParts {
+		return nil, fmt.Errorf("%w: %d parts", ErrInvalidHeader, count)
+	}
+
+	var parts []openPart
+	var first *header
+	position, maxChunk, maxFrame, indexSize := 0, 0, 0, len(ConcatMagic)+len(prefix)
+	for i := 0; i < int(count); i++ {
+		var sizes [12]byte
+		if _, err := io.ReadFull(src, sizes[:]); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+		}
+		chunks, size := binary.BigEndian.Uint64(sizes[:]), binary.BigEndian.Uint32(sizes[8:])
+		if size > maxHeaderSize || size < uint32(len(FormatMagic)) || chunks > math.MaxInt32 {
+			return nil, fmt.Errorf("%w: bad part %d", ErrInvalidHeader, i)
+		}
+		raw := make([]byte, size)
+		if _, err := io.ReadFull(src, raw); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+		}
+		indexSize += len(sizes) + len(raw)
+		if string(raw[:len(FormatMagic)]) != FormatMagic {
+			return nil, fmt.Errorf("%w: part %d has no header", ErrInvalidHeader, i)
+		}
+		r := bytes.NewReader(raw[len(FormatMagic):])
+		h, err := readHeader(r, c.parseMode)
+		if err != nil {
+			return nil, fmt.Errorf("part %d: %w", i, err)
+		}
+		if r.Len() > 0 {
+			return nil, fmt.Errorf("%w: part %d header has trailing data", ErrInvalidHeader, i)
+		}
+		if h.deterministic || len(h.holes) > 0 {
+			return nil, fmt.Errorf("%w: part %d is delta friendly or sparse", ErrInvalidHeader, i)
+		}
+		if first == nil {
+			first = h
+		} else if h.compression != first.compression || h.chunkFlags != first.chunkFlags {
+			return nil, fmt.Errorf("%w: part %d compressed unlike part 0", ErrInvalidHeader, i)
+		}
+		if err := c.checkGeneration(h.generation); err != nil {
+			return nil, err
+		}
+		if err := c.checkExpiry(h.expiry); err != nil {
+			return nil, err
+		}
+		gcm, err := c.fileGCM(h)
+		if err != nil {
+			return nil, fmt.Errorf("part %d: %w", i, err)
+		}
+		c.observeCounter(h.counter)
+
+		part := openPart{gcm: gcm, aad: headerAAD(h), first: position, chunks: int(chunks)}
+		if h.trailer {
+			if part.trailerKey, err = c.streamTrailerKey(h); err != nil {
+				return nil, err
+			}
+		}
+		parts = append(parts, part)
+		position += int(chunks)
+		frame := int(h.chunkSize) + gcm.NonceSize() + gcm.Overhead()
+		if h.chunkFlags {
+			frame++
+		}
+		maxChunk, maxFrame = max(maxChunk, int(h.chunkSize)), max(maxFrame, frame)
+	}
+
+	op.headerSize = indexSize
+	// The additional data handed to transform is the position alone,
+	// openConcatChunk binds it to the part
+	op.aad = []byte{}
+	op.transform = openConcatChunk(parts)
+	op.readFrame = concatFrames(maxFrame, op.buffers, parts)
+	if len(parts) == 0 {
+		return src, nil
+	}
+	op.gcm = parts[0].gcm
+	if first.compression != CompressionNone {
+		var err error
+		if op.decompress, err = decompressor(first.compression, maxChunk); err != nil {
+			return nil, err
+		}
+		op.chunkFlags = first.chunkFlags
+	} else {
+		op.outputSize = openedSize(op.gcm)
+	}
+	return src, nil
+}
+
+// openPart is a part of a concatenation being decrypted
+type openPart struct {
+	gcm cipher.AEAD
+	aad []byte
+	// first is the position of its first chunk in the concatenation
+	first, chunks int
+	// trailerKey is the key of the trailer ending its chunks, nil when it
+	// has none
+	trailerKey []byte
+}
+
+// openConcatChunk opens the chunk at the position aad holds with the key of
+// its part
+func openConcatChunk(parts []openPart) chunkFunc {
+	return func(op *operation, aad, data []byte) ([]byte, error) {
+		position := int(binary.BigEndian.Uint64(aad))
+		i := sort.Search(len(parts), func(i int) bool { return parts[i].first+parts[i].chunks > position })
+		if i == len(parts) {
+			return nil, fmt.Errorf("chunk %d is past the last part", position)
+		}
+		part := parts[i]
+		partAAD := binary.BigEndian.AppendUint64(append([]byte(nil), part.aad...), uint64(position-part.first))
+		return openFrame(op, part.gcm, partAAD, data)
+	}
+}
+
+// concatFrames reads the chunks of parts one after the other, checking the
+// trailer ending each part that has one, and fails on a short or longer
+// input
+func concatFrames(maxFrame int, buffers *bufferPool, parts []openPart) frameReader {
+	part, read := 0, 0
+	var frames frameReader
+	if len(parts) > 0 {
+		frames = parts[0].frames(maxFrame, buffers)
+	}
+	return func(src io.Reader) ([]byte, int, error) {
+		for part < len(parts) {
+			if read < parts[part].chunks {
+				data, consumed, err := frames(src)
+				if err == io.EOF {
+					err = fmt.Errorf("%w: %d of %d chunks", ErrTruncated, read, parts[part].chunks)
+				}
+				if err != nil {
+					return nil, 0, fmt.Errorf("part %d: %w", part, err)
+				}
+				read++
+				return data, consumed, nil
+			}
+
+			if parts[part].trailerKey != nil {
+				// Only the trailer is left
+				if _, _, err := frames(src); err != io.EOF {
+					if err == nil {
+						err = fmt.Errorf("%w: more chunks than indexed", ErrChunksModified)
+					}
+					return nil, 0, fmt.Errorf("part %d: %w", part, err)
+				}
+			}
+			part, read = part+1, 0
+			if part < len(parts) {
+				frames = parts[part].frames(maxFrame, buffers)
+			}
+		}
+
+		if _, err := io.ReadFull(src, make([]byte, 1)); err != io.EOF {
+			return nil, 0, errors.New("data after the last part")
+		}
+		return nil, 0, io.EOF
+	}
+}
+
+// frames returns the reader of the frames of p
+func (p openPart) frames(maxFrame int, buffers *bufferPool) frameReader {
+	if p.trailerKey == nil {
+		return lengthFrames(maxFrame, buffers)
+	}
+	return streamFrames(maxFrame, buffers, p.trailerKey, p.aad, false)
+}
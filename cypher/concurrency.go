@@ -0,0 +1,63 @@
+package cypher
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrConfiguredAfterUse is returned by Validate, Err and every operation once
+// the settings of a Cypher were changed after it started operations
+var ErrConfiguredAfterUse = errors.New("cypher configured after it was used")
+
+// configGuard is shared by a Cypher and its copies. It notices settings
+// changed after the Cypher started operations, which would race with them.
+type configGuard struct {
+	used atomic.Bool
+	// rejected is set when a With method was called after use
+	rejected atomic.Bool
+	// fields holds ChunkSize, NumWorkers and NumCores at first use, the
+	// exported settings don't go through configure
+	fields atomic.Pointer[[3]int]
+}
+
+// exported returns the exported settings of c, see configGuard.fields
+func (c Cypher) exported() [3]int {
+	return [3]int{c.ChunkSize, c.NumWorkers, c.NumCores}
+}
+
+// markUsed records that c started an operation
+func (c Cypher) markUsed() {
+	if c.guard != nil && !c.guard.used.Load() {
+		fields := c.exported()
+		c.guard.fields.CompareAndSwap(nil, &fields)
+		c.guard.used.Store(true)
+	}
+}
+
+// configure is called by the With methods before they change c, which they
+// only do when it returns true. Once c has been used its settings are
+// immutable: a change would race with running operations, so it is ignored,
+// logged and remembered for checkConfigured.
+func (c *Cypher) configure() bool {
+	if c.guard == nil || !c.guard.used.Load() {
+		return true
+	}
+	c.guard.rejected.Store(true)
+	c.log().Warn("cypher reconfigured after it was used, the change is ignored; configure a Clone instead")
+	return false
+}
+
+// checkConfigured returns ErrConfiguredAfterUse when a With method was
+// rejected or an exported setting differs from its value at first use
+func (c Cypher) checkConfigured() error {
+	if c.guard == nil {
+		return nil
+	}
+	if c.guard.rejected.Load() {
+		return ErrConfiguredAfterUse
+	}
+	if fields := c.guard.fields.Load(); fields != nil && *fields != c.exported() {
+		return ErrConfiguredAfterUse
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+// Package config loads configuration files whose values are encrypted while
+// their keys stay readable, like sops. YAML, JSON and .env files are
+// supported, the format is chosen from the file name.
+//
+// Encrypted values look like ENC[gocypher,<base64>] and are bound to the path
+// of their key, so they can't be moved to another key. Comments, the order of
+// keys and the types of values are kept when a file is edited.
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown configuration format")
+	// ErrNotEncrypted is returned by Load for values left in plaintext
+	ErrNotEncrypted = errors.New("configuration value is not encrypted")
+	// ErrTampered is returned for values that fail to decrypt or belong to
+	// another key
+	ErrTampered = errors.New("configuration value has been tampered with")
+)
+
+// Format of a configuration file
+type Format int
+
+const (
+	YAML Format = iota
+	JSON
+	// Env files hold KEY=value lines
+	Env
+)
+
+// FormatOf returns the format of the file at path from its name. Files named
+// .env, .env.* or *.env are Env files.
+func FormatOf(path string) (Format, error) {
+	base := strings.ToLower(filepath.Base(path))
+	switch {
+	case strings.HasSuffix(base, ".yaml"), strings.HasSuffix(base, ".yml"):
+		return YAML, nil
+	case strings.HasSuffix(base, ".json"):
+		return JSON, nil
+	case base == ".env", strings.HasPrefix(base, ".env."), strings.HasSuffix(base, ".env"):
+		return Env, nil
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnknownFormat, path)
+}
+
+// Load decrypts the configuration file at path and unmarshals it into v with
+// its yaml or json tags, or its env tags for Env files, see Unmarshal. Every
+// value of the file must be encrypted.
+func Load(c *cypher.Cypher, path string, v any) error {
+	format, err := FormatOf(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration: %w", err)
+	}
+	plain, err := transform(data, format, func(path []string, meta, value string) (string, string, error) {
+		if !isSealed(value) {
+			return "", "", fmt.Errorf("%w: %s", ErrNotEncrypted, pathString(path))
+		}
+		return open(c, path, meta, value)
+	})
+	if err != nil {
+		return err
+	}
+	return Unmarshal(plain, format, v)
+}
+
+// Encrypt returns data with its plaintext values encrypted, values already
+// encrypted are kept
+func Encrypt(c *cypher.Cypher, data []byte, format Format) ([]byte, error) {
+	return transform(data, format, func(path []string, meta, value string) (string, string, error) {
+		if isSealed(value) {
+			return meta, value, nil
+		}
+		return seal(c, path, meta, value)
+	})
+}
+
+// Decrypt returns data with its values decrypted, values left in plaintext
+// are kept
+func Decrypt(c *cypher.Cypher, data []byte, format Format) ([]byte, error) {
+	return transform(data, format, func(path []string, meta, value string) (string, string, error) {
+		if !isSealed(value) {
+			return meta, value, nil
+		}
+		return open(c, path, meta, value)
+	})
+}
+
+// Edit decrypts the configuration file at path, passes the document to edit
+// and writes what it returns back with its values encrypted. The file is
+// replaced atomically and keeps its mode.
+func Edit(c *cypher.Cypher, path string, edit func(plain []byte) ([]byte, error)) error {
+	format, err := FormatOf(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration: %w", err)
+	}
+	plain, err := Decrypt(c, data, format)
+	if err != nil {
+		return err
+	}
+	edited, err := edit(plain)
+	if err != nil {
+		return err
+	}
+	sealed, err := Encrypt(c, edited, format)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, sealed, info.Mode().Perm())
+}
+
+// writeFile replaces the file at path by data
+func writeFile(path string, data []byte, mode os.FileMode) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write configuration: %w", err)
+	}
+	if _, err := temp.Write(data); err == nil {
+		err = temp.Chmod(mode)
+	}
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to write configuration: %w", err)
+	}
+	return nil
+}
+
+// transformFunc returns the new meta data and value of the value at path
+type transformFunc func(path []string, meta, value string) (string, string, error)
+
+// transform calls fn for every value of data
+func transform(data []byte, format Format, fn transformFunc) ([]byte, error) {
+	switch format {
+	case YAML:
+		return transformYAML(data, fn)
+	case JSON:
+		return transformJSON(data, fn)
+	case Env:
+		return transformEnv(data, fn)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Unmarshal parses a decrypted configuration document into v. Env documents
+// are parsed into a *map[string]string or a pointer to a struct whose fields
+// are tagged with their variable names, such as `env:"DB_PASSWORD"`.
+func Unmarshal(plain []byte, format Format, v any) error {
+	switch format {
+	case YAML:
+		return unmarshalYAML(plain, v)
+	case JSON:
+		return unmarshalJSON(plain, v)
+	case Env:
+		return unmarshalEnv(plain, v)
+	}
+	return ErrUnknownFormat
+}
+
+const (
+	sealedPrefix = "ENC[gocypher,"
+	sealedSuffix = "]"
+)
+
+func isSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix) && strings.HasSuffix(value, sealedSuffix)
+}
+
+// location identifies a value by the path of its key, it prefixes the
+// plaintext so values can't be moved
+func location(path []string) []byte {
+	loc := binary.BigEndian.AppendUint32(nil, uint32(len(path)))
+	for _, segment := range path {
+		loc = binary.BigEndian.AppendUint32(loc, uint32(len(segment)))
+		loc = append(loc, segment...)
+	}
+	return loc
+}
+
+// seal encrypts value with its meta data, which is replaced by that of a
+// string
+func seal(c *cypher.Cypher, path []string, meta, value string) (string, string, error) {
+	plain := binary.BigEndian.AppendUint32(location(path), uint32(len(meta)))
+	plain = append(append(plain, meta...), value...)
+	sealed, err := c.Encrypt(plain)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encrypt %s: %w", pathString(path), err)
+	}
+	return "", sealedPrefix + base64.StdEncoding.EncodeToString(sealed) + sealedSuffix, nil
+}
+
+// open decrypts the value sealed at path and returns it with its meta data
+func open(c *cypher.Cypher, path []string, _, value string) (string, string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(value[len(sealedPrefix) : len(value)-len(sealedSuffix)])
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s", ErrTampered, pathString(path))
+	}
+	plain, err := c.Decrypt(sealed)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s: %w", ErrTampered, pathString(path), err)
+	}
+	loc := location(path)
+	if !bytes.HasPrefix(plain, loc) || len(plain) < len(loc)+4 {
+		return "", "", fmt.Errorf("%w: %s", ErrTampered, pathString(path))
+	}
+	rest := plain[len(loc):]
+	n := uint64(binary.BigEndian.Uint32(rest))
+	if uint64(len(rest)) < 4+n {
+		return "", "", fmt.Errorf("%w: %s", ErrTampered, pathString(path))
+	}
+	return string(rest[4 : 4+n]), string(rest[4+n:]), nil
+}
+
+// pathString returns path in the a.b[0] notation
+func pathString(path []string) string {
+	var b strings.Builder
+	for _, segment := range path {
+		if strings.HasPrefix(segment, ".") && b.Len() == 0 {
+			segment = segment[1:]
+		}
+		b.WriteString(segment)
+	}
+	return b.String()
+}
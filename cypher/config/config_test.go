@@ -0,0 +1,121 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+type appConfig struct {
+	Database struct {
+		Host     string `yaml:"host" json:"host"`
+		Port     int    `yaml:"port" json:"port"`
+		Password string `yaml:"password" json:"password"`
+	} `yaml:"database" json:"database"`
+	Debug bool     `yaml:"debug" json:"debug"`
+	Hosts []string `yaml:"hosts" json:"hosts"`
+}
+
+func encryptFile(t *testing.T, c *cypher.Cypher, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	format, err := FormatOf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := Encrypt(c, []byte(content), format)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if err := os.WriteFile(path, sealed, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	c := cypher.NewCypher("test-key")
+	documents := map[string]string{
+		"app.yaml": "# database settings\ndatabase:\n  host: db.internal\n  port: 5432\n  password: \"s3cret\"\ndebug: true\nhosts: [a, b]\n",
+		"app.json": `{"database": {"host": "db.internal", "port": 5432, "password": "s3cret"}, "debug": true, "hosts": ["a", "b"]}`,
+	}
+	for name, content := range documents {
+		path := encryptFile(t, c, name, content)
+		sealed, _ := os.ReadFile(path)
+		if strings.Contains(string(sealed), "s3cret") || !strings.Contains(string(sealed), "password") {
+			t.Errorf("%s: expected readable keys and encrypted values, got\n%s", name, sealed)
+		}
+
+		var cfg appConfig
+		if err := Load(c, path, &cfg); err != nil {
+			t.Fatalf("%s: Load failed: %v", name, err)
+		}
+		if cfg.Database.Host != "db.internal" || cfg.Database.Port != 5432 || cfg.Database.Password != "s3cret" || !cfg.Debug || len(cfg.Hosts) != 2 {
+			t.Errorf("%s: unexpected configuration %+v", name, cfg)
+		}
+		if err := Load(cypher.NewCypher("wrong-key"), path, &cfg); !errors.Is(err, ErrTampered) {
+			t.Errorf("%s: expected ErrTampered with the wrong key, got %v", name, err)
+		}
+	}
+
+	path := encryptFile(t, c, ".env", "# comment\nexport DB_PASSWORD=\"s3cret\"\nTIMEOUT=5s # seconds\nWORKERS=4\n")
+	var env struct {
+		Password string        `env:"DB_PASSWORD"`
+		Timeout  time.Duration `env:"TIMEOUT"`
+		Workers  int           `env:"WORKERS"`
+	}
+	if err := Load(c, path, &env); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if env.Password != "s3cret" || env.Timeout != 5*time.Second || env.Workers != 4 {
+		t.Errorf("Unexpected env configuration %+v", env)
+	}
+}
+
+func TestEdit(t *testing.T) {
+	c := cypher.NewCypher("test-key")
+	original := "# database settings\ndatabase:\n  port: 5432\n  password: \"s3cret\"\n"
+	path := encryptFile(t, c, "app.yaml", original)
+
+	err := Edit(c, path, func(plain []byte) ([]byte, error) {
+		if string(plain) != original {
+			t.Errorf("Expected the original document, got\n%s", plain)
+		}
+		return append(plain, "debug: true\n"...), nil
+	})
+	if err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the mode to be kept, got %v", info.Mode())
+	}
+	var cfg appConfig
+	if err := Load(c, path, &cfg); err != nil || !cfg.Debug || cfg.Database.Password != "s3cret" {
+		t.Errorf("Expected the edited configuration, got %+v: %v", cfg, err)
+	}
+}
+
+func TestTampering(t *testing.T) {
+	c := cypher.NewCypher("test-key")
+	path := encryptFile(t, c, ".env", "USER=admin\nPASSWORD=s3cret\n")
+	sealed, _ := os.ReadFile(path)
+	lines := strings.Split(string(sealed), "\n")
+
+	// Values can't be swapped between keys
+	swapped := strings.Join([]string{"USER" + lines[1][len("PASSWORD"):], "PASSWORD" + lines[0][len("USER"):], ""}, "\n")
+	os.WriteFile(path, []byte(swapped), 0600)
+	var values map[string]string
+	if err := Load(c, path, &values); !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected ErrTampered, got %v", err)
+	}
+
+	os.WriteFile(path, []byte(lines[0]+"\nPASSWORD=plain\n"), 0600)
+	if err := Load(c, path, &values); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("Expected ErrNotEncrypted, got %v", err)
+	}
+}
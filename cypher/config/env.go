@@ -0,0 +1,174 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envLine is a KEY=value line of an Env document, comments and blank lines
+// only have a prefix
+type envLine struct {
+	// prefix is the line up to and including "="
+	prefix string
+	key    string
+	// value is the raw value, with its quotes and comment
+	value string
+	end   string
+}
+
+func parseEnv(data []byte) ([]envLine, error) {
+	var lines []envLine
+	for i, raw := range strings.SplitAfter(string(data), "\n") {
+		if raw == "" {
+			continue
+		}
+		text := strings.TrimRight(raw, "\r\n")
+		line := envLine{prefix: text, end: raw[len(text):]}
+		if trimmed := strings.TrimSpace(text); trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			eq := strings.IndexByte(text, '=')
+			if eq < 0 {
+				return nil, fmt.Errorf("failed to parse env configuration: line %d: expected KEY=value", i+1)
+			}
+			key := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text[:eq]), "export "))
+			if key == "" || strings.ContainsAny(key, " \t") {
+				return nil, fmt.Errorf("failed to parse env configuration: line %d: invalid key %q", i+1, key)
+			}
+			line = envLine{prefix: text[:eq+1], key: key, value: text[eq+1:], end: line.end}
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// transformEnv transforms the raw values of an Env document, which have no
+// meta data
+func transformEnv(data []byte, fn transformFunc) ([]byte, error) {
+	lines, err := parseEnv(data)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, line := range lines {
+		if line.key != "" {
+			if _, line.value, err = fn([]string{line.key}, "", line.value); err != nil {
+				return nil, err
+			}
+		}
+		b.WriteString(line.prefix + line.value + line.end)
+	}
+	return b.Bytes(), nil
+}
+
+// envValue returns the value of a raw value without its quotes or comment
+func envValue(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		end := 1
+		for ; end < len(raw) && raw[end] != '"'; end++ {
+			if raw[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(raw) {
+			return "", fmt.Errorf("unterminated quoted value %s", raw)
+		}
+		return strconv.Unquote(raw[:end+1])
+	case strings.HasPrefix(raw, "'"):
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted value %s", raw)
+		}
+		return raw[1 : end+1], nil
+	}
+	if comment := strings.Index(raw, " #"); comment >= 0 {
+		raw = raw[:comment]
+	}
+	return strings.TrimSpace(raw), nil
+}
+
+func unmarshalEnv(plain []byte, v any) error {
+	lines, err := parseEnv(plain)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]string, len(lines))
+	for _, line := range lines {
+		if line.key == "" {
+			continue
+		}
+		if values[line.key], err = envValue(line.value); err != nil {
+			return fmt.Errorf("failed to parse env configuration: %s: %w", line.key, err)
+		}
+	}
+
+	if m, ok := v.(*map[string]string); ok {
+		*m = values
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot load env configuration into %T", v)
+	}
+	rv = rv.Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		name, ok := rv.Type().Field(i).Tag.Lookup("env")
+		if !ok || name == "-" {
+			continue
+		}
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+		if err := setField(rv.Field(i), value); err != nil {
+			return fmt.Errorf("failed to load %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setField parses value into field
+func setField(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err == nil {
+			field.SetInt(int64(d))
+		}
+		return err
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// transformYAML transforms the scalar values of a YAML document, their meta
+// data is their tag and style
+func transformYAML(data []byte, fn transformFunc) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML configuration: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return data, nil
+	}
+	if err := transformNode(&doc, nil, fn); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to write YAML configuration: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write YAML configuration: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// transformNode transforms the scalar values below node, mapping keys are
+// path segments starting with "." and sequence indexes ones like "[0]"
+func transformNode(node *yaml.Node, path []string, fn transformFunc) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			if err := transformNode(child, path, fn); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			segment := "." + node.Content[i].Value
+			if err := transformNode(node.Content[i+1], append(path[:len(path):len(path)], segment), fn); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			segment := "[" + strconv.Itoa(i) + "]"
+			if err := transformNode(child, append(path[:len(path):len(path)], segment), fn); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		meta := node.ShortTag() + "," + strconv.Itoa(int(node.Style))
+		if node.ShortTag() == "!!str" && node.Style == 0 {
+			meta = ""
+		}
+		meta, value, err := fn(path, meta, node.Value)
+		if err != nil {
+			return err
+		}
+		node.Value, node.Tag, node.Style = value, "!!str", 0
+		if tag, style, ok := strings.Cut(meta, ","); ok {
+			n, err := strconv.Atoi(style)
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrTampered, pathString(path))
+			}
+			node.Tag, node.Style = tag, yaml.Style(n)
+		}
+	}
+	// Aliases refer to values transformed at their anchor
+	return nil
+}
+
+func unmarshalYAML(plain []byte, v any) error {
+	if err := yaml.Unmarshal(plain, v); err != nil {
+		return fmt.Errorf("failed to parse YAML configuration: %w", err)
+	}
+	return nil
+}
+
+// transformJSON transforms the values of a JSON document the way YAML ones
+// are, keeping the order of keys
+func transformJSON(data []byte, fn transformFunc) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	node, err := parseJSON(dec)
+	if err == nil {
+		if _, err = dec.Token(); err == io.EOF {
+			err = nil
+		} else if err == nil {
+			err = errors.New("unexpected data after the document")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JSON configuration: %w", err)
+	}
+	if err := transformNode(node, nil, fn); err != nil {
+		return nil, err
+	}
+	var compact, b bytes.Buffer
+	if err := writeJSON(&compact, node); err != nil {
+		return nil, err
+	}
+	if err := json.Indent(&b, compact.Bytes(), "", "  "); err != nil {
+		return nil, fmt.Errorf("failed to write JSON configuration: %w", err)
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// parseJSON reads the next JSON value of dec into a YAML node
+func parseJSON(dec *json.Decoder) (*yaml.Node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		if t == '{' {
+			node.Kind, node.Tag = yaml.MappingNode, "!!map"
+		}
+		for dec.More() {
+			if node.Kind == yaml.MappingNode {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key.(string)})
+			}
+			child, err := parseJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: t}, nil
+	case json.Number:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: t.String()}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(t)}, nil
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+}
+
+// writeJSON writes node as compact JSON
+func writeJSON(b *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.MappingNode, yaml.SequenceNode:
+		open, end := byte('['), byte(']')
+		if node.Kind == yaml.MappingNode {
+			open, end = '{', '}'
+		}
+		b.WriteByte(open)
+		for i, child := range node.Content {
+			if i > 0 {
+				if node.Kind == yaml.MappingNode && i%2 == 1 {
+					b.WriteByte(':')
+				} else {
+					b.WriteByte(',')
+				}
+			}
+			if err := writeJSON(b, child); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(end)
+		return nil
+	}
+	if node.Tag != "!!str" {
+		b.WriteString(node.Value)
+		return nil
+	}
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(node.Value); err != nil {
+		return err
+	}
+	// Encode ends values with a newline
+	b.Truncate(b.Len() - 1)
+	return nil
+}
+
+func unmarshalJSON(plain []byte, v any) error {
+	if err := json.Unmarshal(plain, v); err != nil {
+		return fmt.Errorf("failed to parse JSON configuration: %w", err)
+	}
+	return nil
+}
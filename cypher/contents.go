@@ -0,0 +1,222 @@
+package cypher
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ContentsFile lists the files of a directory encrypted WithSignedContents,
+// signed so recipients can check it before decrypting anything
+const ContentsFile = ".gocypher-contents"
+
+// ContentsChunkSize is the size of the ranges of encrypted files hashed in
+// ContentsFile
+const ContentsChunkSize = 1 << 20
+
+var (
+	// ErrContentsSignature is returned when ContentsFile is missing or not
+	// signed by the expected key
+	ErrContentsSignature = errors.New("contents signature is invalid")
+	// ErrContentsMismatch is returned when the files of a directory differ
+	// from its signed ContentsFile
+	ErrContentsMismatch = errors.New("directory doesn't match its signed contents")
+)
+
+// Contents lists the encrypted files of a directory, see WithSignedContents
+type Contents struct {
+	Created time.Time       `json:"created"`
+	Entries []ContentsEntry `json:"entries"`
+}
+
+// ContentsEntry is an encrypted file listed in ContentsFile
+type ContentsEntry struct {
+	// Name is the slash separated path of the file below the directory
+	Name string `json:"name"`
+	// Size is the size of the encrypted file
+	Size int64 `json:"size"`
+	// Chunks are the hex encoded SHA-256 digests of every ContentsChunkSize
+	// bytes of the encrypted file
+	Chunks []string `json:"chunks"`
+}
+
+// signedContents is the content of ContentsFile
+type signedContents struct {
+	Contents  json.RawMessage `json:"contents"`
+	Signature []byte          `json:"signature"`
+}
+
+// WithSignedContents makes EncryptDir write ContentsFile to the destination,
+// listing the name, size and hashes of every encrypted file, including
+// ManifestFile, signed with key. Recipients check with VerifyContents, or
+// DecryptDir WithVerifiedContents, that the directory is complete and
+// untampered before spending any time decrypting it; they only need the
+// public key. The listing isn't encrypted, it reveals the encrypted names
+// and sizes like the directory itself.
+func (c *Cypher) WithSignedContents(key ed25519.PrivateKey) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.contentsKey = key
+	return c
+}
+
+// WithVerifiedContents makes DecryptDir check the source against its
+// ContentsFile with VerifyContents first, decrypting nothing when it fails
+func (c *Cypher) WithVerifiedContents(key ed25519.PublicKey) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.contentsPublicKey = key
+	return c
+}
+
+// VerifyContents checks that the ContentsFile of dir is signed with key and
+// that dir holds exactly the files it lists, with their sizes and hashes. It
+// doesn't need the encryption key. The error of a difference matches
+// ErrContentsMismatch, the one of a bad signature ErrContentsSignature.
+func VerifyContents(dir string, key ed25519.PublicKey) (*Contents, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ContentsFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: no %s", ErrContentsSignature, ContentsFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contents: %w", err)
+	}
+	var signed signedContents
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrContentsSignature, err)
+	}
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, signed.Contents, signed.Signature) {
+		return nil, ErrContentsSignature
+	}
+	var contents Contents
+	if err := json.Unmarshal(signed.Contents, &contents); err != nil {
+		return nil, fmt.Errorf("failed to parse contents: %w", err)
+	}
+
+	listed := map[string]ContentsEntry{}
+	for _, entry := range contents.Entries {
+		listed[entry.Name] = entry
+	}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if name == ContentsFile {
+			return nil
+		}
+		entry, ok := listed[name]
+		if !ok {
+			return fmt.Errorf("%w: %s isn't listed", ErrContentsMismatch, name)
+		}
+		delete(listed, name)
+		actual, err := contentsEntry(path, name)
+		if err != nil {
+			return err
+		}
+		if actual.Size != entry.Size || len(actual.Chunks) != len(entry.Chunks) {
+			return fmt.Errorf("%w: %s has %d bytes, listed with %d", ErrContentsMismatch, name, actual.Size, entry.Size)
+		}
+		for i := range actual.Chunks {
+			if !EqualHex(actual.Chunks[i], entry.Chunks[i]) {
+				return fmt.Errorf("%w: %s differs at byte %d", ErrContentsMismatch, name, int64(i)*ContentsChunkSize)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name := range listed {
+		return nil, fmt.Errorf("%w: %s is missing", ErrContentsMismatch, name)
+	}
+	return &contents, nil
+}
+
+// writeContents signs the listing of the files plan encrypted, and of its
+// ManifestFile, into the ContentsFile of its destination
+func (c Cypher) writeContents(plan *Plan) error {
+	dir := plan.dstDir
+	paths := []string{}
+	for _, entry := range plan.Entries {
+		if entry.Action != ActionSkip {
+			paths = append(paths, entry.Destination)
+		}
+	}
+	if plan.manifest != nil {
+		paths = append(paths, filepath.Join(dir, ManifestFile))
+	}
+
+	contents := Contents{Created: time.Now().UTC()}
+	for _, path := range paths {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		entry, err := contentsEntry(LongPath(path), filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		contents.Entries = append(contents.Entries, entry)
+	}
+	sort.Slice(contents.Entries, func(i, j int) bool { return contents.Entries[i].Name < contents.Entries[j].Name })
+
+	data, err := json.Marshal(contents)
+	if err != nil {
+		return err
+	}
+	signed, err := json.Marshal(signedContents{Contents: data, Signature: ed25519.Sign(c.contentsKey, data)})
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, ContentsFile+".tmp")
+	if err := os.WriteFile(tmp, signed, 0644); err != nil {
+		return fmt.Errorf("failed to write contents: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, ContentsFile)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write contents: %w", err)
+	}
+	return nil
+}
+
+// contentsEntry hashes the file at path, listed under name
+func contentsEntry(path, name string) (ContentsEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ContentsEntry{}, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer file.Close()
+
+	entry := ContentsEntry{Name: name, Chunks: []string{}}
+	buf := make([]byte, ContentsChunkSize)
+	for {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			sum := sha256.Sum256(buf[:n])
+			entry.Chunks = append(entry.Chunks, hex.EncodeToString(sum[:]))
+			entry.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return entry, nil
+		}
+		if err != nil {
+			return ContentsEntry{}, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+	}
+}
@@ -1,54 +1,158 @@
 package cypher
 
 import (
+	"bytes"
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ed25519"
 	"crypto/md5"
 	"crypto/rand"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"io"
-	"os"
+	"log/slog"
 	"runtime"
-	"sync"
+	"time"
 )
 
-type DataChunk struct {
-	data     []byte
-	position int
-}
-
+// Cypher encrypts and decrypts data with a key. Configure it with options or
+// the With methods before use; afterwards a single Cypher is safe for
+// concurrent operations from many goroutines, each of which works on its own
+// copy of the settings and pipeline state. To change settings of a Cypher in
+// use, configure a Clone. A With method called after use is ignored and
+// assigning ChunkSize, NumWorkers or NumCores after use isn't prevented; both
+// make Validate, Err and every later operation fail with
+// ErrConfiguredAfterUse.
 type Cypher struct {
-	key        []byte
+	key        *keyMaterial
 	ChunkSize  int
 	NumWorkers int
 	NumCores   int
+	logger     *slog.Logger
+	metrics    Metrics
+	tracer     Tracer
+	progress   ProgressFunc
+	auditor    Auditor
+
+	slowChunkThreshold time.Duration
+	generation         uint64
+	minGeneration      uint64
+	nonceCounter       *NonceCounter
+	compression        Compression
+	compressionLevel   int
+	chunking           *cdcParams
+	deltaFriendly      bool
+	algorithm          Algorithm
+	backend            Backend
+	numaNode           int
+	spillBudget        int64
+	spillDir           string
+	parseMode          ParseMode
+	// include and exclude filter the files of directory operations
+	include        []string
+	exclude        []string
+	obfuscateNames bool
+	shredPasses    int
+	expiry         time.Time
+	enforceExpiry  bool
+	pool           *WorkerPool
+	maxMemory      int64
+	adaptive       bool
+	encryptStages  []Stage
+	decryptStages  []Stage
+	thresholds     *executionThresholds
+	nameTemplate   string
+	originalName   bool
+	overwrite      bool
+	xattrs         bool
+	lockPolicy     LockPolicy
+	journal        bool
+	// contentsKey signs the ContentsFile of EncryptDir, contentsPublicKey
+	// verifies the one of DecryptDir
+	contentsKey       ed25519.PrivateKey
+	contentsPublicKey ed25519.PublicKey
+	keyUsage          *KeyUsage
+	randReader        io.Reader
+	// passphraseStrength is set when the key was derived from a passphrase
+	passphraseStrength *Strength
+	// envErr reports the environment variables that were ignored, see
+	// applyEnv
+	envErr error
+	guard  *configGuard
 }
+
+// Option configures a Cypher when it is created, see options.go for the
+// constructors matching the With methods
 type Option func(*Cypher)
 
+// KeySize is the size of raw AES-256 keys
+const KeySize = 32
+
+var ErrInvalidKeySize = fmt.Errorf("key must be %d bytes", KeySize)
+
 func NewCypher(key string, opts ...Option) *Cypher {
-	maxCPUs := runtime.NumCPU()
-	runtime.GOMAXPROCS(maxCPUs)
+	strength := EstimatePassphraseStrength(key)
+	return newCypher([]byte(MD5HashFromString(key)), &strength, opts...)
+}
 
+// NewCypherFromKey uses a random KeySize bytes key, such as one returned by
+// GenerateKey, instead of deriving one from a passphrase
+func NewCypherFromKey(key []byte, opts ...Option) (*Cypher, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKeySize
+	}
+	c := newCypher(append([]byte(nil), key...), nil, opts...)
+	if err := c.Validate(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// GenerateKey returns a new random key for NewCypherFromKey
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// newCypher applies opts after the defaults, strength is that of the
+// passphrase the key was derived from if any
+func newCypher(key []byte, strength *Strength, opts ...Option) *Cypher {
 	// Default values
 	cypher := &Cypher{
-		ChunkSize:  10 * 1024 * 1024, // 10MB
-		NumWorkers: 10,               // 10 workers
-		key:        []byte(MD5HashFromString(key)),
-		NumCores:   maxCPUs,
+		ChunkSize:          10 * 1024 * 1024, // 10MB
+		NumWorkers:         10,               // 10 workers
+		key:                newKeyMaterial(key),
+		NumCores:           runtime.NumCPU(),
+		numaNode:           -1,
+		passphraseStrength: strength,
+		guard:              &configGuard{},
 	}
 
+	applied, envErr := cypher.applyEnv()
+
 	// Apply options
 	for _, opt := range opts {
 		opt(cypher)
 	}
+	cypher.envErr = envErr
+	cypher.logEnv(applied, envErr)
 
 	return cypher
 }
 
+// WithNumCores bounds how many workers transform chunks at the same time
+// (default: all CPUs), capped at the number of CPUs. Other workers wait, so
+// the rest of the process keeps the remaining cores. The Go scheduler
+// settings of the host application are left alone.
 func (c *Cypher) WithNumCores(numCores int) *Cypher {
+	if !c.configure() {
+		return c
+	}
 	maxCPUs := runtime.NumCPU()
 
 	if numCores > maxCPUs {
@@ -60,496 +164,171 @@ func (c *Cypher) WithNumCores(numCores int) *Cypher {
 }
 
 func (c *Cypher) WithChunkSize(chunkSize int) *Cypher {
+	if !c.configure() {
+		return c
+	}
 	c.ChunkSize = chunkSize
 	return c
 }
 
 func (c *Cypher) WithNumWorkers(numWorkers int) *Cypher {
+	if !c.configure() {
+		return c
+	}
 	c.NumWorkers = numWorkers
 	return c
 }
 
-func (c Cypher) EncryptFile(inputPath string) (*string, error) {
-	outputPath := inputPath + ".encrypted"
-	inputFile, err := os.Open(inputPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open input file: %w", err)
-	}
-	defer inputFile.Close()
-
-	outputFile, err := os.Create(outputPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create output file: %w", err)
-	}
-	defer outputFile.Close()
-
-	block, err := aes.NewCipher(c.key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
+// WithLogger sets the logger used to report operation events. By default the
+// Cypher is silent.
+func (c *Cypher) WithLogger(logger *slog.Logger) *Cypher {
+	if !c.configure() {
+		return c
 	}
+	c.logger = logger
+	return c
+}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
-	}
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Create channels
-	rawChunks := make(chan DataChunk, c.NumWorkers)
-	encryptedChunks := make(chan DataChunk, c.NumWorkers)
-	errorChan := make(chan error, 1)
-
-	// Start the worker pool
-	var wg sync.WaitGroup
-	for i := 0; i < c.NumWorkers; i++ {
-		wg.Add(1)
-		go encryptWorker(ctx, &wg, gcm, rawChunks, encryptedChunks, errorChan)
-	}
-
-	// Start the writer goroutine
-	writeComplete := make(chan struct{})
-	go writeChunks(outputFile, encryptedChunks, writeComplete, errorChan)
-
-	// Read and send chunks for processing
-	position := 0
-	buffer := make([]byte, c.ChunkSize)
-	for {
-		n, err := inputFile.Read(buffer)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			cancel()
-			return nil, fmt.Errorf("failed to read input file: %w", err)
-		}
-
-		chunk := make([]byte, n)
-		copy(chunk, buffer[:n])
-
-		select {
-		case rawChunks <- DataChunk{data: chunk, position: position}:
-			position++
-		case err := <-errorChan:
-			cancel()
-			return nil, err
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		}
+// WithMetrics sets the recorder that receives throughput, worker utilization
+// and error measurements.
+func (c *Cypher) WithMetrics(metrics Metrics) *Cypher {
+	if !c.configure() {
+		return c
 	}
+	c.metrics = metrics
+	return c
+}
 
-	// Close the raw chunks channel to signal no more data
-	close(rawChunks)
-
-	// Wait for all encryption workers to complete
-	wg.Wait()
-
-	// Close encrypted chunks channel
-	close(encryptedChunks)
-
-	// Wait for writer to complete
-	select {
-	case <-writeComplete:
-		return &outputPath, nil
-	case err := <-errorChan:
-		return nil, err
+// WithTracer sets the tracer used to create a span for every operation
+func (c *Cypher) WithTracer(tracer Tracer) *Cypher {
+	if !c.configure() {
+		return c
 	}
+	c.tracer = tracer
+	return c
 }
 
-func encryptWorker(ctx context.Context, wg *sync.WaitGroup, gcm cipher.AEAD, input <-chan DataChunk, output chan<- DataChunk, errorChan chan<- error) {
-	defer wg.Done()
-
-	for {
-		select {
-		case chunk, ok := <-input:
-			if !ok {
-				return
-			}
-
-			nonce := make([]byte, gcm.NonceSize())
-			if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-				select {
-				case errorChan <- fmt.Errorf("failed to generate nonce: %w", err):
-				default:
-				}
-				return
-			}
-
-			encrypted := gcm.Seal(nil, nonce, chunk.data, nil)
-			select {
-			case output <- DataChunk{data: append(nonce, encrypted...), position: chunk.position}:
-			case <-ctx.Done():
-				return
-			}
-
-		case <-ctx.Done():
-			return
-		}
+// WithSlowChunkThreshold sets how long a chunk may take before it is recorded
+// as an event on the operation span (default: 1s).
+func (c *Cypher) WithSlowChunkThreshold(threshold time.Duration) *Cypher {
+	if !c.configure() {
+		return c
 	}
+	c.slowChunkThreshold = threshold
+	return c
 }
 
-func writeChunks(file *os.File, input <-chan DataChunk, complete chan<- struct{}, errorChan chan<- error) {
-	pending := make(map[int][]byte)
-	nextPosition := 0
-
-	for chunk := range input {
-		pending[chunk.position] = chunk.data
-
-		// Write chunks in order
-		for data, ok := pending[nextPosition]; ok; data, ok = pending[nextPosition] {
-			_, err := file.Write(data)
-			if err != nil {
-				select {
-				case errorChan <- fmt.Errorf("failed to write chunk: %w", err):
-				default:
-				}
-				return
-			}
-			delete(pending, nextPosition)
-			nextPosition++
-		}
+// WithProgress sets a callback that receives progress, smoothed throughput
+// and ETA estimates after every chunk.
+func (c *Cypher) WithProgress(progress ProgressFunc) *Cypher {
+	if !c.configure() {
+		return c
 	}
-
-	// Signal completion
-	complete <- struct{}{}
+	c.progress = progress
+	return c
 }
 
-func (c Cypher) DecryptFile(inputPath string) (*string, error) {
-	outputPath := inputPath + ".decrypted"
-	inputFile, err := os.Open(inputPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open input file: %w", err)
+// WithAuditor sets the auditor that records every operation
+func (c *Cypher) WithAuditor(auditor Auditor) *Cypher {
+	if !c.configure() {
+		return c
 	}
-	defer inputFile.Close()
+	c.auditor = auditor
+	return c
+}
 
-	outputFile, err := os.Create(outputPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create output file: %w", err)
+func (c Cypher) newGCM() (cipher.AEAD, error) {
+	if c.key == nil {
+		return nil, ErrClosed
 	}
-	defer outputFile.Close()
 
-	block, err := aes.NewCipher(c.key)
+	var block cipher.Block
+	err := c.key.use(func(key []byte) (err error) {
+		block, err = aes.NewCipher(key)
+		if err != nil {
+			return fmt.Errorf("failed to create cipher: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
+		return nil, err
 	}
 
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create GCM: %w", err)
 	}
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Calculate total size for encrypted chunk (including nonce and overhead)
-	encryptedChunkSize := c.ChunkSize + gcm.NonceSize() + gcm.Overhead()
-
-	// Create channels
-	encryptedChunks := make(chan DataChunk, c.NumWorkers)
-	decryptedChunks := make(chan DataChunk, c.NumWorkers)
-	errorChan := make(chan error, 1)
-
-	// Start the worker pool
-	var wg sync.WaitGroup
-	for i := 0; i < c.NumWorkers; i++ {
-		wg.Add(1)
-		go decryptWorker(ctx, &wg, gcm, encryptedChunks, decryptedChunks, errorChan)
-	}
-
-	// Start the writer goroutine
-	writeComplete := make(chan struct{})
-	go writeChunks(outputFile, decryptedChunks, writeComplete, errorChan)
-
-	// Read and send chunks for processing
-	position := 0
-	buffer := make([]byte, encryptedChunkSize)
-	for {
-		n, err := inputFile.Read(buffer)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			cancel()
-			return nil, fmt.Errorf("failed to read input file: %w", err)
-		}
-
-		chunk := make([]byte, n)
-		copy(chunk, buffer[:n])
-
-		select {
-		case encryptedChunks <- DataChunk{data: chunk, position: position}:
-			position++
-		case err := <-errorChan:
-			cancel()
-			return nil, err
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		}
-	}
-
-	// Close the encrypted chunks channel to signal no more data
-	close(encryptedChunks)
-
-	// Wait for all decryption workers to complete
-	wg.Wait()
-
-	// Close decrypted chunks channel
-	close(decryptedChunks)
-
-	// Wait for writer to complete
-	select {
-	case <-writeComplete:
-		return &outputPath, nil
-	case err := <-errorChan:
-		return nil, err
-	}
+	return gcm, nil
 }
 
-func decryptWorker(ctx context.Context, wg *sync.WaitGroup, gcm cipher.AEAD, input <-chan DataChunk, output chan<- DataChunk, errorChan chan<- error) {
-	defer wg.Done()
-
-	for {
-		select {
-		case chunk, ok := <-input:
-			if !ok {
-				return
-			}
-
-			nonceSize := gcm.NonceSize()
-			if len(chunk.data) < nonceSize {
-				select {
-				case errorChan <- errors.New("encrypted chunk too small"):
-				default:
-				}
-				return
-			}
-
-			nonce := chunk.data[:nonceSize]
-			ciphertext := chunk.data[nonceSize:]
-
-			plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
-			if err != nil {
-				select {
-				case errorChan <- fmt.Errorf("failed to decrypt chunk: %w", err):
-				default:
-				}
-				return
-			}
-
-			select {
-			case output <- DataChunk{data: plaintext, position: chunk.position}:
-			case <-ctx.Done():
-				return
-			}
-
-		case <-ctx.Done():
-			return
-		}
-	}
+func (c Cypher) encryptOperation() operation {
+	op := operation{name: "encrypt", prepare: c.prepareEncrypt, wipeInput: true, sparse: true, framed: true}
+	op.stagesBefore, op.stagesAfter = splitStages(c.encryptStages, true)
+	return op
 }
 
-func MD5HashFromFile(filename string) (string, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	hash := md5.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(hash.Sum(nil)), nil
+func (c Cypher) decryptOperation() operation {
+	op := operation{name: "decrypt", prepare: c.prepareDecrypt, wipeOutput: true}
+	op.stagesBefore, op.stagesAfter = splitStages(c.decryptStages, false)
+	return op
 }
 
+// MD5HashFromString returns the hex encoded MD5 digest of str
 func MD5HashFromString(str string) string {
-	hash := md5.New()
-	if _, err := io.WriteString(hash, str); err != nil {
-		panic(err)
-	}
-	return hex.EncodeToString(hash.Sum(nil))
+	sum := md5.Sum([]byte(str))
+	return hex.EncodeToString(sum[:])
 }
 
 func (c Cypher) Encrypt(data []byte) ([]byte, error) {
-	block, err := aes.NewCipher(c.key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
-	}
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Create channels
-	rawChunks := make(chan DataChunk, c.NumWorkers)
-	encryptedChunks := make(chan DataChunk, c.NumWorkers)
-	errorChan := make(chan error, 1)
-
-	// Start the worker pool
-	var wg sync.WaitGroup
-	for i := 0; i < c.NumWorkers; i++ {
-		wg.Add(1)
-		go encryptWorker(ctx, &wg, gcm, rawChunks, encryptedChunks, errorChan)
-	}
-
-	// Start collecting results
-	var result []byte
-	var pendingChunks sync.Map
-	var nextPosition int
-	var resultMutex sync.Mutex
-
-	// Start collector goroutine
-	collectorDone := make(chan struct{})
-	go func() {
-		defer close(collectorDone)
-		for chunk := range encryptedChunks {
-			pendingChunks.Store(chunk.position, chunk.data)
-
-			// Try to append chunks in order
-			for {
-				if data, ok := pendingChunks.LoadAndDelete(nextPosition); ok {
-					resultMutex.Lock()
-					result = append(result, data.([]byte)...)
-					resultMutex.Unlock()
-					nextPosition++
-				} else {
-					break
-				}
-			}
-		}
-	}()
-
-	// Split data into chunks and send for encryption
-	for i := 0; i < len(data); i += c.ChunkSize {
-		end := i + c.ChunkSize
-		if end > len(data) {
-			end = len(data)
-		}
-
-		chunk := make([]byte, end-i)
-		copy(chunk, data[i:end])
-
-		select {
-		case rawChunks <- DataChunk{data: chunk, position: i / c.ChunkSize}:
-		case err := <-errorChan:
-			cancel()
-			return nil, err
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		}
-	}
+	encrypted, _, err := c.EncryptWithStats(data)
+	return encrypted, err
+}
 
-	// Close input channel and wait for workers
-	close(rawChunks)
-	wg.Wait()
-	close(encryptedChunks)
+// EncryptWithStats is like Encrypt and also reports statistics about the
+// operation
+func (c Cypher) EncryptWithStats(data []byte) ([]byte, *Stats, error) {
+	return c.processData(data, c.encryptOperation)
+}
 
-	// Wait for collector
-	<-collectorDone
+func (c Cypher) Decrypt(data []byte) ([]byte, error) {
+	decrypted, _, err := c.DecryptWithStats(data)
+	return decrypted, err
+}
 
-	// Check for errors
-	select {
-	case err := <-errorChan:
-		return nil, err
-	default:
-		return result, nil
-	}
+// DecryptWithStats is like Decrypt and also reports statistics about the
+// operation
+func (c Cypher) DecryptWithStats(data []byte) ([]byte, *Stats, error) {
+	return c.processData(data, c.decryptOperation)
 }
 
-func (c Cypher) Decrypt(data []byte) ([]byte, error) {
-	block, err := aes.NewCipher(c.key)
+// EncryptStream encrypts src into dst. It only needs an io.Reader and an
+// io.Writer, so it works where files aren't available, such as WebAssembly.
+func (c Cypher) EncryptStream(ctx context.Context, src io.Reader, dst io.Writer) (*Stats, error) {
+	stats, err := c.process(ctx, c.encryptOperation(), src, dst)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
+		return nil, err
 	}
+	return &stats, nil
+}
 
-	gcm, err := cipher.NewGCM(block)
+// DecryptStream decrypts src into dst, writing the holes of sparse files as
+// zeros
+func (c Cypher) DecryptStream(ctx context.Context, src io.Reader, dst io.Writer) (*Stats, error) {
+	stats, err := c.process(ctx, c.decryptOperation(), src, dst)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
-	}
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Calculate chunk size for encrypted data
-	encryptedChunkSize := c.ChunkSize + gcm.NonceSize() + gcm.Overhead()
-
-	// Create channels
-	encryptedChunks := make(chan DataChunk, c.NumWorkers)
-	decryptedChunks := make(chan DataChunk, c.NumWorkers)
-	errorChan := make(chan error, 1)
-
-	// Start the worker pool
-	var wg sync.WaitGroup
-	for i := 0; i < c.NumWorkers; i++ {
-		wg.Add(1)
-		go decryptWorker(ctx, &wg, gcm, encryptedChunks, decryptedChunks, errorChan)
-	}
-
-	// Start collecting results
-	var result []byte
-	var pendingChunks sync.Map
-	var nextPosition int
-	var resultMutex sync.Mutex
-
-	// Start collector goroutine
-	collectorDone := make(chan struct{})
-	go func() {
-		defer close(collectorDone)
-		for chunk := range decryptedChunks {
-			pendingChunks.Store(chunk.position, chunk.data)
-
-			// Try to append chunks in order
-			for {
-				if data, ok := pendingChunks.LoadAndDelete(nextPosition); ok {
-					resultMutex.Lock()
-					result = append(result, data.([]byte)...)
-					resultMutex.Unlock()
-					nextPosition++
-				} else {
-					break
-				}
-			}
-		}
-	}()
-
-	// Split data into chunks and send for decryption
-	for i := 0; i < len(data); i += encryptedChunkSize {
-		end := i + encryptedChunkSize
-		if end > len(data) {
-			end = len(data)
-		}
-
-		chunk := make([]byte, end-i)
-		copy(chunk, data[i:end])
-
-		select {
-		case encryptedChunks <- DataChunk{data: chunk, position: i / encryptedChunkSize}:
-		case err := <-errorChan:
-			cancel()
-			return nil, err
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		}
+		return nil, err
 	}
+	return &stats, nil
+}
 
-	// Close input channel and wait for workers
-	close(encryptedChunks)
-	wg.Wait()
-	close(decryptedChunks)
-
-	// Wait for collector
-	<-collectorDone
+func (c Cypher) processData(data []byte, newOperation func() operation) ([]byte, *Stats, error) {
+	op := newOperation()
+	op.total, op.sized = int64(len(data)), true
 
-	// Check for errors
-	select {
-	case err := <-errorChan:
-		return nil, err
-	default:
-		return result, nil
+	var result bytes.Buffer
+	stats, err := c.process(context.Background(), op, bytes.NewReader(data), &result)
+	if err != nil {
+		return nil, nil, err
 	}
+	return result.Bytes(), &stats, nil
 }
@@ -0,0 +1,2721 @@
+package cypher
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// randomBytes returns size bytes of random data
+func randomBytes(t *testing.T, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("Failed to generate random data: %v", err)
+	}
+	return data
+}
+
+func TestEncryptDecryptData(t *testing.T) {
+	c := NewCypher("test-key").WithChunkSize(1024).WithNumWorkers(4)
+
+	for _, size := range []int{0, 1, 1023, 1024, 1025, 10 * 1024} {
+		data := randomBytes(t, size)
+
+		encrypted, err := c.Encrypt(data)
+		if err != nil {
+			t.Fatalf("Encryption of %d bytes failed: %v", size, err)
+		}
+
+		decrypted, err := c.Decrypt(encrypted)
+		if err != nil {
+			t.Fatalf("Decryption of %d bytes failed: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Errorf("Decrypted data doesn't match input for %d bytes", size)
+		}
+	}
+}
+
+func TestEncryptDecryptFile(t *testing.T) {
+	c := NewCypher("test-key").WithChunkSize(4096)
+
+	inputPath := filepath.Join(t.TempDir(), "input.bin")
+	data := randomBytes(t, 100*1024+7)
+	if err := os.WriteFile(inputPath, data, 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+
+	encryptedPath, err := c.EncryptFile(inputPath)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	// The plaintext next to the encrypted file is never replaced silently
+	if err := os.WriteFile(inputPath, []byte("newer plaintext"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DecryptFile(*encryptedPath); !errors.Is(err, ErrOutputExists) {
+		t.Errorf("Expected ErrOutputExists, got %v", err)
+	}
+	if kept, _ := os.ReadFile(inputPath); string(kept) != "newer plaintext" {
+		t.Errorf("Expected the existing file to survive, got %q", kept)
+	}
+	decryptedPath, err := c.Clone().WithOverwrite().DecryptFile(*encryptedPath)
+	if err != nil {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+
+	decrypted, err := os.ReadFile(*decryptedPath)
+	if err != nil {
+		t.Fatalf("Failed to read decrypted file: %v", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Error("Decrypted file doesn't match input file")
+	}
+}
+
+func TestDecryptWithWrongKey(t *testing.T) {
+	encrypted, err := NewCypher("right-key").Encrypt([]byte("your data"))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	if _, err := NewCypher("wrong-key").Decrypt(encrypted); err == nil {
+		t.Error("Expected error when decrypting with wrong key, got nil")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	c := NewCypher("test-key").WithChunkSize(16).WithLogger(logger)
+
+	if _, err := c.Encrypt(randomBytes(t, 40)); err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	output := logs.String()
+	for _, want := range []string{"operation started", "operation completed", "op=encrypt", "chunks=3"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected log output to contain %q, got:\n%s", want, output)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	c := NewCypher("test-key").WithChunkSize(16).WithNumWorkers(2)
+
+	encrypted, stats, err := c.EncryptWithStats(randomBytes(t, 40))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	if stats.BytesRead != 40 {
+		t.Errorf("Expected 40 bytes read, got %d", stats.BytesRead)
+	}
+	if stats.BytesWritten != int64(len(encrypted)) {
+		t.Errorf("Expected %d bytes written, got %d", len(encrypted), stats.BytesWritten)
+	}
+	if stats.Chunks != 3 {
+		t.Errorf("Expected 3 chunks, got %d", stats.Chunks)
+	}
+	if len(stats.WorkerBusy) != 2 {
+		t.Errorf("Expected busy time for 2 workers, got %d", len(stats.WorkerBusy))
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var updates []Progress
+	c := NewCypher("test-key").WithChunkSize(16).WithProgress(func(p Progress) {
+		updates = append(updates, p)
+	})
+
+	if _, err := c.Encrypt(randomBytes(t, 40)); err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	if len(updates) != 3 {
+		t.Fatalf("Expected 3 progress updates, got %d", len(updates))
+	}
+	last := updates[len(updates)-1]
+	if last.BytesDone != 40 || last.BytesTotal != 40 {
+		t.Errorf("Expected 40/40 bytes done, got %d/%d", last.BytesDone, last.BytesTotal)
+	}
+	if last.Percent() != 100 {
+		t.Errorf("Expected 100%% done, got %v", last.Percent())
+	}
+	if last.ETA != 0 {
+		t.Errorf("Expected no ETA once complete, got %v", last.ETA)
+	}
+}
+
+func TestClose(t *testing.T) {
+	c := NewCypher("test-key")
+	fingerprint := c.KeyFingerprint()
+	copied := *c
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if _, err := c.Encrypt([]byte("your data")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+	if _, err := copied.Decrypt([]byte("your data")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed from a copy, got %v", err)
+	}
+	if c.KeyFingerprint() != fingerprint {
+		t.Error("Expected fingerprint to survive Close")
+	}
+}
+
+func TestLockMemory(t *testing.T) {
+	c := NewCypher("test-key")
+
+	err := c.LockMemory()
+	if err != nil && !errors.Is(err, ErrMemoryLock) && !errors.Is(err, ErrMemoryLockUnsupported) {
+		t.Fatalf("Unexpected LockMemory error: %v", err)
+	}
+	if err != nil {
+		t.Logf("Key memory not locked: %v", err)
+	}
+
+	if _, err := c.Encrypt([]byte("your data")); err != nil {
+		t.Fatalf("Encryption with locked key failed: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := c.LockMemory(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestDecryptKeyMismatch(t *testing.T) {
+	encrypted, err := NewCypher("right-key").Encrypt([]byte("your data"))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	if _, err := NewCypher("wrong-key").Decrypt(encrypted); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch, got %v", err)
+	}
+}
+
+func TestDecryptTamperedHeader(t *testing.T) {
+	c := NewCypher("test-key").WithChunkSize(16)
+	encrypted, err := c.Encrypt(randomBytes(t, 40))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	// Change the generation record, the last value before the end record
+	tampered := bytes.Clone(encrypted)
+	tampered[c.headerSize()-4]++
+	if _, err := c.Decrypt(tampered); err == nil {
+		t.Error("Expected error when decrypting a tampered header, got nil")
+	}
+
+	tampered = bytes.Clone(encrypted)
+	tampered[len(FormatMagic)] = FormatVersion + 1
+	if _, err := c.Decrypt(tampered); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Expected ErrInvalidHeader, got %v", err)
+	}
+}
+
+// withRecord re-encrypts encrypted under a header with record added before
+// its end record
+func withRecord(t *testing.T, c *Cypher, encrypted []byte, record Record) []byte {
+	t.Helper()
+	r := bytes.NewReader(encrypted)
+	h, err := ParseHeader(r, ParseStrict)
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	gcm, err := c.fileGCM(&header{salt: h.Salt, commitment: h.Commitment, algorithm: h.Algorithm})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw bytes.Buffer
+	raw.Write(h.Raw[:len(h.Raw)-3])
+	writeRecord(&raw, record.Type, record.Value)
+	writeRecord(&raw, RecordEnd, nil)
+	extended := &Header{Raw: raw.Bytes()}
+
+	out := bytes.NewBuffer(bytes.Clone(extended.Raw))
+	for position := 0; r.Len() > 0; position++ {
+		if h.Trailer && r.Len() == streamTrailerSize {
+			key, err := c.streamTrailerKey(&header{salt: h.Salt})
+			if err != nil {
+				t.Fatal(err)
+			}
+			size := int64(out.Len() - len(extended.Raw))
+			out.Write(encodeStreamTrailer(key, headerAAD(&header{raw: extended.Raw}), position, size))
+			break
+		}
+		frame, _, err := lengthFrames(MaxChunkSize, nil)(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce := frame[:gcm.NonceSize()]
+		plain, err := gcm.Open(nil, nonce, frame[gcm.NonceSize():], h.ChunkAAD(position))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed := gcm.Seal(bytes.Clone(nonce), nonce, plain, extended.ChunkAAD(position))
+		binary.Write(out, binary.BigEndian, uint32(len(sealed)))
+		out.Write(sealed)
+	}
+	return out.Bytes()
+}
+
+func TestParseHeader(t *testing.T) {
+	c := NewCypher("test-key").WithChunkSize(16).WithGeneration(3)
+	plaintext := randomBytes(t, 40)
+	encrypted, err := c.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	h, err := ParseHeader(bytes.NewReader(encrypted), ParseStrict)
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	if h.Version != FormatVersion || h.ChunkSize != 16 || h.Generation != 3 || len(h.Salt) != SaltSize || len(h.Raw) != int(c.headerSize()) {
+		t.Errorf("Unexpected header %+v", h)
+	}
+
+	// A newer writer adding an optional extension record
+	extended := withRecord(t, c, encrypted, Record{Type: RecordExtension + 1, Value: []byte("future")})
+	if _, err := c.Decrypt(extended); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Expected ErrInvalidHeader in strict mode, got %v", err)
+	}
+	decrypted, err := c.Clone().WithParseMode(ParseLenient).Decrypt(extended)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Expected lenient mode to skip the extension: %v", err)
+	}
+	h, err = ParseHeader(bytes.NewReader(extended), ParseLenient)
+	if err != nil || len(h.Extensions) != 1 || string(h.Extensions[0].Value) != "future" {
+		t.Errorf("Expected the extension record, got %+v: %v", h, err)
+	}
+
+	// Unknown records below RecordExtension change the meaning of the data
+	critical := withRecord(t, c, encrypted, Record{Type: RecordExtension - 1})
+	if _, err := c.Clone().WithParseMode(ParseLenient).Decrypt(critical); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Expected ErrInvalidHeader for an unknown critical record, got %v", err)
+	}
+	duplicate := withRecord(t, c, encrypted, Record{Type: RecordGeneration, Value: make([]byte, 8)})
+	if _, err := c.Decrypt(duplicate); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Expected ErrInvalidHeader for a duplicate record, got %v", err)
+	}
+}
+
+func TestDecryptReorderedChunks(t *testing.T) {
+	c := NewCypher("test-key").WithChunkSize(16)
+	encrypted, err := c.Encrypt(randomBytes(t, 32))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	body := encrypted[c.headerSize():]
+	frame := len(body) / 2
+	reordered := append(bytes.Clone(encrypted[:c.headerSize()]), body[frame:]...)
+	reordered = append(reordered, body[:frame]...)
+	if _, err := c.Decrypt(reordered); err == nil {
+		t.Error("Expected error when decrypting reordered chunks, got nil")
+	}
+}
+
+func TestDecryptLegacyFormat(t *testing.T) {
+	c := NewCypher("test-key").WithChunkSize(16)
+	data := randomBytes(t, 40)
+
+	// Legacy data is a bare sequence of nonce | ciphertext | tag chunks
+	gcm, err := c.newGCM()
+	if err != nil {
+		t.Fatalf("Failed to create GCM: %v", err)
+	}
+	var legacy []byte
+	for start := 0; start < len(data); start += c.ChunkSize {
+		end := min(start+c.ChunkSize, len(data))
+		nonce := randomBytes(t, gcm.NonceSize())
+		legacy = append(legacy, gcm.Seal(nonce, nonce, data[start:end], nil)...)
+	}
+
+	decrypted, err := c.Decrypt(legacy)
+	if err != nil {
+		t.Fatalf("Decryption of legacy data failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Error("Decrypted legacy data doesn't match input")
+	}
+}
+
+func TestRedactKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, KeySize)
+	c, err := NewCypherFromKey(key)
+	if err != nil {
+		t.Fatalf("Failed to create cypher: %v", err)
+	}
+
+	var logs bytes.Buffer
+	slog.New(slog.NewJSONHandler(&logs, nil)).Info("cypher", "c", c)
+
+	outputs := []string{logs.String()}
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x", "%X", "%d"} {
+		outputs = append(outputs, fmt.Sprintf(format, c), fmt.Sprintf(format, *c), fmt.Sprintf(format, c.key))
+	}
+	for _, output := range outputs {
+		if strings.Contains(output, "abab") || strings.Contains(output, "ABAB") || strings.Contains(output, "171 171") || bytes.Contains([]byte(output), key[:4]) {
+			t.Errorf("Key leaked in %q", output)
+		}
+		if !strings.Contains(output, c.KeyFingerprint()) {
+			t.Errorf("Expected fingerprint in %q", output)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	if !Equal([]byte("mac"), []byte("mac")) || Equal([]byte("mac"), []byte("mad")) || Equal([]byte("mac"), []byte("ma")) {
+		t.Error("Equal reported the wrong result")
+	}
+	if !EqualHex("ABCD", "abcd") || EqualHex("abcd", "abce") || EqualHex("zz", "zz") {
+		t.Error("EqualHex reported the wrong result")
+	}
+
+	c := NewCypher("test-key")
+	if !c.HasFingerprint(strings.ToUpper(c.KeyFingerprint())) {
+		t.Error("Expected cypher to match its own fingerprint")
+	}
+	if NewCypher("other-key").HasFingerprint(c.KeyFingerprint()) || c.HasFingerprint("") {
+		t.Error("Expected fingerprint mismatch")
+	}
+}
+
+func TestPassphraseStrength(t *testing.T) {
+	for _, test := range []struct {
+		passphrase string
+		maxScore   int
+		minScore   int
+	}{
+		{"", 0, 0},
+		{"password", 0, 0},
+		{"P@ssw0rd", 0, 0},
+		{"123456789", 0, 0},
+		{"qwertyuiop", 0, 0},
+		{"aaaaaaaaaaaa", 0, 0},
+		{"correct horse battery staple", 4, 4},
+		{"x7#Kq!9zLm2$", 4, 4},
+	} {
+		strength := EstimatePassphraseStrength(test.passphrase)
+		if strength.Score < test.minScore || strength.Score > test.maxScore {
+			t.Errorf("Expected score of %q between %d and %d, got %d", test.passphrase, test.minScore, test.maxScore, strength.Score)
+		}
+	}
+}
+
+func TestMinPassphraseStrength(t *testing.T) {
+	weak := NewCypher("password").WithMinPassphraseStrength(3)
+	if err := weak.Err(); !errors.Is(err, ErrWeakPassphrase) {
+		t.Errorf("Expected ErrWeakPassphrase from Err, got %v", err)
+	}
+	if _, err := weak.Encrypt([]byte("your data")); !errors.Is(err, ErrWeakPassphrase) {
+		t.Errorf("Expected ErrWeakPassphrase from Encrypt, got %v", err)
+	}
+
+	strong := NewCypher("correct horse battery staple").WithMinPassphraseStrength(3)
+	if err := strong.Err(); err != nil {
+		t.Errorf("Expected strong passphrase to be accepted, got %v", err)
+	}
+
+	raw, err := NewCypherFromKey(make([]byte, KeySize))
+	if err != nil {
+		t.Fatalf("Failed to create cypher: %v", err)
+	}
+	if err := raw.WithMinPassphraseStrength(4).Err(); err != nil {
+		t.Errorf("Expected raw keys to be accepted, got %v", err)
+	}
+}
+
+func TestKeyfile(t *testing.T) {
+	keyfile := filepath.Join(t.TempDir(), "keyfile")
+	if err := GenerateKeyfile(keyfile); err != nil {
+		t.Fatalf("Failed to generate keyfile: %v", err)
+	}
+
+	c, err := NewCypherWithKeyfile("test-key", keyfile)
+	if err != nil {
+		t.Fatalf("Failed to create cypher: %v", err)
+	}
+	encrypted, err := c.Encrypt([]byte("your data"))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	again, err := NewCypherWithKeyfile("test-key", keyfile)
+	if err != nil {
+		t.Fatalf("Failed to create cypher: %v", err)
+	}
+	if decrypted, err := again.Decrypt(encrypted); err != nil || string(decrypted) != "your data" {
+		t.Errorf("Decryption with passphrase and keyfile failed: %v", err)
+	}
+
+	if _, err := NewCypher("test-key").Decrypt(encrypted); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch without the keyfile, got %v", err)
+	}
+	otherFile, err := NewCypherWithKeyfileReader("test-key", bytes.NewReader(randomBytes(t, MinKeyfileSize)))
+	if err != nil {
+		t.Fatalf("Failed to create cypher: %v", err)
+	}
+	if _, err := otherFile.Decrypt(encrypted); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch with another keyfile, got %v", err)
+	}
+
+	if _, err := NewCypherWithKeyfile("", keyfile); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("Expected ErrPassphraseRequired, got %v", err)
+	}
+	if _, err := NewCypherWithKeyfileReader("test-key", strings.NewReader("short")); !errors.Is(err, ErrKeyfileTooSmall) {
+		t.Errorf("Expected ErrKeyfileTooSmall, got %v", err)
+	}
+	if err := GenerateKeyfile(keyfile); err == nil {
+		t.Error("Expected GenerateKeyfile to refuse overwriting a keyfile")
+	}
+}
+
+func TestMinGeneration(t *testing.T) {
+	old, err := NewCypher("test-key").WithGeneration(1).Encrypt([]byte("your data"))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	current, err := NewCypher("test-key").WithGeneration(2).Encrypt([]byte("your data"))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	c := NewCypher("test-key").WithMinGeneration(2)
+	if _, err := c.Decrypt(current); err != nil {
+		t.Errorf("Decryption of current generation failed: %v", err)
+	}
+	if _, err := c.Decrypt(old); !errors.Is(err, ErrRollback) {
+		t.Errorf("Expected ErrRollback, got %v", err)
+	}
+
+	// Raising the generation in the header breaks its authentication
+	tampered := bytes.Clone(old)
+	tampered[c.headerSize()-4] = 2
+	if _, err := c.Decrypt(tampered); err == nil {
+		t.Error("Expected error when decrypting a tampered generation, got nil")
+	}
+}
+
+func TestNonceCounter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nonces.json")
+	c := NewCypher("test-key").WithChunkSize(16)
+
+	counter, err := OpenNonceCounter(path, c.KeyFingerprint())
+	if err != nil {
+		t.Fatalf("Failed to open nonce counter: %v", err)
+	}
+	data := randomBytes(t, 40)
+	encrypted, err := c.WithNonceCounter(counter).Encrypt(data)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	if decrypted, err := c.Decrypt(encrypted); err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+
+	reopened, err := OpenNonceCounter(path, c.KeyFingerprint())
+	if err != nil {
+		t.Fatalf("Failed to reopen nonce counter: %v", err)
+	}
+	if next, _ := reopened.Next(); next < 4 {
+		t.Errorf("Expected reopened counter past the used values, got %d", next)
+	}
+	if _, err := OpenNonceCounter(path, NewCypher("other-key").KeyFingerprint()); !errors.Is(err, ErrNonceCounterKey) {
+		t.Errorf("Expected ErrNonceCounterKey, got %v", err)
+	}
+
+	// Restoring a snapshot taken before the first encryption
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := OpenNonceCounter(path, c.KeyFingerprint())
+	if err != nil {
+		t.Fatalf("Failed to open nonce counter: %v", err)
+	}
+	rolledBack := c.Clone().WithNonceCounter(restored)
+	if _, err := rolledBack.Decrypt(encrypted); err != nil {
+		t.Errorf("Expected decryption to keep working after a rollback, got %v", err)
+	}
+	if _, err := rolledBack.Encrypt(data); !errors.Is(err, ErrNonceRollback) {
+		t.Errorf("Expected ErrNonceRollback, got %v", err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"name": "gocypher", "values": [1, 2, 3]}`+"\n"), 1000)
+
+	for _, algo := range []Compression{CompressionGzip, CompressionZstd} {
+		c := NewCypher("test-key").WithChunkSize(4096).WithCompression(algo, 0)
+		encrypted, err := c.Encrypt(data)
+		if err != nil {
+			t.Fatalf("Encryption with %v failed: %v", algo, err)
+		}
+		if len(encrypted) > len(data)/4 {
+			t.Errorf("Expected %v to shrink %d bytes of JSON, got %d", algo, len(data), len(encrypted))
+		}
+
+		// Decryption doesn't need to be told about the compression
+		decrypted, err := NewCypher("test-key").Decrypt(encrypted)
+		if err != nil {
+			t.Fatalf("Decryption with %v failed: %v", algo, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Errorf("Decrypted data doesn't match input with %v", algo)
+		}
+	}
+
+	if _, err := NewCypher("test-key").WithCompression(CompressionGzip, 42).Encrypt(data); err == nil {
+		t.Error("Expected error for invalid gzip level, got nil")
+	}
+}
+
+func TestCompressionIncompressible(t *testing.T) {
+	c := NewCypher("test-key").WithChunkSize(128*1024).WithCompression(CompressionZstd, 0)
+	text := bytes.Repeat([]byte("compressible "), 128*1024/13)
+	data := append(randomBytes(t, 300*1024), text...)
+
+	encrypted, err := c.Encrypt(data)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	if max := c.EncryptedSize(int64(len(data))); int64(len(encrypted)) > max {
+		t.Errorf("Expected at most %d bytes, got %d", max, len(encrypted))
+	}
+	if int64(len(encrypted)) >= c.EncryptedSize(int64(len(data)))-64*1024 {
+		t.Errorf("Expected the text chunk to be compressed, got %d bytes", len(encrypted))
+	}
+
+	decrypted, err := c.Decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Error("Decrypted data doesn't match input")
+	}
+}
+
+func TestContentDefinedChunking(t *testing.T) {
+	params := cdcParams{min: 1024, avg: 4096, max: 16384}
+	data := randomBytes(t, 256*1024)
+
+	split := func(data []byte) map[string]bool {
+		chunks := make(map[string]bool)
+		next := cdcFrames(params, nil)
+		src := bytes.NewReader(data)
+		for {
+			chunk, _, err := next(src)
+			if err == io.EOF {
+				return chunks
+			}
+			if err != nil {
+				t.Fatalf("Chunking failed: %v", err)
+			}
+			if len(chunk) > params.max || (len(chunk) < params.min && src.Len() > 0) {
+				t.Errorf("Chunk of %d bytes outside %d-%d", len(chunk), params.min, params.max)
+			}
+			chunks[string(chunk)] = true
+		}
+	}
+
+	original := split(data)
+	edited := split(append([]byte("inserted bytes"), data...))
+	shared := 0
+	for chunk := range edited {
+		if original[chunk] {
+			shared++
+		}
+	}
+	if shared < len(original)-3 {
+		t.Errorf("Expected an insertion to change only a few chunks, %d of %d are shared", shared, len(original))
+	}
+
+	c := NewCypher("test-key").WithContentDefinedChunking(params.min, params.avg, params.max)
+	encrypted, err := c.Encrypt(data)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	decrypted, err := NewCypher("test-key").Decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Error("Decrypted data doesn't match input")
+	}
+
+	if _, err := NewCypher("test-key").WithContentDefinedChunking(4096, 1024, 16384).Encrypt(data); err == nil {
+		t.Error("Expected error for invalid chunking sizes, got nil")
+	}
+}
+
+// splitFrames splits encrypted data into its length prefixed frames
+func splitFrames(t *testing.T, c *Cypher, encrypted []byte) [][]byte {
+	t.Helper()
+	var frames [][]byte
+	for body := encrypted[c.headerSize():]; len(body) > 0; {
+		size := int(binary.BigEndian.Uint32(body)&^trailerFlag) + FrameLengthSize
+		if size > len(body) {
+			t.Fatalf("Frame of %d bytes overruns the data", size)
+		}
+		frames = append(frames, body[:size])
+		body = body[size:]
+	}
+	return frames
+}
+
+func TestDeltaFriendlyOutput(t *testing.T) {
+	c := NewCypher("test-key").WithContentDefinedChunking(1024, 4096, 16384).WithDeltaFriendlyOutput()
+	data := randomBytes(t, 256*1024)
+
+	first, err := c.Encrypt(data)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	again, err := c.Encrypt(data)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	if !bytes.Equal(first, again) {
+		t.Error("Expected equal plaintexts to produce equal ciphertexts")
+	}
+
+	edited := append(bytes.Clone(data[:100*1024]), append([]byte("inserted"), data[100*1024:]...)...)
+	second, err := c.Encrypt(edited)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	frames := make(map[string]bool)
+	for _, frame := range splitFrames(t, c, first) {
+		frames[string(frame)] = true
+	}
+	changed := 0
+	for _, frame := range splitFrames(t, c, second) {
+		if !frames[string(frame)] {
+			changed++
+		}
+	}
+	if changed > 4 {
+		t.Errorf("Expected an insertion to change a few frames, %d changed", changed)
+	}
+
+	decrypted, err := NewCypher("test-key").Decrypt(second)
+	if err != nil {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, edited) {
+		t.Error("Decrypted data doesn't match input")
+	}
+
+	parts := splitFrames(t, c, first)
+	header := first[:c.headerSize()]
+	swapped := bytes.Join(append([][]byte{header, parts[1], parts[0]}, parts[2:]...), nil)
+	if _, err := c.Decrypt(swapped); !errors.Is(err, ErrChunksModified) {
+		t.Errorf("Expected ErrChunksModified for reordered chunks, got %v", err)
+	}
+	if _, err := c.Decrypt(first[:len(first)-trailerSize]); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated without the trailer, got %v", err)
+	}
+}
+
+func TestTruncation(t *testing.T) {
+	c := NewCypher("test-key", WithChunkSize(1024))
+	data := randomBytes(t, 5000)
+	sealed, err := c.Encrypt(data)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	if int64(len(sealed)) != c.EncryptedSize(int64(len(data))) {
+		t.Errorf("Expected %d bytes, got %d", c.EncryptedSize(int64(len(data))), len(sealed))
+	}
+
+	// Cut after the third chunk, with and without the trailer moved along
+	end := int(c.headerSize()) + 3*(chunkOverhead+1024)
+	trailer := sealed[len(sealed)-streamTrailerSize:]
+	for name, truncated := range map[string][]byte{
+		"bare":    sealed[:end],
+		"trailer": append(bytes.Clone(sealed[:end]), trailer...),
+	} {
+		if _, err := c.Decrypt(truncated); !errors.Is(err, ErrTruncated) {
+			t.Errorf("%s: expected ErrTruncated, got %v", name, err)
+		}
+		if _, err := c.NewSeekableReader(bytes.NewReader(truncated), int64(len(truncated))); !errors.Is(err, ErrTruncated) {
+			t.Errorf("%s: expected ErrTruncated from NewSeekableReader, got %v", name, err)
+		}
+		path := filepath.Join(t.TempDir(), "truncated.encrypted")
+		os.WriteFile(path, truncated, 0600)
+		if _, err := c.OpenAppend(path); !errors.Is(err, ErrTruncated) {
+			t.Errorf("%s: expected ErrTruncated from OpenAppend, got %v", name, err)
+		}
+	}
+
+	if _, err := c.Decrypt(append(bytes.Clone(sealed), 0)); !errors.Is(err, ErrChunksModified) {
+		t.Errorf("Expected ErrChunksModified for data after the trailer, got %v", err)
+	}
+	if decrypted, err := c.Decrypt(sealed); err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("Expected the whole data to decrypt: %v", err)
+	}
+}
+
+func TestUpdateEncryptedFile(t *testing.T) {
+	dir := t.TempDir()
+	c := NewCypher("test-key").WithChunkSize(1024)
+
+	original := randomBytes(t, 4*1024)
+	plainPath := filepath.Join(dir, "plain")
+	encPath := filepath.Join(dir, "plain.encrypted")
+	if err := os.WriteFile(plainPath, original, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.EncryptFileToPath(context.Background(), plainPath, encPath); err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	before, err := os.ReadFile(encPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the second chunk and append a fifth one
+	updated := append(bytes.Clone(original), randomBytes(t, 100)...)
+	updated[1500] ^= 0xff
+	if err := os.WriteFile(plainPath, updated, 0600); err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.UpdateEncryptedFile(encPath, plainPath)
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if !result.InPlace || result.Chunks != 5 || result.Rewritten != 2 {
+		t.Errorf("Expected 2 of 5 chunks rewritten in place, got %+v", result)
+	}
+
+	after, err := os.ReadFile(encPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := int(c.headerSize())
+	frame := chunkOverhead + 1024
+	if !bytes.Equal(before[:header+frame], after[:header+frame]) || !bytes.Equal(before[header+2*frame:header+4*frame], after[header+2*frame:header+4*frame]) {
+		t.Error("Expected unchanged chunks to be kept as they were")
+	}
+	decrypted, err := c.Decrypt(after)
+	if err != nil || !bytes.Equal(decrypted, updated) {
+		t.Fatalf("Expected the updated content, got error %v", err)
+	}
+
+	// Shrinking drops the trailing chunks
+	if err := os.WriteFile(plainPath, updated[:1500], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UpdateEncryptedFile(encPath, plainPath); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	after, _ = os.ReadFile(encPath)
+	if decrypted, err := c.Decrypt(after); err != nil || !bytes.Equal(decrypted, updated[:1500]) {
+		t.Errorf("Expected the truncated content, got error %v", err)
+	}
+
+	// Another chunk size can't be updated in place
+	result, err = NewCypher("test-key").WithChunkSize(512).UpdateEncryptedFile(encPath, plainPath)
+	if err != nil || result.InPlace {
+		t.Errorf("Expected the file to be encrypted again, got %+v: %v", result, err)
+	}
+	if _, err := NewCypher("other-key").WithChunkSize(512).UpdateEncryptedFile(encPath, plainPath); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch, got %v", err)
+	}
+
+	// Neither another key nor a plaintext target is replaced, even when the
+	// file would be encrypted again as a whole
+	before, _ = os.ReadFile(encPath)
+	other := NewCypher("other-key").WithCompression(CompressionGzip, 0)
+	if _, err := other.UpdateEncryptedFile(encPath, plainPath); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch for a compressing cypher, got %v", err)
+	}
+	if after, _ := os.ReadFile(encPath); !bytes.Equal(after, before) {
+		t.Error("Expected the file encrypted with another key untouched")
+	}
+	if _, err := c.Clone().WithCompression(CompressionGzip, 0).UpdateEncryptedFile(plainPath, encPath); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Expected ErrInvalidHeader for a plaintext target, got %v", err)
+	}
+	if plain, _ := os.ReadFile(plainPath); !bytes.Equal(plain, updated[:1500]) {
+		t.Error("Expected the plaintext target untouched")
+	}
+}
+
+func TestStream(t *testing.T) {
+	c := NewCypher("test-key").WithChunkSize(1024)
+	data := randomBytes(t, 5000)
+
+	var encrypted, decrypted bytes.Buffer
+	if _, err := c.EncryptStream(context.Background(), bytes.NewReader(data), &encrypted); err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	// Streams use the same format as Encrypt
+	if plain, err := c.Decrypt(encrypted.Bytes()); err != nil || !bytes.Equal(plain, data) {
+		t.Fatalf("Expected Decrypt to read stream output, got error %v", err)
+	}
+	stats, err := c.DecryptStream(context.Background(), &encrypted, &decrypted)
+	if err != nil {
+		t.Fatalf("DecryptStream failed: %v", err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), data) || stats.Chunks != 5 {
+		t.Errorf("Expected the original data in 5 chunks, got %d chunks", stats.Chunks)
+	}
+}
+
+func TestLowMemory(t *testing.T) {
+	data := randomBytes(t, 4*1024*1024)
+	for name, c := range map[string]*Cypher{
+		"plain":      NewCypher("test-key").WithLowMemory(),
+		"compressed": NewCypher("test-key").WithLowMemory().WithCompression(CompressionGzip, 0),
+		"delta":      NewCypher("test-key").WithDeltaFriendlyOutput().WithLowMemory(),
+	} {
+		encrypted, err := c.Encrypt(data)
+		if err != nil {
+			t.Fatalf("%s: encryption failed: %v", name, err)
+		}
+		decrypted, err := c.Decrypt(encrypted)
+		if err != nil || !bytes.Equal(decrypted, data) {
+			t.Fatalf("%s: expected the original data, got error %v", name, err)
+		}
+	}
+
+	// Streaming needs far less memory than the data size
+	c := NewCypher("test-key").WithLowMemory()
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	if _, err := c.EncryptStream(context.Background(), bytes.NewReader(data), io.Discard); err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > uint64(len(data)/2) {
+		t.Errorf("Expected chunk buffers to be reused, %d bytes allocated", allocated)
+	}
+}
+
+func TestAlgorithm(t *testing.T) {
+	data := randomBytes(t, 3000)
+
+	chacha := NewCypher("test-key").WithChunkSize(1024).WithAlgorithm(AlgorithmChaCha20Poly1305)
+	encrypted, err := chacha.Encrypt(data)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	if int64(len(encrypted)) != chacha.EncryptedSize(int64(len(data))) {
+		t.Errorf("Expected %d bytes, got %d", chacha.EncryptedSize(int64(len(data))), len(encrypted))
+	}
+	// The header records the algorithm, so any Cypher with the key decrypts
+	decrypted, err := NewCypher("test-key").WithChunkSize(1024).WithAlgorithm(AlgorithmAES256GCM).Decrypt(encrypted)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Expected ChaCha20-Poly1305 data to decrypt, got error %v", err)
+	}
+
+	// Without AES hardware the automatic choice falls back to ChaCha20-Poly1305
+	defer func(has bool) { hasAESHardware = has }(hasAESHardware)
+	hasAESHardware = false
+	auto := NewCypher("test-key")
+	if got := auto.encryptAlgorithm(); got != AlgorithmChaCha20Poly1305 {
+		t.Errorf("Expected ChaCha20-Poly1305 without AES hardware, got %s", got)
+	}
+	hasAESHardware = true
+	if got := auto.encryptAlgorithm(); got != AlgorithmAES256GCM {
+		t.Errorf("Expected AES-256-GCM with AES hardware, got %s", got)
+	}
+}
+
+// countingBackend counts the AEADs it creates with the software backend
+type countingBackend struct {
+	created int
+}
+
+func (b *countingBackend) NewAEAD(algorithm Algorithm, key []byte) (cipher.AEAD, error) {
+	b.created++
+	return newAEAD(algorithm, key)
+}
+
+func TestBackend(t *testing.T) {
+	backend := &countingBackend{}
+	c := NewCypher("test-key").WithBackend(backend)
+	data := randomBytes(t, 1000)
+
+	encrypted, err := c.Encrypt(data)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	// Data sealed by a backend is readable without it
+	decrypted, err := NewCypher("test-key").Decrypt(encrypted)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Expected the original data, got error %v", err)
+	}
+	if backend.created != 1 {
+		t.Errorf("Expected the backend to create 1 AEAD, got %d", backend.created)
+	}
+}
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := parseCPUList("0-3,8,10-11\n")
+	if err != nil {
+		t.Fatalf("parseCPUList failed: %v", err)
+	}
+	if fmt.Sprint(cpus) != "[0 1 2 3 8 10 11]" {
+		t.Errorf("Unexpected CPUs %v", cpus)
+	}
+	for _, bad := range []string{"a", "3-1", "1-b"} {
+		if _, err := parseCPUList(bad); err == nil {
+			t.Errorf("Expected error parsing %q, got nil", bad)
+		}
+	}
+}
+
+func TestNUMANode(t *testing.T) {
+	// Pinning is best effort, the roundtrip must work whether node 0 exists
+	c := NewCypher("test-key").WithNUMANode(0)
+	data := randomBytes(t, 1000)
+	encrypted, err := c.Encrypt(data)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	decrypted, err := c.Decrypt(encrypted)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Expected the original data, got error %v", err)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	dir := t.TempDir()
+	op := &operation{spillBudget: 10, spillDir: dir}
+	input := make(chan DataChunk, 4)
+	for _, position := range []int{3, 1, 2, 0} {
+		input <- DataChunk{data: []byte(strings.Repeat(string(rune('a'+position)), 8)), position: position, size: 8}
+	}
+	close(input)
+
+	var out bytes.Buffer
+	fail := func(kind string, err error) { t.Errorf("Writer failed: %v", err) }
+	writeChunks(context.Background(), &out, input, op, nil, fail)
+	if got := out.String(); got != "aaaaaaaabbbbbbbbccccccccdddddddd" {
+		t.Errorf("Expected the chunks in order, got %q", got)
+	}
+	if op.spilled != 2 {
+		t.Errorf("Expected 2 spilled chunks, got %d", op.spilled)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected the spill file to be removed, got %d files", len(entries))
+	}
+
+	c := NewCypher("test-key").WithChunkSize(1024).WithSpill(1, dir)
+	data := randomBytes(t, 64*1024)
+	encrypted, err := c.Encrypt(data)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	decrypted, err := c.Decrypt(encrypted)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Expected the original data, got error %v", err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	c := NewCypher("test-key", WithChunkSize(4096), WithNumWorkers(3), WithCompression(CompressionZstd, 0))
+	if c.ChunkSize != 4096 || c.NumWorkers != 3 || c.compression != CompressionZstd {
+		t.Errorf("Expected the options to be applied, got %v", c)
+	}
+	data := randomBytes(t, 10000)
+	encrypted, err := c.Encrypt(data)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	if decrypted, err := c.Decrypt(encrypted); err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Expected the original data, got error %v", err)
+	}
+
+	// Options see the passphrase the key was derived from
+	if err := NewCypher("test-key", WithMinPassphraseStrength(4)).Err(); !errors.Is(err, ErrWeakPassphrase) {
+		t.Errorf("Expected ErrWeakPassphrase, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if _, err := NewCypherE(""); !errors.Is(err, ErrEmptyKey) {
+		t.Errorf("Expected ErrEmptyKey, got %v", err)
+	}
+	_, err := NewCypherE("test-key", WithChunkSize(0), WithNumWorkers(-1))
+	var configErr *ConfigError
+	if !errors.Is(err, ErrInvalidConfig) || !errors.As(err, &configErr) || configErr.Field != "chunk size" {
+		t.Fatalf("Expected a chunk size ConfigError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "number of workers") {
+		t.Errorf("Expected every invalid setting to be reported, got %v", err)
+	}
+	if _, err := NewCypherE("test-key", WithContentDefinedChunking(8, 4, 16)); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected invalid chunking sizes to be rejected, got %v", err)
+	}
+
+	// Settings changed after creation are checked when an operation starts
+	c, err := NewCypherE("test-key")
+	if err != nil {
+		t.Fatalf("NewCypherE failed: %v", err)
+	}
+	if _, err := c.WithNumWorkers(0).Encrypt([]byte("data")); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+// concurrencyAEAD records the highest number of concurrent Seal calls
+type concurrencyAEAD struct {
+	cipher.AEAD
+	active, peak *atomic.Int32
+}
+
+func (a concurrencyAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	n := a.active.Add(1)
+	defer a.active.Add(-1)
+	for peak := a.peak.Load(); n > peak && !a.peak.CompareAndSwap(peak, n); peak = a.peak.Load() {
+	}
+	time.Sleep(time.Millisecond)
+	return a.AEAD.Seal(dst, nonce, plaintext, additionalData)
+}
+
+type concurrencyBackend struct {
+	active, peak atomic.Int32
+}
+
+func (b *concurrencyBackend) NewAEAD(algorithm Algorithm, key []byte) (cipher.AEAD, error) {
+	aead, err := newAEAD(algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+	return concurrencyAEAD{AEAD: aead, active: &b.active, peak: &b.peak}, nil
+}
+
+func TestNumCoresBoundsWorkers(t *testing.T) {
+	backend := &concurrencyBackend{}
+	c := NewCypher("test-key", WithChunkSize(1024), WithNumWorkers(8), WithBackend(backend))
+	c.NumCores = 2
+	if _, err := c.Encrypt(randomBytes(t, 64*1024)); err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	if peak := backend.peak.Load(); peak > 2 {
+		t.Errorf("Expected at most 2 workers sealing at once, got %d", peak)
+	}
+}
+
+func TestCloneDeriveSubCypher(t *testing.T) {
+	c := NewCypher("test-key", WithChunkSize(4096))
+	clone := c.Clone()
+	clone.Close()
+	if err := c.Err(); err != nil {
+		t.Fatalf("Expected closing the clone to leave c usable, got %v", err)
+	}
+
+	tenantA, err := c.DeriveSubCypher("tenant a")
+	if err != nil {
+		t.Fatalf("DeriveSubCypher failed: %v", err)
+	}
+	again, _ := c.DeriveSubCypher("tenant a")
+	tenantB, _ := c.DeriveSubCypher("tenant b")
+	if tenantA.ChunkSize != 4096 {
+		t.Errorf("Expected the settings of c, got chunk size %d", tenantA.ChunkSize)
+	}
+
+	encrypted, err := tenantA.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	if decrypted, err := again.Decrypt(encrypted); err != nil || string(decrypted) != "secret" {
+		t.Errorf("Expected the same context to derive the same key, got error %v", err)
+	}
+	for name, other := range map[string]*Cypher{"master": c, "other tenant": tenantB} {
+		if _, err := other.Decrypt(encrypted); !errors.Is(err, ErrKeyMismatch) {
+			t.Errorf("Expected ErrKeyMismatch decrypting with the %s key, got %v", name, err)
+		}
+	}
+}
+
+func TestEnvironmentDefaults(t *testing.T) {
+	t.Setenv(EnvChunkSize, "4096")
+	t.Setenv(EnvWorkers, "3")
+	t.Setenv(EnvCipher, "chacha20-poly1305")
+
+	var logs bytes.Buffer
+	c := NewCypher("test-key", WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	if c.ChunkSize != 4096 || c.NumWorkers != 3 || c.encryptAlgorithm() != AlgorithmChaCha20Poly1305 {
+		t.Errorf("Expected the environment defaults, got %v with %s", c, c.algorithm)
+	}
+	if !strings.Contains(logs.String(), EnvWorkers) {
+		t.Errorf("Expected the overrides to be logged, got %q", logs.String())
+	}
+	if c := NewCypher("test-key", WithNumWorkers(5)); c.NumWorkers != 5 {
+		t.Errorf("Expected options to take precedence, got %d workers", c.NumWorkers)
+	}
+
+	t.Setenv(EnvWorkers, "lots")
+	if c := NewCypher("test-key"); c.NumWorkers != 10 {
+		t.Errorf("Expected an invalid value to be ignored, got %d workers", c.NumWorkers)
+	}
+	if _, err := NewCypherE("test-key"); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), EnvWorkers) {
+		t.Errorf("Expected NewCypherE to reject %s, got %v", EnvWorkers, err)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	const passphrase = "correct horse battery staple violin"
+	salt, err := NewProfileSalt()
+	if err != nil {
+		t.Fatalf("NewProfileSalt failed: %v", err)
+	}
+	fast, err := NewCypherWithProfile(passphrase, Fast, salt)
+	if err != nil {
+		t.Fatalf("NewCypherWithProfile failed: %v", err)
+	}
+	balanced, err := NewCypherWithProfile(passphrase, Balanced, salt, WithChunkSize(4096))
+	if err != nil {
+		t.Fatalf("NewCypherWithProfile failed: %v", err)
+	}
+	if fast.ChunkSize != 16*1024*1024 || balanced.ChunkSize != 4096 {
+		t.Errorf("Expected the profile chunk size unless overridden, got %d and %d", fast.ChunkSize, balanced.ChunkSize)
+	}
+
+	encrypted, err := fast.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	again, _ := NewCypherWithProfile(passphrase, Fast, salt)
+	if decrypted, err := again.Decrypt(encrypted); err != nil || string(decrypted) != "secret" {
+		t.Errorf("Expected the same profile to derive the same key, got error %v", err)
+	}
+	if _, err := balanced.Decrypt(encrypted); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch with another profile, got %v", err)
+	}
+	otherSalt, _ := NewProfileSalt()
+	if other, err := NewCypherWithProfile(passphrase, Fast, otherSalt); err != nil {
+		t.Errorf("NewCypherWithProfile failed: %v", err)
+	} else if _, err := other.Decrypt(encrypted); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch with another salt, got %v", err)
+	}
+
+	if _, err := NewCypherWithProfile("password1", Balanced, salt); !errors.Is(err, ErrWeakPassphrase) {
+		t.Errorf("Expected ErrWeakPassphrase, got %v", err)
+	}
+	if _, err := NewCypherWithProfile(passphrase, Profile(42), salt); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected an unknown profile to be rejected, got %v", err)
+	}
+	if _, err := NewCypherWithProfile(passphrase, Fast, []byte("short")); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected a short salt to be rejected, got %v", err)
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	var logs bytes.Buffer
+	c := NewCypher("test-key", WithChunkSize(1024), WithNumWorkers(4), WithCompression(CompressionZstd, 0),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn}))))
+	dir := t.TempDir()
+
+	errs := make(chan error, 16)
+	inputs := make([][]byte, cap(errs))
+	for i := range inputs {
+		inputs[i] = randomBytes(t, 20*1024+i)
+	}
+	for i, data := range inputs {
+		go func() {
+			encrypted, err := c.Encrypt(data)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if decrypted, err := c.Decrypt(encrypted); err != nil || !bytes.Equal(decrypted, data) {
+				errs <- fmt.Errorf("roundtrip %d failed: %v", i, err)
+				return
+			}
+
+			path := filepath.Join(dir, fmt.Sprintf("file%d", i))
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := c.EncryptFileToPath(context.Background(), path, path+".enc"); err != nil {
+				errs <- err
+				return
+			}
+			_, err = c.DecryptFileToPath(context.Background(), path+".enc", path+".dec")
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	if logs.Len() != 0 {
+		t.Errorf("Expected no warnings, got %q", logs.String())
+	}
+	c.Clone().WithChunkSize(2048)
+	if logs.Len() != 0 {
+		t.Errorf("Expected configuring a clone not to warn, got %q", logs.String())
+	}
+	c.WithChunkSize(2048)
+	if !strings.Contains(logs.String(), "reconfigured") {
+		t.Errorf("Expected reconfiguring a used Cypher to warn, got %q", logs.String())
+	}
+	if c.ChunkSize != 1024 {
+		t.Errorf("Expected the settings of a used Cypher to stay unchanged, got chunk size %d", c.ChunkSize)
+	}
+	if err := c.Validate(); !errors.Is(err, ErrConfiguredAfterUse) {
+		t.Errorf("Expected Validate to report the ignored change, got %v", err)
+	}
+	if err := c.Err(); !errors.Is(err, ErrConfiguredAfterUse) {
+		t.Errorf("Expected Err to report the ignored change, got %v", err)
+	}
+	if _, err := c.Encrypt([]byte("data")); !errors.Is(err, ErrConfiguredAfterUse) {
+		t.Errorf("Expected operations to fail after the ignored change, got %v", err)
+	}
+	clone := c.Clone()
+	if err := clone.Validate(); err != nil {
+		t.Errorf("Expected a clone to be usable, got %v", err)
+	}
+
+	// The exported fields are checked against their value at first use
+	if _, err := clone.Encrypt([]byte("data")); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	clone.NumWorkers++
+	if _, err := clone.Encrypt([]byte("data")); !errors.Is(err, ErrConfiguredAfterUse) {
+		t.Errorf("Expected changing NumWorkers after use to fail, got %v", err)
+	}
+}
+
+// panickingAEAD panics when sealing
+type panickingAEAD struct {
+	cipher.AEAD
+}
+
+func (panickingAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	panic("broken accelerator")
+}
+
+type panickingBackend struct{}
+
+func (panickingBackend) NewAEAD(algorithm Algorithm, key []byte) (cipher.AEAD, error) {
+	aead, err := newAEAD(algorithm, key)
+	return panickingAEAD{aead}, err
+}
+
+func TestNoPanics(t *testing.T) {
+	if _, err := NewCypher("test-key", WithBackend(panickingBackend{})).Encrypt([]byte("data")); !errors.Is(err, ErrPanic) {
+		t.Errorf("Expected a panicking backend to fail with ErrPanic, got %v", err)
+	}
+	progress := func(Progress) { panic("broken callback") }
+	if _, err := NewCypher("test-key", WithProgress(progress)).Encrypt([]byte("data")); !errors.Is(err, ErrPanic) {
+		t.Errorf("Expected a panicking callback to fail with ErrPanic, got %v", err)
+	}
+
+	// Malformed input fails with an error
+	c := NewCypher("test-key", WithChunkSize(64))
+	encrypted, err := c.Encrypt(randomBytes(t, 300))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	for n := 0; n < len(encrypted); n++ {
+		corrupted := append([]byte(nil), encrypted...)
+		corrupted[n] ^= 0xff
+		if _, err := c.Decrypt(corrupted); errors.Is(err, ErrPanic) {
+			t.Fatalf("Decrypting data modified at byte %d panicked: %v", n, err)
+		}
+		if _, err := c.Decrypt(encrypted[:n]); errors.Is(err, ErrPanic) {
+			t.Fatalf("Decrypting data truncated to %d bytes panicked: %v", n, err)
+		}
+	}
+}
+
+func TestKeyFunc(t *testing.T) {
+	keys := [][]byte{bytes.Repeat([]byte{1}, KeySize), bytes.Repeat([]byte{2}, KeySize)}
+	calls := 0
+	fetch := func(ctx context.Context) ([]byte, error) {
+		if calls == len(keys) {
+			return nil, errors.New("no more keys")
+		}
+		calls++
+		return append([]byte(nil), keys[calls-1]...), nil
+	}
+	c, err := NewCypherWithKeyFunc(fetch, WithChunkSize(1024))
+	if err != nil {
+		t.Fatalf("NewCypherWithKeyFunc failed: %v", err)
+	}
+	if calls != 0 || c.KeyFingerprint() != "" {
+		t.Fatalf("Expected the key to be fetched on first use, got %d calls", calls)
+	}
+
+	first, err := c.Encrypt([]byte("first"))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	if _, err := c.Encrypt([]byte("again")); err != nil || calls != 1 {
+		t.Fatalf("Expected the key to be fetched once, got %d calls: %v", calls, err)
+	}
+	fromKey, _ := NewCypherFromKey(keys[0])
+	if c.KeyFingerprint() != fromKey.KeyFingerprint() {
+		t.Error("Expected the fingerprint of the fetched key")
+	}
+
+	if err := c.RefreshKey(context.Background()); err != nil {
+		t.Fatalf("RefreshKey failed: %v", err)
+	}
+	if _, err := c.Decrypt(first); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected the rotated key to be used, got %v", err)
+	}
+	if err := c.RefreshKey(context.Background()); err == nil {
+		t.Error("Expected the failing KeyFunc to be reported, got nil")
+	}
+	if err := fromKey.RefreshKey(context.Background()); !errors.Is(err, ErrNoKeyFunc) {
+		t.Errorf("Expected ErrNoKeyFunc, got %v", err)
+	}
+}
+
+func TestEncryptFromHandles(t *testing.T) {
+	var total int64
+	c := NewCypher("test-key", WithChunkSize(1024), WithProgress(func(p Progress) { total = p.BytesTotal }))
+	data := randomBytes(t, 5000)
+
+	// Handles are processed from their current offset
+	src := bytes.NewReader(append([]byte("prefix"), data...))
+	src.Seek(int64(len("prefix")), io.SeekStart)
+	var encrypted bytes.Buffer
+	if _, err := c.EncryptFrom(context.Background(), src, &encrypted); err != nil {
+		t.Fatalf("EncryptFrom failed: %v", err)
+	}
+	if total != int64(len(data)) {
+		t.Errorf("Expected the input size %d to be reported, got %d", len(data), total)
+	}
+
+	out, err := os.CreateTemp(t.TempDir(), "decrypted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if _, err := c.DecryptFrom(context.Background(), bytes.NewReader(encrypted.Bytes()), out); err != nil {
+		t.Fatalf("DecryptFrom failed: %v", err)
+	}
+	if got, _ := os.ReadFile(out.Name()); !bytes.Equal(got, data) {
+		t.Error("Expected the original data")
+	}
+}
+
+func TestRawSealOpen(t *testing.T) {
+	key := randomBytes(t, KeySize)
+	c, err := NewCypherFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := c.RawSeal([]byte("small payload"), []byte("context"))
+	if err != nil {
+		t.Fatalf("RawSeal failed: %v", err)
+	}
+	if len(sealed) != 12+len("small payload")+16 {
+		t.Errorf("Expected nonce, ciphertext and tag only, got %d bytes", len(sealed))
+	}
+
+	// Plain AES-GCM reads and writes the same layout
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	if plain, err := gcm.Open(nil, sealed[:12], sealed[12:], []byte("context")); err != nil || string(plain) != "small payload" {
+		t.Errorf("Expected AES-GCM to open RawSeal output, got %q: %v", plain, err)
+	}
+	nonce := randomBytes(t, 12)
+	if plain, err := c.RawOpen(gcm.Seal(nonce, nonce, []byte("from elsewhere"), nil), nil); err != nil || string(plain) != "from elsewhere" {
+		t.Errorf("Expected RawOpen to open AES-GCM output, got %q: %v", plain, err)
+	}
+
+	if _, err := c.RawOpen(sealed, nil); err == nil {
+		t.Error("Expected error with other additional data, got nil")
+	}
+	if _, err := c.RawOpen(sealed[:20], nil); !errors.Is(err, ErrRawTooShort) {
+		t.Errorf("Expected ErrRawTooShort, got %v", err)
+	}
+}
+
+// sealLegacy writes plaintext the way an older encrypt-then-MAC tool would
+func sealLegacy(t *testing.T, format LegacyFormat, plaintext []byte) []byte {
+	t.Helper()
+	block, _ := aes.NewCipher(format.Key)
+	iv := randomBytes(t, aes.BlockSize)
+	var ciphertext []byte
+	if format.Mode == LegacyCBC {
+		padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+		ciphertext = append(append([]byte(nil), plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+	} else {
+		ciphertext = make([]byte, len(plaintext))
+		cipher.NewCTR(block, iv).XORKeyStream(ciphertext, plaintext)
+	}
+	mac := hmac.New(sha256.New, format.MACKey)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	return mac.Sum(append(iv, ciphertext...))
+}
+
+func TestLegacyFormats(t *testing.T) {
+	c := NewCypher("test-key", WithChunkSize(4096))
+	// Larger than the read buffer to cover held back CBC blocks
+	plaintext := randomBytes(t, 100*1024+5)
+	for _, mode := range []LegacyMode{LegacyCBC, LegacyCTR} {
+		format := LegacyFormat{Mode: mode, Key: randomBytes(t, 16), MACKey: randomBytes(t, 32)}
+		sealed := sealLegacy(t, format, plaintext)
+		if got, err := DecryptLegacy(format, sealed); err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("Mode %d: expected the plaintext, got %d bytes: %v", mode, len(got), err)
+		}
+
+		dir := t.TempDir()
+		input, output := filepath.Join(dir, "archive.old"), filepath.Join(dir, "archive.enc")
+		os.WriteFile(input, sealed, 0600)
+		if _, err := c.ReEncryptFile(context.Background(), input, output, format); err != nil {
+			t.Fatalf("Mode %d: ReEncryptFile failed: %v", mode, err)
+		}
+		if _, err := c.DecryptFileToPath(context.Background(), output, filepath.Join(dir, "archive")); err != nil {
+			t.Fatalf("Mode %d: DecryptFileToPath failed: %v", mode, err)
+		}
+		if got, _ := os.ReadFile(filepath.Join(dir, "archive")); !bytes.Equal(got, plaintext) {
+			t.Errorf("Mode %d: expected the migrated file to hold the plaintext", mode)
+		}
+
+		sealed[len(sealed)/2] ^= 1
+		os.WriteFile(input, sealed, 0600)
+		if _, err := c.ReEncryptFile(context.Background(), input, output, format); !errors.Is(err, ErrLegacyMAC) {
+			t.Errorf("Mode %d: expected ErrLegacyMAC, got %v", mode, err)
+		}
+	}
+}
+
+func TestShredSource(t *testing.T) {
+	c := NewCypher("test-key", WithChunkSize(1024), WithShredSource(2))
+	dir := t.TempDir()
+	input := filepath.Join(dir, "plain.txt")
+	data := randomBytes(t, 5000)
+	os.WriteFile(input, data, 0600)
+
+	result, err := c.EncryptFileWithStats(context.Background(), input)
+	if err != nil {
+		t.Fatalf("EncryptFileWithStats failed: %v", err)
+	}
+	if result.Shred == nil || !result.Shred.Removed || result.Shred.Passes != 2 {
+		t.Fatalf("Expected the source shredded, got %+v", result.Shred)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected only the output left, got %d files", len(entries))
+	}
+	sealed, _ := os.ReadFile(result.OutputPath)
+	decrypted, err := c.Decrypt(sealed)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("Expected the output to hold the source: %v", err)
+	}
+
+	if err := NewCypher("test-key", WithShredSource(-1)).Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestBindToMachine(t *testing.T) {
+	c := NewCypher("test-key")
+	here, err := c.BindTo([]byte("machine a"))
+	if err != nil {
+		t.Fatalf("BindTo failed: %v", err)
+	}
+	elsewhere, _ := c.BindTo([]byte("machine b"))
+	sealed, err := here.Encrypt([]byte("cached credentials"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Decrypt(sealed); err == nil {
+		t.Error("Expected the unbound key to fail")
+	}
+	if _, err := elsewhere.Decrypt(sealed); err == nil {
+		t.Error("Expected another machine to fail")
+	}
+	again, _ := c.BindTo([]byte("machine a"))
+	if plain, err := again.Decrypt(sealed); err != nil || string(plain) != "cached credentials" {
+		t.Errorf("Expected the same machine to decrypt, got %q: %v", plain, err)
+	}
+
+	if _, err := c.BindToMachine(); err != nil && !errors.Is(err, ErrNoMachineID) {
+		t.Errorf("BindToMachine failed: %v", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	expired, err := NewCypher("test-key", WithExpiry(time.Now().Add(-time.Hour))).Encrypt([]byte("handoff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, _ := NewCypher("test-key", WithExpiry(time.Now().Add(time.Hour))).Encrypt([]byte("handoff"))
+	if h, err := ParseHeader(bytes.NewReader(valid), ParseStrict); err != nil || h.Expiry.IsZero() {
+		t.Errorf("Expected the expiry in the header, got %+v: %v", h, err)
+	}
+
+	enforcing := NewCypher("test-key", WithEnforceExpiry())
+	if _, err := enforcing.Decrypt(expired); !errors.Is(err, ErrExpired) {
+		t.Errorf("Expected ErrExpired, got %v", err)
+	}
+	if plain, err := enforcing.Decrypt(valid); err != nil || string(plain) != "handoff" {
+		t.Errorf("Expected unexpired data to decrypt, got %q: %v", plain, err)
+	}
+	if _, err := NewCypher("test-key").Decrypt(expired); err != nil {
+		t.Errorf("Expected expiry to be enforced only on request, got %v", err)
+	}
+
+	// Moving the expiry breaks the authentication of the chunks
+	h, _ := ParseHeader(bytes.NewReader(expired), ParseStrict)
+	extended := bytes.Clone(expired)
+	binary.BigEndian.PutUint64(extended[len(h.Raw)-3-8:], uint64(time.Now().Add(time.Hour).Unix()))
+	if _, err := enforcing.Decrypt(extended); err == nil || errors.Is(err, ErrExpired) {
+		t.Errorf("Expected an authentication failure, got %v", err)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	pool := NewWorkerPool(3)
+	data := make([]byte, 10*1024+17)
+	rand.Read(data)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := NewCypher("test-key", WithChunkSize(1024), WithNumWorkers(8), WithWorkerPool(pool))
+			encrypted, err := c.Encrypt(data)
+			if err != nil {
+				errs <- err
+				return
+			}
+			decrypted, err := c.Decrypt(encrypted)
+			if err == nil && !bytes.Equal(decrypted, data) {
+				err = errors.New("decrypted data differs")
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Pooled operation failed: %v", err)
+		}
+	}
+
+	pool.Close()
+	if _, err := NewCypher("test-key", WithChunkSize(1024), WithWorkerPool(pool)).Encrypt(data); !errors.Is(err, ErrWorkerPoolClosed) {
+		t.Errorf("Expected ErrWorkerPoolClosed, got %v", err)
+	}
+}
+
+func TestMaxMemory(t *testing.T) {
+	data := make([]byte, 100*1024+5)
+	rand.Read(data)
+	for _, budget := range []int64{1, 4 * 1024, 1 << 20} {
+		c := NewCypher("test-key", WithChunkSize(1024), WithNumWorkers(16), WithMaxMemory(budget))
+		encrypted, err := c.Encrypt(data)
+		if err != nil {
+			t.Fatalf("Encrypt with a budget of %d failed: %v", budget, err)
+		}
+		decrypted, err := c.Decrypt(encrypted)
+		if err != nil || !bytes.Equal(decrypted, data) {
+			t.Errorf("Round trip with a budget of %d failed: %v", budget, err)
+		}
+	}
+
+	b := newMemoryBudget(4096)
+	if n, err := b.acquire(context.Background(), 10000); err != nil || n != 4096 {
+		t.Errorf("Expected the whole budget, got %d: %v", n, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := b.acquire(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected acquire to wait for memory, got %v", err)
+	}
+	b.release(4096)
+	if _, err := b.acquire(context.Background(), 1); err != nil {
+		t.Errorf("Expected released memory to be available, got %v", err)
+	}
+
+	if err := NewCypher("test-key", WithMaxMemory(-1)).Validate(); err == nil {
+		t.Error("Expected a negative budget to be rejected")
+	}
+}
+
+func TestCanceledError(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "large.bin")
+	data := make([]byte, 64*1024)
+	rand.Read(data)
+	if err := os.WriteFile(input, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCypher("test-key", WithChunkSize(1024), WithNumWorkers(2), WithProgress(func(p Progress) {
+		if p.BytesDone >= 8*1024 {
+			cancel()
+		}
+	}))
+	_, err := c.EncryptFileToPath(ctx, input, input+".enc")
+	var canceled *CanceledError
+	if !errors.Is(err, context.Canceled) || !errors.As(err, &canceled) {
+		t.Fatalf("Expected a CanceledError, got %v", err)
+	}
+	if canceled.Processed < 8*1024 || canceled.Processed >= int64(len(data)) {
+		t.Errorf("Expected part of the input processed, got %d bytes", canceled.Processed)
+	}
+}
+
+func TestEncryptFileTo(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "report.pdf")
+	data := make([]byte, 20*1024+3)
+	rand.Read(data)
+	if err := os.WriteFile(input, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCypher("test-key", WithChunkSize(1024))
+	var local, remote bytes.Buffer
+	if _, err := c.EncryptFileTo(context.Background(), input, &local, &remote); err != nil {
+		t.Fatalf("EncryptFileTo failed: %v", err)
+	}
+	if !bytes.Equal(local.Bytes(), remote.Bytes()) {
+		t.Error("Expected identical ciphertext in every output")
+	}
+	if decrypted, err := c.Decrypt(remote.Bytes()); err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("Expected the output to decrypt to the input: %v", err)
+	}
+
+	broken := failingWriter{}
+	if _, err := c.EncryptFileTo(context.Background(), input, &local, broken); err == nil || !strings.Contains(err.Error(), "output 1") {
+		t.Errorf("Expected the failing output to be reported, got %v", err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestSealChunks(t *testing.T) {
+	data := make([]byte, 10*1024+100)
+	rand.Read(data)
+	c := NewCypher("test-key", WithChunkSize(1024))
+
+	it := c.SealChunks(context.Background(), bytes.NewReader(data))
+	var parts [][]byte
+	var offset int64
+	for {
+		chunk, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		if chunk.Index != len(parts) || chunk.Offset != offset {
+			t.Errorf("Expected chunk %d at %d, got %d at %d", len(parts), offset, chunk.Index, chunk.Offset)
+		}
+		parts = append(parts, chunk.Data)
+		offset += int64(len(chunk.Data))
+	}
+	if len(parts) != 11 {
+		t.Errorf("Expected 11 chunks, got %d", len(parts))
+	}
+	if decrypted, err := c.Decrypt(bytes.Join(parts, nil)); err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("Expected the chunks to decrypt to the input: %v", err)
+	}
+
+	// Delta friendly output ends with a trailer, sent with the last chunk
+	delta := NewCypher("test-key", WithDeltaFriendlyOutput())
+	parts = nil
+	it = delta.SealChunks(context.Background(), bytes.NewReader(data))
+	for {
+		chunk, err := it.Next()
+		if err != nil {
+			break
+		}
+		parts = append(parts, chunk.Data)
+	}
+	if decrypted, err := delta.Decrypt(bytes.Join(parts, nil)); err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("Expected delta friendly chunks to decrypt to the input: %v", err)
+	}
+
+	// Stopping early
+	it = c.SealChunks(context.Background(), bytes.NewReader(data))
+	if _, err := it.Next(); err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	it.Close()
+}
+
+func TestChunkAllocations(t *testing.T) {
+	data := randomBytes(t, 2048*1024)
+	c := NewCypher("test-key", WithChunkSize(1024), WithNumWorkers(4))
+	encrypted, _ := c.Encrypt(data)
+	for name, run := range map[string]func(){
+		"encrypt": func() { c.EncryptStream(context.Background(), bytes.NewReader(data), io.Discard) },
+		"decrypt": func() { c.DecryptStream(context.Background(), bytes.NewReader(encrypted), io.Discard) },
+	} {
+		// Buffers, nonces and additional data are reused, what is left is
+		// the setup of the operation
+		if allocs := testing.AllocsPerRun(5, run); allocs > 2048/8 {
+			t.Errorf("%s: expected few allocations for 2048 chunks, got %.0f", name, allocs)
+		}
+	}
+}
+
+// slowWriter is an output storage that takes delay for every write
+type slowWriter struct {
+	delay time.Duration
+}
+
+func (w slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	return len(p), nil
+}
+
+func TestAdaptiveWorkers(t *testing.T) {
+	defer func(interval time.Duration) { adaptInterval = interval }(adaptInterval)
+	adaptInterval = 10 * time.Millisecond
+
+	data := randomBytes(t, 256*1024)
+	c := NewCypher("test-key", WithChunkSize(1024), WithNumWorkers(10), WithNumCores(4), WithAdaptiveWorkers())
+	stats, err := c.EncryptStream(context.Background(), bytes.NewReader(data), slowWriter{delay: time.Millisecond})
+	if err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	if stats.ActiveWorkers < 1 || stats.ActiveWorkers > 2 {
+		t.Errorf("Expected a slow output to scale down to 1 or 2 workers, got %d", stats.ActiveWorkers)
+	}
+
+	encrypted, err := c.Encrypt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err := c.Decrypt(encrypted); err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("Expected the original data: %v", err)
+	}
+}
+
+func TestStages(t *testing.T) {
+	data := randomBytes(t, 10*1024+7)
+	var plainChunks, sealedChunks atomic.Int64
+	count := func(n *atomic.Int64) Stage {
+		return StageFunc(func(_ context.Context, chunk Chunk) ([]byte, error) {
+			n.Add(1)
+			return chunk.Data, nil
+		})
+	}
+	// flip is its own inverse, standing in for an extra encoding layer
+	flip := StageFunc(func(_ context.Context, chunk Chunk) ([]byte, error) {
+		out := make([]byte, len(chunk.Data))
+		for i, b := range chunk.Data {
+			out[i] = ^b
+		}
+		return out, nil
+	})
+
+	c := NewCypher("test-key", WithChunkSize(1024),
+		WithEncryptStages(count(&plainChunks), CryptoStage, flip, count(&sealedChunks)),
+		WithDecryptStages(flip, CryptoStage))
+	encrypted, err := c.Encrypt(data)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if plainChunks.Load() != 11 || sealedChunks.Load() != 11 {
+		t.Errorf("Expected every stage to see 11 chunks, got %d and %d", plainChunks.Load(), sealedChunks.Load())
+	}
+	if _, err := NewCypher("test-key").Decrypt(encrypted); err == nil {
+		t.Error("Expected decryption without the inverse stage to fail")
+	}
+	if decrypted, err := c.Decrypt(encrypted); err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("Expected the original data: %v", err)
+	}
+
+	failing := StageFunc(func(context.Context, Chunk) ([]byte, error) { return nil, errors.New("blocked content") })
+	if _, err := NewCypher("test-key", WithEncryptStages(failing)).Encrypt(data); err == nil || !strings.Contains(err.Error(), "blocked content") {
+		t.Errorf("Expected the stage error, got %v", err)
+	}
+	if err := NewCypher("test-key", WithEncryptStages(CryptoStage, CryptoStage)).Validate(); err == nil {
+		t.Error("Expected a repeated CryptoStage to be rejected")
+	}
+}
+
+func TestPositionedWrites(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "video.bin")
+	data := randomBytes(t, 300*1024+11)
+	if err := os.WriteFile(input, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, c := range map[string]*Cypher{
+		"fixed": NewCypher("test-key", WithChunkSize(1024), WithNumWorkers(8)),
+		"cdc":   NewCypher("test-key", WithNumWorkers(8), WithContentDefinedChunking(2048, 4096, 8192)),
+	} {
+		encrypted, decrypted := filepath.Join(dir, name+".enc"), filepath.Join(dir, name+".dec")
+		result, err := c.EncryptFileToPath(context.Background(), input, encrypted)
+		if err != nil {
+			t.Fatalf("%s: EncryptFileToPath failed: %v", name, err)
+		}
+		if info, _ := os.Stat(encrypted); info.Size() != result.Stats.BytesWritten {
+			t.Errorf("%s: expected %d bytes written, the file has %d", name, result.Stats.BytesWritten, info.Size())
+		}
+		if _, err := c.DecryptFileToPath(context.Background(), encrypted, decrypted); err != nil {
+			t.Fatalf("%s: DecryptFileToPath failed: %v", name, err)
+		}
+		if got, _ := os.ReadFile(decrypted); !bytes.Equal(got, data) {
+			t.Errorf("%s: expected the original data", name)
+		}
+	}
+
+	// The offset ends after the ciphertext, as with sequential writes
+	out, err := os.Create(filepath.Join(dir, "appended.enc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	c := NewCypher("test-key", WithChunkSize(1024))
+	stats, err := c.EncryptStream(context.Background(), bytes.NewReader(data), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset, _ := out.Seek(0, io.SeekCurrent); offset != stats.BytesWritten {
+		t.Errorf("Expected the offset at %d, got %d", stats.BytesWritten, offset)
+	}
+}
+
+func TestExecutionStrategy(t *testing.T) {
+	c := NewCypher("test-key", WithChunkSize(1024), WithNumWorkers(8))
+	c.NumCores = 4
+	for _, tc := range []struct {
+		total   int64
+		sized   bool
+		workers int
+		direct  bool
+	}{
+		{total: 100, sized: true, workers: 1, direct: true},
+		{total: 1024, sized: true, workers: 1, direct: true},
+		{total: 2048, sized: true, workers: 2},
+		{total: 1 << 20, sized: true, workers: 4},
+		{total: 64 << 20, sized: true, workers: 8},
+		{total: 100, workers: 8},
+	} {
+		workers, direct := c.strategy(&operation{total: tc.total, sized: tc.sized})
+		if workers != tc.workers || direct != tc.direct {
+			t.Errorf("%d bytes (sized %v): expected %d workers, direct %v, got %d, %v", tc.total, tc.sized, tc.workers, tc.direct, workers, direct)
+		}
+	}
+	if workers, direct := c.Clone().WithExecutionThresholds(-1, 0).strategy(&operation{total: 100, sized: true}); workers != 8 || direct {
+		t.Errorf("Expected thresholds to force all workers, got %d, %v", workers, direct)
+	}
+
+	// Every feature works on the calling goroutine
+	data := randomBytes(t, 3000)
+	for name, c := range map[string]*Cypher{
+		"plain":      NewCypher("test-key"),
+		"compressed": NewCypher("test-key", WithCompression(CompressionZstd, 0)),
+		"delta":      NewCypher("test-key", WithDeltaFriendlyOutput()),
+	} {
+		encrypted, err := c.Encrypt(data)
+		if err != nil {
+			t.Fatalf("%s: Encrypt failed: %v", name, err)
+		}
+		if decrypted, err := c.Decrypt(encrypted); err != nil || !bytes.Equal(decrypted, data) {
+			t.Errorf("%s: expected the original data: %v", name, err)
+		}
+	}
+}
+
+func TestEncryptFileInPlace(t *testing.T) {
+	c := NewCypher("test-key", WithChunkSize(1024))
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plain.txt")
+	data := randomBytes(t, 5000)
+	os.WriteFile(path, data, 0640)
+
+	result, err := c.EncryptFileInPlace(context.Background(), path)
+	if err != nil {
+		t.Fatalf("EncryptFileInPlace failed: %v", err)
+	}
+	if result.OutputPath != path {
+		t.Errorf("Expected the output at %s, got %s", path, result.OutputPath)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected no temporary file left, got %d files", len(entries))
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0640 {
+		t.Errorf("Expected the permissions kept, got %v", info.Mode().Perm())
+	}
+	sealed, _ := os.ReadFile(path)
+	if decrypted, err := c.Decrypt(sealed); err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Expected the file to hold the encryption: %v", err)
+	}
+
+	if _, err := c.DecryptFileInPlace(context.Background(), path); err != nil {
+		t.Fatalf("DecryptFileInPlace failed: %v", err)
+	}
+	if restored, _ := os.ReadFile(path); !bytes.Equal(restored, data) {
+		t.Error("Expected the plaintext back")
+	}
+
+	// A failed decryption leaves the file alone
+	if _, err := NewCypher("wrong-key").DecryptFileInPlace(context.Background(), path); err == nil {
+		t.Error("Expected DecryptFileInPlace of plaintext to fail")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected the temporary file removed, got %d files", len(entries))
+	}
+	if kept, _ := os.ReadFile(path); !bytes.Equal(kept, data) {
+		t.Error("Expected the file untouched")
+	}
+
+	shredding := NewCypher("test-key", WithShredSource(1))
+	result, err = shredding.EncryptFileInPlace(context.Background(), path)
+	if err != nil {
+		t.Fatalf("EncryptFileInPlace failed: %v", err)
+	}
+	if result.Shred == nil || !result.Shred.Removed {
+		t.Errorf("Expected the plaintext shredded, got %+v", result.Shred)
+	}
+	sealed, _ = os.ReadFile(path)
+	if decrypted, err := shredding.Decrypt(sealed); err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("Expected the file to hold the encryption: %v", err)
+	}
+}
+
+func TestNameTemplate(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "report.pdf")
+	data := randomBytes(t, 3000)
+	os.WriteFile(input, data, 0644)
+
+	c := NewCypher("test-key", WithFileSuffix(".gc"))
+	encrypted, err := c.EncryptFile(input)
+	if err != nil || *encrypted != input+".gc" {
+		t.Fatalf("Expected %s.gc, got %v, %v", input, encrypted, err)
+	}
+	os.Remove(input)
+	decrypted, err := c.DecryptFile(*encrypted)
+	if err != nil || *decrypted != input {
+		t.Fatalf("Expected the suffix stripped, got %v, %v", decrypted, err)
+	}
+	if restored, _ := os.ReadFile(input); !bytes.Equal(restored, data) {
+		t.Error("Expected the plaintext back")
+	}
+
+	c = NewCypher("test-key", WithNameTemplate("{name}.{ts}.enc"))
+	encrypted, err = c.EncryptFile(input)
+	if err != nil {
+		t.Fatalf("EncryptFile failed: %v", err)
+	}
+	if !regexp.MustCompile(`/report\.pdf\.\d{8}T\d{6}Z\.enc$`).MatchString(filepath.ToSlash(*encrypted)) {
+		t.Errorf("Expected the template applied, got %s", *encrypted)
+	}
+	os.Remove(input)
+	if decrypted, err = c.DecryptFile(*encrypted); err != nil || *decrypted != input {
+		t.Errorf("Expected the plaintext name recovered, got %v, %v", decrypted, err)
+	}
+
+	// Names not following the template keep the old behavior
+	if decrypted, err = NewCypher("test-key").DecryptFile(*encrypted); err != nil || *decrypted != *encrypted+".decrypted" {
+		t.Errorf("Expected .decrypted appended, got %v, %v", decrypted, err)
+	}
+
+	for _, template := range []string{"{name}", "enc", "{name}.{name}", "{name}.{id}", "out/{name}"} {
+		if err := NewCypher("test-key", WithNameTemplate(template)).Validate(); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected template %q rejected, got %v", template, err)
+		}
+	}
+}
+
+func TestOriginalName(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "report.pdf")
+	data := randomBytes(t, 3000)
+	os.WriteFile(input, data, 0644)
+
+	c := NewCypher("test-key", WithOriginalName())
+	encrypted, err := c.EncryptFile(input)
+	if err != nil {
+		t.Fatalf("EncryptFile failed: %v", err)
+	}
+	renamed := filepath.Join(dir, "3f2a.bin")
+	os.Rename(*encrypted, renamed)
+	os.Remove(input)
+
+	file, _ := os.Open(renamed)
+	h, err := ParseHeader(file, ParseStrict)
+	file.Close()
+	if err != nil || h.SealedName == nil || bytes.Contains(h.SealedName, []byte("report")) {
+		t.Fatalf("Expected the name sealed in the header: %v", err)
+	}
+
+	decrypted, err := c.DecryptFile(renamed)
+	if err != nil || *decrypted != input {
+		t.Fatalf("Expected the original name restored, got %v, %v", decrypted, err)
+	}
+	if restored, _ := os.ReadFile(input); !bytes.Equal(restored, data) {
+		t.Error("Expected the plaintext back")
+	}
+
+	// Without the option the name is neither recorded nor restored
+	if decrypted, err = NewCypher("test-key").DecryptFile(renamed); err != nil || *decrypted != renamed+".decrypted" {
+		t.Errorf("Expected .decrypted appended, got %v, %v", decrypted, err)
+	}
+	plain, err := NewCypher("test-key").Encrypt(data)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if name, err := c.OriginalName(bytes.NewReader(plain)); err != nil || name != "" {
+		t.Errorf("Expected no recorded name, got %q, %v", name, err)
+	}
+	sealed, _ := os.ReadFile(renamed)
+	if _, err := NewCypher("other-key").OriginalName(bytes.NewReader(sealed)); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch, got %v", err)
+	}
+
+	// An encrypted file renamed back to its original name decrypts in place
+	c = NewCypher("test-key", WithOriginalName())
+	encrypted, err = c.EncryptFile(input)
+	if err != nil {
+		t.Fatalf("EncryptFile failed: %v", err)
+	}
+	if err := os.Rename(*encrypted, input); err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err = c.DecryptFile(input); err != nil || *decrypted != input {
+		t.Fatalf("Expected the input replaced by its decryption, got %v, %v", decrypted, err)
+	}
+	if restored, _ := os.ReadFile(input); !bytes.Equal(restored, data) {
+		t.Errorf("Expected the plaintext back, got %d bytes", len(restored))
+	}
+}
+
+func TestJournalResume(t *testing.T) {
+	defer func(interval time.Duration) { journalInterval = interval }(journalInterval)
+	journalInterval = 0
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "big.bin")
+	data := randomBytes(t, 20*1024+100)
+	os.WriteFile(input, data, 0644)
+	output := input + ".encrypted"
+
+	// The first run is interrupted at the 12th chunk
+	interrupt := StageFunc(func(ctx context.Context, chunk Chunk) ([]byte, error) {
+		if chunk.Position == 12 {
+			return nil, errors.New("power loss")
+		}
+		return chunk.Data, nil
+	})
+	failing := NewCypher("test-key", WithChunkSize(1024), WithNumWorkers(1), WithJournal(), WithEncryptStages(interrupt))
+	if _, err := failing.EncryptFileWithStats(context.Background(), input); err == nil {
+		t.Fatal("Expected the interrupted encryption to fail")
+	}
+	if _, err := os.Stat(output + ".journal"); err != nil {
+		t.Fatalf("Expected a journal left: %v", err)
+	}
+
+	c := NewCypher("test-key", WithChunkSize(1024), WithJournal())
+	result, err := c.EncryptFileWithStats(context.Background(), input)
+	if err != nil {
+		t.Fatalf("Resumed encryption failed: %v", err)
+	}
+	// Chunks in flight when it failed may not have been written
+	resumed := int(result.ResumedBytes / 1024)
+	if resumed == 0 || resumed > 12 || result.ResumedBytes%1024 != 0 || result.Stats.Chunks != 21-resumed {
+		t.Errorf("Expected up to 12 chunks resumed and the rest encrypted, got %d bytes and %d chunks", result.ResumedBytes, result.Stats.Chunks)
+	}
+	if _, err := os.Stat(output + ".journal"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the journal removed, got %v", err)
+	}
+	sealed, _ := os.ReadFile(output)
+	if decrypted, err := c.Decrypt(sealed); err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Expected the resumed output to decrypt: %v", err)
+	}
+
+	// A journal of another input, or a damaged output, starts over
+	os.WriteFile(output+".journal", journalState{inputSize: 1}.encode(), 0600)
+	if result, err = c.EncryptFileWithStats(context.Background(), input); err != nil || result.ResumedBytes != 0 {
+		t.Errorf("Expected a fresh encryption, got %d bytes resumed, %v", result.ResumedBytes, err)
+	}
+	info, _ := os.Stat(input)
+	state := journalState{inputSize: info.Size(), inputModTime: info.ModTime().UnixNano(), headerSize: c.headerSize(),
+		chunks: 2, offset: c.headerSize() + 2*(1024+chunkOverhead), lastFrame: c.headerSize() + 1024 + chunkOverhead}
+	os.WriteFile(output+".journal", state.encode(), 0600)
+	file, _ := os.OpenFile(output, os.O_RDWR, 0)
+	file.WriteAt([]byte{0xff}, state.offset-1)
+	file.Close()
+	if result, err = c.EncryptFileWithStats(context.Background(), input); err != nil || result.ResumedBytes != 0 {
+		t.Errorf("Expected a fresh encryption, got %d bytes resumed, %v", result.ResumedBytes, err)
+	}
+	sealed, _ = os.ReadFile(output)
+	if decrypted, err := c.Decrypt(sealed); err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("Expected the output to decrypt: %v", err)
+	}
+}
+
+func TestOpenAppend(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithCompression(CompressionZstd, 0)}} {
+		c := NewCypher("test-key", append([]Option{WithChunkSize(1024)}, opts...)...)
+		path := filepath.Join(t.TempDir(), "log.encrypted")
+		data := randomBytes(t, 2500)
+		sealed, err := c.Encrypt(data)
+		if err != nil {
+			t.Fatalf("Encrypt failed: %v", err)
+		}
+		os.WriteFile(path, sealed, 0644)
+
+		w, err := c.OpenAppend(path)
+		if err != nil {
+			t.Fatalf("OpenAppend failed: %v", err)
+		}
+		more := randomBytes(t, 3000)
+		if n, err := w.Write(more[:100]); err != nil || n != 100 {
+			t.Fatalf("Write failed: %d, %v", n, err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		if _, err := w.Write(more[100:]); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		if _, err := w.Write(more); !errors.Is(err, ErrAppendClosed) {
+			t.Errorf("Expected ErrAppendClosed, got %v", err)
+		}
+
+		appended, _ := os.ReadFile(path)
+		if !bytes.HasPrefix(appended, sealed[:len(sealed)-streamTrailerSize]) {
+			t.Error("Expected the existing chunks untouched")
+		}
+		decrypted, err := c.Decrypt(appended)
+		if err != nil || !bytes.Equal(decrypted, append(data, more...)) {
+			t.Fatalf("Expected the appended data to decrypt: %v", err)
+		}
+
+		// Another key can't append
+		if _, err := NewCypher("other-key").OpenAppend(path); !errors.Is(err, ErrKeyMismatch) {
+			t.Errorf("Expected ErrKeyMismatch, got %v", err)
+		}
+	}
+
+	// A crash while sealing a chunk leaves a state the next OpenAppend
+	// completes or rolls back
+	c := NewCypher("test-key", WithChunkSize(1024))
+	path := filepath.Join(t.TempDir(), "crash.encrypted")
+	data, more := randomBytes(t, 2500), randomBytes(t, 100)
+	before, _ := c.Encrypt(data)
+	os.WriteFile(path, before, 0644)
+	w, _ := c.OpenAppend(path)
+	w.Write(more)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	after, _ := os.ReadFile(path)
+	offset := len(before) - streamTrailerSize
+	tail := after[offset:]
+	staged := append(append(bytes.Clone(before), make([]byte, len(tail)-streamTrailerSize)...), tail...)
+	tornMove := bytes.Clone(staged)
+	copy(tornMove[offset:], tail[:len(tail)/2])
+	moved := bytes.Clone(staged)
+	copy(moved[offset:], tail)
+	for name, crash := range map[string]struct {
+		file     []byte
+		expected []byte
+	}{
+		"staged":         {staged, append(bytes.Clone(data), more...)},
+		"torn move":      {tornMove, append(bytes.Clone(data), more...)},
+		"moved, not cut": {moved, append(bytes.Clone(data), more...)},
+		"torn stage":     {staged[:len(staged)-len(tail)/2], data},
+	} {
+		os.WriteFile(path, crash.file, 0644)
+		w, err := c.OpenAppend(path)
+		if err != nil {
+			t.Fatalf("%s: OpenAppend failed: %v", name, err)
+		}
+		w.Close()
+		recovered, _ := os.ReadFile(path)
+		if decrypted, err := c.Decrypt(recovered); err != nil || !bytes.Equal(decrypted, crash.expected) {
+			t.Errorf("%s: Expected the recovered data to decrypt: %v", name, err)
+		}
+	}
+	// Data cut at a chunk boundary isn't taken for a crash
+	os.WriteFile(path, before[:offset], 0644)
+	if _, err := c.OpenAppend(path); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated for data without its trailer, got %v", err)
+	}
+
+	c = NewCypher("test-key", WithDeltaFriendlyOutput())
+	path = filepath.Join(t.TempDir(), "delta.encrypted")
+	sealed, _ := c.Encrypt([]byte("delta"))
+	os.WriteFile(path, sealed, 0644)
+	if _, err := c.OpenAppend(path); err == nil {
+		t.Error("Expected delta friendly data refused")
+	}
+}
+
+func TestConcatEncrypted(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithCompression(CompressionZstd, 0)}} {
+		c := NewCypher("test-key", append([]Option{WithChunkSize(1024)}, opts...)...)
+		var plain []byte
+		var parts []io.ReadSeeker
+		for _, size := range []int{2500, 0, 1024, 10} {
+			data := randomBytes(t, size)
+			sealed, err := c.Encrypt(data)
+			if err != nil {
+				t.Fatalf("Encrypt failed: %v", err)
+			}
+			plain = append(plain, data...)
+			parts = append(parts, bytes.NewReader(sealed))
+		}
+
+		var buf bytes.Buffer
+		if err := ConcatEncrypted(&buf, parts...); err != nil {
+			t.Fatalf("ConcatEncrypted failed: %v", err)
+		}
+		concatenated := buf.Bytes()
+		decrypted, err := c.Decrypt(concatenated)
+		if err != nil || !bytes.Equal(decrypted, plain) {
+			t.Fatalf("Expected the parts to decrypt in order: %v", err)
+		}
+
+		// Dropping the last chunk is detected
+		if _, err := c.Decrypt(concatenated[:len(concatenated)-streamTrailerSize-10-FrameLengthSize-28]); err == nil {
+			t.Error("Expected a truncated concatenation to fail")
+		}
+		if len(opts) == 0 {
+			cut := bytes.Clone(concatenated[:len(concatenated)-streamTrailerSize-10-FrameLengthSize-28])
+			if _, err := c.Decrypt(append(cut, concatenated[len(concatenated)-streamTrailerSize:]...)); !errors.Is(err, ErrTruncated) {
+				t.Errorf("Expected ErrTruncated for a part missing its last chunk, got %v", err)
+			}
+		}
+		sealed, _ := c.Encrypt(randomBytes(t, 2500))
+		if err := ConcatEncrypted(io.Discard, bytes.NewReader(sealed[:len(sealed)-streamTrailerSize])); !errors.Is(err, ErrTruncated) {
+			t.Errorf("Expected ErrTruncated for a part without its trailer, got %v", err)
+		}
+		if _, err := c.Decrypt(append(bytes.Clone(concatenated), 0, 0, 0, 0)); err == nil {
+			t.Error("Expected trailing data to fail")
+		}
+		if _, err := NewCypher("other-key").Decrypt(concatenated); !errors.Is(err, ErrKeyMismatch) {
+			t.Errorf("Expected ErrKeyMismatch, got %v", err)
+		}
+	}
+
+	// Chunks stay bound to their part
+	c := NewCypher("test-key", WithChunkSize(16))
+	a, _ := c.Encrypt([]byte("0123456789abcdef"))
+	b, _ := c.Encrypt([]byte("fedcba9876543210"))
+	var buf bytes.Buffer
+	if err := ConcatEncrypted(&buf, bytes.NewReader(a), bytes.NewReader(b)); err != nil {
+		t.Fatalf("ConcatEncrypted failed: %v", err)
+	}
+	concatenated := buf.Bytes()
+	// Length, nonce, chunk and tag
+	frame := FrameLengthSize + 12 + 16 + 16
+	swapped := bytes.Clone(concatenated)
+	end := len(swapped)
+	copy(swapped[end-2*frame:], concatenated[end-frame:])
+	copy(swapped[end-frame:], concatenated[end-2*frame:end-frame])
+	if _, err := c.Decrypt(swapped); err == nil {
+		t.Error("Expected chunks moved between parts to fail")
+	}
+
+	buf.Reset()
+	if err := ConcatEncrypted(&buf); err != nil {
+		t.Fatalf("ConcatEncrypted failed: %v", err)
+	}
+	if decrypted, err := c.Decrypt(buf.Bytes()); err != nil || len(decrypted) != 0 {
+		t.Errorf("Expected no parts to decrypt to nothing: %v", err)
+	}
+
+	zstd := NewCypher("test-key", WithCompression(CompressionZstd, 0))
+	z, _ := zstd.Encrypt([]byte("compressed"))
+	if err := ConcatEncrypted(io.Discard, bytes.NewReader(a), bytes.NewReader(z)); err == nil {
+		t.Error("Expected parts compressed differently to be refused")
+	}
+}
+
+func TestSamePlaintext(t *testing.T) {
+	ctx := context.Background()
+	data := randomBytes(t, 5000)
+	a, _ := NewCypher("test-key", WithChunkSize(1024)).Encrypt(data)
+	b, _ := NewCypher("test-key", WithCompression(CompressionZstd, 0)).Encrypt(data)
+	other, _ := NewCypher("test-key").Encrypt(append(bytes.Clone(data[:4999]), data[4999]^1))
+
+	c := NewCypher("test-key")
+	if same, err := c.SamePlaintext(ctx, bytes.NewReader(a), bytes.NewReader(b)); err != nil || !same {
+		t.Errorf("Expected copies of one plaintext to match: %v, %v", same, err)
+	}
+	if same, err := c.SamePlaintext(ctx, bytes.NewReader(a), bytes.NewReader(other)); err != nil || same {
+		t.Errorf("Expected different plaintexts not to match: %v, %v", same, err)
+	}
+	if _, err := NewCypher("other-key").SamePlaintext(ctx, bytes.NewReader(a), bytes.NewReader(b)); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch, got %v", err)
+	}
+}
+
+func TestFileBytesConversions(t *testing.T) {
+	c := NewCypher("test-key", WithChunkSize(1024))
+	dir := t.TempDir()
+	data := randomBytes(t, 3000)
+
+	encrypted := filepath.Join(dir, "data.encrypted")
+	if err := c.EncryptBytesToFile(data, encrypted); err != nil {
+		t.Fatalf("EncryptBytesToFile failed: %v", err)
+	}
+	decrypted, err := c.DecryptFileToBytes(encrypted)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Expected DecryptFileToBytes to return the data: %v", err)
+	}
+
+	plain := filepath.Join(dir, "data")
+	os.WriteFile(plain, data, 0644)
+	sealed, err := c.EncryptFileToBytes(plain)
+	if err != nil {
+		t.Fatalf("EncryptFileToBytes failed: %v", err)
+	}
+	restored := filepath.Join(dir, "restored")
+	if err := c.DecryptBytesToFile(sealed, restored); err != nil {
+		t.Fatalf("DecryptBytesToFile failed: %v", err)
+	}
+	if got, _ := os.ReadFile(restored); !bytes.Equal(got, data) {
+		t.Error("Expected DecryptBytesToFile to write the data")
+	}
+
+	if _, err := c.EncryptFileToBytes(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected a missing file to fail")
+	}
+}
+
+func TestSeekableReader(t *testing.T) {
+	c := NewCypher("test-key", WithChunkSize(1024))
+	for _, size := range []int{0, 1024, 5000} {
+		data := randomBytes(t, size)
+		sealed, _ := c.Encrypt(data)
+		s, err := c.NewSeekableReader(bytes.NewReader(sealed), int64(len(sealed)))
+		if err != nil {
+			t.Fatalf("NewSeekableReader failed: %v", err)
+		}
+		if s.Size() != int64(size) {
+			t.Fatalf("Expected size %d, got %d", size, s.Size())
+		}
+		for _, r := range [][2]int{{0, size}, {size / 3, size / 2}, {1000, 1100}, {size - 1, size}} {
+			if r[0] < 0 || r[1] > size || r[0] > r[1] {
+				continue
+			}
+			buf := make([]byte, r[1]-r[0])
+			if n, err := s.ReadAt(buf, int64(r[0])); n != len(buf) || (err != nil && err != io.EOF) {
+				t.Fatalf("ReadAt(%d) read %d: %v", r[0], n, err)
+			}
+			if !bytes.Equal(buf, data[r[0]:r[1]]) {
+				t.Errorf("Expected bytes %d to %d of the plaintext", r[0], r[1])
+			}
+		}
+		s.Seek(int64(size/2), io.SeekStart)
+		if rest, err := io.ReadAll(s); err != nil || !bytes.Equal(rest, data[size/2:]) {
+			t.Errorf("Expected Read to continue from the offset: %v", err)
+		}
+	}
+
+	// Tampered chunks fail when they are read
+	sealed, _ := c.Encrypt(randomBytes(t, 5000))
+	sealed[len(sealed)-streamTrailerSize-1] ^= 1
+	s, _ := c.NewSeekableReader(bytes.NewReader(sealed), int64(len(sealed)))
+	if _, err := s.ReadAt(make([]byte, 10), 0); err != nil {
+		t.Errorf("Expected the first chunk to open: %v", err)
+	}
+	if _, err := s.ReadAt(make([]byte, 10), 4990); err == nil {
+		t.Error("Expected the tampered chunk to fail")
+	}
+
+	// Every Flush of an append ends a short chunk in the middle of the data
+	path := filepath.Join(t.TempDir(), "log.encrypted")
+	data := randomBytes(t, 2500)
+	sealed, _ = c.Encrypt(data[:1100])
+	os.WriteFile(path, sealed, 0644)
+	w, err := c.OpenAppend(path)
+	if err != nil {
+		t.Fatalf("OpenAppend failed: %v", err)
+	}
+	w.Write(data[1100:1200])
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	w.Write(data[1200:])
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	appended, _ := os.ReadFile(path)
+	s, err = c.NewSeekableReader(bytes.NewReader(appended), int64(len(appended)))
+	if err != nil {
+		t.Fatalf("NewSeekableReader failed: %v", err)
+	}
+	if s.Size() != int64(len(data)) {
+		t.Fatalf("Expected size %d, got %d", len(data), s.Size())
+	}
+	buf := make([]byte, 300)
+	if _, err := s.ReadAt(buf, 1050); err != nil || !bytes.Equal(buf, data[1050:1350]) {
+		t.Errorf("Expected ReadAt across the short chunks: %v", err)
+	}
+	s.Seek(2300, io.SeekStart)
+	if rest, err := io.ReadAll(s); err != nil || !bytes.Equal(rest, data[2300:]) {
+		t.Errorf("Expected Read to continue past the short chunks: %v", err)
+	}
+
+	// Data encrypted in one go is found without reading the prefixes of its 100
+	// chunks, only the header and trailer
+	sealed, _ = c.Encrypt(randomBytes(t, 100*1024))
+	counting := &countingReaderAt{r: bytes.NewReader(sealed)}
+	if _, err := c.NewSeekableReader(counting, int64(len(sealed))); err != nil {
+		t.Fatalf("NewSeekableReader failed: %v", err)
+	}
+	if counting.reads > 20 {
+		t.Errorf("Expected full chunks to be found without the prefixes, got %d reads", counting.reads)
+	}
+
+	// Two short chunks the trailer can't tell from full ones
+	data = randomBytes(t, 1200)
+	sealed, _ = c.Encrypt(data[:600])
+	os.WriteFile(path, sealed, 0644)
+	if w, err = c.OpenAppend(path); err != nil {
+		t.Fatalf("OpenAppend failed: %v", err)
+	}
+	w.Write(data[600:])
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	appended, _ = os.ReadFile(path)
+	s, err = c.NewSeekableReader(bytes.NewReader(appended), int64(len(appended)))
+	if err != nil {
+		t.Fatalf("NewSeekableReader failed: %v", err)
+	}
+	if _, err := s.ReadAt(buf, 700); err != nil || !bytes.Equal(buf, data[700:1000]) {
+		t.Errorf("Expected ReadAt in the second short chunk: %v", err)
+	}
+
+	cdc := NewCypher("test-key", WithContentDefinedChunking(256, 1024, 4096))
+	data = randomBytes(t, 20000)
+	sealed, _ = cdc.Encrypt(data)
+	s, err = cdc.NewSeekableReader(bytes.NewReader(sealed), int64(len(sealed)))
+	if err != nil {
+		t.Fatalf("NewSeekableReader failed for content defined chunks: %v", err)
+	}
+	if _, err := s.ReadAt(buf, 15000); err != nil || !bytes.Equal(buf, data[15000:15300]) {
+		t.Errorf("Expected ReadAt in content defined chunks: %v", err)
+	}
+
+	compressed, _ := NewCypher("test-key", WithCompression(CompressionZstd, 0)).Encrypt([]byte("data"))
+	if _, err := c.NewSeekableReader(bytes.NewReader(compressed), int64(len(compressed))); !errors.Is(err, ErrNotSeekable) {
+		t.Errorf("Expected ErrNotSeekable, got %v", err)
+	}
+}
+
+// countingReaderAt counts the reads of r
+type countingReaderAt struct {
+	r     io.ReaderAt
+	reads int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	return c.r.ReadAt(p, off)
+}
+
+func TestArmor(t *testing.T) {
+	c := NewCypher("test-key")
+	for _, size := range []int{0, 1, 47, 48, 1000} {
+		data := randomBytes(t, size)
+		var armored bytes.Buffer
+		w := NewArmorWriter(&armored, 32)
+		if _, err := c.EncryptStream(context.Background(), bytes.NewReader(data), w); err != nil {
+			t.Fatalf("EncryptStream failed: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		lines := strings.Split(strings.TrimSuffix(armored.String(), "\n"), "\n")
+		if lines[0] != ArmorHeader || lines[len(lines)-1] != ArmorFooter {
+			t.Fatalf("Expected the header and footer, got %q", armored.String())
+		}
+		for i, line := range lines[1 : len(lines)-1] {
+			last := i == len(lines)-3
+			if last == strings.HasSuffix(line, `\`) || len(strings.TrimSuffix(line, `\`)) > 32 {
+				t.Fatalf("Unexpected line %q", line)
+			}
+		}
+
+		// Indented in YAML, after other text
+		yaml := "secret: |\n  " + strings.ReplaceAll(strings.TrimSuffix(armored.String(), "\n"), "\n", "\n  ") + "\n"
+		var decrypted bytes.Buffer
+		if _, err := c.DecryptStream(context.Background(), NewArmorReader(strings.NewReader(yaml)), &decrypted); err != nil {
+			t.Fatalf("DecryptStream failed: %v", err)
+		}
+		if !bytes.Equal(decrypted.Bytes(), data) {
+			t.Errorf("Expected armored data of %d bytes to read back", size)
+		}
+
+		// Cut off pastes are noticed
+		cut := strings.Join(append(lines[:len(lines)-2:len(lines)-2], ArmorFooter), "\n")
+		if _, err := io.ReadAll(NewArmorReader(strings.NewReader(cut))); !errors.Is(err, ErrInvalidArmor) {
+			t.Errorf("Expected ErrInvalidArmor for a missing last line, got %v", err)
+		}
+		if _, err := io.ReadAll(NewArmorReader(strings.NewReader(strings.Join(lines[:len(lines)-1], "\n")))); !errors.Is(err, ErrInvalidArmor) {
+			t.Errorf("Expected ErrInvalidArmor for a missing footer, got %v", err)
+		}
+	}
+}
+
+func TestKeyUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage")
+	c := NewCypher("test-key", WithChunkSize(1024))
+	usage, err := OpenKeyUsage(path, c.KeyFingerprint(), UsageLimits{Chunks: 5})
+	if err != nil {
+		t.Fatalf("OpenKeyUsage failed: %v", err)
+	}
+	c.WithKeyUsage(usage)
+
+	if _, err := c.Encrypt(randomBytes(t, 3000)); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if got := usage.Usage(); got != (Usage{Bytes: 3000, Chunks: 3, Operations: 1}) {
+		t.Errorf("Unexpected usage %+v", got)
+	}
+	if _, err := c.RawSeal([]byte("raw"), nil); err != nil {
+		t.Fatalf("RawSeal failed: %v", err)
+	}
+	if _, err := c.Encrypt(randomBytes(t, 2000)); !errors.Is(err, ErrKeyOveruse) {
+		t.Errorf("Expected ErrKeyOveruse, got %v", err)
+	}
+
+	// A crash counts the rest of the batch, Close the exact usage
+	reopened, err := OpenKeyUsage(path, c.KeyFingerprint(), UsageLimits{})
+	if err != nil {
+		t.Fatalf("OpenKeyUsage failed: %v", err)
+	}
+	if got := reopened.Usage(); got.Chunks < 4 {
+		t.Errorf("Expected at least the counted chunks persisted, got %+v", got)
+	}
+	if err := usage.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	reopened, _ = OpenKeyUsage(path, c.KeyFingerprint(), UsageLimits{})
+	if got := reopened.Usage(); got.Chunks != usage.Usage().Chunks || got.Operations != usage.Usage().Operations {
+		t.Errorf("Expected %+v after Close, got %+v", usage.Usage(), got)
+	}
+
+	if _, err := OpenKeyUsage(path, NewCypher("other-key").KeyFingerprint(), UsageLimits{}); !errors.Is(err, ErrKeyUsageKey) {
+		t.Errorf("Expected ErrKeyUsageKey, got %v", err)
+	}
+	if _, err := NewCypher("other-key").WithKeyUsage(usage).Encrypt([]byte("data")); !errors.Is(err, ErrKeyUsageKey) {
+		t.Errorf("Expected ErrKeyUsageKey, got %v", err)
+	}
+}
+
+func TestRandReader(t *testing.T) {
+	data := randomBytes(t, 5000)
+	encrypt := func() []byte {
+		seeded := mathrand.New(mathrand.NewSource(1))
+		c := NewCypher("test-key", WithChunkSize(1024), WithNumWorkers(1), WithRandReader(seeded))
+		sealed, err := c.Encrypt(data)
+		if err != nil {
+			t.Fatalf("Encrypt failed: %v", err)
+		}
+		return sealed
+	}
+	first := encrypt()
+	if !bytes.Equal(first, encrypt()) {
+		t.Error("Expected a seeded source to give reproducible output")
+	}
+	if decrypted, err := NewCypher("test-key").Decrypt(first); err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("Expected the output to decrypt: %v", err)
+	}
+
+	failing := NewCypher("test-key", WithRandReader(iotest.ErrReader(errors.New("hsm offline"))))
+	if _, err := failing.Encrypt(data); err == nil || !strings.Contains(err.Error(), "hsm offline") {
+		t.Errorf("Expected the source error, got %v", err)
+	}
+	if _, err := failing.RawSeal(data, nil); err == nil {
+		t.Error("Expected RawSeal to use the source")
+	}
+	if a, b := NewCypher("test-key").WithRandReader(nil), NewCypher("test-key"); a.random() != b.random() {
+		t.Error("Expected nil to restore crypto/rand")
+	}
+}
+
+func TestSplitKnowledge(t *testing.T) {
+	security, operations := NewCypher("security-team-key"), NewCypher("operations-team-key")
+	c, err := NewCypherWithSplitKnowledge(security, operations)
+	if err != nil {
+		t.Fatalf("Failed to create cypher: %v", err)
+	}
+	encrypted, err := c.Encrypt([]byte("your data"))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	swapped, err := NewCypherWithSplitKnowledge(operations, security)
+	if err != nil {
+		t.Fatalf("Failed to create cypher: %v", err)
+	}
+	if decrypted, err := swapped.Decrypt(encrypted); err != nil || string(decrypted) != "your data" {
+		t.Errorf("Decryption with both keys failed: %v", err)
+	}
+	for _, alone := range []*Cypher{security, operations} {
+		if _, err := alone.Decrypt(encrypted); !errors.Is(err, ErrKeyMismatch) {
+			t.Errorf("Expected ErrKeyMismatch with one key, got %v", err)
+		}
+	}
+
+	if _, err := NewCypherWithSplitKnowledge(security, NewCypher("security-team-key")); !errors.Is(err, ErrSameKey) {
+		t.Errorf("Expected ErrSameKey, got %v", err)
+	}
+	copied := *security
+	if _, err := NewCypherWithSplitKnowledge(security, &copied); !errors.Is(err, ErrSameKey) {
+		t.Errorf("Expected ErrSameKey for shared key material, got %v", err)
+	}
+	operations.Close()
+	if _, err := NewCypherWithSplitKnowledge(security, operations); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+	if decrypted, err := c.Decrypt(encrypted); err != nil || string(decrypted) != "your data" {
+		t.Errorf("Expected the combined cypher to outlive its halves: %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+// Package daemon exposes a scheduler and keystore over a local HTTP API so
+// other processes can offload encryption without linking the library.
+//
+//	POST   /keys         {"name", "passphrase" | "key" (base64), "not_before", "not_after"}
+//	GET    /keys
+//	DELETE /keys/{name}
+//	POST   /jobs         {"op", "key", "input", "output", "priority"}
+//	GET    /jobs
+//	GET    /jobs/{id}
+//	DELETE /jobs/{id}    cancels the job
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher/keystore"
+	"github.com/nikola43/gocypher/cypher/scheduler"
+)
+
+// Server handles the control API
+type Server struct {
+	keys      *keystore.Store
+	scheduler *scheduler.Scheduler
+	mux       *http.ServeMux
+}
+
+func New(keys *keystore.Store, s *scheduler.Scheduler) *Server {
+	srv := &Server{keys: keys, scheduler: s, mux: http.NewServeMux()}
+
+	srv.mux.HandleFunc("POST /keys", srv.addKey)
+	srv.mux.HandleFunc("GET /keys", srv.listKeys)
+	srv.mux.HandleFunc("DELETE /keys/{name}", srv.deleteKey)
+	srv.mux.HandleFunc("POST /jobs", srv.submitJob)
+	srv.mux.HandleFunc("GET /jobs", srv.listJobs)
+	srv.mux.HandleFunc("GET /jobs/{id}", srv.getJob)
+	srv.mux.HandleFunc("DELETE /jobs/{id}", srv.cancelJob)
+
+	return srv
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mux.ServeHTTP(w, r)
+}
+
+// ListenUnix listens on a Unix socket only accessible by the current user,
+// replacing a stale socket file left by a previous run. It refuses to serve
+// the keys when the socket ends up with another owner or wider permissions.
+func ListenUnix(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	listener, err := listenPrivate(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSocket(path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("refusing to listen: %w", err)
+	}
+	return listener, nil
+}
+
+type keyRequest struct {
+	Name       string `json:"name"`
+	Passphrase string `json:"passphrase,omitempty"`
+	Key        []byte `json:"key,omitempty"`
+	// NotBefore and NotAfter bound when the key may be used
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+}
+
+type keyResponse struct {
+	Name        string     `json:"name"`
+	Fingerprint string     `json:"fingerprint"`
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+}
+
+func describeKey(entry keystore.Entry) keyResponse {
+	resp := keyResponse{Name: entry.Name, Fingerprint: entry.Fingerprint}
+	if !entry.NotBefore.IsZero() {
+		resp.NotBefore = &entry.NotBefore
+	}
+	if !entry.NotAfter.IsZero() {
+		resp.NotAfter = &entry.NotAfter
+	}
+	return resp
+}
+
+type jobRequest struct {
+	Op       scheduler.Op `json:"op"`
+	Key      string       `json:"key"`
+	Input    string       `json:"input"`
+	Output   string       `json:"output"`
+	Priority int          `json:"priority"`
+}
+
+type jobResponse struct {
+	ID         string           `json:"id"`
+	Op         scheduler.Op     `json:"op"`
+	Input      string           `json:"input"`
+	Status     scheduler.Status `json:"status"`
+	Priority   int              `json:"priority"`
+	BytesDone  int64            `json:"bytes_done"`
+	BytesTotal int64            `json:"bytes_total"`
+	Percent    float64          `json:"percent"`
+	ETA        time.Duration    `json:"eta_ns,omitempty"`
+	Output     string           `json:"output,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+func (srv *Server) addKey(w http.ResponseWriter, r *http.Request) {
+	var req keyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var entry *keystore.Entry
+	var err error
+	switch {
+	case req.Passphrase != "" && req.Key != nil:
+		err = errors.New("passphrase and key are mutually exclusive")
+	case req.Passphrase != "":
+		entry, err = srv.keys.AddPassphrase(req.Name, req.Passphrase)
+	default:
+		entry, err = srv.keys.AddKey(req.Name, req.Key)
+	}
+	if err == nil && (!req.NotBefore.IsZero() || !req.NotAfter.IsZero()) {
+		if err = srv.keys.SetValidity(entry.Name, req.NotBefore, req.NotAfter); err != nil {
+			srv.keys.Delete(entry.Name)
+		}
+		entry.NotBefore, entry.NotAfter = req.NotBefore, req.NotAfter
+	}
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, describeKey(*entry))
+}
+
+func (srv *Server) listKeys(w http.ResponseWriter, r *http.Request) {
+	entries := srv.keys.List()
+	keys := make([]keyResponse, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, describeKey(entry))
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (srv *Server) deleteKey(w http.ResponseWriter, r *http.Request) {
+	if err := srv.keys.Delete(r.PathValue("name")); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *Server) submitJob(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Input == "" || req.Output == "" {
+		writeError(w, http.StatusBadRequest, errors.New("input and output are required"))
+		return
+	}
+
+	keyFor := srv.keys.ForEncrypt
+	if req.Op == scheduler.Decrypt {
+		keyFor = srv.keys.ForDecrypt
+	}
+	c, err := keyFor(req.Key)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+
+	job, err := srv.scheduler.SubmitWith(c, req.Op, req.Input, req.Output, req.Priority)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, describe(job))
+}
+
+func (srv *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := srv.scheduler.Jobs()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID() < jobs[j].ID() })
+
+	responses := make([]jobResponse, 0, len(jobs))
+	for _, job := range jobs {
+		responses = append(responses, describe(job))
+	}
+	writeJSON(w, http.StatusOK, responses)
+}
+
+func (srv *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := srv.scheduler.Job(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, describe(job))
+}
+
+func (srv *Server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := srv.scheduler.Job(id); !ok {
+		writeError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+	if !srv.scheduler.Cancel(id) {
+		writeError(w, http.StatusConflict, errors.New("job already finished"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func describe(job *scheduler.Job) jobResponse {
+	progress := job.Progress()
+	resp := jobResponse{
+		ID:         job.ID(),
+		Op:         job.Op(),
+		Input:      job.Input(),
+		Status:     job.Status(),
+		Priority:   job.Priority(),
+		BytesDone:  progress.BytesDone,
+		BytesTotal: progress.BytesTotal,
+		Percent:    progress.Percent(),
+		ETA:        progress.ETA,
+	}
+	if result := job.Result(); result != nil {
+		resp.Output = result.OutputPath
+	}
+	if err := job.Err(); err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, keystore.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, keystore.ErrExists):
+		return http.StatusConflict
+	case errors.Is(err, keystore.ErrExpired), errors.Is(err, keystore.ErrNotYetValid):
+		return http.StatusForbidden
+	case errors.Is(err, scheduler.ErrClosed):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,118 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher"
+	"github.com/nikola43/gocypher/cypher/keystore"
+	"github.com/nikola43/gocypher/cypher/scheduler"
+)
+
+func TestServer(t *testing.T) {
+	s := scheduler.New(cypher.NewCypher("unused"))
+	defer s.Close()
+	srv := httptest.NewServer(New(keystore.New(), s))
+	defer srv.Close()
+
+	do := func(method, path string, body any, want int, out any) {
+		t.Helper()
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req, _ := http.NewRequest(method, srv.URL+path, &buf)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s: expected status %d, got %d", method, path, want, resp.StatusCode)
+		}
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+	}
+
+	var key keyResponse
+	do("POST", "/keys", keyRequest{Name: "backup", Passphrase: "secret"}, http.StatusCreated, &key)
+	if key.Fingerprint != cypher.NewCypher("secret").KeyFingerprint() {
+		t.Errorf("Unexpected fingerprint %s", key.Fingerprint)
+	}
+	do("POST", "/keys", keyRequest{Name: "backup", Passphrase: "other"}, http.StatusConflict, nil)
+	do("POST", "/keys", keyRequest{Name: "short", Key: []byte("too short")}, http.StatusBadRequest, nil)
+
+	input := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(input, []byte("your data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var job jobResponse
+	do("POST", "/jobs", jobRequest{Op: scheduler.Encrypt, Key: "backup", Input: input, Output: input + ".encrypted"}, http.StatusAccepted, &job)
+	do("POST", "/jobs", jobRequest{Op: scheduler.Encrypt, Key: "missing", Input: input, Output: input + ".x"}, http.StatusNotFound, nil)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status != scheduler.Done {
+		if time.Now().After(deadline) || job.Status == scheduler.Failed {
+			t.Fatalf("Job didn't complete: %+v", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+		do("GET", "/jobs/"+job.ID, nil, http.StatusOK, &job)
+	}
+	if job.Output != input+".encrypted" || job.Percent != 100 {
+		t.Errorf("Unexpected finished job %+v", job)
+	}
+
+	expired := keyRequest{Name: "old", Passphrase: "old secret", NotAfter: time.Now().Add(-time.Hour)}
+	do("POST", "/keys", expired, http.StatusCreated, &key)
+	if key.NotAfter == nil || !key.NotAfter.Equal(expired.NotAfter) {
+		t.Errorf("Expected validity in response, got %+v", key)
+	}
+	do("POST", "/jobs", jobRequest{Op: scheduler.Encrypt, Key: "old", Input: input, Output: input + ".old"}, http.StatusForbidden, nil)
+	do("POST", "/jobs", jobRequest{Op: scheduler.Decrypt, Key: "old", Input: input + ".encrypted", Output: input + ".old"}, http.StatusAccepted, nil)
+	do("DELETE", "/keys/old", nil, http.StatusNoContent, nil)
+
+	var keys []keyResponse
+	do("DELETE", "/keys/backup", nil, http.StatusNoContent, nil)
+	do("GET", "/keys", nil, http.StatusOK, &keys)
+	if len(keys) != 0 {
+		t.Errorf("Expected no keys, got %v", keys)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("socket permissions are Unix only")
+	}
+	path := filepath.Join(t.TempDir(), "daemon.sock")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := ListenUnix(path)
+	if err != nil {
+		t.Fatalf("ListenUnix failed: %v", err)
+	}
+	defer listener.Close()
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a 0600 socket replacing the stale file, got %v", info.Mode())
+	}
+	if err := checkSocket(path); err != nil {
+		t.Errorf("Expected the socket to pass the check: %v", err)
+	}
+	os.Chmod(path, 0666)
+	if err := checkSocket(path); err == nil {
+		t.Error("Expected a socket other users can connect to to be refused")
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !unix
+
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// listenPrivate restricts the socket after creating it, there is no umask
+func listenPrivate(path string) (net.Listener, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+	return listener, nil
+}
+
+// checkSocket has no ownership to check outside Unix
+func checkSocket(path string) error {
+	return nil
+}
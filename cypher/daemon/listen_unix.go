@@ -0,0 +1,37 @@
+//go:build unix
+
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenPrivate creates the socket with a umask that leaves it 0600 from the
+// start, so no other user can connect before it is restricted. The umask is
+// process wide and restored right after.
+func listenPrivate(path string) (net.Listener, error) {
+	old := unix.Umask(0177)
+	listener, err := net.Listen("unix", path)
+	unix.Umask(old)
+	return listener, err
+}
+
+// checkSocket refuses a socket another user owns or may connect to
+func checkSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		return fmt.Errorf("socket %s has mode %o, need 600", path, perm)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("socket %s is owned by uid %d, not %d", path, stat.Uid, os.Getuid())
+	}
+	return nil
+}
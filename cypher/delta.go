@@ -0,0 +1,185 @@
+package cypher
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// trailerFlag marks the length prefix of the trailer frame of delta friendly
+// data
+const trailerFlag = 1 << 31
+
+// trailerSize is the size of the trailer frame
+const trailerSize = FrameLengthSize + sha256.Size
+
+// Default content defined chunking sizes of delta friendly output
+const (
+	deltaMinChunk = 64 * 1024
+	deltaAvgChunk = 256 * 1024
+	deltaMaxChunk = 1024 * 1024
+)
+
+var (
+	// ErrTruncated is returned when encrypted data ends before its trailer,
+	// or its trailer counts chunks it doesn't have
+	ErrTruncated = errors.New("encrypted data is truncated")
+	// ErrChunksModified is returned when the chunks of delta friendly data
+	// were removed, duplicated or reordered, or a trailer doesn't match
+	ErrChunksModified = errors.New("encrypted chunks were modified")
+)
+
+// WithDeltaFriendlyOutput makes small plaintext edits produce small
+// ciphertext edits, so rsync, borg and similar tools transfer updated
+// encrypted files efficiently. It turns on content defined chunking (with
+// 64 KB/256 KB/1 MB chunks unless WithContentDefinedChunking was called),
+// derives the salt from the key instead of picking it at random, and derives
+// the nonce of every chunk from its content.
+//
+// The output is deterministic: equal chunks encrypted with the same key
+// produce equal ciphertexts, which reveals which chunks are shared between
+// files and versions. Chunks are not bound to their position; instead a
+// trailer authenticates the order of all chunks, so removing, duplicating or
+// reordering them is still detected.
+func (c *Cypher) WithDeltaFriendlyOutput() *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.deltaFriendly = true
+	if c.chunking == nil {
+		c.WithContentDefinedChunking(deltaMinChunk, deltaAvgChunk, deltaMaxChunk)
+	}
+	return c
+}
+
+// deriveSecret derives a secret from the master key for info
+func (c Cypher) deriveSecret(salt []byte, info string) ([]byte, error) {
+	if c.key == nil {
+		return nil, ErrClosed
+	}
+
+	secret := make([]byte, sha256.Size)
+	err := c.key.use(func(key []byte) error {
+		_, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte(info)), secret)
+		return err
+	})
+	return secret, err
+}
+
+// DeriveSecret derives a 32 byte secret for purpose from the key, such as a
+// MAC key for naming encrypted objects. Different purposes give independent
+// secrets and none of them reveals the key.
+func (c Cypher) DeriveSecret(purpose string) ([]byte, error) {
+	return c.deriveSecret(nil, "gocypher v1 application "+purpose)
+}
+
+// deltaKeys returns the keys deriving chunk nonces and authenticating the
+// trailer of data with salt
+func (c Cypher) deltaKeys(salt []byte) (nonceKey, trailerKey []byte, err error) {
+	if nonceKey, err = c.deriveSecret(salt, "gocypher v1 chunk nonce"); err != nil {
+		return nil, nil, err
+	}
+	if trailerKey, err = c.deriveSecret(salt, "gocypher v1 chunk trailer"); err != nil {
+		return nil, nil, err
+	}
+	return nonceKey, trailerKey, nil
+}
+
+// prepareDeltaEncrypt sets up op for delta friendly output of h, whose salt
+// it fills in
+func (c Cypher) prepareDeltaEncrypt(op *operation, h *header) error {
+	if c.nonceCounter != nil {
+		return errors.New("delta friendly output can't be combined with a nonce counter")
+	}
+
+	salt, err := c.deriveSecret(nil, "gocypher v1 delta salt")
+	if err != nil {
+		return err
+	}
+	h.salt = salt
+	h.deterministic = true
+
+	nonceKey, trailerKey, err := c.deltaKeys(salt)
+	if err != nil {
+		return err
+	}
+	op.nonce = func(data []byte) ([]byte, error) {
+		mac := hmac.New(sha256.New, nonceKey)
+		mac.Write(data)
+		return mac.Sum(nil)[:12], nil
+	}
+
+	trailer := hmac.New(sha256.New, trailerKey)
+	op.written = func(frame []byte) {
+		trailer.Write(frame[FrameLengthSize : FrameLengthSize+12])
+	}
+	op.trailer = func() []byte {
+		frame := binary.BigEndian.AppendUint32(nil, trailerFlag|sha256.Size)
+		return trailer.Sum(frame)
+	}
+	return nil
+}
+
+// prepareDeltaDecrypt sets up op to read delta friendly data of h
+func (c Cypher) prepareDeltaDecrypt(op *operation, h *header, maxFrame int) error {
+	_, trailerKey, err := c.deltaKeys(h.salt)
+	if err != nil {
+		return err
+	}
+	op.readFrame = trailerFrames(lengthFrames(maxFrame, op.buffers), hmac.New(sha256.New, trailerKey))
+	return nil
+}
+
+// trailerFrames reads length prefixed frames ending with the trailer, which
+// must hold the MAC of the nonces of all frames before it
+func trailerFrames(frames frameReader, trailer hash.Hash) frameReader {
+	done := false
+	return func(src io.Reader) ([]byte, int, error) {
+		if done {
+			return nil, 0, io.EOF
+		}
+
+		var prefix [FrameLengthSize]byte
+		if _, err := io.ReadFull(src, prefix[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, 0, ErrTruncated
+			}
+			return nil, 0, err
+		}
+
+		size := binary.BigEndian.Uint32(prefix[:])
+		if size&trailerFlag == 0 {
+			frame, consumed, err := frames(io.MultiReader(bytes.NewReader(prefix[:]), src))
+			if err != nil {
+				return nil, 0, err
+			}
+			if len(frame) >= 12 {
+				trailer.Write(frame[:12])
+			}
+			return frame, consumed, nil
+		}
+
+		if size&^trailerFlag != sha256.Size {
+			return nil, 0, fmt.Errorf("%w: bad trailer", ErrChunksModified)
+		}
+		sum := make([]byte, sha256.Size)
+		if _, err := io.ReadFull(src, sum); err != nil {
+			return nil, 0, ErrTruncated
+		}
+		if !Equal(sum, trailer.Sum(nil)) {
+			return nil, 0, ErrChunksModified
+		}
+		if _, err := io.ReadFull(src, make([]byte, 1)); err != io.EOF {
+			return nil, 0, fmt.Errorf("%w: data after the trailer", ErrChunksModified)
+		}
+		done = true
+		return nil, 0, io.EOF
+	}
+}
@@ -0,0 +1,217 @@
+// Package deniable writes containers holding an outer and an optional hidden
+// payload, each opened by its own passphrase, for plausible deniability:
+// someone forced to give up a passphrase can give up the outer one, and
+// nothing in the container shows whether a hidden payload exists.
+//
+// A container has a fixed size chosen by the writer and is random
+// looking throughout: two slots of a salt and the sealed location of a
+// payload, in random order, then the data area. The outer payload starts
+// the data area and the hidden one ends it, the rest is random. Payloads
+// are encrypted in the gocypher format under a key derived from the
+// passphrase with Argon2id and masked with ChaCha20, so not even the
+// format magic shows. Unused slots and space are random bytes, so a
+// container without a hidden payload looks the same as one with it.
+package deniable
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nikola43/gocypher/cypher"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	// ErrWrongPassphrase is returned when the passphrase opens no payload
+	// of the container, which can't tell a wrong passphrase from a payload
+	// that doesn't exist
+	ErrWrongPassphrase = errors.New("passphrase opens no payload")
+	// ErrTooLarge is returned when the payloads don't fit the container
+	ErrTooLarge = errors.New("payloads don't fit the container")
+)
+
+const (
+	saltSize = 16
+	// slotSize is a salt and the sealed offset and length of a payload
+	slotSize   = saltSize + 16 + chacha20poly1305.Overhead
+	headerSize = 2 * slotSize
+)
+
+// Argon2id cost of the passphrase keys: passes, memory in KiB and lanes
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+)
+
+// Volume is a payload of a container and the passphrase opening it
+type Volume struct {
+	Passphrase string
+	Data       io.Reader
+}
+
+// Create writes a container of size bytes to dst holding outer and, unless
+// hidden is nil, hidden. Give every container the same size regardless of
+// its payloads, as the size is its only visible property. The payloads are
+// encrypted in memory first. opts configure the Cypher encrypting them.
+func Create(ctx context.Context, dst io.Writer, size int64, outer Volume, hidden *Volume, opts ...cypher.Option) error {
+	if hidden != nil && hidden.Passphrase == outer.Passphrase {
+		return errors.New("the hidden passphrase must differ from the outer one")
+	}
+	area := size - headerSize
+	if area < 0 {
+		return fmt.Errorf("%w: %d bytes are less than the %d of the header", ErrTooLarge, size, headerSize)
+	}
+
+	container := make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, container); err != nil {
+		return err
+	}
+	// The outer payload takes the first slot or the second at random
+	slots := [2][]byte{container[:slotSize], container[slotSize:headerSize]}
+	coin := make([]byte, 1)
+	if _, err := io.ReadFull(rand.Reader, coin); err != nil {
+		return err
+	}
+	if coin[0]&1 == 1 {
+		slots[0], slots[1] = slots[1], slots[0]
+	}
+
+	outerData, err := seal(ctx, slots[0], outer, headerSize, 0, opts)
+	if err != nil {
+		return err
+	}
+	if int64(len(outerData)) > area {
+		return fmt.Errorf("%w: outer payload of %d bytes in %d", ErrTooLarge, len(outerData), area)
+	}
+	copy(container[headerSize:], outerData)
+	if hidden != nil {
+		hiddenData, err := seal(ctx, slots[1], *hidden, headerSize, area, opts)
+		if err != nil {
+			return err
+		}
+		if int64(len(outerData)+len(hiddenData)) > area {
+			return fmt.Errorf("%w: payloads of %d and %d bytes in %d", ErrTooLarge, len(outerData), len(hiddenData), area)
+		}
+		copy(container[size-int64(len(hiddenData)):], hiddenData)
+	}
+
+	if _, err := dst.Write(container); err != nil {
+		return fmt.Errorf("failed to write container: %w", err)
+	}
+	return nil
+}
+
+// seal encrypts and masks the payload of v and writes its slot. The payload
+// starts the data area at offset start, or ends it when end isn't 0.
+func seal(ctx context.Context, slot []byte, v Volume, start, end int64, opts []cypher.Option) ([]byte, error) {
+	slotKey, payloadKey, maskKey, err := deriveKeys(v.Passphrase, slot[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	c, err := cypher.NewCypherFromKey(payloadKey, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	var data bytes.Buffer
+	if _, err := c.EncryptStream(ctx, v.Data, &data); err != nil {
+		return nil, err
+	}
+	if err := mask(maskKey, data.Bytes()); err != nil {
+		return nil, err
+	}
+
+	offset := start
+	if end != 0 {
+		offset = start + end - int64(data.Len())
+	}
+	location := binary.BigEndian.AppendUint64(nil, uint64(offset))
+	location = binary.BigEndian.AppendUint64(location, uint64(data.Len()))
+	aead, err := chacha20poly1305.New(slotKey)
+	if err != nil {
+		return nil, err
+	}
+	aead.Seal(slot[:saltSize], make([]byte, aead.NonceSize()), location, nil)
+	return data.Bytes(), nil
+}
+
+// Open decrypts the payload of the container in src, of size bytes, that
+// passphrase opens into dst. Both slots are tried, so opening takes as long
+// whichever payload the passphrase opens.
+func Open(ctx context.Context, dst io.Writer, src io.ReaderAt, size int64, passphrase string, opts ...cypher.Option) (*cypher.Stats, error) {
+	header := make([]byte, headerSize)
+	if _, err := src.ReadAt(header, 0); err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	var offset, length int64
+	var payloadKey, maskKey []byte
+	found := false
+	for _, slot := range [][]byte{header[:slotSize], header[slotSize:]} {
+		slotKey, pk, mk, err := deriveKeys(passphrase, slot[:saltSize])
+		if err != nil {
+			return nil, err
+		}
+		aead, err := chacha20poly1305.New(slotKey)
+		if err != nil {
+			return nil, err
+		}
+		location, err := aead.Open(nil, make([]byte, aead.NonceSize()), slot[saltSize:], nil)
+		if err != nil || found {
+			continue
+		}
+		found, payloadKey, maskKey = true, pk, mk
+		offset, length = int64(binary.BigEndian.Uint64(location)), int64(binary.BigEndian.Uint64(location[8:]))
+	}
+	if !found || offset < headerSize || length < 0 || offset > size-length {
+		return nil, ErrWrongPassphrase
+	}
+
+	data := make([]byte, length)
+	if _, err := src.ReadAt(data, offset); err != nil {
+		return nil, fmt.Errorf("failed to read payload: %w", err)
+	}
+	if err := mask(maskKey, data); err != nil {
+		return nil, err
+	}
+	c, err := cypher.NewCypherFromKey(payloadKey, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.DecryptStream(ctx, bytes.NewReader(data), dst)
+}
+
+// deriveKeys returns the keys sealing the slot, encrypting the payload and
+// masking it, derived from passphrase and the salt of the slot
+func deriveKeys(passphrase string, salt []byte) (slotKey, payloadKey, maskKey []byte, err error) {
+	secret := argon2.IDKey([]byte(passphrase), salt, argonTime, argonMemory, argonThreads, 32)
+	keys := make([][]byte, 3)
+	for i, info := range []string{"gocypher deniable slot", "gocypher deniable payload", "gocypher deniable mask"} {
+		keys[i] = make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(info)), keys[i]); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return keys[0], keys[1], keys[2], nil
+}
+
+// mask XORs data with the ChaCha20 keystream of key, in place
+func mask(key, data []byte) error {
+	stream, err := chacha20.NewUnauthenticatedCipher(key, make([]byte, chacha20.NonceSize))
+	if err != nil {
+		return err
+	}
+	stream.XORKeyStream(data, data)
+	return nil
+}
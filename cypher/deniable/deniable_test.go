@@ -0,0 +1,45 @@
+package deniable
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCreateOpen(t *testing.T) {
+	ctx := context.Background()
+	const size = 64 * 1024
+	var container bytes.Buffer
+	err := Create(ctx, &container, size,
+		Volume{Passphrase: "outer passphrase", Data: strings.NewReader("tax returns")},
+		&Volume{Passphrase: "hidden passphrase", Data: strings.NewReader("the real ledger")})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if container.Len() != size {
+		t.Errorf("Expected %d bytes, got %d", size, container.Len())
+	}
+	if bytes.Contains(container.Bytes(), []byte("GOCY")) {
+		t.Error("The container shows the format magic")
+	}
+
+	for passphrase, expected := range map[string]string{"outer passphrase": "tax returns", "hidden passphrase": "the real ledger"} {
+		var plain bytes.Buffer
+		if _, err := Open(ctx, &plain, bytes.NewReader(container.Bytes()), size, passphrase); err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		if plain.String() != expected {
+			t.Errorf("Expected %q, got %q", expected, plain.String())
+		}
+	}
+	if _, err := Open(ctx, &bytes.Buffer{}, bytes.NewReader(container.Bytes()), size, "guess"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+
+	err = Create(ctx, &bytes.Buffer{}, 1024, Volume{Passphrase: "outer", Data: bytes.NewReader(make([]byte, 2048))}, nil)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package cypher
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	encryptedSuffix = ".encrypted"
+	decryptedSuffix = ".decrypted"
+)
+
+// PlanAction is what a directory operation will do with a single file
+type PlanAction string
+
+const (
+	ActionProcess   PlanAction = "process"
+	ActionOverwrite PlanAction = "overwrite"
+	ActionSkip      PlanAction = "skip"
+)
+
+// PlanEntry describes how a single file will be handled
+type PlanEntry struct {
+	Source      string
+	Destination string
+	Action      PlanAction
+	// Reason explains why a file is skipped
+	Reason string
+	Size   int64
+	// EstimatedSize is the expected size of the destination file
+	EstimatedSize int64
+}
+
+// Plan lists what a directory operation will do without doing it
+type Plan struct {
+	Op             string
+	Entries        []PlanEntry
+	TotalBytes     int64
+	EstimatedBytes int64
+	// manifest maps random names to paths, written to dstDir when
+	// encrypting WithObfuscatedNames
+	manifest map[string]string
+	dstDir   string
+}
+
+// Count returns how many entries have the given action
+func (p *Plan) Count(action PlanAction) int {
+	count := 0
+	for _, entry := range p.Entries {
+		if entry.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// DirResult is returned by EncryptDir and DecryptDir
+type DirResult struct {
+	Plan    *Plan
+	Results []Result
+}
+
+// PlanEncryptDir reports what EncryptDir(srcDir, dstDir) would process, skip
+// or overwrite, and the estimated output sizes, without encrypting or writing
+// anything.
+func (c Cypher) PlanEncryptDir(srcDir, dstDir string) (*Plan, error) {
+	return c.planDir("encrypt", srcDir, dstDir)
+}
+
+// PlanDecryptDir is the DecryptDir counterpart of PlanEncryptDir
+func (c Cypher) PlanDecryptDir(srcDir, dstDir string) (*Plan, error) {
+	return c.planDir("decrypt", srcDir, dstDir)
+}
+
+// EncryptDir encrypts every regular file below srcDir into the same relative
+// location below dstDir. Files that already look encrypted are skipped, as
+// are those excluded by WithInclude, WithExclude or an IgnoreFile.
+func (c Cypher) EncryptDir(ctx context.Context, srcDir, dstDir string) (*DirResult, error) {
+	plan, err := c.PlanEncryptDir(srcDir, dstDir)
+	if err != nil {
+		return nil, err
+	}
+	return c.executePlan(ctx, plan, c.encryptOperation)
+}
+
+// DecryptDir decrypts every encrypted file below srcDir into dstDir
+func (c Cypher) DecryptDir(ctx context.Context, srcDir, dstDir string) (*DirResult, error) {
+	if c.contentsPublicKey != nil {
+		if _, err := VerifyContents(srcDir, c.contentsPublicKey); err != nil {
+			return nil, err
+		}
+	}
+	plan, err := c.PlanDecryptDir(srcDir, dstDir)
+	if err != nil {
+		return nil, err
+	}
+	return c.executePlan(ctx, plan, c.decryptOperation)
+}
+
+func (c Cypher) planDir(op, srcDir, dstDir string) (*Plan, error) {
+	if _, err := c.newGCM(); err != nil {
+		return nil, err
+	}
+
+	filter, err := NewFilter(c.include, c.exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{Op: op, dstDir: dstDir}
+	var names, byPath map[string]string
+	if op == "decrypt" || c.obfuscateNames {
+		manifestDir := srcDir
+		if op == "encrypt" {
+			manifestDir = dstDir
+		}
+		if names, err = c.readManifest(manifestDir); err != nil {
+			return nil, err
+		}
+		byPath = map[string]string{}
+		for name, path := range names {
+			byPath[path] = name
+		}
+	}
+	if op == "encrypt" && c.obfuscateNames {
+		plan.manifest = names
+	}
+	// Every file of the plan is named after the same time
+	now := time.Now()
+	err = filter.Walk(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		portable := PortableName(filepath.ToSlash(rel))
+		// Destinations use NFC names escaped for this platform, so trees
+		// round-trip between macOS, Windows and other systems
+		rel = filepath.FromSlash(LocalName(portable))
+
+		entry := PlanEntry{Source: path, Size: info.Size(), Action: ActionProcess}
+		plain, encrypted := c.decryptedName(rel)
+		switch {
+		case portable == ManifestFile:
+			entry.Action, entry.Reason = ActionSkip, "manifest"
+		case portable == ContentsFile:
+			entry.Action, entry.Reason = ActionSkip, "contents"
+		case op == "encrypt" && encrypted:
+			entry.Action, entry.Reason = ActionSkip, "already encrypted"
+		case op == "decrypt" && !encrypted:
+			entry.Action, entry.Reason = ActionSkip, "not encrypted"
+		}
+
+		if entry.Action != ActionSkip {
+			switch {
+			case op == "encrypt" && plan.manifest != nil:
+				entry.Destination = filepath.Join(dstDir, c.encryptedName(obfuscatedName(names, byPath, portable), now))
+				entry.EstimatedSize = c.EncryptedSize(entry.Size)
+			case op == "encrypt":
+				entry.Destination = filepath.Join(dstDir, c.encryptedName(rel, now))
+				entry.EstimatedSize = c.EncryptedSize(entry.Size)
+			default:
+				entry.Destination = filepath.Join(dstDir, plain)
+				if original, ok := names[filepath.ToSlash(plain)]; ok {
+					entry.Destination = filepath.Join(dstDir, filepath.FromSlash(LocalName(original)))
+				}
+				entry.EstimatedSize = c.decryptedSize(entry.Size)
+			}
+
+			if _, err := os.Lstat(entry.Destination); err == nil {
+				entry.Action = ActionOverwrite
+			}
+			plan.TotalBytes += entry.Size
+			plan.EstimatedBytes += entry.EstimatedSize
+		}
+
+		plan.Entries = append(plan.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan directory: %w", err)
+	}
+	return plan, nil
+}
+
+func (c Cypher) executePlan(ctx context.Context, plan *Plan, newOperation func() operation) (*DirResult, error) {
+	result := &DirResult{Plan: plan}
+	for _, entry := range plan.Entries {
+		if entry.Action == ActionSkip {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		if err := os.MkdirAll(LongPath(filepath.Dir(entry.Destination)), 0755); err != nil {
+			return result, fmt.Errorf("failed to create output directory: %w", err)
+		}
+
+		fileResult, err := c.processFile(ctx, LongPath(entry.Source), LongPath(entry.Destination), newOperation)
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", entry.Source, err)
+		}
+		result.Results = append(result.Results, *fileResult)
+	}
+	if plan.manifest != nil {
+		if err := c.writeManifest(plan.dstDir, plan.manifest); err != nil {
+			return result, err
+		}
+	}
+	if c.contentsKey != nil && plan.Op == "encrypt" {
+		if err := c.writeContents(plan); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// chunkCount returns how many chunks of chunkSize a stream of size bytes holds
+func chunkCount(size, chunkSize int64) int64 {
+	if size <= 0 || chunkSize <= 0 {
+		return 0
+	}
+	return (size + chunkSize - 1) / chunkSize
+}
@@ -0,0 +1,266 @@
+package cypher
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlanAndEncryptDir(t *testing.T) {
+	c := NewCypher("test-key").WithChunkSize(1024)
+	srcDir, encDir, decDir := t.TempDir(), t.TempDir(), t.TempDir()
+
+	files := map[string][]byte{
+		"a.txt":               randomBytes(t, 3000),
+		"nested/b.txt":        randomBytes(t, 10),
+		"old.bin.encrypted":   randomBytes(t, 50),
+		"nested/deep/c.empty": {},
+	}
+	for name, data := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(encDir, "a.txt.encrypted"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := c.PlanEncryptDir(srcDir, encDir)
+	if err != nil {
+		t.Fatalf("Planning failed: %v", err)
+	}
+	if plan.Count(ActionProcess) != 2 || plan.Count(ActionOverwrite) != 1 || plan.Count(ActionSkip) != 1 {
+		t.Errorf("Unexpected plan: %+v", plan.Entries)
+	}
+	if _, err := os.Stat(filepath.Join(encDir, "nested")); !os.IsNotExist(err) {
+		t.Error("Planning must not write anything")
+	}
+
+	result, err := c.EncryptDir(context.Background(), srcDir, encDir)
+	if err != nil {
+		t.Fatalf("Directory encryption failed: %v", err)
+	}
+	for i, entry := range plan.Entries {
+		if entry.Action == ActionSkip {
+			continue
+		}
+		info, err := os.Stat(entry.Destination)
+		if err != nil {
+			t.Fatalf("Missing output %s: %v", entry.Destination, err)
+		}
+		if info.Size() != entry.EstimatedSize {
+			t.Errorf("Entry %d: estimated %d bytes, got %d", i, entry.EstimatedSize, info.Size())
+		}
+	}
+	if len(result.Results) != 3 {
+		t.Errorf("Expected 3 encrypted files, got %d", len(result.Results))
+	}
+
+	if _, err := c.DecryptDir(context.Background(), encDir, decDir); err != nil {
+		t.Fatalf("Directory decryption failed: %v", err)
+	}
+	for name, data := range files {
+		if name == "old.bin.encrypted" {
+			continue
+		}
+		decrypted, err := os.ReadFile(filepath.Join(decDir, name))
+		if err != nil {
+			t.Fatalf("Missing decrypted file %s: %v", name, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Errorf("Decrypted %s doesn't match input", name)
+		}
+	}
+}
+
+func TestEncryptDirIgnore(t *testing.T) {
+	srcDir, encDir := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		IgnoreFile:                    "*.tmp\nnode_modules/\n/build\n!keep.tmp\n",
+		"a.txt":                       "a",
+		"scratch.tmp":                 "tmp",
+		"keep.tmp":                    "kept",
+		"node_modules/pkg/index.js":   "js",
+		"build/out.bin":               "bin",
+		"src/build/notes.txt":         "anchored pattern only matches at the root",
+		"src/cache/x.log":             "log",
+		"src/" + IgnoreFile:           "cache/\n",
+		"docs/deep/node_modules/y.js": "js",
+		"docs/readme.md":              "md",
+	}
+	for name, data := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plan, err := NewCypher("test-key").WithExclude("*.md").PlanEncryptDir(srcDir, encDir)
+	if err != nil {
+		t.Fatalf("Planning failed: %v", err)
+	}
+	var got []string
+	for _, entry := range plan.Entries {
+		rel, _ := filepath.Rel(srcDir, entry.Source)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	want := []string{IgnoreFile, "a.txt", "keep.tmp", "src/" + IgnoreFile, "src/build/notes.txt"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	plan, err = NewCypher("test-key").WithInclude("*.txt").PlanEncryptDir(srcDir, encDir)
+	if err != nil {
+		t.Fatalf("Planning failed: %v", err)
+	}
+	if len(plan.Entries) != 2 {
+		t.Errorf("Expected only the text files, got %+v", plan.Entries)
+	}
+}
+
+func TestEncryptDirObfuscatedNames(t *testing.T) {
+	c := NewCypher("test-key").WithObfuscatedNames()
+	srcDir, encDir, decDir := t.TempDir(), t.TempDir(), t.TempDir()
+	files := map[string]string{"taxes/2024.pdf": "pdf", "health/records.txt": "txt"}
+	for name, data := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := c.EncryptDir(context.Background(), srcDir, encDir); err != nil {
+		t.Fatalf("Directory encryption failed: %v", err)
+	}
+	entries, _ := os.ReadDir(encDir)
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.Contains(entry.Name(), "taxes") || strings.Contains(entry.Name(), "health") {
+			t.Errorf("Output %s leaks the structure", entry.Name())
+		}
+		names = append(names, entry.Name())
+	}
+	if len(names) != 3 {
+		t.Fatalf("Expected 2 files and the manifest, got %v", names)
+	}
+	// Encrypting again keeps the names
+	if _, err := c.EncryptDir(context.Background(), srcDir, encDir); err != nil {
+		t.Fatalf("Directory encryption failed: %v", err)
+	}
+	if entries, _ := os.ReadDir(encDir); len(entries) != 3 {
+		t.Errorf("Expected the same 3 files, got %d", len(entries))
+	}
+
+	if _, err := NewCypher("test-key").DecryptDir(context.Background(), encDir, decDir); err != nil {
+		t.Fatalf("Directory decryption failed: %v", err)
+	}
+	for name, data := range files {
+		decrypted, err := os.ReadFile(filepath.Join(decDir, name))
+		if err != nil || string(decrypted) != data {
+			t.Errorf("Expected %s restored, got %q: %v", name, decrypted, err)
+		}
+	}
+	if _, err := NewCypher("other-key").PlanDecryptDir(encDir, decDir); err == nil {
+		t.Error("Expected the manifest to need the key")
+	}
+}
+
+func TestDirFileSuffix(t *testing.T) {
+	srcDir, encDir, decDir := t.TempDir(), t.TempDir(), t.TempDir()
+	data := randomBytes(t, 100)
+	os.WriteFile(filepath.Join(srcDir, "a.txt"), data, 0644)
+	c := NewCypher("test-key", WithFileSuffix(".aes"))
+
+	if _, err := c.EncryptDir(context.Background(), srcDir, encDir); err != nil {
+		t.Fatalf("EncryptDir failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(encDir, "a.txt.aes")); err != nil {
+		t.Fatalf("Expected a.txt.aes: %v", err)
+	}
+	if _, err := c.DecryptDir(context.Background(), encDir, decDir); err != nil {
+		t.Fatalf("DecryptDir failed: %v", err)
+	}
+	if restored, _ := os.ReadFile(filepath.Join(decDir, "a.txt")); !bytes.Equal(restored, data) {
+		t.Error("Expected a.txt restored")
+	}
+}
+
+func TestSignedContents(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	srcDir, encDir := t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(srcDir, "nested"), 0755)
+	os.WriteFile(filepath.Join(srcDir, "a.txt"), randomBytes(t, ContentsChunkSize+100), 0644)
+	os.WriteFile(filepath.Join(srcDir, "nested", "b.txt"), randomBytes(t, 10), 0644)
+
+	c := NewCypher("test-key").WithObfuscatedNames().WithSignedContents(private)
+	if _, err := c.EncryptDir(ctx, srcDir, encDir); err != nil {
+		t.Fatalf("EncryptDir failed: %v", err)
+	}
+	contents, err := VerifyContents(encDir, public)
+	if err != nil {
+		t.Fatalf("VerifyContents failed: %v", err)
+	}
+	if len(contents.Entries) != 3 {
+		t.Errorf("Expected two files and the manifest listed, got %+v", contents.Entries)
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, err := VerifyContents(encDir, other); !errors.Is(err, ErrContentsSignature) {
+		t.Errorf("Expected ErrContentsSignature, got %v", err)
+	}
+
+	reader := NewCypher("test-key").WithVerifiedContents(public)
+	if _, err := reader.DecryptDir(ctx, encDir, t.TempDir()); err != nil {
+		t.Fatalf("DecryptDir failed: %v", err)
+	}
+
+	// Tampering, additions and removals are found before decrypting
+	var largest ContentsEntry
+	for _, entry := range contents.Entries {
+		if entry.Size > largest.Size {
+			largest = entry
+		}
+	}
+	path := filepath.Join(encDir, filepath.FromSlash(largest.Name))
+	sealed, _ := os.ReadFile(path)
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	os.WriteFile(path, tampered, 0644)
+	decDir := t.TempDir()
+	if _, err := reader.DecryptDir(ctx, encDir, decDir); !errors.Is(err, ErrContentsMismatch) || !strings.Contains(err.Error(), "differs at byte 1048576") {
+		t.Errorf("Expected the changed chunk reported, got %v", err)
+	}
+	if entries, _ := os.ReadDir(decDir); len(entries) != 0 {
+		t.Errorf("Expected nothing decrypted, got %v", entries)
+	}
+	os.WriteFile(path, sealed, 0644)
+
+	os.WriteFile(filepath.Join(encDir, "extra"), nil, 0644)
+	if _, err := VerifyContents(encDir, public); !errors.Is(err, ErrContentsMismatch) {
+		t.Errorf("Expected an added file to fail, got %v", err)
+	}
+	os.Remove(filepath.Join(encDir, "extra"))
+	os.Remove(path)
+	if _, err := VerifyContents(encDir, public); !errors.Is(err, ErrContentsMismatch) {
+		t.Errorf("Expected a removed file to fail, got %v", err)
+	}
+}
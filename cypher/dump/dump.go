@@ -0,0 +1,237 @@
+// Package dump encrypts the output of database dump tools such as pg_dump
+// and mysqldump as it is produced, so backups never touch the disk in
+// plaintext, and feeds encrypted dumps back to psql or mysql to restore
+// them.
+//
+//	cmd := exec.CommandContext(ctx, "pg_dump", "--format=custom", "shop")
+//	result, err := dump.EncryptToFile(ctx, c, cmd, "shop.dump.encrypted")
+//
+// The SHA-256 digest of the encrypted dump is computed while it is written,
+// so uploads can be checked against it without reading the dump again.
+package dump
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+const (
+	// ChecksumSuffix is appended to the path of EncryptToFile output for
+	// the file holding its digest, in the format of sha256sum
+	ChecksumSuffix = ".sha256"
+	// stderrLimit bounds the standard error of a command kept for errors
+	stderrLimit = 4096
+)
+
+// Result describes an encrypted dump
+type Result struct {
+	Stats *cypher.Stats
+	// Size is the size of the encrypted dump
+	Size int64
+	// SHA256 is the hex encoded SHA-256 digest of the encrypted dump
+	SHA256 string
+}
+
+// CommandError is returned when the dump or restore command fails. Output
+// written before it failed is incomplete.
+type CommandError struct {
+	Command string
+	// Stderr is the end of the standard error of the command, unless it
+	// was redirected
+	Stderr string
+	Err    error
+}
+
+func (e *CommandError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("%s failed: %v", e.Command, e.Err)
+	}
+	return fmt.Sprintf("%s failed: %v: %s", e.Command, e.Err, e.Stderr)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Encrypt runs cmd, which must not have its standard output set, and
+// encrypts its output with c into dst, such as a file or the upload stream
+// of a remote backend. It fails with a CommandError when cmd exits with an
+// error, since its dump is then incomplete; cmd is killed when the
+// encryption fails.
+func Encrypt(ctx context.Context, c *cypher.Cypher, cmd *exec.Cmd, dst io.Writer) (*Result, error) {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := captureStderr(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, &CommandError{Command: commandName(cmd), Err: err}
+	}
+
+	digest := sha256.New()
+	counter := &countingWriter{}
+	stats, err := c.EncryptStream(ctx, stdout, io.MultiWriter(dst, digest, counter))
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	if err := wait(cmd, stderr); err != nil {
+		return nil, err
+	}
+	return &Result{Stats: stats, Size: counter.n, SHA256: hex.EncodeToString(digest.Sum(nil))}, nil
+}
+
+// EncryptToFile is like Encrypt into the file at path, which only appears
+// once the dump completed, along with its digest in path+ChecksumSuffix
+func EncryptToFile(ctx context.Context, c *cypher.Cypher, cmd *exec.Cmd, path string) (*Result, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	temp, err := os.CreateTemp(dir, "."+base+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	result, err := Encrypt(ctx, c, cmd, temp)
+	if err != nil {
+		return nil, err
+	}
+	if err := temp.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync output file: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close output file: %w", err)
+	}
+	checksum := fmt.Sprintf("%s  %s\n", result.SHA256, base)
+	if err := os.WriteFile(path+ChecksumSuffix, []byte(checksum), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write checksum: %w", err)
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return nil, fmt.Errorf("failed to rename output file: %w", err)
+	}
+	return result, nil
+}
+
+// Restore decrypts src with c into the standard input of cmd, which must not
+// have it set, such as psql or mysql. It fails with a CommandError when cmd
+// exits with an error.
+func Restore(ctx context.Context, c *cypher.Cypher, cmd *exec.Cmd, src io.Reader) (*cypher.Stats, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := captureStderr(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, &CommandError{Command: commandName(cmd), Err: err}
+	}
+
+	stats, err := c.DecryptStream(ctx, src, stdin)
+	if err != nil {
+		// Half a restore must not be committed by a clean end of input
+		cmd.Process.Kill()
+		stdin.Close()
+		cmd.Wait()
+		return nil, err
+	}
+	if err := stdin.Close(); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("failed to close standard input: %w", err)
+	}
+	if err := wait(cmd, stderr); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// VerifyFile checks the file at path against the digest in
+// path+ChecksumSuffix
+func VerifyFile(path string) error {
+	checksum, err := os.ReadFile(path + ChecksumSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to read checksum: %w", err)
+	}
+	fields := strings.Fields(string(checksum))
+	if len(fields) == 0 {
+		return errors.New("empty checksum file")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	digest := sha256.New()
+	if _, err := io.Copy(digest, file); err != nil {
+		return fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	if !cypher.EqualHex(fields[0], hex.EncodeToString(digest.Sum(nil))) {
+		return fmt.Errorf("%s doesn't match its checksum", path)
+	}
+	return nil
+}
+
+// captureStderr keeps the end of the standard error of cmd, unless the
+// caller set it
+func captureStderr(cmd *exec.Cmd) *tailBuffer {
+	if cmd.Stderr != nil {
+		return nil
+	}
+	stderr := &tailBuffer{}
+	cmd.Stderr = stderr
+	return stderr
+}
+
+// wait waits for cmd, turning its failure into a CommandError
+func wait(cmd *exec.Cmd, stderr *tailBuffer) error {
+	if err := cmd.Wait(); err != nil {
+		cmdErr := &CommandError{Command: commandName(cmd), Err: err}
+		if stderr != nil {
+			cmdErr.Stderr = strings.TrimSpace(string(stderr.buf))
+		}
+		return cmdErr
+	}
+	return nil
+}
+
+func commandName(cmd *exec.Cmd) string {
+	if len(cmd.Args) > 0 {
+		return filepath.Base(cmd.Args[0])
+	}
+	return filepath.Base(cmd.Path)
+}
+
+// tailBuffer keeps the last stderrLimit bytes written to it
+type tailBuffer struct {
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > stderrLimit {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-stderrLimit:]...)
+	}
+	return len(p), nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
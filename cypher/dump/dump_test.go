@@ -0,0 +1,69 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+func TestEncryptToFile(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+	ctx := context.Background()
+	c := cypher.NewCypher("test-key")
+	path := filepath.Join(t.TempDir(), "shop.dump.encrypted")
+
+	cmd := exec.Command("sh", "-c", "for i in 1 2 3; do echo \"INSERT INTO t VALUES ($i);\"; done")
+	result, err := EncryptToFile(ctx, c, cmd, path)
+	if err != nil {
+		t.Fatalf("EncryptToFile failed: %v", err)
+	}
+	sealed, _ := os.ReadFile(path)
+	if result.Size != int64(len(sealed)) {
+		t.Errorf("Expected size %d, got %d", len(sealed), result.Size)
+	}
+	if err := VerifyFile(path); err != nil {
+		t.Errorf("VerifyFile failed: %v", err)
+	}
+
+	var restored bytes.Buffer
+	restore := exec.Command("cat")
+	restore.Stdout = &restored
+	if _, err := Restore(ctx, c, restore, bytes.NewReader(sealed)); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if want := "INSERT INTO t VALUES (1);\nINSERT INTO t VALUES (2);\nINSERT INTO t VALUES (3);\n"; restored.String() != want {
+		t.Errorf("Expected the dump restored, got %q", restored.String())
+	}
+
+	os.WriteFile(path, append(sealed, 0), 0644)
+	if err := VerifyFile(path); err == nil {
+		t.Error("Expected a modified dump to fail verification")
+	}
+
+	// A failing dump leaves no output
+	failing := filepath.Join(t.TempDir(), "failed.encrypted")
+	cmd = exec.Command("sh", "-c", "echo partial; echo 'connection refused' >&2; exit 1")
+	_, err = EncryptToFile(ctx, c, cmd, failing)
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) || !strings.Contains(cmdErr.Stderr, "connection refused") {
+		t.Fatalf("Expected a CommandError with the stderr, got %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(failing)); len(entries) != 0 {
+		t.Errorf("Expected no output, got %v", entries)
+	}
+
+	// Corrupt input kills the restore
+	sealed[len(sealed)-1] ^= 1
+	if _, err := Restore(ctx, c, exec.Command("cat"), bytes.NewReader(sealed)); err == nil {
+		t.Error("Expected a tampered dump to fail")
+	}
+}
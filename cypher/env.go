@@ -0,0 +1,74 @@
+package cypher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Environment variables overriding the defaults of new Cyphers. Options
+// passed to the constructors and the With methods still take precedence.
+const (
+	EnvChunkSize = "GOCYPHER_CHUNK_SIZE"
+	EnvWorkers   = "GOCYPHER_WORKERS"
+	EnvCipher    = "GOCYPHER_CIPHER"
+)
+
+// envOverride is a default replaced by an environment variable
+type envOverride struct {
+	name, value string
+}
+
+// applyEnv replaces the defaults of c with the environment variables set.
+// Invalid values are skipped and reported in err.
+func (c *Cypher) applyEnv() (applied []envOverride, err error) {
+	var errs []error
+	lookup := func(name string, apply func(string) error) {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return
+		}
+		if err := apply(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s=%q: %w", name, value, err))
+			return
+		}
+		applied = append(applied, envOverride{name: name, value: value})
+	}
+
+	lookup(EnvChunkSize, func(value string) error {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 || size > MaxChunkSize {
+			return &ConfigError{Field: "chunk size", Value: value, Reason: fmt.Sprintf("need 0 < size <= %d", MaxChunkSize)}
+		}
+		c.ChunkSize = size
+		return nil
+	})
+	lookup(EnvWorkers, func(value string) error {
+		workers, err := strconv.Atoi(value)
+		if err != nil || workers <= 0 || workers > maxNumWorkers {
+			return &ConfigError{Field: "number of workers", Value: value, Reason: fmt.Sprintf("need 0 < workers <= %d", maxNumWorkers)}
+		}
+		c.NumWorkers = workers
+		return nil
+	})
+	lookup(EnvCipher, func(value string) error {
+		algorithm, err := ParseAlgorithm(value)
+		if err != nil {
+			return &ConfigError{Field: "cipher", Value: value, Reason: err.Error()}
+		}
+		c.algorithm = algorithm
+		return nil
+	})
+	return applied, errors.Join(errs...)
+}
+
+// logEnv reports the environment overrides once the logger is configured
+func (c *Cypher) logEnv(applied []envOverride, err error) {
+	for _, override := range applied {
+		c.log().Info("default overridden by environment", "variable", override.name, "value", override.value)
+	}
+	if err != nil {
+		c.log().Warn("ignoring invalid environment variable", "error", err)
+	}
+}
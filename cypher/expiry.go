@@ -0,0 +1,59 @@
+package cypher
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrExpired is returned when decrypting data past its expiry with
+// WithEnforceExpiry
+var ErrExpired = errors.New("encrypted data has expired")
+
+// WithExpiry records expiry in the header of encrypted data, for time
+// limited handoffs. The expiry is authenticated with the rest of the header,
+// so it can't be moved without failing decryption, but only readers
+// decrypting WithEnforceExpiry refuse expired data: it limits honest
+// recipients, it can't take back data already handed over.
+func (c *Cypher) WithExpiry(expiry time.Time) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.expiry = expiry
+	return c
+}
+
+// WithEnforceExpiry makes decryption fail with ErrExpired for data past the
+// expiry set WithExpiry when it was encrypted. Data without one still
+// decrypts.
+func (c *Cypher) WithEnforceExpiry() *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.enforceExpiry = true
+	return c
+}
+
+// expiryUnix returns the expiry recorded in headers, 0 for none
+func (c Cypher) expiryUnix() int64 {
+	if c.expiry.IsZero() {
+		return 0
+	}
+	return c.expiry.Unix()
+}
+
+// checkExpiry rejects expired data when expiry is enforced
+func (c Cypher) checkExpiry(expiry int64) error {
+	if c.enforceExpiry && expiry != 0 && time.Now().Unix() >= expiry {
+		return fmt.Errorf("%w: expired %s", ErrExpired, expiryTime(expiry).Format(time.RFC3339))
+	}
+	return nil
+}
+
+// expiryTime returns the time of a header expiry, zero for none
+func expiryTime(expiry int64) time.Time {
+	if expiry == 0 {
+		return time.Time{}
+	}
+	return time.Unix(expiry, 0)
+}
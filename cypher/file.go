@@ -0,0 +1,276 @@
+package cypher
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrOutputExists is returned by DecryptFile when a file has the name it
+// restores already
+var ErrOutputExists = errors.New("output file exists")
+
+// EncryptFile encrypts inputPath next to it, named after WithNameTemplate,
+// by default with ".encrypted" appended
+func (c Cypher) EncryptFile(inputPath string) (*string, error) {
+	return c.EncryptFileContext(context.Background(), inputPath)
+}
+
+// EncryptFileContext is like EncryptFile but stops when ctx is done and
+// parents the operation span on ctx
+func (c Cypher) EncryptFileContext(ctx context.Context, inputPath string) (*string, error) {
+	result, err := c.EncryptFileWithStats(ctx, inputPath)
+	if err != nil {
+		return nil, err
+	}
+	return &result.OutputPath, nil
+}
+
+// EncryptFileWithStats is like EncryptFileContext and also reports
+// statistics about the operation
+func (c Cypher) EncryptFileWithStats(ctx context.Context, inputPath string) (*Result, error) {
+	return c.processFile(ctx, inputPath, c.encryptedName(inputPath, time.Now()), c.encryptOperation)
+}
+
+// DecryptFile decrypts inputPath next to it, under its name without the
+// suffix or template of EncryptFile, or the name WithOriginalName recorded,
+// failing with ErrOutputExists when a file has that name already unless
+// WithOverwrite is set. Other names get ".decrypted" appended.
+func (c Cypher) DecryptFile(inputPath string) (*string, error) {
+	return c.DecryptFileContext(context.Background(), inputPath)
+}
+
+// DecryptFileContext is like DecryptFile but stops when ctx is done and
+// parents the operation span on ctx
+func (c Cypher) DecryptFileContext(ctx context.Context, inputPath string) (*string, error) {
+	result, err := c.DecryptFileWithStats(ctx, inputPath)
+	if err != nil {
+		return nil, err
+	}
+	return &result.OutputPath, nil
+}
+
+// DecryptFileWithStats is like DecryptFileContext and also reports
+// statistics about the operation
+func (c Cypher) DecryptFileWithStats(ctx context.Context, inputPath string) (*Result, error) {
+	outputPath, err := c.restoredName(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	if sameFile(inputPath, outputPath) {
+		// The encrypted file already has the restored name, creating the
+		// output would truncate the input
+		return c.processInPlace(ctx, inputPath, c.decryptOperation)
+	}
+	// The ".decrypted" name is the own output of DecryptFile, others may be
+	// unrelated files
+	newOperation := c.decryptOperation
+	if outputPath != inputPath+decryptedSuffix && !c.overwrite {
+		newOperation = func() operation {
+			op := c.decryptOperation()
+			op.newOutput = true
+			return op
+		}
+	}
+	return c.processFile(ctx, inputPath, outputPath, newOperation)
+}
+
+// EncryptFileToPath encrypts inputPath into outputPath
+func (c Cypher) EncryptFileToPath(ctx context.Context, inputPath, outputPath string) (*Result, error) {
+	return c.processFile(ctx, inputPath, outputPath, c.encryptOperation)
+}
+
+// DecryptFileToPath decrypts inputPath into outputPath
+func (c Cypher) DecryptFileToPath(ctx context.Context, inputPath, outputPath string) (*Result, error) {
+	return c.processFile(ctx, inputPath, outputPath, c.decryptOperation)
+}
+
+// EncryptFileToBytes returns the encryption of the file at path, skipping
+// its holes like EncryptFileToPath
+func (c Cypher) EncryptFileToBytes(path string) ([]byte, error) {
+	return c.fileToBytes(path, c.encryptOperation)
+}
+
+// DecryptFileToBytes returns the decryption of the file at path, with the
+// holes of sparse files as zeros
+func (c Cypher) DecryptFileToBytes(path string) ([]byte, error) {
+	return c.fileToBytes(path, c.decryptOperation)
+}
+
+// EncryptBytesToFile encrypts data into the file at path
+func (c Cypher) EncryptBytesToFile(data []byte, path string) error {
+	return c.bytesToFile(data, path, c.encryptOperation)
+}
+
+// DecryptBytesToFile decrypts data into the file at path, skipping the holes
+// of sparse files like DecryptFileToPath
+func (c Cypher) DecryptBytesToFile(data []byte, path string) error {
+	return c.bytesToFile(data, path, c.decryptOperation)
+}
+
+func (c Cypher) fileToBytes(path string, newOperation func() operation) ([]byte, error) {
+	ctx := context.Background()
+	file, err := c.openInput(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var result bytes.Buffer
+	if _, err := c.processHandles(ctx, newOperation(), file, &result); err != nil {
+		return nil, err
+	}
+	return result.Bytes(), nil
+}
+
+func (c Cypher) bytesToFile(data []byte, path string, newOperation func() operation) error {
+	ctx := context.Background()
+	file, err := c.createOutput(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := c.processHandles(ctx, newOperation(), bytes.NewReader(data), file); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (c Cypher) processFile(ctx context.Context, inputPath, outputPath string, newOperation func() operation) (*Result, error) {
+	inputFile, err := c.openInput(ctx, inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer inputFile.Close()
+
+	op := newOperation()
+	if c.xattrs && op.name == "decrypt" {
+		op.xattrs = new([]xattr)
+	}
+	var outputFile *os.File
+	var journal *fileJournal
+	if c.journaled() && op.name == "encrypt" {
+		journal, outputFile, err = c.startJournal(ctx, &op, inputFile, outputPath)
+	} else if op.newOutput {
+		outputFile, err = c.createNewOutput(ctx, outputPath)
+	} else {
+		outputFile, err = c.createOutput(ctx, outputPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer outputFile.Close()
+
+	c.log().Debug("processing file", "op", op.name, "input", inputPath, "output", outputPath)
+	op.inputPath, op.outputPath = inputPath, outputPath
+	op.attributes = map[string]any{"gocypher.input": inputPath, "gocypher.output": outputPath}
+	stats, err := c.processHandles(ctx, op, inputFile, outputFile)
+	if journal != nil {
+		err = journal.finish(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{OutputPath: outputPath, Stats: stats}
+	if journal != nil {
+		result.ResumedBytes = journal.resumed
+	}
+	if err := c.restoreXattrs(op, outputPath); err != nil {
+		return result, err
+	}
+	if op.name == "encrypt" && c.shredPasses > 0 {
+		// The source is only destroyed once the output is durable
+		if err := outputFile.Sync(); err != nil {
+			return nil, fmt.Errorf("failed to sync output file: %w", err)
+		}
+		inputFile.Close()
+		if result.Shred, err = c.shredSource(ctx, inputPath, outputPath); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// EncryptFrom encrypts the rest of r into w. Unlike EncryptStream it uses r
+// to learn the input size for progress reports and, when r is an *os.File
+// at its start, skips its holes, so already open handles such as O_TMPFILE
+// files, memfds or files of an embedded FS work as well as paths.
+func (c Cypher) EncryptFrom(ctx context.Context, r io.ReadSeeker, w io.Writer) (*Stats, error) {
+	stats, err := c.processHandles(ctx, c.encryptOperation(), r, w)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// DecryptFrom decrypts the rest of r into w. When w can seek and truncate,
+// like an *os.File at its start, the holes of sparse files are skipped
+// instead of written as zeros.
+func (c Cypher) DecryptFrom(ctx context.Context, r io.ReadSeeker, w io.Writer) (*Stats, error) {
+	stats, err := c.processHandles(ctx, c.decryptOperation(), r, w)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// processHandles runs op from the current offset of in to out
+func (c Cypher) processHandles(ctx context.Context, op operation, in io.ReadSeeker, out io.Writer) (Stats, error) {
+	// Pipes and terminals opened as files can't seek, their size is unknown
+	start, err := in.Seek(0, io.SeekCurrent)
+	seekable := err == nil
+	var end int64
+	if seekable {
+		if end, err = in.Seek(0, io.SeekEnd); err == nil {
+			_, err = in.Seek(start, io.SeekStart)
+		}
+		if err != nil {
+			return Stats{}, fmt.Errorf("failed to seek input: %w", err)
+		}
+		op.total, op.sized = end-start, true
+	}
+
+	var src io.Reader = in
+	// Holes are found by absolute offset, so only for whole files
+	if file, ok := in.(*os.File); ok && seekable && op.sparse && start == 0 {
+		holes, err := findHoles(file, end)
+		if err != nil {
+			return Stats{}, fmt.Errorf("failed to find holes: %w", err)
+		}
+		if len(holes) > 0 {
+			op.holes = limitHoles(holes)
+			src = &sparseReader{file: file, holes: op.holes}
+			for _, h := range op.holes {
+				op.total -= h.length
+			}
+		}
+	}
+	if file, ok := out.(seekTruncater); ok {
+		// The output is truncated to its size relative to the start
+		if offset, err := file.Seek(0, io.SeekCurrent); err == nil && offset == 0 {
+			op.outputFile = file
+		}
+	}
+	return c.process(ctx, op, src, out)
+}
+
+func MD5HashFromFile(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,134 @@
+package cypher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned WithFileLocking(LockFail) when another process
+// holds the lock of a file
+var ErrLocked = errors.New("file is locked by another process")
+
+// LockPolicy sets what file operations do about advisory locks
+type LockPolicy int
+
+const (
+	// LockNone doesn't lock files (default)
+	LockNone LockPolicy = iota
+	// LockWait waits for the locks other processes hold, until the context
+	// of the operation is done
+	LockWait
+	// LockFail fails with ErrLocked when another process holds a lock
+	LockFail
+)
+
+// lockRetryInterval bounds how long LockWait sleeps between attempts
+var lockRetryInterval = 100 * time.Millisecond
+
+// WithFileLocking makes EncryptFile, DecryptFile and the other file
+// operations hold advisory locks while they run: flock on Unix, LockFileEx
+// on Windows. The source is locked shared and the destination exclusive, so
+// cooperating processes, such as another gocypher, can't write either half
+// way through. The destination is only truncated once locked.
+func (c *Cypher) WithFileLocking(policy LockPolicy) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.lockPolicy = policy
+	return c
+}
+
+// lockFile takes the shared or exclusive lock of file following c.lockPolicy.
+// The lock is released when file is closed.
+func (c Cypher) lockFile(ctx context.Context, file *os.File, exclusive bool) error {
+	if c.lockPolicy == LockNone {
+		return nil
+	}
+	wait := time.Millisecond
+	for {
+		locked, err := tryLock(file, exclusive)
+		if err != nil {
+			return fmt.Errorf("failed to lock %s: %w", file.Name(), err)
+		}
+		if locked {
+			return nil
+		}
+		if c.lockPolicy == LockFail {
+			return fmt.Errorf("%w: %s", ErrLocked, file.Name())
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for the lock of %s: %w", file.Name(), ctx.Err())
+		}
+		wait = min(2*wait, lockRetryInterval)
+	}
+}
+
+// openInput opens path for reading, locked shared
+func (c Cypher) openInput(ctx context.Context, path string) (*os.File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	if err := c.lockFile(ctx, file, false); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// createOutput creates or truncates path like os.Create, locked exclusive.
+// With a lock policy it only truncates once it holds the lock.
+func (c Cypher) createOutput(ctx context.Context, path string) (*os.File, error) {
+	return c.openOutput(ctx, path, true)
+}
+
+// createNewOutput creates path like createOutput, failing with
+// ErrOutputExists when it already exists
+func (c Cypher) createNewOutput(ctx context.Context, path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("%w: %s", ErrOutputExists, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	if err := c.lockFile(ctx, file, true); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// openOutput opens path for writing like createOutput, creating it, and
+// only truncates it when truncate is set
+func (c Cypher) openOutput(ctx context.Context, path string, truncate bool) (*os.File, error) {
+	if c.lockPolicy == LockNone && truncate {
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create output file: %w", err)
+		}
+		return file, nil
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	if err := c.lockFile(ctx, file, true); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !truncate {
+		return file, nil
+	}
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to truncate output file: %w", err)
+	}
+	return file, nil
+}
@@ -0,0 +1,57 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly || windows
+
+package cypher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileLocking(t *testing.T) {
+	dir := t.TempDir()
+	input, output := filepath.Join(dir, "plain.txt"), filepath.Join(dir, "plain.txt.encrypted")
+	os.WriteFile(input, randomBytes(t, 2000), 0644)
+	os.WriteFile(output, []byte("held by another writer"), 0644)
+
+	// Another writer holds the destination
+	holder, err := os.OpenFile(output, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Failed to open output: %v", err)
+	}
+	defer holder.Close()
+	if locked, err := tryLock(holder, true); !locked || err != nil {
+		t.Fatalf("Failed to lock output: %v", err)
+	}
+
+	failing := NewCypher("test-key", WithFileLocking(LockFail))
+	if _, err := failing.EncryptFile(input); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Expected ErrLocked, got %v", err)
+	}
+	if held, _ := os.ReadFile(output); string(held) != "held by another writer" {
+		t.Error("Expected the locked destination untouched")
+	}
+
+	waiting := NewCypher("test-key", WithFileLocking(LockWait))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := waiting.EncryptFileContext(ctx, input); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the wait to end with the context, got %v", err)
+	}
+
+	time.AfterFunc(50*time.Millisecond, func() { holder.Close() })
+	encrypted, err := waiting.EncryptFile(input)
+	if err != nil {
+		t.Fatalf("Expected the lock acquired once released: %v", err)
+	}
+	if _, err := waiting.DecryptFileToPath(context.Background(), *encrypted, filepath.Join(dir, "out.txt")); err != nil {
+		t.Errorf("DecryptFileToPath failed: %v", err)
+	}
+
+	if err := NewCypher("test-key", WithFileLocking(LockPolicy(7))).Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig, got %v", err)
+	}
+}
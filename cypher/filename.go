@@ -0,0 +1,112 @@
+package cypher
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// PortableName returns the slash separated relative path rel in the form
+// directory operations and backups record: NFC normalized, so names read on
+// macOS, which may store them decomposed, match those of other platforms,
+// and with the escapes of LocalName undone on Windows.
+func PortableName(rel string) string {
+	return norm.NFC.String(fromLocalName(rel))
+}
+
+// LocalName maps a portable slash separated path to one that can be created
+// on this platform. On Windows the characters <>:"|?*\ and control
+// characters, reserved names such as CON or NUL, and trailing dots and spaces
+// are escaped as %XX; elsewhere it returns rel unchanged.
+func LocalName(rel string) string {
+	return toLocalName(rel)
+}
+
+// windowsSpecial are the characters Windows doesn't allow in file names
+const windowsSpecial = `<>:"|?*\`
+
+// escapeWindowsName escapes every component of rel that Windows can't create
+func escapeWindowsName(rel string) string {
+	components := strings.Split(rel, "/")
+	for i, name := range components {
+		components[i] = escapeWindowsComponent(name)
+	}
+	return strings.Join(components, "/")
+}
+
+func escapeWindowsComponent(name string) string {
+	if name == "." || name == ".." {
+		return name
+	}
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		if ch < 0x20 || strings.IndexByte(windowsSpecial, ch) >= 0 ||
+			(i == len(name)-1 && (ch == '.' || ch == ' ')) ||
+			(i == 0 && reservedWindowsName(name)) {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		} else {
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+// unescapeWindowsName undoes escapeWindowsName. Only escapes it could have
+// written are decoded, so other names containing % are kept as they are.
+func unescapeWindowsName(rel string) string {
+	components := strings.Split(rel, "/")
+	for i, name := range components {
+		components[i] = unescapeWindowsComponent(name)
+	}
+	return strings.Join(components, "/")
+}
+
+func unescapeWindowsComponent(name string) string {
+	if !strings.Contains(name, "%") {
+		return name
+	}
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '%' && i+2 < len(name) && isHex(name[i+1]) && isHex(name[i+2]) {
+			ch := unhex(name[i+1])<<4 | unhex(name[i+2])
+			if ch < 0x20 || strings.IndexByte(windowsSpecial, ch) >= 0 ||
+				(i+3 == len(name) && (ch == '.' || ch == ' ')) ||
+				(i == 0 && reservedWindowsName(string(ch)+name[3:])) {
+				b.WriteByte(ch)
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
+
+// reservedWindowsName reports whether name is a device name such as CON or
+// COM1, with or without an extension
+func reservedWindowsName(name string) bool {
+	base, _, _ := strings.Cut(name, ".")
+	base = strings.ToUpper(strings.TrimRight(base, " "))
+	switch base {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	return len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) &&
+		base[3] >= '1' && base[3] <= '9'
+}
+
+func isHex(ch byte) bool {
+	return '0' <= ch && ch <= '9' || 'A' <= ch && ch <= 'F' || 'a' <= ch && ch <= 'f'
+}
+
+func unhex(ch byte) byte {
+	switch {
+	case ch <= '9':
+		return ch - '0'
+	case ch <= 'F':
+		return ch - 'A' + 10
+	}
+	return ch - 'a' + 10
+}
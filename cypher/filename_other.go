@@ -0,0 +1,17 @@
+//go:build !windows
+
+package cypher
+
+func toLocalName(rel string) string {
+	return rel
+}
+
+func fromLocalName(rel string) string {
+	return rel
+}
+
+// LongPath returns path as an extended-length \\?\ path on Windows when it is
+// too long for the Windows APIs; elsewhere it returns path unchanged
+func LongPath(path string) string {
+	return path
+}
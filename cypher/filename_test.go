@@ -0,0 +1,62 @@
+package cypher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWindowsNameEscaping(t *testing.T) {
+	for name, want := range map[string]string{
+		"plain/file.txt":     "plain/file.txt",
+		"a:b/c?.txt":         "a%3Ab/c%3F.txt",
+		"CON":                "%43ON",
+		"nul.tar.gz":         "%6Eul.tar.gz",
+		"com1/CONSOLE":       "%63om1/CONSOLE",
+		"trailing./space ":   "trailing%2E/space%20",
+		"back\\slash":        "back%5Cslash",
+		"100%/%3A is kept":   "100%/%3A is kept",
+		"../escape":          "../escape",
+		"tab\there":          "tab%09here",
+		"prn .txt/LPT9.x/ok": "%70rn .txt/%4CPT9.x/ok",
+	} {
+		if got := escapeWindowsName(name); got != want {
+			t.Errorf("escapeWindowsName(%q) = %q, want %q", name, got, want)
+		}
+		if name == "100%/%3A is kept" {
+			// not round-trippable: a literal escape is decoded
+			continue
+		}
+		if got := unescapeWindowsName(want); got != name {
+			t.Errorf("unescapeWindowsName(%q) = %q, want %q", want, got, name)
+		}
+	}
+
+	// Names that are valid on Windows survive reading and writing them back
+	for _, name := range []string{"100%25.txt", "%41", "x%3A", "%43ON", "a%2E"} {
+		if got := escapeWindowsName(unescapeWindowsName(name)); got != name {
+			t.Errorf("Expected %q to round-trip, got %q", name, got)
+		}
+	}
+}
+
+func TestDirNormalizesNames(t *testing.T) {
+	c := NewCypher("test-key")
+	srcDir, encDir, decDir := t.TempDir(), t.TempDir(), t.TempDir()
+
+	// "café" decomposed, as macOS may report it
+	decomposed := "café.txt"
+	if err := os.WriteFile(filepath.Join(srcDir, decomposed), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.EncryptDir(context.Background(), srcDir, encDir); err != nil {
+		t.Fatalf("EncryptDir failed: %v", err)
+	}
+	if _, err := c.DecryptDir(context.Background(), encDir, decDir); err != nil {
+		t.Fatalf("DecryptDir failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(decDir, "caf\u00e9.txt")); err != nil {
+		t.Errorf("Expected the composed name, got %v", err)
+	}
+}
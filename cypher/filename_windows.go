@@ -0,0 +1,33 @@
+package cypher
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath is MAX_PATH less room for the 8.3 name Windows may append
+const maxPath = 248
+
+func toLocalName(rel string) string {
+	return escapeWindowsName(rel)
+}
+
+func fromLocalName(rel string) string {
+	return unescapeWindowsName(rel)
+}
+
+// LongPath returns path as an extended-length \\?\ path when it is too long
+// for the Windows APIs, so deep directory trees can be written
+func LongPath(path string) string {
+	if len(path) < maxPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
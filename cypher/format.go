@@ -0,0 +1,725 @@
+package cypher
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Encrypted data starts with a header:
+//
+//	magic "GOCY" | version | records... | end record
+//
+// where each record is type (1 byte) | length (2 bytes) | value. The header
+// is followed by the chunks, each framed as
+//
+//	length (4 bytes) | nonce | ciphertext | tag
+//
+// Every chunk is sealed with the SHA-256 of the header and its position as
+// additional data, so headers can't be swapped and chunks can't be
+// reordered. Data with RecordTrailer ends with a trailer frame counting the
+// chunks, so it can't be truncated at a chunk boundary either. Data written
+// before the header existed (the legacy format) is a bare sequence of nonce
+// | ciphertext | tag chunks and is still decrypted.
+//
+// The constants below are the wire format, for implementations in other
+// languages. All integers are big endian. The chunk key is HKDF-SHA256 of the
+// key with the salt and info "gocypher v1 file key", the commitment the same
+// with info "gocypher v1 key commitment". The additional data of a chunk is
+// the SHA-256 of the raw header followed by its position as 8 bytes, or the
+// hash alone for deterministic data. Sealed records hold nonce | ciphertext
+// | tag, sealed with the record type as additional data and the first 32
+// bytes HKDF-SHA256 of the key with the salt and info "gocypher v1 record
+// key" derives; the nonce is the HMAC-SHA256 of the record type followed by
+// the value, keyed with the next 32. The trailer frame has a length prefix
+// with the top bit set and holds the chunk count and the size of the chunk
+// frames as 8 bytes each, followed by the HMAC-SHA256 of the header hash,
+// the count and the size, keyed with HKDF-SHA256 of the key with the salt
+// and info "gocypher v1 stream trailer".
+const (
+	FormatMagic   = "GOCY"
+	FormatVersion = 1
+
+	// RecordEnd ends the header, its value is empty
+	RecordEnd = 0x00
+	// RecordSalt holds the SaltSize bytes salt of the key derivation
+	RecordSalt = 0x01
+	// RecordCommitment holds the CommitmentSize bytes key commitment
+	RecordCommitment = 0x02
+	// RecordChunkSize holds the maximum plaintext chunk size as 4 bytes
+	RecordChunkSize = 0x03
+	// RecordGeneration holds the key rotation generation as 8 bytes
+	RecordGeneration = 0x04
+	// RecordCounter holds the first nonce counter value as 8 bytes
+	RecordCounter = 0x05
+	// RecordCompression holds the Compression, followed by 0x01 when every
+	// chunk starts with a flag byte, 0x00 for stored and 0x01 for compressed
+	RecordCompression = 0x06
+	// RecordDeterministic marks delta friendly data, its value is empty
+	RecordDeterministic = 0x07
+	// RecordHoles lists the holes of a sparse file as offset and length
+	// pairs, the chunks only hold the data between them
+	RecordHoles = 0x08
+	// RecordAlgorithm names the AEAD when it isn't AES-256-GCM
+	RecordAlgorithm = 0x09
+	// RecordExpiry holds the time after which the data expires, as 8 bytes
+	// of Unix seconds
+	RecordExpiry = 0x0a
+	// RecordName holds the name of the plaintext file, sealed
+	RecordName = 0x0b
+	// RecordXattrs holds the extended attributes of the plaintext file,
+	// sealed, each as name length (1 byte) | name | value length (2 bytes)
+	// | value
+	RecordXattrs = 0x0c
+	// RecordTrailer marks data ending with a trailer frame, its value is
+	// empty
+	RecordTrailer = 0x0d
+	// RecordExtension is the first extension record type. Extensions carry
+	// optional data that readers may ignore, so ParseLenient skips the ones
+	// it doesn't know; unknown records below it are always rejected.
+	RecordExtension = 0x80
+
+	SaltSize        = 32
+	CommitmentSize  = 32
+	FrameLengthSize = 4
+	MaxChunkSize    = 1 << 30
+)
+
+var (
+	// ErrKeyMismatch is returned when data was encrypted with another key
+	ErrKeyMismatch = errors.New("key does not match the encrypted data")
+	// ErrInvalidHeader is returned when the container header is malformed
+	ErrInvalidHeader = errors.New("invalid header")
+	// ErrRollback is returned when data is older than the minimum generation
+	// set with WithMinGeneration
+	ErrRollback = errors.New("encrypted data is older than the minimum generation")
+)
+
+// header is the parsed container header
+type header struct {
+	version   byte
+	chunkSize uint32
+	// generation is the key rotation counter the data was encrypted at, 0
+	// for data written without one
+	generation uint64
+	// counter is the first nonce counter value of the data, set when it was
+	// encrypted with a NonceCounter
+	counter     *uint64
+	compression Compression
+	// chunkFlags is set when every compressed chunk starts with a flag
+	// telling whether it was stored compressed or raw
+	chunkFlags bool
+	// deterministic is set for delta friendly data
+	deterministic bool
+	// trailer is set when the chunks are followed by a stream trailer
+	trailer   bool
+	holes     []hole
+	algorithm Algorithm
+	// expiry is the Unix time the data expires at, 0 for none
+	expiry int64
+	// name is the sealed name of the plaintext file, see WithOriginalName
+	name []byte
+	// xattrs are the sealed extended attributes of the plaintext file, see
+	// WithXattrs
+	xattrs []byte
+	salt   []byte
+	// commitment is derived from the key and salt, it lets decryption detect
+	// a wrong key and makes the ciphertext committing: it can't be crafted to
+	// decrypt successfully under two different keys
+	commitment []byte
+	// extensions are the unknown extension records skipped by ParseLenient
+	extensions []Record
+	raw        []byte
+}
+
+func (h *header) encode() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(FormatMagic)
+	buf.WriteByte(h.version)
+
+	chunkSize := make([]byte, 4)
+	binary.BigEndian.PutUint32(chunkSize, h.chunkSize)
+	generation := make([]byte, 8)
+	binary.BigEndian.PutUint64(generation, h.generation)
+
+	writeRecord(&buf, RecordSalt, h.salt)
+	writeRecord(&buf, RecordCommitment, h.commitment)
+	writeRecord(&buf, RecordChunkSize, chunkSize)
+	writeRecord(&buf, RecordGeneration, generation)
+	if h.counter != nil {
+		writeRecord(&buf, RecordCounter, binary.BigEndian.AppendUint64(nil, *h.counter))
+	}
+	if h.compression != CompressionNone {
+		value := []byte{byte(h.compression)}
+		if h.chunkFlags {
+			value = append(value, compressionChunkFlags)
+		}
+		writeRecord(&buf, RecordCompression, value)
+	}
+	if h.deterministic {
+		writeRecord(&buf, RecordDeterministic, nil)
+	}
+	if len(h.holes) > 0 {
+		writeRecord(&buf, RecordHoles, encodeHoles(h.holes))
+	}
+	if h.algorithm != AlgorithmAES256GCM {
+		writeRecord(&buf, RecordAlgorithm, []byte{byte(h.algorithm)})
+	}
+	if h.expiry != 0 {
+		writeRecord(&buf, RecordExpiry, binary.BigEndian.AppendUint64(nil, uint64(h.expiry)))
+	}
+	if h.name != nil {
+		writeRecord(&buf, RecordName, h.name)
+	}
+	if h.xattrs != nil {
+		writeRecord(&buf, RecordXattrs, h.xattrs)
+	}
+	if h.trailer {
+		writeRecord(&buf, RecordTrailer, nil)
+	}
+	writeRecord(&buf, RecordEnd, nil)
+
+	h.raw = buf.Bytes()
+	return h.raw
+}
+
+func writeRecord(buf *bytes.Buffer, recordType byte, value []byte) {
+	buf.WriteByte(recordType)
+	binary.Write(buf, binary.BigEndian, uint16(len(value)))
+	buf.Write(value)
+}
+
+// readHeader parses a header whose magic has already been consumed
+func readHeader(r io.Reader, mode ParseMode) (*header, error) {
+	raw := bytes.NewBufferString(FormatMagic)
+	r = io.TeeReader(r, raw)
+
+	version := make([]byte, 1)
+	if _, err := io.ReadFull(r, version); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	if version[0] != FormatVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidHeader, version[0])
+	}
+
+	h := &header{version: version[0], algorithm: AlgorithmAES256GCM}
+	seen := make(map[byte]bool)
+	for {
+		var prefix [3]byte
+		if _, err := io.ReadFull(r, prefix[:]); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+		}
+		recordType := prefix[0]
+		value := make([]byte, binary.BigEndian.Uint16(prefix[1:]))
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+		}
+		if seen[recordType] {
+			return nil, fmt.Errorf("%w: duplicate record type %d", ErrInvalidHeader, recordType)
+		}
+		seen[recordType] = true
+
+		switch recordType {
+		case RecordEnd:
+			if h.salt == nil || h.commitment == nil || h.chunkSize == 0 {
+				return nil, fmt.Errorf("%w: missing records", ErrInvalidHeader)
+			}
+			if h.trailer && h.deterministic {
+				return nil, fmt.Errorf("%w: trailer record on deterministic data", ErrInvalidHeader)
+			}
+			h.raw = raw.Bytes()
+			return h, nil
+		case RecordSalt:
+			h.salt = value
+		case RecordCommitment:
+			h.commitment = value
+		case RecordChunkSize:
+			if len(value) != 4 {
+				return nil, fmt.Errorf("%w: bad chunk size record", ErrInvalidHeader)
+			}
+			h.chunkSize = binary.BigEndian.Uint32(value)
+			if h.chunkSize > MaxChunkSize {
+				return nil, fmt.Errorf("%w: chunk size %d too large", ErrInvalidHeader, h.chunkSize)
+			}
+		case RecordGeneration:
+			if len(value) != 8 {
+				return nil, fmt.Errorf("%w: bad generation record", ErrInvalidHeader)
+			}
+			h.generation = binary.BigEndian.Uint64(value)
+		case RecordCounter:
+			if len(value) != 8 {
+				return nil, fmt.Errorf("%w: bad counter record", ErrInvalidHeader)
+			}
+			counter := binary.BigEndian.Uint64(value)
+			h.counter = &counter
+		case RecordCompression:
+			switch {
+			case len(value) == 1:
+			case len(value) == 2 && value[1] == compressionChunkFlags:
+				h.chunkFlags = true
+			default:
+				return nil, fmt.Errorf("%w: bad compression record", ErrInvalidHeader)
+			}
+			h.compression = Compression(value[0])
+		case RecordDeterministic:
+			h.deterministic = true
+		case RecordAlgorithm:
+			if len(value) != 1 || Algorithm(value[0]) == AlgorithmAuto {
+				return nil, fmt.Errorf("%w: bad algorithm record", ErrInvalidHeader)
+			}
+			h.algorithm = Algorithm(value[0])
+		case RecordExpiry:
+			if len(value) != 8 || int64(binary.BigEndian.Uint64(value)) == 0 {
+				return nil, fmt.Errorf("%w: bad expiry record", ErrInvalidHeader)
+			}
+			h.expiry = int64(binary.BigEndian.Uint64(value))
+		case RecordName:
+			h.name = value
+		case RecordXattrs:
+			h.xattrs = value
+		case RecordTrailer:
+			if len(value) != 0 {
+				return nil, fmt.Errorf("%w: bad trailer record", ErrInvalidHeader)
+			}
+			h.trailer = true
+		case RecordHoles:
+			holes, err := decodeHoles(value)
+			if err != nil {
+				return nil, err
+			}
+			h.holes = holes
+		default:
+			if mode == ParseLenient && recordType >= RecordExtension {
+				h.extensions = append(h.extensions, Record{Type: recordType, Value: value})
+				continue
+			}
+			return nil, fmt.Errorf("%w: unknown record type %d", ErrInvalidHeader, recordType)
+		}
+	}
+}
+
+// deriveKeys derives the per-file key and the key commitment from the
+// master key and salt
+func (c Cypher) deriveKeys(salt []byte) (fileKey, commitment []byte, err error) {
+	if c.key == nil {
+		return nil, nil, ErrClosed
+	}
+
+	err = c.key.use(func(key []byte) error {
+		fileKey = make([]byte, KeySize)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte("gocypher v1 file key")), fileKey); err != nil {
+			return err
+		}
+		commitment = make([]byte, CommitmentSize)
+		_, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte("gocypher v1 key commitment")), commitment)
+		return err
+	})
+	return fileKey, commitment, err
+}
+
+// fileGCM returns the AEAD of h keyed with the file key derived for h
+func (c Cypher) fileGCM(h *header) (cipher.AEAD, error) {
+	fileKey, commitment, err := c.deriveKeys(h.salt)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(fileKey)
+
+	if h.commitment == nil {
+		h.commitment = commitment
+	} else if !Equal(h.commitment, commitment) {
+		return nil, ErrKeyMismatch
+	}
+
+	return c.fileAEAD(h.algorithm, fileKey)
+}
+
+// headerAAD is the additional data prefix shared by every chunk of h
+func headerAAD(h *header) []byte {
+	sum := sha256.Sum256(h.raw)
+	return sum[:]
+}
+
+// prepareEncrypt writes a new header to dst
+func (c Cypher) prepareEncrypt(op *operation, src io.Reader, dst io.Writer) (io.Reader, error) {
+	if c.chunking != nil {
+		if err := c.chunking.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	chunkSize := c.ChunkSize
+	if c.chunking != nil {
+		chunkSize = c.chunking.max
+	}
+	h := &header{
+		version:     FormatVersion,
+		chunkSize:   uint32(chunkSize),
+		generation:  c.generation,
+		compression: c.compression,
+		chunkFlags:  c.compression != CompressionNone,
+		holes:       op.holes,
+		algorithm:   c.encryptAlgorithm(),
+		expiry:      c.expiryUnix(),
+		trailer:     !c.deltaFriendly,
+		salt:        make([]byte, SaltSize),
+	}
+	if c.deltaFriendly {
+		if err := c.prepareDeltaEncrypt(op, h); err != nil {
+			return nil, err
+		}
+	} else if _, err := io.ReadFull(c.random(), h.salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	counter, err := c.startCounter()
+	if err != nil {
+		return nil, err
+	}
+	h.counter = counter
+	if err := c.countOperation(); err != nil {
+		return nil, err
+	}
+	if c.originalName && op.inputPath != "" {
+		if h.name, err = c.sealName(h, filepath.Base(op.inputPath)); err != nil {
+			return nil, err
+		}
+	}
+	if c.xattrs && op.inputPath != "" {
+		if err := c.sealXattrs(h, op.inputPath); err != nil {
+			return nil, err
+		}
+	}
+
+	gcm, err := c.fileGCM(h)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dst.Write(h.encode()); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+	if err := c.setupEncrypt(op, h, gcm); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// setupEncrypt sets op up to seal the chunks following h
+func (c Cypher) setupEncrypt(op *operation, h *header, gcm cipher.AEAD) error {
+	var err error
+	if op.usage, err = c.usage(); err != nil {
+		return err
+	}
+	op.gcm = gcm
+	op.aad = headerAAD(h)
+	op.random = c.random()
+	op.positionless = h.deterministic
+	op.readFrame = fixedFrames(c.ChunkSize, op.buffers)
+	if c.chunking != nil {
+		op.readFrame = cdcFrames(*c.chunking, op.buffers)
+	}
+	op.transform = sealFrame
+	if h.compression == CompressionNone {
+		op.outputSize = func(frame []byte) int {
+			return FrameLengthSize + gcm.NonceSize() + len(frame) + gcm.Overhead()
+		}
+	}
+	if c.nonceCounter != nil {
+		op.nonce = c.nonceCounter.nonce
+	}
+	if h.trailer {
+		key, err := c.streamTrailerKey(h)
+		if err != nil {
+			return err
+		}
+		op.streamTrailer = func(chunks int, size int64) []byte {
+			return encodeStreamTrailer(key, op.aad, chunks, size)
+		}
+	}
+	if h.compression != CompressionNone {
+		if op.compress, err = compressor(h.compression, c.compressionLevel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareDecrypt reads the header from src, or falls back to the legacy
+// format when there is none
+func (c Cypher) prepareDecrypt(op *operation, src io.Reader, dst io.Writer) (io.Reader, error) {
+	magic := make([]byte, len(FormatMagic))
+	n, err := io.ReadFull(src, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	if n == len(magic) && string(magic) == ConcatMagic {
+		return c.prepareConcatDecrypt(op, src)
+	}
+	if n < len(magic) || string(magic) != FormatMagic {
+		return c.prepareLegacyDecrypt(op, io.MultiReader(bytes.NewReader(magic[:n]), src))
+	}
+
+	h, err := readHeader(src, c.parseMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkGeneration(h.generation); err != nil {
+		return nil, err
+	}
+	if err := c.checkExpiry(h.expiry); err != nil {
+		return nil, err
+	}
+	gcm, err := c.fileGCM(h)
+	if err != nil {
+		return nil, err
+	}
+	c.observeCounter(h.counter)
+	if op.xattrs != nil {
+		if *op.xattrs, err = c.openXattrs(h); err != nil {
+			return nil, err
+		}
+	}
+	if h.compression != CompressionNone {
+		if op.decompress, err = decompressor(h.compression, int(h.chunkSize)); err != nil {
+			return nil, err
+		}
+		op.chunkFlags = h.chunkFlags
+	}
+
+	op.gcm = gcm
+	op.aad = headerAAD(h)
+	op.headerSize = len(h.raw)
+	maxFrame := int(h.chunkSize) + gcm.NonceSize() + gcm.Overhead()
+	if h.chunkFlags {
+		maxFrame++
+	}
+	op.readFrame = lengthFrames(maxFrame, op.buffers)
+	op.positionless = h.deterministic
+	if h.deterministic {
+		if err := c.prepareDeltaDecrypt(op, h, maxFrame); err != nil {
+			return nil, err
+		}
+	}
+	if h.trailer {
+		key, err := c.streamTrailerKey(h)
+		if err != nil {
+			return nil, err
+		}
+		op.readFrame = streamFrames(maxFrame, op.buffers, key, op.aad, true)
+	}
+	if len(h.holes) > 0 {
+		op.holeWriter = newHoleWriter(dst, op.outputFile, h.holes)
+	}
+	if h.compression == CompressionNone && !h.deterministic {
+		op.outputSize = openedSize(gcm)
+	}
+	op.transform = openChunk
+	return src, nil
+}
+
+func (c Cypher) prepareLegacyDecrypt(op *operation, src io.Reader) (io.Reader, error) {
+	if err := c.checkGeneration(0); err != nil {
+		return nil, err
+	}
+	gcm, err := c.newGCM()
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate total size for encrypted chunk (including nonce and overhead)
+	encryptedChunkSize := c.ChunkSize + gcm.NonceSize() + gcm.Overhead()
+
+	op.gcm = gcm
+	op.readFrame = fixedFrames(encryptedChunkSize, op.buffers)
+	op.outputSize = openedSize(gcm)
+	op.transform = openChunk
+	return src, nil
+}
+
+// checkGeneration rejects data encrypted before the minimum generation
+func (c Cypher) checkGeneration(generation uint64) error {
+	if generation < c.minGeneration {
+		return fmt.Errorf("%w: generation %d, need at least %d", ErrRollback, generation, c.minGeneration)
+	}
+	return nil
+}
+
+// WithGeneration sets the key rotation counter recorded in the header of
+// encrypted data. Increase it whenever the key is rotated or the data
+// superseded, so WithMinGeneration can refuse older ciphertexts.
+func (c *Cypher) WithGeneration(generation uint64) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.generation = generation
+	return c
+}
+
+// WithMinGeneration makes decryption fail with ErrRollback for data written
+// with a lower generation, including data without a header, so an attacker
+// can't substitute an older ciphertext for the current one. The generation is
+// authenticated along with the rest of the header.
+func (c *Cypher) WithMinGeneration(generation uint64) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.minGeneration = generation
+	return c
+}
+
+// lengthFrames reads length prefixed frames of at most maxSize bytes
+func lengthFrames(maxSize int, buffers *bufferPool) frameReader {
+	// The frames are read by one goroutine, which reuses prefix
+	prefix := make([]byte, FrameLengthSize)
+	return func(src io.Reader) ([]byte, int, error) {
+		if _, err := io.ReadFull(src, prefix); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, 0, errors.New("truncated chunk length")
+			}
+			return nil, 0, err
+		}
+
+		return frameBody(src, prefix, maxSize, buffers)
+	}
+}
+
+// frameBody reads the frame whose length prefix was read from src
+func frameBody(src io.Reader, prefix []byte, maxSize int, buffers *bufferPool) ([]byte, int, error) {
+	size := binary.BigEndian.Uint32(prefix)
+	if int64(size) > int64(maxSize) {
+		return nil, 0, fmt.Errorf("chunk of %d bytes exceeds the maximum of %d", size, maxSize)
+	}
+
+	frame := buffers.get(int(size))
+	if _, err := io.ReadFull(src, frame); err != nil {
+		return nil, 0, fmt.Errorf("truncated chunk: %w", err)
+	}
+	return frame, FrameLengthSize + int(size), nil
+}
+
+// openedSize returns the size of the plaintext of frames opened with gcm
+func openedSize(gcm cipher.AEAD) func(frame []byte) int {
+	return func(frame []byte) int {
+		return max(len(frame)-gcm.NonceSize()-gcm.Overhead(), 0)
+	}
+}
+
+// sealFrame seals data and frames it with its length
+func sealFrame(op *operation, aad, data []byte) ([]byte, error) {
+	if op.usage != nil {
+		if err := op.usage.add(Usage{Bytes: uint64(len(data)), Chunks: 1}); err != nil {
+			return nil, err
+		}
+	}
+	if op.compress != nil {
+		compressed, err := compressChunk(op.compress, data)
+		if err != nil {
+			return nil, err
+		}
+		defer wipe(compressed)
+		data = compressed
+	}
+
+	gcm := op.gcm
+	size := gcm.NonceSize() + len(data) + gcm.Overhead()
+	frame := op.buffers.get(FrameLengthSize + size)[:FrameLengthSize+gcm.NonceSize()]
+	binary.BigEndian.PutUint32(frame, uint32(size))
+
+	nonce := frame[FrameLengthSize:]
+	if op.nonce != nil {
+		next, err := op.nonce(data)
+		if err != nil {
+			return nil, err
+		}
+		copy(nonce, next)
+	} else if _, err := io.ReadFull(op.randomSource(), nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(frame, nonce, data, aad), nil
+}
+
+func openChunk(op *operation, aad, data []byte) ([]byte, error) {
+	return openFrame(op, op.gcm, aad, data)
+}
+
+// openFrame opens data sealed with gcm, for operations whose chunks aren't
+// all sealed with op.gcm
+func openFrame(op *operation, gcm cipher.AEAD, aad, data []byte) ([]byte, error) {
+	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("encrypted chunk too small")
+	}
+
+	nonce := data[:nonceSize]
+	ciphertext := data[nonceSize:]
+
+	plaintext, err := gcm.Open(op.buffers.get(len(ciphertext))[:0], nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt chunk: %w", err)
+	}
+	if op.decompress != nil {
+		return decompressChunk(op, plaintext)
+	}
+	return plaintext, nil
+}
+
+// headerSize is the size of the header written by EncryptFile and Encrypt
+func (c Cypher) headerSize() int64 {
+	h := &header{chunkSize: 1, salt: make([]byte, SaltSize), commitment: make([]byte, CommitmentSize)}
+	if c.nonceCounter != nil {
+		h.counter = new(uint64)
+	}
+	h.compression = c.compression
+	h.chunkFlags = c.compression != CompressionNone
+	h.deterministic = c.deltaFriendly
+	h.trailer = !c.deltaFriendly
+	h.algorithm = c.encryptAlgorithm()
+	h.expiry = c.expiryUnix()
+	return int64(len(h.encode()))
+}
+
+// chunkOverhead is the number of bytes added to every sealed chunk
+const chunkOverhead = FrameLengthSize + 12 + 16
+
+// EncryptedSize returns the size of the ciphertext produced for size bytes of
+// plaintext. With compression it is an upper bound, reached when no chunk
+// compresses; with content defined chunking it assumes chunks of the
+// average size.
+func (c Cypher) EncryptedSize(size int64) int64 {
+	overhead := int64(chunkOverhead)
+	if c.compression != CompressionNone {
+		overhead++
+	}
+	chunkSize := int64(c.ChunkSize)
+	if c.chunking != nil {
+		chunkSize = int64(c.chunking.avg)
+	}
+	total := c.headerSize() + size + chunkCount(size, chunkSize)*overhead
+	if c.deltaFriendly {
+		total += trailerSize
+	} else {
+		total += streamTrailerSize
+	}
+	return total
+}
+
+// decryptedSize estimates the plaintext size of size bytes of ciphertext
+// encrypted with the same chunk size
+func (c Cypher) decryptedSize(size int64) int64 {
+	body := size - c.headerSize()
+	if !c.deltaFriendly {
+		body -= streamTrailerSize
+	}
+	if body <= 0 {
+		return 0
+	}
+	return body - chunkCount(body, int64(c.ChunkSize)+chunkOverhead)*chunkOverhead
+}
@@ -0,0 +1,128 @@
+package gpu
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// sbox is the AES S-box, computed rather than typed in: the inverse in
+// GF(2^8) followed by the affine transformation
+var sbox = func() (table [256]byte) {
+	for x := range table {
+		inverse := byte(0)
+		if x != 0 {
+			// x^254 is the inverse of x
+			inverse = 1
+			for i := 0; i < 254; i++ {
+				inverse = gmul(inverse, byte(x))
+			}
+		}
+		table[x] = inverse ^ bits.RotateLeft8(inverse, 1) ^ bits.RotateLeft8(inverse, 2) ^
+			bits.RotateLeft8(inverse, 3) ^ bits.RotateLeft8(inverse, 4) ^ 0x63
+	}
+	return table
+}()
+
+// gmul multiplies a and b in GF(2^8) modulo the AES polynomial
+func gmul(a, b byte) byte {
+	var product byte
+	for ; b > 0; b >>= 1 {
+		if b&1 == 1 {
+			product ^= a
+		}
+		a = xtime(a)
+	}
+	return product
+}
+
+// xtime multiplies a by x in GF(2^8)
+func xtime(a byte) byte {
+	return a<<1 ^ (a>>7)*0x1b
+}
+
+// expandKey returns the 15 round keys of the AES-256 key key, sent to the
+// device once per message since the kernel doesn't expand keys
+func expandKey(key []byte) []byte {
+	w := make([]byte, 240)
+	copy(w, key)
+	rcon := byte(1)
+	for i := 32; i < len(w); i += 4 {
+		t := [4]byte{w[i-4], w[i-3], w[i-2], w[i-1]}
+		switch i / 4 % 8 {
+		case 0:
+			t = [4]byte{sbox[t[1]] ^ rcon, sbox[t[2]], sbox[t[3]], sbox[t[0]]}
+			rcon = xtime(rcon)
+		case 4:
+			t = [4]byte{sbox[t[0]], sbox[t[1]], sbox[t[2]], sbox[t[3]]}
+		}
+		for j := range t {
+			w[i+j] = w[i-32+j] ^ t[j]
+		}
+	}
+	return w
+}
+
+// kernelSource is the OpenCL C of the keystream kernel: every work item
+// encrypts the counter block of its index. The state is column major, byte
+// i in row i%4 of column i/4.
+func kernelSource() string {
+	var table strings.Builder
+	for i, b := range sbox {
+		if i > 0 {
+			table.WriteString(",")
+		}
+		fmt.Fprintf(&table, "0x%02x", b)
+	}
+	return `__constant uchar sbox[256] = {` + table.String() + `};
+
+inline uchar xtime(uchar a) {
+	return (uchar)((a << 1) ^ ((a >> 7) * 0x1b));
+}
+
+__kernel void aes256_ctr(__global const uchar *roundKeys, __global const uchar *counter,
+		__global uchar *out, const uint blocks) {
+	uint gid = get_global_id(0);
+	if (gid >= blocks) {
+		return;
+	}
+
+	uchar s[16], t[16];
+	for (int i = 0; i < 12; i++) {
+		s[i] = counter[i];
+	}
+	uint ctr = ((uint)counter[12] << 24 | (uint)counter[13] << 16 | (uint)counter[14] << 8 | (uint)counter[15]) + gid;
+	s[12] = (uchar)(ctr >> 24);
+	s[13] = (uchar)(ctr >> 16);
+	s[14] = (uchar)(ctr >> 8);
+	s[15] = (uchar)ctr;
+	for (int i = 0; i < 16; i++) {
+		s[i] ^= roundKeys[i];
+	}
+
+	for (int round = 1; round < 15; round++) {
+		// SubBytes and ShiftRows
+		for (int i = 0; i < 16; i++) {
+			t[i] = sbox[s[(i + 4 * (i % 4)) % 16]];
+		}
+		if (round < 14) {
+			for (int c = 0; c < 16; c += 4) {
+				uchar a0 = t[c], a1 = t[c + 1], a2 = t[c + 2], a3 = t[c + 3];
+				uchar all = a0 ^ a1 ^ a2 ^ a3;
+				t[c] = a0 ^ all ^ xtime(a0 ^ a1);
+				t[c + 1] = a1 ^ all ^ xtime(a1 ^ a2);
+				t[c + 2] = a2 ^ all ^ xtime(a2 ^ a3);
+				t[c + 3] = a3 ^ all ^ xtime(a3 ^ a0);
+			}
+		}
+		for (int i = 0; i < 16; i++) {
+			s[i] = t[i] ^ roundKeys[16 * round + i];
+		}
+	}
+
+	for (int i = 0; i < 16; i++) {
+		out[16 * gid + i] = s[i];
+	}
+}
+`
+}
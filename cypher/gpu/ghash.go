@@ -0,0 +1,93 @@
+package gpu
+
+import "encoding/binary"
+
+// fieldElement is an element of GF(2^128) in the bit order of GCM: low
+// holds the first 8 bytes of a block
+type fieldElement struct {
+	low, high uint64
+}
+
+// ghash is the GHASH of GCM with a 4 bit table of the multiples of its
+// hash key
+type ghash struct {
+	table [16]fieldElement
+}
+
+// reductionTable reduces the 4 bits shifted out of a product
+var reductionTable = [16]uint16{
+	0x0000, 0x1c20, 0x3840, 0x2460, 0x7080, 0x6ca0, 0x48c0, 0x54e0,
+	0xe100, 0xfd20, 0xd940, 0xc560, 0x9180, 0x8da0, 0xa9c0, 0xb5e0,
+}
+
+// newGHASH returns the GHASH keyed with h, the encryption of the zero block
+func newGHASH(h [16]byte) ghash {
+	var g ghash
+	x := fieldElement{binary.BigEndian.Uint64(h[:8]), binary.BigEndian.Uint64(h[8:])}
+	g.table[reverseBits(1)] = x
+	for i := 2; i < 16; i += 2 {
+		g.table[reverseBits(i)] = double(g.table[reverseBits(i/2)])
+		g.table[reverseBits(i+1)] = add(g.table[reverseBits(i)], x)
+	}
+	return g
+}
+
+// reverseBits reverses the 4 bits of i
+func reverseBits(i int) int {
+	i = ((i << 2) & 0xc) | ((i >> 2) & 0x3)
+	return ((i << 1) & 0xa) | ((i >> 1) & 0x5)
+}
+
+func add(x, y fieldElement) fieldElement {
+	return fieldElement{x.low ^ y.low, x.high ^ y.high}
+}
+
+// double multiplies x by the generator
+func double(x fieldElement) fieldElement {
+	doubled := fieldElement{x.low >> 1, x.high>>1 | x.low<<63}
+	if x.high&1 == 1 {
+		doubled.low ^= 0xe100000000000000
+	}
+	return doubled
+}
+
+// mul sets y to y times the hash key
+func (g *ghash) mul(y *fieldElement) {
+	var z fieldElement
+	for _, word := range [2]uint64{y.high, y.low} {
+		for j := 0; j < 64; j += 4 {
+			msw := z.high & 0xf
+			z.high = z.high>>4 | z.low<<60
+			z.low = z.low>>4 ^ uint64(reductionTable[msw])<<48
+			t := g.table[word&0xf]
+			z.low ^= t.low
+			z.high ^= t.high
+			word >>= 4
+		}
+	}
+	*y = z
+}
+
+// update hashes data into y, padding its last block with zeros
+func (g *ghash) update(y *fieldElement, data []byte) {
+	for len(data) > 0 {
+		var block [16]byte
+		n := copy(block[:], data)
+		data = data[n:]
+		y.low ^= binary.BigEndian.Uint64(block[:8])
+		y.high ^= binary.BigEndian.Uint64(block[8:])
+		g.mul(y)
+	}
+}
+
+// sum writes the GHASH of additionalData and ciphertext to out
+func (g *ghash) sum(out, additionalData, ciphertext []byte) {
+	var y fieldElement
+	g.update(&y, additionalData)
+	g.update(&y, ciphertext)
+	y.low ^= uint64(len(additionalData)) * 8
+	y.high ^= uint64(len(ciphertext)) * 8
+	g.mul(&y)
+	binary.BigEndian.PutUint64(out, y.low)
+	binary.BigEndian.PutUint64(out[8:], y.high)
+}
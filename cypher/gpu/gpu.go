@@ -0,0 +1,195 @@
+// Package gpu is an experimental cypher.Backend that offloads the AES-CTR
+// keystream of large AES-256-GCM chunks to an OpenCL device, for nightly
+// encryption of multi-terabyte datasets. GHASH and short chunks stay on the
+// CPU, and the output is plain AES-256-GCM, so data sealed with it decrypts
+// without it.
+//
+// The device code is built with the opencl build tag and needs the OpenCL
+// headers and library; without the tag New returns ErrUnavailable:
+//
+//	go build -tags opencl
+package gpu
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/nikola43/gocypher/cypher"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// DefaultMinBatch is the chunk size from which the keystream is generated on
+// the device, copying smaller chunks to it costs more than it saves
+const DefaultMinBatch = 1 << 20
+
+const (
+	nonceSize = 12
+	tagSize   = 16
+)
+
+var (
+	// ErrUnavailable is returned by New when no OpenCL device can be used,
+	// or the package was built without the opencl tag
+	ErrUnavailable = errors.New("gpu: no OpenCL device available")
+
+	errOpen = errors.New("gpu: message authentication failed")
+)
+
+// keystreamer generates AES-256-CTR keystream on a device
+type keystreamer interface {
+	// keystream fills dst, a multiple of 16 bytes, with the keystream of the
+	// expanded key roundKeys from the counter block counter, whose last 4
+	// bytes are incremented as a big endian counter
+	keystream(roundKeys []byte, counter [aes.BlockSize]byte, dst []byte) error
+	close() error
+}
+
+// Backend creates AES-256-GCM AEADs whose keystream an OpenCL device
+// generates, see New
+type Backend struct {
+	dev      keystreamer
+	minBatch int
+}
+
+// New returns a Backend using the first OpenCL GPU. Chunks of at least
+// minBatch bytes are sent to it, DefaultMinBatch when minBatch is 0. Pass
+// it to cypher.WithBackend and Close it once done.
+func New(minBatch int) (*Backend, error) {
+	if minBatch == 0 {
+		minBatch = DefaultMinBatch
+	}
+	if minBatch < 0 {
+		return nil, fmt.Errorf("gpu: negative batch size %d", minBatch)
+	}
+	dev, err := newDevice()
+	if err != nil {
+		return nil, err
+	}
+	return &Backend{dev: dev, minBatch: minBatch}, nil
+}
+
+// Close releases the device
+func (b *Backend) Close() error {
+	return b.dev.close()
+}
+
+// NewAEAD returns the AEAD of algorithm keyed with key. ChaCha20-Poly1305
+// is left to the CPU.
+func (b *Backend) NewAEAD(algorithm cypher.Algorithm, key []byte) (cipher.AEAD, error) {
+	switch algorithm {
+	case cypher.AlgorithmChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	case cypher.AlgorithmAES256GCM:
+	default:
+		return nil, fmt.Errorf("gpu: unsupported algorithm %s", algorithm)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("gpu: key of %d bytes, need 32", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	var h [aes.BlockSize]byte
+	block.Encrypt(h[:], h[:])
+	return &aead{
+		cpu:       gcm,
+		block:     block,
+		roundKeys: expandKey(key),
+		hash:      newGHASH(h),
+		dev:       b.dev,
+		minBatch:  b.minBatch,
+	}, nil
+}
+
+// aead is AES-256-GCM with the keystream of large messages from a device
+type aead struct {
+	// cpu seals and opens messages below minBatch, and those the device
+	// fails on
+	cpu       cipher.AEAD
+	block     cipher.Block
+	roundKeys []byte
+	hash      ghash
+	dev       keystreamer
+	minBatch  int
+}
+
+func (a *aead) NonceSize() int { return nonceSize }
+
+func (a *aead) Overhead() int { return tagSize }
+
+func (a *aead) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(plaintext) < a.minBatch || len(nonce) != nonceSize {
+		return a.cpu.Seal(dst, nonce, plaintext, additionalData)
+	}
+	stream, err := a.keystream(nonce, len(plaintext))
+	if err != nil {
+		return a.cpu.Seal(dst, nonce, plaintext, additionalData)
+	}
+	ret, out := sliceForAppend(dst, len(plaintext)+tagSize)
+	subtle.XORBytes(out, plaintext, stream)
+	a.tag(out[len(plaintext):], nonce, out[:len(plaintext)], additionalData)
+	return ret
+}
+
+func (a *aead) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext)-tagSize < a.minBatch || len(nonce) != nonceSize {
+		return a.cpu.Open(dst, nonce, ciphertext, additionalData)
+	}
+	sealed, tag := ciphertext[:len(ciphertext)-tagSize], ciphertext[len(ciphertext)-tagSize:]
+	var expected [tagSize]byte
+	a.tag(expected[:], nonce, sealed, additionalData)
+	if subtle.ConstantTimeCompare(expected[:], tag) != 1 {
+		return nil, errOpen
+	}
+	stream, err := a.keystream(nonce, len(sealed))
+	if err != nil {
+		return a.cpu.Open(dst, nonce, ciphertext, additionalData)
+	}
+	ret, out := sliceForAppend(dst, len(sealed))
+	subtle.XORBytes(out, sealed, stream)
+	return ret, nil
+}
+
+// keystream returns n bytes of the keystream of nonce, which starts at
+// counter 2: counter 1 masks the tag
+func (a *aead) keystream(nonce []byte, n int) ([]byte, error) {
+	var counter [aes.BlockSize]byte
+	copy(counter[:], nonce)
+	counter[aes.BlockSize-1] = 2
+	stream := make([]byte, (n+aes.BlockSize-1)/aes.BlockSize*aes.BlockSize)
+	if err := a.dev.keystream(a.roundKeys, counter, stream); err != nil {
+		return nil, err
+	}
+	return stream[:n], nil
+}
+
+// tag writes the GCM tag of ciphertext and additionalData to out
+func (a *aead) tag(out, nonce, ciphertext, additionalData []byte) {
+	var mask [aes.BlockSize]byte
+	copy(mask[:], nonce)
+	mask[aes.BlockSize-1] = 1
+	a.block.Encrypt(mask[:], mask[:])
+	a.hash.sum(out, additionalData, ciphertext)
+	subtle.XORBytes(out, out, mask[:])
+}
+
+// sliceForAppend extends in by n bytes, returning the whole slice and the
+// extension
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	return head, head[len(in):]
+}
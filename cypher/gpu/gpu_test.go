@@ -0,0 +1,156 @@
+package gpu
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+// kernelKeystream runs the algorithm of the OpenCL kernel on the CPU, block
+// by block as its work items do
+type kernelKeystream struct {
+	calls int
+}
+
+func (k *kernelKeystream) keystream(roundKeys []byte, counter [aes.BlockSize]byte, dst []byte) error {
+	k.calls++
+	ctr := uint32(counter[12])<<24 | uint32(counter[13])<<16 | uint32(counter[14])<<8 | uint32(counter[15])
+	for gid := 0; gid < len(dst)/16; gid++ {
+		var s, t [16]byte
+		copy(s[:12], counter[:12])
+		c := ctr + uint32(gid)
+		s[12], s[13], s[14], s[15] = byte(c>>24), byte(c>>16), byte(c>>8), byte(c)
+		for i := range s {
+			s[i] ^= roundKeys[i]
+		}
+		for round := 1; round < 15; round++ {
+			for i := range t {
+				t[i] = sbox[s[(i+4*(i%4))%16]]
+			}
+			if round < 14 {
+				for c := 0; c < 16; c += 4 {
+					a0, a1, a2, a3 := t[c], t[c+1], t[c+2], t[c+3]
+					all := a0 ^ a1 ^ a2 ^ a3
+					t[c], t[c+1], t[c+2], t[c+3] = a0^all^xtime(a0^a1), a1^all^xtime(a1^a2), a2^all^xtime(a2^a3), a3^all^xtime(a3^a0)
+				}
+			}
+			for i := range s {
+				s[i] = t[i] ^ roundKeys[16*round+i]
+			}
+		}
+		copy(dst[16*gid:], s[:])
+	}
+	return nil
+}
+
+func (k *kernelKeystream) close() error { return nil }
+
+// failingKeystream is a device that always fails
+type failingKeystream struct{}
+
+func (failingKeystream) keystream([]byte, [aes.BlockSize]byte, []byte) error {
+	return errors.New("device lost")
+}
+
+func (failingKeystream) close() error { return nil }
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestKernelKeystream(t *testing.T) {
+	key := randomBytes(t, 32)
+	block, _ := aes.NewCipher(key)
+	var counter [aes.BlockSize]byte
+	copy(counter[:], randomBytes(t, 12))
+	counter[15] = 2
+
+	expected := make([]byte, 16*100)
+	cipher.NewCTR(block, counter[:]).XORKeyStream(expected, expected)
+	got := make([]byte, len(expected))
+	if err := (&kernelKeystream{}).keystream(expandKey(key), counter, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Error("Expected the kernel keystream to match AES-CTR")
+	}
+	if !bytes.Contains([]byte(kernelSource()), []byte("0x63,0x7c,0x77,0x7b")) {
+		t.Error("Expected the S-box in the kernel source")
+	}
+}
+
+func TestAEAD(t *testing.T) {
+	dev := &kernelKeystream{}
+	b := &Backend{dev: dev, minBatch: 64}
+	key := randomBytes(t, 32)
+	aead, err := b.NewAEAD(cypher.AlgorithmAES256GCM, key)
+	if err != nil {
+		t.Fatalf("NewAEAD failed: %v", err)
+	}
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+
+	for _, size := range []int{0, 10, 64, 100, 1000} {
+		nonce, plaintext, aad := randomBytes(t, 12), randomBytes(t, size), randomBytes(t, size%37)
+		sealed := aead.Seal(nil, nonce, plaintext, aad)
+		if expected := gcm.Seal(nil, nonce, plaintext, aad); !bytes.Equal(sealed, expected) {
+			t.Fatalf("Expected the output of AES-256-GCM for %d bytes", size)
+		}
+		opened, err := aead.Open(nil, nonce, sealed, aad)
+		if err != nil || !bytes.Equal(opened, plaintext) {
+			t.Fatalf("Expected %d bytes to open: %v", size, err)
+		}
+		// In place, as the pipeline reuses its buffers
+		inPlace := append(bytes.Clone(plaintext), make([]byte, tagSize)...)[:size]
+		if sealed := aead.Seal(inPlace[:0], nonce, inPlace, aad); !bytes.Equal(sealed, gcm.Seal(nil, nonce, plaintext, aad)) {
+			t.Fatalf("Expected sealing %d bytes in place to match", size)
+		}
+		sealed[len(sealed)-1] ^= 1
+		if _, err := aead.Open(nil, nonce, sealed, aad); err == nil {
+			t.Fatalf("Expected a tampered tag to fail for %d bytes", size)
+		}
+	}
+	if dev.calls == 0 {
+		t.Error("Expected large messages to use the device")
+	}
+
+	// A failing device leaves the work to the CPU
+	failing, _ := (&Backend{dev: failingKeystream{}, minBatch: 64}).NewAEAD(cypher.AlgorithmAES256GCM, key)
+	nonce, plaintext := randomBytes(t, 12), randomBytes(t, 1000)
+	if sealed := failing.Seal(nil, nonce, plaintext, nil); !bytes.Equal(sealed, gcm.Seal(nil, nonce, plaintext, nil)) {
+		t.Error("Expected the CPU to seal when the device fails")
+	}
+
+	if _, err := b.NewAEAD(cypher.AlgorithmChaCha20Poly1305, key); err != nil {
+		t.Errorf("Expected ChaCha20-Poly1305 on the CPU: %v", err)
+	}
+}
+
+func TestBackend(t *testing.T) {
+	b := &Backend{dev: &kernelKeystream{}, minBatch: 1024}
+	data := randomBytes(t, 10000)
+	c := cypher.NewCypher("test-key", cypher.WithChunkSize(4096), cypher.WithAlgorithm(cypher.AlgorithmAES256GCM), cypher.WithBackend(b))
+	encrypted, err := c.Encrypt(data)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	// The output is plain AES-256-GCM
+	decrypted, err := cypher.NewCypher("test-key").Decrypt(encrypted)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Expected the data to decrypt without the backend: %v", err)
+	}
+
+	if _, err := New(0); err != nil && !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable without a device, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+//go:build opencl
+
+package gpu
+
+/*
+#cgo !darwin LDFLAGS: -lOpenCL
+#cgo darwin LDFLAGS: -framework OpenCL
+#define CL_TARGET_OPENCL_VERSION 120
+#ifdef __APPLE__
+#include <OpenCL/opencl.h>
+#else
+#include <CL/cl.h>
+#endif
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"crypto/aes"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// device is an OpenCL GPU with the keystream kernel built
+type device struct {
+	// mu serializes the workers of a pipeline on the command queue
+	mu      sync.Mutex
+	context C.cl_context
+	queue   C.cl_command_queue
+	program C.cl_program
+	kernel  C.cl_kernel
+}
+
+// clError describes the failure of an OpenCL call
+func clError(call string, status C.cl_int) error {
+	return fmt.Errorf("gpu: %s failed with status %d", call, int(status))
+}
+
+// newDevice builds the kernel for the first GPU of the first platform
+func newDevice() (keystreamer, error) {
+	var platform C.cl_platform_id
+	var count C.cl_uint
+	if C.clGetPlatformIDs(1, &platform, &count) != C.CL_SUCCESS || count == 0 {
+		return nil, ErrUnavailable
+	}
+	var id C.cl_device_id
+	if C.clGetDeviceIDs(platform, C.CL_DEVICE_TYPE_GPU, 1, &id, &count) != C.CL_SUCCESS || count == 0 {
+		return nil, ErrUnavailable
+	}
+
+	d := &device{}
+	var status C.cl_int
+	if d.context = C.clCreateContext(nil, 1, &id, nil, nil, &status); status != C.CL_SUCCESS {
+		return nil, clError("clCreateContext", status)
+	}
+	if d.queue = C.clCreateCommandQueue(d.context, id, 0, &status); status != C.CL_SUCCESS {
+		d.close()
+		return nil, clError("clCreateCommandQueue", status)
+	}
+	source := C.CString(kernelSource())
+	defer C.free(unsafe.Pointer(source))
+	if d.program = C.clCreateProgramWithSource(d.context, 1, &source, nil, &status); status != C.CL_SUCCESS {
+		d.close()
+		return nil, clError("clCreateProgramWithSource", status)
+	}
+	if status = C.clBuildProgram(d.program, 1, &id, nil, nil, nil); status != C.CL_SUCCESS {
+		d.close()
+		return nil, clError("clBuildProgram", status)
+	}
+	name := C.CString("aes256_ctr")
+	defer C.free(unsafe.Pointer(name))
+	if d.kernel = C.clCreateKernel(d.program, name, &status); status != C.CL_SUCCESS {
+		d.close()
+		return nil, clError("clCreateKernel", status)
+	}
+	return d, nil
+}
+
+func (d *device) keystream(roundKeys []byte, counter [aes.BlockSize]byte, dst []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var status C.cl_int
+	keys := C.clCreateBuffer(d.context, C.CL_MEM_READ_ONLY|C.CL_MEM_COPY_HOST_PTR, C.size_t(len(roundKeys)), unsafe.Pointer(&roundKeys[0]), &status)
+	if status != C.CL_SUCCESS {
+		return clError("clCreateBuffer", status)
+	}
+	defer C.clReleaseMemObject(keys)
+	start := C.clCreateBuffer(d.context, C.CL_MEM_READ_ONLY|C.CL_MEM_COPY_HOST_PTR, C.size_t(len(counter)), unsafe.Pointer(&counter[0]), &status)
+	if status != C.CL_SUCCESS {
+		return clError("clCreateBuffer", status)
+	}
+	defer C.clReleaseMemObject(start)
+	out := C.clCreateBuffer(d.context, C.CL_MEM_WRITE_ONLY, C.size_t(len(dst)), nil, &status)
+	if status != C.CL_SUCCESS {
+		return clError("clCreateBuffer", status)
+	}
+	defer C.clReleaseMemObject(out)
+
+	blocks := C.cl_uint(len(dst) / aes.BlockSize)
+	args := []struct {
+		size  uintptr
+		value unsafe.Pointer
+	}{
+		{unsafe.Sizeof(keys), unsafe.Pointer(&keys)},
+		{unsafe.Sizeof(start), unsafe.Pointer(&start)},
+		{unsafe.Sizeof(out), unsafe.Pointer(&out)},
+		{unsafe.Sizeof(blocks), unsafe.Pointer(&blocks)},
+	}
+	for i, arg := range args {
+		if status = C.clSetKernelArg(d.kernel, C.cl_uint(i), C.size_t(arg.size), arg.value); status != C.CL_SUCCESS {
+			return clError("clSetKernelArg", status)
+		}
+	}
+	global := C.size_t(blocks)
+	if status = C.clEnqueueNDRangeKernel(d.queue, d.kernel, 1, nil, &global, nil, 0, nil, nil); status != C.CL_SUCCESS {
+		return clError("clEnqueueNDRangeKernel", status)
+	}
+	if status = C.clEnqueueReadBuffer(d.queue, out, C.CL_TRUE, 0, C.size_t(len(dst)), unsafe.Pointer(&dst[0]), 0, nil, nil); status != C.CL_SUCCESS {
+		return clError("clEnqueueReadBuffer", status)
+	}
+	return nil
+}
+
+func (d *device) close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.kernel != nil {
+		C.clReleaseKernel(d.kernel)
+	}
+	if d.program != nil {
+		C.clReleaseProgram(d.program)
+	}
+	if d.queue != nil {
+		C.clReleaseCommandQueue(d.queue)
+	}
+	if d.context != nil {
+		C.clReleaseContext(d.context)
+	}
+	d.kernel, d.program, d.queue, d.context = nil, nil, nil, nil
+	return nil
+}
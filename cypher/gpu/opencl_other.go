@@ -0,0 +1,8 @@
+//go:build !opencl
+
+package gpu
+
+// newDevice reports that the package was built without the opencl tag
+func newDevice() (keystreamer, error) {
+	return nil, ErrUnavailable
+}
@@ -0,0 +1,50 @@
+//go:build opencl
+
+package gpu
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+func TestDevice(t *testing.T) {
+	b, err := New(4096)
+	if errors.Is(err, ErrUnavailable) {
+		t.Skip("no OpenCL GPU")
+	}
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer b.Close()
+
+	key := randomBytes(t, 32)
+	block, _ := aes.NewCipher(key)
+	var counter [aes.BlockSize]byte
+	copy(counter[:], randomBytes(t, 12))
+	counter[15] = 2
+	expected := make([]byte, 1<<20)
+	cipher.NewCTR(block, counter[:]).XORKeyStream(expected, expected)
+	got := make([]byte, len(expected))
+	if err := b.dev.keystream(expandKey(key), counter, got); err != nil {
+		t.Fatalf("keystream failed: %v", err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Fatal("Expected the device keystream to match AES-CTR")
+	}
+
+	data := randomBytes(t, 3<<20)
+	c := cypher.NewCypher("test-key", cypher.WithChunkSize(1<<20), cypher.WithAlgorithm(cypher.AlgorithmAES256GCM), cypher.WithBackend(b))
+	encrypted, err := c.Encrypt(data)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	decrypted, err := cypher.NewCypher("test-key").Decrypt(encrypted)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Expected the data to decrypt without the device: %v", err)
+	}
+}
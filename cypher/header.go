@@ -0,0 +1,119 @@
+package cypher
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ParseMode sets how headers with records unknown to this version are parsed
+type ParseMode int
+
+const (
+	// ParseStrict rejects headers with unknown records (default)
+	ParseStrict ParseMode = iota
+	// ParseLenient skips unknown extension records, RecordExtension and
+	// above, so data written by newer versions with optional extensions
+	// still decrypts. They remain authenticated as part of the header.
+	ParseLenient
+)
+
+// WithParseMode sets how the headers of decrypted data are parsed. With
+// ParseLenient, unknown extension records are skipped.
+func (c *Cypher) WithParseMode(mode ParseMode) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.parseMode = mode
+	return c
+}
+
+// Record is a header record
+type Record struct {
+	Type  byte
+	Value []byte
+}
+
+// Hole is a range of a sparse file stored as a hole instead of chunks
+type Hole struct {
+	Offset int64
+	Length int64
+}
+
+// Header is a parsed container header, see ParseHeader
+type Header struct {
+	Version     byte
+	ChunkSize   uint32
+	Generation  uint64
+	Counter     *uint64
+	Compression Compression
+	// ChunkFlags is set when every compressed chunk starts with a flag byte
+	ChunkFlags    bool
+	Deterministic bool
+	// Trailer is set when the chunks are followed by a stream trailer, see
+	// RecordTrailer
+	Trailer   bool
+	Holes     []Hole
+	Algorithm Algorithm
+	// Expiry is when the data expires, zero for data that doesn't
+	Expiry time.Time
+	// SealedName is the sealed name of the plaintext file, see
+	// Cypher.OriginalName
+	SealedName []byte
+	// SealedXattrs are the sealed extended attributes of the plaintext
+	// file, see WithXattrs
+	SealedXattrs []byte
+	Salt         []byte
+	Commitment   []byte
+	// Extensions are the unknown extension records skipped by ParseLenient
+	Extensions []Record
+	// Raw is the encoded header, the chunks follow it
+	Raw []byte
+}
+
+// ParseHeader parses the header at the start of r, leaving r at the first
+// chunk. It doesn't need the key, so tools can inspect encrypted data and
+// other implementations can check their output against it. Data without a
+// header, written by the legacy format, returns ErrInvalidHeader.
+func ParseHeader(r io.Reader, mode ParseMode) (*Header, error) {
+	magic := make([]byte, len(FormatMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != FormatMagic {
+		return nil, fmt.Errorf("%w: missing magic", ErrInvalidHeader)
+	}
+	h, err := readHeader(r, mode)
+	if err != nil {
+		return nil, err
+	}
+	parsed := &Header{
+		Version:       h.version,
+		ChunkSize:     h.chunkSize,
+		Generation:    h.generation,
+		Counter:       h.counter,
+		Compression:   h.compression,
+		ChunkFlags:    h.chunkFlags,
+		Deterministic: h.deterministic,
+		Trailer:       h.trailer,
+		Algorithm:     h.algorithm,
+		Expiry:        expiryTime(h.expiry),
+		SealedName:    h.name,
+		SealedXattrs:  h.xattrs,
+		Salt:          h.salt,
+		Commitment:    h.commitment,
+		Extensions:    h.extensions,
+		Raw:           h.raw,
+	}
+	for _, hole := range h.holes {
+		parsed.Holes = append(parsed.Holes, Hole{Offset: hole.offset, Length: hole.length})
+	}
+	return parsed, nil
+}
+
+// ChunkAAD returns the additional data the chunk at position is sealed with
+func (h *Header) ChunkAAD(position int) []byte {
+	aad := headerAAD(&header{raw: h.Raw})
+	if h.Deterministic {
+		return aad
+	}
+	return binary.BigEndian.AppendUint64(aad, uint64(position))
+}
@@ -0,0 +1,108 @@
+// Package httpcypher serves files stored encrypted on disk over HTTP. Range
+// requests decrypt only the chunks they cover, so media servers can seek in
+// large files kept encrypted at rest.
+//
+// A request for /videos/talk.mp4 is served from videos/talk.mp4.encrypted
+// below the root, which must have been written without compression, see
+// cypher.NewSeekableReader. Directories aren't listed.
+package httpcypher
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+// Handler serves the decryption of the files of a file system
+type Handler struct {
+	cypher *cypher.Cypher
+	root   http.FileSystem
+	suffix string
+	logger *slog.Logger
+}
+
+// Option configures a Handler
+type Option func(*Handler)
+
+// WithSuffix sets the suffix of the encrypted files, ".encrypted" by default
+func WithSuffix(suffix string) Option {
+	return func(h *Handler) {
+		h.suffix = suffix
+	}
+}
+
+// WithLogger logs the files that fail to decrypt to logger instead of
+// slog.Default
+func WithLogger(logger *slog.Logger) Option {
+	return func(h *Handler) {
+		h.logger = logger
+	}
+}
+
+// New returns a handler serving the files of root decrypted with c, such as
+// New(c, http.Dir("/srv/media"))
+func New(c *cypher.Cypher, root http.FileSystem, opts ...Option) *Handler {
+	h := &Handler{cypher: c, root: root, suffix: ".encrypted", logger: slog.Default()}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := path.Clean("/" + r.URL.Path)
+	if name == "/" || strings.HasSuffix(r.URL.Path, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, err := h.root.Open(name + h.suffix)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		h.fail(w, name, err)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		h.fail(w, name, err)
+		return
+	}
+	if info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		h.fail(w, name, errors.New("file system doesn't support random access"))
+		return
+	}
+
+	plaintext, err := h.cypher.NewSeekableReader(readerAt, info.Size())
+	if err != nil {
+		h.fail(w, name, err)
+		return
+	}
+	defer plaintext.Close()
+	http.ServeContent(w, r, path.Base(name), info.ModTime(), plaintext)
+}
+
+// fail answers a request for name that can't be served, without revealing
+// why to the client
+func (h *Handler) fail(w http.ResponseWriter, name string, err error) {
+	h.logger.Error("failed to serve encrypted file", "name", name, "error", err)
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
@@ -0,0 +1,83 @@
+package httpcypher
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+func TestHandler(t *testing.T) {
+	c := cypher.NewCypher("test-key", cypher.WithChunkSize(1024))
+	dir := t.TempDir()
+	data := make([]byte, 10000)
+	rand.Read(data)
+	if err := c.EncryptBytesToFile(data, filepath.Join(dir, "video.mp4.encrypted")); err != nil {
+		t.Fatalf("EncryptBytesToFile failed: %v", err)
+	}
+	compressed := cypher.NewCypher("test-key", cypher.WithCompression(cypher.CompressionZstd, 0))
+	compressed.EncryptBytesToFile(data, filepath.Join(dir, "packed.bin.encrypted"))
+	os.WriteFile(filepath.Join(dir, "plain.txt"), []byte("plain"), 0644)
+
+	server := httptest.NewServer(New(c, http.Dir(dir), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))))
+	defer server.Close()
+
+	get := func(path, ranges string) (*http.Response, []byte) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if ranges != "" {
+			req.Header.Set("Range", ranges)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	resp, body := get("/video.mp4", "")
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, data) {
+		t.Fatalf("Expected the plaintext, got %d with %d bytes", resp.StatusCode, len(body))
+	}
+	if resp.Header.Get("Content-Type") != "video/mp4" || resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Errorf("Unexpected headers %v", resp.Header)
+	}
+
+	resp, body = get("/video.mp4", "bytes=3000-5099")
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, data[3000:5100]) {
+		t.Errorf("Expected bytes 3000 to 5099, got %d with %d bytes", resp.StatusCode, len(body))
+	}
+	if got := resp.Header.Get("Content-Range"); got != "bytes 3000-5099/10000" {
+		t.Errorf("Unexpected Content-Range %q", got)
+	}
+
+	for path, status := range map[string]int{
+		"/missing":      http.StatusNotFound,
+		"/plain.txt":    http.StatusNotFound,
+		"/":             http.StatusNotFound,
+		"/../video.mp4": http.StatusOK,
+		"/packed.bin":   http.StatusInternalServerError,
+		"/video.mp4/":   http.StatusNotFound,
+	} {
+		if resp, _ := get(path, ""); resp.StatusCode != status {
+			t.Errorf("Expected %d for %s, got %d", status, path, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Post(server.URL+"/video.mp4", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", resp.StatusCode)
+	}
+}
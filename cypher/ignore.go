@@ -0,0 +1,253 @@
+package cypher
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFile is read from every directory EncryptDir, DecryptDir and the
+// backup package walk. It holds gitignore style patterns relative to its
+// directory.
+const IgnoreFile = ".gocypherignore"
+
+// ignoreRule is a single gitignore pattern
+type ignoreRule struct {
+	// base is the slash separated directory the pattern is relative to
+	base     string
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// parseIgnoreRule parses pattern relative to base, it returns nil for blank
+// lines and comments
+func parseIgnoreRule(base, pattern string) (*ignoreRule, error) {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil, nil
+	}
+
+	rule := &ignoreRule{base: base}
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\`) {
+		// \# and \! match a literal leading # or !
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return nil, nil
+	}
+
+	// Patterns without a slash match at any depth, others are anchored
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	rule.segments = strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	for _, segment := range rule.segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+	}
+	return rule, nil
+}
+
+// match reports whether the slash separated path rel, relative to the walk
+// root, matches the rule
+func (r *ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		var ok bool
+		if rel, ok = strings.CutPrefix(rel, r.base+"/"); !ok {
+			return false
+		}
+	}
+	return matchSegments(r.segments, strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// Filter selects the files of a directory tree with include and exclude
+// patterns and the IgnoreFile of every directory, following gitignore
+// semantics: the last matching pattern wins, a leading ! re-includes, and
+// files below an excluded directory are never visited.
+type Filter struct {
+	include []*ignoreRule
+	exclude []*ignoreRule
+}
+
+// NewFilter returns a Filter skipping paths matching exclude. When include is
+// not empty, only files matching one of its patterns are kept. Ignore files
+// take precedence over exclude.
+func NewFilter(include, exclude []string) (*Filter, error) {
+	f := &Filter{}
+	for _, pattern := range include {
+		rule, err := parseIgnoreRule("", pattern)
+		if err != nil {
+			return nil, err
+		}
+		if rule != nil {
+			f.include = append(f.include, rule)
+		}
+	}
+	for _, pattern := range exclude {
+		rule, err := parseIgnoreRule("", pattern)
+		if err != nil {
+			return nil, err
+		}
+		if rule != nil {
+			f.exclude = append(f.exclude, rule)
+		}
+	}
+	return f, nil
+}
+
+// Walk walks the tree rooted at root like filepath.WalkDir, calling fn only
+// for the root, directories and files the filter keeps
+func (f *Filter) Walk(root string, fn fs.WalkDirFunc) error {
+	// rules holds the exclude rules in effect in each visited directory
+	rules := map[string][]*ignoreRule{}
+
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fn(p, d, err)
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if rel == "." {
+			dirRules, err := f.readIgnoreFile(p, "", f.exclude)
+			if err != nil {
+				return err
+			}
+			rules["."] = dirRules
+			return fn(p, d, nil)
+		}
+
+		inherited := rules[path.Dir(rel)]
+		if lastMatch(inherited, rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			dirRules, err := f.readIgnoreFile(p, rel, inherited)
+			if err != nil {
+				return err
+			}
+			rules[rel] = dirRules
+		} else if !f.included(rel) {
+			return nil
+		}
+		return fn(p, d, nil)
+	})
+}
+
+// readIgnoreFile appends the rules of the IgnoreFile in dir to inherited
+func (f *Filter) readIgnoreFile(dir, base string, inherited []*ignoreRule) ([]*ignoreRule, error) {
+	file, err := os.Open(filepath.Join(dir, IgnoreFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return inherited, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	parsed, err := parseIgnoreRules(file, base)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, IgnoreFile), err)
+	}
+	if len(parsed) == 0 {
+		return inherited, nil
+	}
+	return append(append([]*ignoreRule(nil), inherited...), parsed...), nil
+}
+
+func parseIgnoreRules(r io.Reader, base string) ([]*ignoreRule, error) {
+	var rules []*ignoreRule
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		rule, err := parseIgnoreRule(base, scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		if rule != nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+func (f *Filter) included(rel string) bool {
+	if len(f.include) == 0 {
+		return true
+	}
+	return lastMatch(f.include, rel, false)
+}
+
+// lastMatch reports whether the last rule matching rel is not negated
+func lastMatch(rules []*ignoreRule, rel string, isDir bool) bool {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].match(rel, isDir) {
+			return !rules[i].negate
+		}
+	}
+	return false
+}
+
+// WithInclude makes directory operations only process files matching one of
+// patterns, gitignore style patterns relative to the source directory
+func (c *Cypher) WithInclude(patterns ...string) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.include = append(c.include, patterns...)
+	return c
+}
+
+// WithExclude makes directory operations skip paths matching one of
+// patterns, in addition to those listed in IgnoreFile files
+func (c *Cypher) WithExclude(patterns ...string) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.exclude = append(c.exclude, patterns...)
+	return c
+}
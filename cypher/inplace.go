@@ -0,0 +1,99 @@
+package cypher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// EncryptFileInPlace replaces path with its encryption. The output goes to
+// a temporary file next to path, on the same filesystem, which atomically
+// replaces it once complete and synced, so an interruption leaves path
+// untouched. WithShredSource overwrites the plaintext once the output is
+// verified, before the replacement.
+func (c Cypher) EncryptFileInPlace(ctx context.Context, path string) (*Result, error) {
+	return c.processInPlace(ctx, path, c.encryptOperation)
+}
+
+// DecryptFileInPlace replaces path with its decryption, like
+// EncryptFileInPlace
+func (c Cypher) DecryptFileInPlace(ctx context.Context, path string) (*Result, error) {
+	return c.processInPlace(ctx, path, c.decryptOperation)
+}
+
+func (c Cypher) processInPlace(ctx context.Context, path string, newOperation func() operation) (*Result, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat input file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("can't replace %s, not a regular file", path)
+	}
+	inputFile, err := c.openInput(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer inputFile.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	// keep is set once the temporary file holds the only copy of the data
+	keep := false
+	defer func() {
+		tmp.Close()
+		if !keep {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	op := newOperation()
+	if c.xattrs && op.name == "decrypt" {
+		op.xattrs = new([]xattr)
+	}
+	c.log().Debug("processing file in place", "op", op.name, "path", path)
+	op.inputPath, op.outputPath = path, tmp.Name()
+	op.attributes = map[string]any{"gocypher.input": path, "gocypher.output": path}
+	stats, err := c.processHandles(ctx, op, inputFile, tmp)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		return nil, fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := c.restoreXattrs(op, tmp.Name()); err != nil {
+		return nil, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync output file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close output file: %w", err)
+	}
+	inputFile.Close()
+
+	result := &Result{OutputPath: path, Stats: stats}
+	if op.name == "encrypt" && c.shredPasses > 0 {
+		if err := c.verifyOutput(ctx, path, tmp.Name()); err != nil {
+			return nil, err
+		}
+		result.Shred = &ShredReport{Passes: c.shredPasses, Caveats: shredCaveats(path)}
+		keep = true
+		if err := overwrite(path, info.Size(), c.shredPasses); err != nil {
+			return result, fmt.Errorf("failed to overwrite source, the encrypted data is in %s: %w", tmp.Name(), err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		if keep {
+			return result, fmt.Errorf("failed to replace %s, the encrypted data is in %s: %w", path, tmp.Name(), err)
+		}
+		return nil, fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	keep = true
+	if result.Shred != nil {
+		result.Shred.Removed = true
+	}
+	return result, nil
+}
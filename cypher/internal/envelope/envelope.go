@@ -0,0 +1,83 @@
+// Package envelope is the part the formats wrapping a random file key in a
+// header share, keyslots, sshkeys and timelock: the header is authenticated
+// with an HMAC under a key derived from the file key, and the data after it
+// is encrypted in the gocypher format under another.
+package envelope
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nikola43/gocypher/cypher"
+	"golang.org/x/crypto/hkdf"
+)
+
+// ErrInvalidMAC is returned by Decrypt when the MAC of the header is missing
+// or wrong
+var ErrInvalidMAC = errors.New("invalid header MAC")
+
+// deriveKeys returns the keys authenticating the header and encrypting the
+// data under fileKey. label names the format, it is the prefix of the HKDF
+// info of both.
+func deriveKeys(label string, fileKey []byte) (macKey, payloadKey []byte, err error) {
+	macKey = make([]byte, 32)
+	payloadKey = make([]byte, cypher.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, fileKey, nil, []byte(label+" header")), macKey); err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.ReadFull(hkdf.New(sha256.New, fileKey, nil, []byte(label+" payload")), payloadKey); err != nil {
+		return nil, nil, err
+	}
+	return macKey, payloadKey, nil
+}
+
+// headerMAC returns the MAC of header under macKey
+func headerMAC(macKey, header []byte) []byte {
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(header)
+	return mac.Sum(nil)
+}
+
+// Encrypt writes header and its MAC to dst, then encrypts src after them
+// under fileKey. opts configure the Cypher encrypting the data.
+func Encrypt(ctx context.Context, dst io.Writer, src io.Reader, label string, fileKey, header []byte, opts ...cypher.Option) (*cypher.Stats, error) {
+	macKey, payloadKey, err := deriveKeys(label, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dst.Write(append(header[:len(header):len(header)], headerMAC(macKey, header)...)); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	c, err := cypher.NewCypherFromKey(payloadKey, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.EncryptStream(ctx, src, dst)
+}
+
+// Decrypt reads the MAC following header from src and checks it under
+// fileKey, then decrypts the rest of src into dst. It returns ErrInvalidMAC
+// when the header was changed or fileKey is wrong.
+func Decrypt(ctx context.Context, dst io.Writer, src io.Reader, label string, fileKey, header []byte, opts ...cypher.Option) (*cypher.Stats, error) {
+	macKey, payloadKey, err := deriveKeys(label, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	sum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(src, sum); err != nil || !hmac.Equal(sum, headerMAC(macKey, header)) {
+		return nil, ErrInvalidMAC
+	}
+
+	c, err := cypher.NewCypherFromKey(payloadKey, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.DecryptStream(ctx, src, dst)
+}
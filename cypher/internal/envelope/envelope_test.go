@@ -0,0 +1,46 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+func TestEnvelope(t *testing.T) {
+	fileKey, err := cypher.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, data := []byte("header with wrapped keys"), []byte("secret data")
+	var sealed bytes.Buffer
+	if _, err := Encrypt(context.Background(), &sealed, bytes.NewReader(data), "gocypher test", fileKey, header); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if !bytes.HasPrefix(sealed.Bytes(), header) {
+		t.Fatal("Expected the header first")
+	}
+	body := sealed.Bytes()[len(header):]
+
+	var decrypted bytes.Buffer
+	if _, err := Decrypt(context.Background(), &decrypted, bytes.NewReader(body), "gocypher test", fileKey, header); err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), data) {
+		t.Errorf("Expected %q, got %q", data, decrypted.Bytes())
+	}
+
+	for name, try := range map[string]struct {
+		label  string
+		header []byte
+	}{
+		"changed header": {"gocypher test", []byte("header with other keys!!")},
+		"other format":   {"gocypher other", header},
+	} {
+		if _, err := Decrypt(context.Background(), &bytes.Buffer{}, bytes.NewReader(body), try.label, fileKey, try.header); !errors.Is(err, ErrInvalidMAC) {
+			t.Errorf("%s: Expected ErrInvalidMAC, got %v", name, err)
+		}
+	}
+}
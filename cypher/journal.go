@@ -0,0 +1,293 @@
+package cypher
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// A journal is a single record, rewritten in place:
+//
+//	magic "GOCYJNL1" | input size | input modification time | header size |
+//	chunks | output size | offset of the last chunk | SHA-256 of the rest
+const (
+	journalMagic  = "GOCYJNL1"
+	journalSuffix = ".journal"
+	journalSize   = len(journalMagic) + 6*8 + sha256.Size
+)
+
+// journalInterval is how often journaled encryptions sync their output and
+// record how far they got
+var journalInterval = time.Second
+
+// WithJournal makes EncryptFile and the other file encryptions keep a journal
+// of the chunks they wrote next to the output, with ".journal" appended. The
+// output is synced before every record, once a second. An interrupted
+// encryption of the unchanged input to the same output then resumes after
+// the last chunk recorded, once it checks that chunk opens, instead of
+// starting over; the journal is removed once an encryption completes.
+// Journaled encryptions store the holes of sparse files as data. Content
+// defined chunking, delta friendly output and stages after CryptoStage
+// aren't journaled.
+func (c *Cypher) WithJournal() *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.journal = true
+	return c
+}
+
+// journaled reports whether c keeps a journal of file encryptions
+func (c Cypher) journaled() bool {
+	_, after := splitStages(c.encryptStages, true)
+	return c.journal && c.chunking == nil && !c.deltaFriendly && len(after) == 0
+}
+
+// journalState is the content of a journal
+type journalState struct {
+	inputSize    int64
+	inputModTime int64
+	headerSize   int64
+	chunks       int64
+	// offset is the size of the output, lastFrame where its last chunk
+	// starts
+	offset    int64
+	lastFrame int64
+}
+
+func (s journalState) encode() []byte {
+	buf := []byte(journalMagic)
+	for _, v := range []int64{s.inputSize, s.inputModTime, s.headerSize, s.chunks, s.offset, s.lastFrame} {
+		buf = binary.BigEndian.AppendUint64(buf, uint64(v))
+	}
+	sum := sha256.Sum256(buf)
+	return append(buf, sum[:]...)
+}
+
+func decodeJournal(buf []byte) (journalState, error) {
+	if len(buf) != journalSize || string(buf[:len(journalMagic)]) != journalMagic {
+		return journalState{}, errors.New("not a journal")
+	}
+	body := buf[:len(buf)-sha256.Size]
+	if sum := sha256.Sum256(body); !bytes.Equal(sum[:], buf[len(body):]) {
+		return journalState{}, errors.New("torn journal")
+	}
+	fields := make([]int64, 6)
+	for i := range fields {
+		fields[i] = int64(binary.BigEndian.Uint64(body[len(journalMagic)+8*i:]))
+	}
+	return journalState{
+		inputSize:    fields[0],
+		inputModTime: fields[1],
+		headerSize:   fields[2],
+		chunks:       fields[3],
+		offset:       fields[4],
+		lastFrame:    fields[5],
+	}, nil
+}
+
+// fileJournal records the progress of the encryption to output in path
+type fileJournal struct {
+	path   string
+	file   *os.File
+	output *os.File
+	state  journalState
+	// resumed is the number of input bytes an earlier run encrypted
+	resumed  int64
+	lastSync time.Time
+}
+
+// startJournal opens the output of op and sets op up to resume an encryption
+// of input interrupted after the chunks its journal records, or to start one
+// over, recording its progress
+func (c Cypher) startJournal(ctx context.Context, op *operation, input *os.File, outputPath string) (*fileJournal, *os.File, error) {
+	info, err := input.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to stat input file: %w", err)
+	}
+	j := &fileJournal{path: outputPath + journalSuffix, lastSync: time.Now()}
+	fresh := journalState{inputSize: info.Size(), inputModTime: info.ModTime().UnixNano()}
+
+	state, h, err := c.resumePoint(j.path, outputPath, fresh)
+	if err != nil {
+		c.log().Debug("starting journaled encryption over", "output", outputPath, "reason", err)
+		if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, fmt.Errorf("failed to remove journal: %w", err)
+		}
+		if j.output, err = c.createOutput(ctx, outputPath); err != nil {
+			return nil, nil, err
+		}
+		j.state = fresh
+		prepare := op.prepare
+		op.prepare = func(op *operation, src io.Reader, dst io.Writer) (io.Reader, error) {
+			frames, err := prepare(op, src, dst)
+			if err != nil {
+				return nil, err
+			}
+			// The header is all the output holds so far
+			if j.state.headerSize, err = j.output.Seek(0, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+			j.state.offset = j.state.headerSize
+			return frames, nil
+		}
+	} else {
+		c.log().Info("resuming journaled encryption", "output", outputPath, "chunks", state.chunks)
+		if j.output, err = c.openOutput(ctx, outputPath, false); err != nil {
+			return nil, nil, err
+		}
+		// The last chunk may have been short
+		j.state, j.resumed = state, min(state.chunks*int64(c.ChunkSize), state.inputSize)
+		if err := j.output.Truncate(state.offset); err != nil {
+			j.output.Close()
+			return nil, nil, fmt.Errorf("failed to truncate output file: %w", err)
+		}
+		if _, err := j.output.Seek(state.offset, io.SeekStart); err != nil {
+			j.output.Close()
+			return nil, nil, fmt.Errorf("failed to seek output: %w", err)
+		}
+		if _, err := input.Seek(j.resumed, io.SeekStart); err != nil {
+			j.output.Close()
+			return nil, nil, fmt.Errorf("failed to seek input: %w", err)
+		}
+		op.firstPosition, op.firstOffset = int(state.chunks), state.offset-state.headerSize
+		op.prepare = func(op *operation, src io.Reader, dst io.Writer) (io.Reader, error) {
+			gcm, err := c.fileGCM(h)
+			if err != nil {
+				return nil, err
+			}
+			return src, c.setupEncrypt(op, h, gcm)
+		}
+	}
+	// Offsets in the input follow the chunks only without holes
+	op.sparse = false
+	op.committed = j.commit
+	return j, j.output, nil
+}
+
+// resumePoint returns the state of the journal at path, and the header of
+// the output it describes, when the encryption it records can be resumed
+func (c Cypher) resumePoint(path, outputPath string, fresh journalState) (journalState, *header, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return journalState{}, nil, err
+	}
+	state, err := decodeJournal(buf)
+	if err != nil {
+		return journalState{}, nil, err
+	}
+	if state.inputSize != fresh.inputSize || state.inputModTime != fresh.inputModTime {
+		return journalState{}, nil, errors.New("input changed")
+	}
+
+	output, err := os.Open(outputPath)
+	if err != nil {
+		return journalState{}, nil, err
+	}
+	defer output.Close()
+	magic := make([]byte, len(FormatMagic))
+	if _, err := io.ReadFull(output, magic); err != nil || string(magic) != FormatMagic {
+		return journalState{}, nil, errors.New("output has no header")
+	}
+	h, err := readHeader(output, c.parseMode)
+	if err != nil {
+		return journalState{}, nil, err
+	}
+	gcm, err := c.fileGCM(h)
+	if err != nil {
+		return journalState{}, nil, err
+	}
+	if int64(len(h.raw)) != state.headerSize || h.chunkSize != uint32(c.ChunkSize) || h.compression != c.compression ||
+		h.algorithm != c.encryptAlgorithm() || h.generation != c.generation || h.expiry != c.expiryUnix() ||
+		(h.counter != nil) != (c.nonceCounter != nil) || h.deterministic || !h.trailer || len(h.holes) > 0 {
+		return journalState{}, nil, errors.New("output written with other settings")
+	}
+	if info, err := output.Stat(); err != nil || info.Size() < state.offset {
+		return journalState{}, nil, errors.New("output shorter than journaled")
+	}
+	if state.chunks == 0 {
+		return state, h, nil
+	}
+
+	// The last chunk recorded has to open, the earlier ones were synced
+	// along with it
+	frame := make([]byte, state.offset-state.lastFrame)
+	if len(frame) <= FrameLengthSize+gcm.NonceSize() {
+		return journalState{}, nil, errors.New("bad last chunk")
+	}
+	if _, err := output.ReadAt(frame, state.lastFrame); err != nil {
+		return journalState{}, nil, err
+	}
+	if int(binary.BigEndian.Uint32(frame)) != len(frame)-FrameLengthSize {
+		return journalState{}, nil, errors.New("bad last chunk")
+	}
+	aad := binary.BigEndian.AppendUint64(headerAAD(h), uint64(state.chunks-1))
+	nonce, sealed := frame[FrameLengthSize:FrameLengthSize+gcm.NonceSize()], frame[FrameLengthSize+gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		return journalState{}, nil, errors.New("last chunk doesn't open")
+	}
+	wipe(plain)
+	return state, h, nil
+}
+
+// commit records a chunk of size bytes written, and the journal once every
+// journalInterval
+func (j *fileJournal) commit(size int) error {
+	j.state.chunks++
+	j.state.lastFrame = j.state.offset
+	j.state.offset += int64(size)
+	if time.Since(j.lastSync) < journalInterval {
+		return nil
+	}
+	j.lastSync = time.Now()
+	return j.checkpoint()
+}
+
+// checkpoint syncs the output, then records how far it got
+func (j *fileJournal) checkpoint() error {
+	if err := j.output.Sync(); err != nil {
+		return fmt.Errorf("failed to sync output file: %w", err)
+	}
+	if j.file == nil {
+		file, err := os.OpenFile(j.path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create journal: %w", err)
+		}
+		j.file = file
+	}
+	if _, err := j.file.WriteAt(j.state.encode(), 0); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	return nil
+}
+
+// finish removes the journal once the encryption succeeded. After err, it
+// records the chunks written before it, so the next run resumes from them.
+func (j *fileJournal) finish(err error) error {
+	if err != nil {
+		if j.state.headerSize > 0 {
+			j.checkpoint()
+		}
+		if j.file != nil {
+			j.file.Close()
+		}
+		return err
+	}
+	if j.file != nil {
+		j.file.Close()
+	}
+	if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+// Package jwk imports and exports keys as JSON Web Keys (RFC 7517), so key
+// material can be exchanged with web services and KMS products speaking JWK.
+//
+// Symmetric keys, such as the raw keys of cypher.NewCypherFromKey, are "oct"
+// keys. The recipient keys of the sshkeys package are Ed25519 ("OKP") and
+// RSA keys, and X25519 keys, such as saltpack keys, are "OKP" keys too.
+package jwk
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	// ErrUnsupportedKey is returned for key types and curves that can't be
+	// imported or exported
+	ErrUnsupportedKey = errors.New("unsupported JWK key")
+	// ErrInvalidKey is returned for JWKs with missing or malformed members
+	ErrInvalidKey = errors.New("invalid JWK")
+)
+
+// jwk holds the members of the supported key types
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	K   string `json:"k,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	D   string `json:"d,omitempty"`
+	P   string `json:"p,omitempty"`
+	Q   string `json:"q,omitempty"`
+	DP  string `json:"dp,omitempty"`
+	DQ  string `json:"dq,omitempty"`
+	QI  string `json:"qi,omitempty"`
+}
+
+var encoding = base64.RawURLEncoding
+
+func encodeInt(n *big.Int) string {
+	return encoding.EncodeToString(n.Bytes())
+}
+
+// ExportJWK returns the JWK of key: a []byte symmetric key, an
+// ed25519.PublicKey or ed25519.PrivateKey, an *rsa.PublicKey or
+// *rsa.PrivateKey, or an X25519 *ecdh.PublicKey or *ecdh.PrivateKey.
+// Asymmetric keys get their RFC 7638 thumbprint as key id.
+func ExportJWK(key any) ([]byte, error) {
+	var k jwk
+	switch key := key.(type) {
+	case []byte:
+		k = jwk{Kty: "oct", K: encoding.EncodeToString(key)}
+		if len(key) == 32 {
+			k.Alg = "A256GCM"
+		}
+	case ed25519.PublicKey:
+		k = jwk{Kty: "OKP", Crv: "Ed25519", X: encoding.EncodeToString(key)}
+	case ed25519.PrivateKey:
+		k = jwk{Kty: "OKP", Crv: "Ed25519", X: encoding.EncodeToString(key.Public().(ed25519.PublicKey)), D: encoding.EncodeToString(key.Seed())}
+	case *rsa.PublicKey:
+		k = jwk{Kty: "RSA", Alg: "RSA-OAEP-256", N: encodeInt(key.N), E: encodeInt(big.NewInt(int64(key.E)))}
+	case *rsa.PrivateKey:
+		if len(key.Primes) != 2 {
+			return nil, fmt.Errorf("%w: RSA keys with %d primes", ErrUnsupportedKey, len(key.Primes))
+		}
+		key.Precompute()
+		k = jwk{
+			Kty: "RSA", Alg: "RSA-OAEP-256", N: encodeInt(key.N), E: encodeInt(big.NewInt(int64(key.E))),
+			D: encodeInt(key.D), P: encodeInt(key.Primes[0]), Q: encodeInt(key.Primes[1]),
+			DP: encodeInt(key.Precomputed.Dp), DQ: encodeInt(key.Precomputed.Dq), QI: encodeInt(key.Precomputed.Qinv),
+		}
+	case *ecdh.PublicKey:
+		if key.Curve() != ecdh.X25519() {
+			return nil, fmt.Errorf("%w: ECDH curve %v", ErrUnsupportedKey, key.Curve())
+		}
+		k = jwk{Kty: "OKP", Crv: "X25519", Alg: "ECDH-ES", X: encoding.EncodeToString(key.Bytes())}
+	case *ecdh.PrivateKey:
+		if key.Curve() != ecdh.X25519() {
+			return nil, fmt.Errorf("%w: ECDH curve %v", ErrUnsupportedKey, key.Curve())
+		}
+		k = jwk{Kty: "OKP", Crv: "X25519", Alg: "ECDH-ES", X: encoding.EncodeToString(key.PublicKey().Bytes()), D: encoding.EncodeToString(key.Bytes())}
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+	if k.Alg != "" {
+		k.Use = "enc"
+	}
+	if k.Kty != "oct" {
+		k.Kid = thumbprint(k)
+	}
+	return json.Marshal(k)
+}
+
+// thumbprint returns the RFC 7638 thumbprint of the public members of k
+func thumbprint(k jwk) string {
+	var members string
+	switch k.Kty {
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, k.Crv, k.X)
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return encoding.EncodeToString(sum[:])
+}
+
+// ImportJWK parses a JWK and returns its key, of one of the types exported
+// by ExportJWK: a []byte for "oct" keys, ed25519 keys, *rsa keys and X25519
+// *ecdh keys. Private keys are returned when the JWK holds one.
+func ImportJWK(data []byte) (any, error) {
+	var k jwk
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+	switch k.Kty {
+	case "oct":
+		key, err := decode("k", k.K)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	case "OKP":
+		return importOKP(k)
+	case "RSA":
+		return importRSA(k)
+	}
+	return nil, fmt.Errorf("%w: key type %q", ErrUnsupportedKey, k.Kty)
+}
+
+// decode decodes the required member name
+func decode(name, value string) ([]byte, error) {
+	if value == "" {
+		return nil, fmt.Errorf("%w: missing %q", ErrInvalidKey, name)
+	}
+	b, err := encoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", ErrInvalidKey, name, err)
+	}
+	return b, nil
+}
+
+func importOKP(k jwk) (any, error) {
+	x, err := decode("x", k.X)
+	if err != nil {
+		return nil, err
+	}
+	var d []byte
+	if k.D != "" {
+		if d, err = decode("d", k.D); err != nil {
+			return nil, err
+		}
+	}
+	switch k.Crv {
+	case "Ed25519":
+		if len(x) != ed25519.PublicKeySize || (d != nil && len(d) != ed25519.SeedSize) {
+			return nil, fmt.Errorf("%w: Ed25519 key size", ErrInvalidKey)
+		}
+		if d == nil {
+			return ed25519.PublicKey(x), nil
+		}
+		key := ed25519.NewKeyFromSeed(d)
+		if !key.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(x)) {
+			return nil, fmt.Errorf("%w: \"x\" doesn't match \"d\"", ErrInvalidKey)
+		}
+		return key, nil
+	case "X25519":
+		if d == nil {
+			key, err := ecdh.X25519().NewPublicKey(x)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+			}
+			return key, nil
+		}
+		key, err := ecdh.X25519().NewPrivateKey(d)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+		}
+		if string(key.PublicKey().Bytes()) != string(x) {
+			return nil, fmt.Errorf("%w: \"x\" doesn't match \"d\"", ErrInvalidKey)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: curve %q", ErrUnsupportedKey, k.Crv)
+}
+
+func importRSA(k jwk) (any, error) {
+	members := map[string]string{"n": k.N, "e": k.E, "d": k.D, "p": k.P, "q": k.Q}
+	names := []string{"n", "e"}
+	if k.D != "" {
+		names = append(names, "d", "p", "q")
+	}
+	values := map[string]*big.Int{}
+	for _, name := range names {
+		b, err := decode(name, members[name])
+		if err != nil {
+			return nil, err
+		}
+		values[name] = new(big.Int).SetBytes(b)
+	}
+	if !values["e"].IsInt64() || values["e"].Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("%w: RSA exponent", ErrInvalidKey)
+	}
+	public := rsa.PublicKey{N: values["n"], E: int(values["e"].Int64())}
+	if k.D == "" {
+		return &public, nil
+	}
+	key := &rsa.PrivateKey{PublicKey: public, D: values["d"], Primes: []*big.Int{values["p"], values["q"]}}
+	if err := key.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+	key.Precompute()
+	return key, nil
+}
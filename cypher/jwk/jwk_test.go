@@ -0,0 +1,105 @@
+package jwk
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+	"github.com/nikola43/gocypher/cypher/sshkeys"
+)
+
+func roundTrip(t *testing.T, key any) any {
+	t.Helper()
+	data, err := ExportJWK(key)
+	if err != nil {
+		t.Fatalf("ExportJWK failed: %v", err)
+	}
+	imported, err := ImportJWK(data)
+	if err != nil {
+		t.Fatalf("ImportJWK failed: %v", err)
+	}
+	return imported
+}
+
+func TestSymmetricKey(t *testing.T) {
+	key, _ := cypher.GenerateKey()
+	data, _ := ExportJWK(key)
+	var members map[string]string
+	json.Unmarshal(data, &members)
+	if members["kty"] != "oct" || members["alg"] != "A256GCM" {
+		t.Errorf("Unexpected JWK %s", data)
+	}
+	if imported := roundTrip(t, key); !bytes.Equal(imported.([]byte), key) {
+		t.Error("Expected the key back")
+	}
+}
+
+// The Ed25519 key of RFC 8037 appendix A
+func TestEd25519Key(t *testing.T) {
+	key, err := ImportJWK([]byte(`{"kty":"OKP","crv":"Ed25519","d":"nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`))
+	if err != nil {
+		t.Fatalf("ImportJWK failed: %v", err)
+	}
+	data, _ := ExportJWK(key.(ed25519.PrivateKey).Public())
+	var members map[string]string
+	json.Unmarshal(data, &members)
+	if members["kid"] != "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k" {
+		t.Errorf("Unexpected thumbprint %s", members["kid"])
+	}
+
+	_, err = ImportJWK([]byte(`{"kty":"OKP","crv":"Ed25519","d":"nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A","x":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}`))
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+	if _, err := ImportJWK([]byte(`{"kty":"EC","crv":"P-256"}`)); !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("Expected ErrUnsupportedKey, got %v", err)
+	}
+}
+
+func TestRecipientKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	var recipients []*sshkeys.Recipient
+	var identities []*sshkeys.Identity
+	for _, key := range []crypto.Signer{rsaKey, edKey} {
+		public, _ := ExportJWK(key.Public())
+		imported, err := ImportJWK(public)
+		if err != nil {
+			t.Fatalf("ImportJWK failed: %v", err)
+		}
+		recipient, err := sshkeys.NewRecipient(imported)
+		if err != nil {
+			t.Fatalf("NewRecipient failed: %v", err)
+		}
+		identity, err := sshkeys.NewIdentity(roundTrip(t, key))
+		if err != nil {
+			t.Fatalf("NewIdentity failed: %v", err)
+		}
+		recipients = append(recipients, recipient)
+		identities = append(identities, identity)
+	}
+
+	var sealed bytes.Buffer
+	if _, err := sshkeys.Encrypt(context.Background(), &sealed, bytes.NewReader([]byte("archive")), recipients); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	for _, identity := range identities {
+		var plain bytes.Buffer
+		if _, err := sshkeys.Decrypt(context.Background(), &plain, bytes.NewReader(sealed.Bytes()), []*sshkeys.Identity{identity}); err != nil || plain.String() != "archive" {
+			t.Errorf("Expected the archive, got %q: %v", plain.String(), err)
+		}
+	}
+
+	x25519, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	if imported := roundTrip(t, x25519); !imported.(*ecdh.PrivateKey).Equal(x25519) {
+		t.Error("Expected the X25519 key back")
+	}
+}
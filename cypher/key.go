@@ -0,0 +1,114 @@
+package cypher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+// ErrClosed is returned by operations on a Cypher whose key was wiped by Close
+var ErrClosed = errors.New("cypher is closed")
+
+// keyMaterial holds the key shared by a Cypher and all its copies, so Close
+// wipes it everywhere
+type keyMaterial struct {
+	mu     sync.RWMutex
+	key    []byte
+	closed bool
+	// err is returned by use once the key is closed
+	err         error
+	locked      bool
+	fingerprint string
+	// fetch acquires the key on first use when it isn't known up front, see
+	// NewCypherWithKeyFunc. wantLock makes the fetched key locked in RAM.
+	fetch    KeyFunc
+	wantLock bool
+}
+
+func newKeyMaterial(key []byte) *keyMaterial {
+	return &keyMaterial{key: key, fingerprint: keyFingerprint(key)}
+}
+
+func keyFingerprint(key []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte("gocypher fingerprint\x00"))
+	hash.Write(key)
+	return hex.EncodeToString(hash.Sum(nil)[:8])
+}
+
+// getFingerprint returns the fingerprint of the key, empty while a lazy key
+// hasn't been acquired
+func (k *keyMaterial) getFingerprint() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.fingerprint
+}
+
+// use calls fn with the key while holding it open; fn must not retain it
+func (k *keyMaterial) use(fn func(key []byte) error) error {
+	if err := k.acquire(context.Background()); err != nil {
+		return err
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.closed {
+		return k.err
+	}
+	return fn(k.key)
+}
+
+func (k *keyMaterial) wipe() {
+	k.close(ErrClosed)
+}
+
+// close wipes the key and makes later uses fail with err, unless it was
+// already closed
+func (k *keyMaterial) close(err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.closed {
+		return
+	}
+
+	wipe(k.key)
+	if k.locked {
+		unlockMemory(k.key)
+		k.locked = false
+	}
+	k.key = nil
+	k.closed = true
+	k.err = err
+}
+
+// wipe overwrites b with zeros
+func wipe(b []byte) {
+	clear(b)
+}
+
+// Close wipes the key held in memory. Operations started afterwards, on c or
+// on any copy of it, fail with ErrClosed.
+func (c *Cypher) Close() error {
+	if c.key != nil {
+		c.key.wipe()
+	}
+	return nil
+}
+
+// Err returns the error operations on c fail with before they start: ErrClosed
+// after Close, the reason the key was rejected, or ErrConfiguredAfterUse. It
+// returns nil when c is usable. It doesn't acquire a lazy key.
+func (c Cypher) Err() error {
+	if c.key == nil {
+		return ErrClosed
+	}
+	c.key.mu.RLock()
+	defer c.key.mu.RUnlock()
+	if c.key.closed {
+		return c.key.err
+	}
+	return c.checkConfigured()
+}
@@ -0,0 +1,87 @@
+package cypher
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// MinKeyfileSize is the smallest keyfile accepted by NewCypherWithKeyfile
+const MinKeyfileSize = 32
+
+var (
+	// ErrPassphraseRequired is returned when a keyfile is used without a
+	// passphrase
+	ErrPassphraseRequired = errors.New("passphrase is required")
+	// ErrKeyfileTooSmall is returned for keyfiles shorter than MinKeyfileSize
+	ErrKeyfileTooSmall = fmt.Errorf("keyfile must be at least %d bytes", MinKeyfileSize)
+)
+
+// NewCypherWithKeyfile derives the key from both a passphrase and the
+// contents of a keyfile, so decrypting takes something you know and
+// something you have. Any file of at least MinKeyfileSize bytes can be used;
+// GenerateKeyfile creates a random one.
+func NewCypherWithKeyfile(passphrase, keyfilePath string, opts ...Option) (*Cypher, error) {
+	file, err := os.Open(keyfilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open keyfile: %w", err)
+	}
+	defer file.Close()
+	return NewCypherWithKeyfileReader(passphrase, file, opts...)
+}
+
+// NewCypherWithKeyfileReader is like NewCypherWithKeyfile but reads the
+// keyfile from r
+func NewCypherWithKeyfileReader(passphrase string, r io.Reader, opts ...Option) (*Cypher, error) {
+	if passphrase == "" {
+		return nil, ErrPassphraseRequired
+	}
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyfile: %w", err)
+	}
+	if n < MinKeyfileSize {
+		return nil, ErrKeyfileTooSmall
+	}
+
+	secret := append([]byte(passphrase), hash.Sum(nil)...)
+	defer wipe(secret)
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte("gocypher v1 passphrase and keyfile")), key); err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	strength := EstimatePassphraseStrength(passphrase)
+	c := newCypher(key, &strength, opts...)
+	if err := c.Validate(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// GenerateKeyfile writes a new random keyfile to path, which must not exist
+func GenerateKeyfile(path string) error {
+	data := make([]byte, 2*MinKeyfileSize)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		return fmt.Errorf("failed to generate keyfile: %w", err)
+	}
+	defer wipe(data)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create keyfile: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write keyfile: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,101 @@
+package cypher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNoKeyFunc is returned by RefreshKey for Cyphers created with a key
+var ErrNoKeyFunc = errors.New("cypher has no key function")
+
+// KeyFunc returns a KeySize bytes key, for example by prompting the user or
+// asking a KMS or agent. The Cypher takes ownership of the returned slice and
+// wipes it on Close.
+type KeyFunc func(ctx context.Context) ([]byte, error)
+
+// NewCypherWithKeyFunc returns a Cypher that calls fetch for its key when
+// the first operation starts, with the context of that operation. Until
+// then the secret doesn't have to exist. Failed fetches are retried by the
+// next operation; RefreshKey fetches the key again after a rotation.
+func NewCypherWithKeyFunc(fetch KeyFunc, opts ...Option) (*Cypher, error) {
+	if fetch == nil {
+		return nil, ErrNoKeyFunc
+	}
+	c := newCypher(nil, nil, opts...)
+	c.key.fingerprint = ""
+	c.key.fetch = fetch
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// RefreshKey replaces the key of a Cypher created with NewCypherWithKeyFunc
+// by calling its KeyFunc again, such as after the key was rotated. Data
+// encrypted with the previous key can't be decrypted afterwards. Operations
+// already running keep the key they started with.
+func (c Cypher) RefreshKey(ctx context.Context) error {
+	if c.key == nil {
+		return ErrClosed
+	}
+	return c.key.refresh(ctx)
+}
+
+// acquire fetches the key if it is lazy and not known yet
+func (k *keyMaterial) acquire(ctx context.Context) error {
+	k.mu.RLock()
+	ready := k.fetch == nil || k.key != nil || k.closed
+	k.mu.RUnlock()
+	if ready {
+		return nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.key != nil || k.closed {
+		return nil
+	}
+	return k.fetchLocked(ctx)
+}
+
+func (k *keyMaterial) refresh(ctx context.Context) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.closed {
+		return k.err
+	}
+	if k.fetch == nil {
+		return ErrNoKeyFunc
+	}
+	return k.fetchLocked(ctx)
+}
+
+// fetchLocked calls the KeyFunc and replaces the key, k.mu must be held
+func (k *keyMaterial) fetchLocked(ctx context.Context) error {
+	key, err := k.fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire key: %w", err)
+	}
+	if len(key) != KeySize {
+		wipe(key)
+		return ErrInvalidKeySize
+	}
+
+	if k.key != nil {
+		wipe(k.key)
+		if k.locked {
+			unlockMemory(k.key)
+			k.locked = false
+		}
+	}
+	k.key, k.fingerprint = key, keyFingerprint(key)
+	if k.wantLock {
+		// Best effort like WithLockedMemory
+		if err := lockMemory(k.key); err == nil {
+			k.locked = true
+		}
+	}
+	return nil
+}
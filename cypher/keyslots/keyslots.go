@@ -0,0 +1,318 @@
+// Package keyslots encrypts data that several independent keys unlock, such
+// as a KMS key for automated systems and a passphrase for humans to recover
+// it, like the key slots of LUKS.
+//
+// A random file key encrypts the data in the gocypher format and is wrapped
+// once per slot in a header: with a key derived from a passphrase with
+// Argon2id, with a raw key or by a KMS. Any one slot decrypts the data. The
+// header is authenticated with the file key, so slots can't be added or
+// removed without the data being rejected.
+package keyslots
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nikola43/gocypher/cypher"
+	"github.com/nikola43/gocypher/cypher/internal/envelope"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	// ErrInvalidHeader is returned for data without a valid key slots
+	// header
+	ErrInvalidHeader = errors.New("invalid key slots header")
+	// ErrNoSlot is returned when the slot given doesn't unlock the data
+	ErrNoSlot = errors.New("no key slot unlocks the data")
+)
+
+const magic = "GOCYKSL1"
+
+// envelopeLabel derives the keys of the header MAC and the data, see envelope
+const envelopeLabel = "gocypher keyslots"
+
+// SlotType is the kind of a key slot
+type SlotType byte
+
+const (
+	SlotPassphrase SlotType = 1
+	SlotKey        SlotType = 2
+	SlotKMS        SlotType = 3
+)
+
+func (t SlotType) String() string {
+	switch t {
+	case SlotPassphrase:
+		return "passphrase"
+	case SlotKey:
+		return "key"
+	case SlotKMS:
+		return "kms"
+	}
+	return fmt.Sprintf("slot(%d)", byte(t))
+}
+
+// Slot wraps the file key of the data when encrypting and unwraps it when
+// decrypting, see Passphrase, Key and KMS
+type Slot interface {
+	slotType() SlotType
+	wrap(ctx context.Context, fileKey []byte) ([]byte, error)
+	unwrap(ctx context.Context, body []byte) ([]byte, error)
+}
+
+// Encrypt encrypts src into dst so that any of slots decrypts it. opts
+// configure the Cypher encrypting the data.
+func Encrypt(ctx context.Context, dst io.Writer, src io.Reader, slots []Slot, opts ...cypher.Option) (*cypher.Stats, error) {
+	if len(slots) == 0 || len(slots) > 255 {
+		return nil, fmt.Errorf("expected 1 to 255 slots, got %d", len(slots))
+	}
+	fileKey, err := cypher.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	header := bytes.NewBufferString(magic)
+	header.WriteByte(byte(len(slots)))
+	for _, slot := range slots {
+		body, err := slot.wrap(ctx, fileKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap the file key in a %s slot: %w", slot.slotType(), err)
+		}
+		header.WriteByte(byte(slot.slotType()))
+		header.Write(binary.BigEndian.AppendUint16(nil, uint16(len(body))))
+		header.Write(body)
+	}
+	return envelope.Encrypt(ctx, dst, src, envelopeLabel, fileKey, header.Bytes(), opts...)
+}
+
+// Decrypt decrypts src, encrypted by Encrypt, into dst with slot, trying it
+// on every slot of its type
+func Decrypt(ctx context.Context, dst io.Writer, src io.Reader, slot Slot, opts ...cypher.Option) (*cypher.Stats, error) {
+	var header bytes.Buffer
+	stanzas, err := readSlots(io.TeeReader(src, &header))
+	if err != nil {
+		return nil, err
+	}
+
+	var fileKey []byte
+	var unwrapErr error
+	for _, stanza := range stanzas {
+		if stanza.typ != slot.slotType() {
+			continue
+		}
+		if fileKey, unwrapErr = slot.unwrap(ctx, stanza.body); unwrapErr == nil && len(fileKey) == cypher.KeySize {
+			break
+		}
+		fileKey = nil
+	}
+	if fileKey == nil {
+		// KMS errors, such as a denied request, are worth telling apart
+		if unwrapErr != nil && slot.slotType() == SlotKMS {
+			return nil, fmt.Errorf("%w: %w", ErrNoSlot, unwrapErr)
+		}
+		return nil, ErrNoSlot
+	}
+
+	stats, err := envelope.Decrypt(ctx, dst, src, envelopeLabel, fileKey, header.Bytes(), opts...)
+	if errors.Is(err, envelope.ErrInvalidMAC) {
+		return nil, ErrInvalidHeader
+	}
+	return stats, err
+}
+
+// List returns the types of the slots of src without unlocking any
+func List(src io.Reader) ([]SlotType, error) {
+	stanzas, err := readSlots(src)
+	if err != nil {
+		return nil, err
+	}
+	types := make([]SlotType, len(stanzas))
+	for i, stanza := range stanzas {
+		types[i] = stanza.typ
+	}
+	return types, nil
+}
+
+type stanza struct {
+	typ  SlotType
+	body []byte
+}
+
+// readSlots reads the slots of a header, leaving r at its MAC
+func readSlots(r io.Reader) ([]stanza, error) {
+	prefix := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(r, prefix); err != nil || string(prefix[:len(magic)]) != magic {
+		return nil, ErrInvalidHeader
+	}
+	stanzas := make([]stanza, prefix[len(magic)])
+	for i := range stanzas {
+		fixed := make([]byte, 3)
+		if _, err := io.ReadFull(r, fixed); err != nil {
+			return nil, ErrInvalidHeader
+		}
+		body := make([]byte, binary.BigEndian.Uint16(fixed[1:]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, ErrInvalidHeader
+		}
+		stanzas[i] = stanza{typ: SlotType(fixed[0]), body: body}
+	}
+	return stanzas, nil
+}
+
+// seal wraps fileKey with a key derived from secret and salt
+func seal(secret, salt []byte, info string, fileKey []byte) ([]byte, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	// Every wrapping key is used once, under a fresh salt
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil), nil
+}
+
+func open(secret, salt []byte, info string, sealed []byte) ([]byte, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), sealed, nil)
+}
+
+const saltSize = 16
+
+// Argon2id cost of passphrase slots: passes, memory in KiB and lanes
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+)
+
+type passphraseSlot struct {
+	passphrase []byte
+}
+
+// Passphrase returns a Slot of a passphrase, stretched with Argon2id. The
+// cost is recorded in the slot, so it can be raised later without breaking
+// older data.
+func Passphrase(passphrase string) Slot {
+	return passphraseSlot{passphrase: []byte(passphrase)}
+}
+
+func (passphraseSlot) slotType() SlotType { return SlotPassphrase }
+
+func (s passphraseSlot) wrap(_ context.Context, fileKey []byte) ([]byte, error) {
+	if len(s.passphrase) == 0 {
+		return nil, cypher.ErrEmptyKey
+	}
+	body := make([]byte, saltSize, saltSize+9+chacha20poly1305.Overhead+len(fileKey))
+	if _, err := io.ReadFull(rand.Reader, body); err != nil {
+		return nil, err
+	}
+	body = binary.BigEndian.AppendUint32(body, argonTime)
+	body = binary.BigEndian.AppendUint32(body, argonMemory)
+	body = append(body, argonThreads)
+	secret := argon2.IDKey(s.passphrase, body[:saltSize], argonTime, argonMemory, argonThreads, 32)
+	sealed, err := seal(secret, body, "gocypher keyslots passphrase", fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(body, sealed...), nil
+}
+
+// maxArgonMemory bounds the cost read from a slot, in KiB
+const maxArgonMemory = 4 * 1024 * 1024
+
+func (s passphraseSlot) unwrap(_ context.Context, body []byte) ([]byte, error) {
+	if len(body) < saltSize+9 {
+		return nil, ErrInvalidHeader
+	}
+	params := body[:saltSize+9]
+	time, memory, threads := binary.BigEndian.Uint32(params[saltSize:]), binary.BigEndian.Uint32(params[saltSize+4:]), params[saltSize+8]
+	if time == 0 || time > 100 || memory > maxArgonMemory || threads == 0 {
+		return nil, fmt.Errorf("%w: Argon2id cost out of range", ErrInvalidHeader)
+	}
+	secret := argon2.IDKey(s.passphrase, params[:saltSize], time, memory, threads, 32)
+	return open(secret, params, "gocypher keyslots passphrase", body[len(params):])
+}
+
+type keySlot struct {
+	key []byte
+}
+
+// Key returns a Slot of a raw cypher.KeySize bytes key, such as one from
+// cypher.GenerateKey or a secret manager
+func Key(key []byte) Slot {
+	return keySlot{key: key}
+}
+
+func (keySlot) slotType() SlotType { return SlotKey }
+
+func (s keySlot) wrap(_ context.Context, fileKey []byte) ([]byte, error) {
+	if len(s.key) != cypher.KeySize {
+		return nil, cypher.ErrInvalidKeySize
+	}
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	sealed, err := seal(s.key, salt, "gocypher keyslots key", fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(salt, sealed...), nil
+}
+
+func (s keySlot) unwrap(_ context.Context, body []byte) ([]byte, error) {
+	if len(body) < saltSize {
+		return nil, ErrInvalidHeader
+	}
+	return open(s.key, body[:saltSize], "gocypher keyslots key", body[saltSize:])
+}
+
+// Wrapper wraps keys with a key it holds, such as a KMS or HSM key that
+// never leaves it
+type Wrapper interface {
+	WrapKey(ctx context.Context, key []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+type kmsSlot struct {
+	wrapper Wrapper
+}
+
+// KMS returns a Slot of a key wrapped by w
+func KMS(w Wrapper) Slot {
+	return kmsSlot{wrapper: w}
+}
+
+func (kmsSlot) slotType() SlotType { return SlotKMS }
+
+func (s kmsSlot) wrap(ctx context.Context, fileKey []byte) ([]byte, error) {
+	wrapped, err := s.wrapper.WrapKey(ctx, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) > 1<<16-1 {
+		return nil, fmt.Errorf("wrapped key of %d bytes", len(wrapped))
+	}
+	return wrapped, nil
+}
+
+func (s kmsSlot) unwrap(ctx context.Context, body []byte) ([]byte, error) {
+	return s.wrapper.UnwrapKey(ctx, body)
+}
@@ -0,0 +1,83 @@
+package keyslots
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+// fakeKMS wraps keys with a local key, refusing to unwrap when denied
+type fakeKMS struct {
+	key    []byte
+	denied bool
+}
+
+func (k *fakeKMS) WrapKey(_ context.Context, key []byte) ([]byte, error) {
+	return seal(k.key, nil, "fake kms", key)
+}
+
+func (k *fakeKMS) UnwrapKey(_ context.Context, wrapped []byte) ([]byte, error) {
+	if k.denied {
+		return nil, errors.New("access denied")
+	}
+	return open(k.key, nil, "fake kms", wrapped)
+}
+
+func TestKeySlots(t *testing.T) {
+	ctx := context.Background()
+	key, _ := cypher.GenerateKey()
+	kmsKey, _ := cypher.GenerateKey()
+	kms := &fakeKMS{key: kmsKey}
+
+	var encrypted bytes.Buffer
+	slots := []Slot{KMS(kms), Key(key), Passphrase("correct horse")}
+	if _, err := Encrypt(ctx, &encrypted, strings.NewReader("payroll"), slots); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	types, err := List(bytes.NewReader(encrypted.Bytes()))
+	if err != nil || !slices.Equal(types, []SlotType{SlotKMS, SlotKey, SlotPassphrase}) {
+		t.Errorf("Expected kms, key and passphrase slots, got %v: %v", types, err)
+	}
+
+	for _, slot := range slots {
+		var decrypted bytes.Buffer
+		if _, err := Decrypt(ctx, &decrypted, bytes.NewReader(encrypted.Bytes()), slot); err != nil {
+			t.Fatalf("Decrypt with %s slot failed: %v", slot.slotType(), err)
+		}
+		if decrypted.String() != "payroll" {
+			t.Errorf("Expected %q, got %q", "payroll", decrypted.String())
+		}
+	}
+
+	other, _ := cypher.GenerateKey()
+	for _, slot := range []Slot{Key(other), Passphrase("wrong")} {
+		if _, err := Decrypt(ctx, &bytes.Buffer{}, bytes.NewReader(encrypted.Bytes()), slot); !errors.Is(err, ErrNoSlot) {
+			t.Errorf("Expected ErrNoSlot for a wrong %s, got %v", slot.slotType(), err)
+		}
+	}
+	kms.denied = true
+	if _, err := Decrypt(ctx, &bytes.Buffer{}, bytes.NewReader(encrypted.Bytes()), KMS(kms)); !errors.Is(err, ErrNoSlot) || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("Expected ErrNoSlot with the KMS error, got %v", err)
+	}
+
+	// Dropping the passphrase slot breaks the MAC
+	var stripped bytes.Buffer
+	if _, err := Encrypt(ctx, &stripped, strings.NewReader("payroll"), []Slot{Key(key), Passphrase("correct horse")}); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	data := stripped.Bytes()
+	keyLen := 3 + int(binary.BigEndian.Uint16(data[len(magic)+2:]))
+	passLen := 3 + int(binary.BigEndian.Uint16(data[len(magic)+1+keyLen+1:]))
+	tampered := append([]byte(magic), 1)
+	tampered = append(tampered, data[len(magic)+1:len(magic)+1+keyLen]...)
+	tampered = append(tampered, data[len(magic)+1+keyLen+passLen:]...)
+	if _, err := Decrypt(ctx, &bytes.Buffer{}, bytes.NewReader(tampered), Key(key)); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Expected ErrInvalidHeader, got %v", err)
+	}
+}
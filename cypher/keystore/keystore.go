@@ -0,0 +1,239 @@
+// Package keystore keeps named keys and the Cyphers built from them.
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+var (
+	ErrNotFound = errors.New("key not found")
+	ErrExists   = errors.New("key already exists")
+	// ErrExpired is returned for keys used after their NotAfter time
+	ErrExpired = errors.New("key has expired")
+	// ErrNotYetValid is returned for keys used before their NotBefore time
+	ErrNotYetValid = errors.New("key is not yet valid")
+)
+
+// Entry is a named key in the store
+type Entry struct {
+	Name        string
+	Fingerprint string
+	// NotBefore and NotAfter bound when the key may be used, a zero time
+	// leaves that side unbounded
+	NotBefore time.Time
+	NotAfter  time.Time
+	cypher    *cypher.Cypher
+}
+
+// Valid reports whether the entry may be used at t
+func (e *Entry) Valid(t time.Time) error {
+	switch {
+	case !e.NotBefore.IsZero() && t.Before(e.NotBefore):
+		return fmt.Errorf("%w: %s until %s", ErrNotYetValid, e.Name, e.NotBefore.Format(time.RFC3339))
+	case !e.NotAfter.IsZero() && t.After(e.NotAfter):
+		return fmt.Errorf("%w: %s since %s", ErrExpired, e.Name, e.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// DecryptPolicy decides what happens when data is decrypted with a key outside
+// its validity window. Encryption always refuses such keys.
+type DecryptPolicy int
+
+const (
+	// DecryptWarn logs a warning and decrypts anyway, so old data stays
+	// readable after its key expired
+	DecryptWarn DecryptPolicy = iota
+	// DecryptDeny refuses to decrypt
+	DecryptDeny
+	// DecryptAllow decrypts silently
+	DecryptAllow
+)
+
+// Store is a set of named keys, safe for concurrent use
+type Store struct {
+	mu      sync.RWMutex
+	entries map[string]*Entry
+
+	decryptPolicy DecryptPolicy
+	logger        *slog.Logger
+	now           func() time.Time
+}
+
+// Option configures a Store
+type Option func(*Store)
+
+// WithDecryptPolicy sets how keys outside their validity window are handled
+// when decrypting (default: DecryptWarn)
+func WithDecryptPolicy(policy DecryptPolicy) Option {
+	return func(s *Store) { s.decryptPolicy = policy }
+}
+
+// WithLogger sets the logger that receives validity warnings
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Store) { s.logger = logger }
+}
+
+// WithClock sets the function returning the current time
+func WithClock(now func() time.Time) Option {
+	return func(s *Store) { s.now = now }
+}
+
+func New(opts ...Option) *Store {
+	s := &Store{
+		entries: make(map[string]*Entry),
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Add stores c under name
+func (s *Store) Add(name string, c *cypher.Cypher) (*Entry, error) {
+	if name == "" {
+		return nil, errors.New("key name is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[name]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrExists, name)
+	}
+	entry := &Entry{Name: name, Fingerprint: c.KeyFingerprint(), cypher: c}
+	s.entries[name] = entry
+	return entry, nil
+}
+
+// AddPassphrase derives a key from passphrase and stores it under name
+func (s *Store) AddPassphrase(name, passphrase string) (*Entry, error) {
+	return s.Add(name, cypher.NewCypher(passphrase))
+}
+
+// AddPassphraseKeyfile derives a key from both passphrase and the keyfile at
+// keyfilePath and stores it under name
+func (s *Store) AddPassphraseKeyfile(name, passphrase, keyfilePath string) (*Entry, error) {
+	c, err := cypher.NewCypherWithKeyfile(passphrase, keyfilePath)
+	if err != nil {
+		return nil, err
+	}
+	return s.Add(name, c)
+}
+
+// AddKey stores a raw key under name
+func (s *Store) AddKey(name string, key []byte) (*Entry, error) {
+	c, err := cypher.NewCypherFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.Add(name, c)
+}
+
+// SetValidity sets the window in which the key stored under name may be used
+func (s *Store) SetValidity(name string, notBefore, notAfter time.Time) error {
+	if !notBefore.IsZero() && !notAfter.IsZero() && notAfter.Before(notBefore) {
+		return errors.New("key validity ends before it starts")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	entry.NotBefore, entry.NotAfter = notBefore, notAfter
+	return nil
+}
+
+// ForEncrypt returns the Cypher of the key stored under name, refusing keys
+// outside their validity window
+func (s *Store) ForEncrypt(name string) (*cypher.Cypher, error) {
+	entry, err := s.entry(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := entry.Valid(s.now()); err != nil {
+		return nil, err
+	}
+	return entry.cypher, nil
+}
+
+// ForDecrypt returns the Cypher of the key stored under name, applying the
+// decrypt policy to keys outside their validity window
+func (s *Store) ForDecrypt(name string) (*cypher.Cypher, error) {
+	entry, err := s.entry(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := entry.Valid(s.now()); err != nil {
+		switch s.decryptPolicy {
+		case DecryptDeny:
+			return nil, err
+		case DecryptWarn:
+			s.logger.Warn("decrypting with a key outside its validity window", "key", name, "error", err)
+		}
+	}
+	return entry.cypher, nil
+}
+
+func (s *Store) entry(name string) (Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[name]
+	if !ok {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return *entry, nil
+}
+
+// Cypher returns the Cypher of the key stored under name without checking
+// its validity window; use ForEncrypt or ForDecrypt to enforce it
+func (s *Store) Cypher(name string) (*cypher.Cypher, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return entry.cypher, nil
+}
+
+// List returns the stored keys sorted by name
+func (s *Store) List() []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]Entry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+// Delete removes the key stored under name and wipes it from memory
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	delete(s.entries, name)
+	return entry.cypher.Close()
+}
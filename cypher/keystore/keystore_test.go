@@ -0,0 +1,108 @@
+package keystore
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+// addKey stores a random key under name, valid from notBefore to notAfter
+func addKey(t *testing.T, s *Store, name string, notBefore, notAfter time.Time) {
+	t.Helper()
+	key, err := cypher.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddKey(name, key); err != nil {
+		t.Fatalf("AddKey failed: %v", err)
+	}
+	if err := s.SetValidity(name, notBefore, notAfter); err != nil {
+		t.Fatalf("SetValidity failed: %v", err)
+	}
+}
+
+func TestValid(t *testing.T) {
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(24 * time.Hour)
+	entry := &Entry{Name: "backup", NotBefore: notBefore, NotAfter: notAfter}
+	for at, expected := range map[time.Time]error{
+		notBefore.Add(-time.Nanosecond): ErrNotYetValid,
+		notBefore:                       nil,
+		notAfter:                        nil,
+		notAfter.Add(time.Nanosecond):   ErrExpired,
+	} {
+		if err := entry.Valid(at); !errors.Is(err, expected) {
+			t.Errorf("Valid(%s): expected %v, got %v", at, expected, err)
+		}
+	}
+	if err := (&Entry{Name: "open"}).Valid(time.Time{}); err != nil {
+		t.Errorf("Expected a key without a window to be valid, got %v", err)
+	}
+
+	// The store checks the window with its clock
+	now := notBefore.Add(-time.Second)
+	s := New(WithClock(func() time.Time { return now }))
+	addKey(t, s, "backup", notBefore, notAfter)
+	if _, err := s.ForEncrypt("backup"); !errors.Is(err, ErrNotYetValid) {
+		t.Errorf("Expected ErrNotYetValid before the window, got %v", err)
+	}
+	now = notBefore
+	if _, err := s.ForEncrypt("backup"); err != nil {
+		t.Errorf("Expected the key to encrypt at the start of the window: %v", err)
+	}
+	now = notAfter.Add(time.Second)
+	if _, err := s.ForEncrypt("backup"); !errors.Is(err, ErrExpired) {
+		t.Errorf("Expected ErrExpired after the window, got %v", err)
+	}
+	if _, err := s.ForEncrypt("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDecryptPolicy(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, policy := range []DecryptPolicy{DecryptWarn, DecryptDeny, DecryptAllow} {
+		var logs bytes.Buffer
+		s := New(WithDecryptPolicy(policy), WithClock(func() time.Time { return now }),
+			WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+		addKey(t, s, "old", time.Time{}, now.Add(-time.Hour))
+
+		c, err := s.ForDecrypt("old")
+		switch policy {
+		case DecryptDeny:
+			if !errors.Is(err, ErrExpired) || c != nil {
+				t.Errorf("Expected DecryptDeny to refuse an expired key, got %v", err)
+			}
+		default:
+			if err != nil || c == nil {
+				t.Errorf("Expected policy %d to decrypt with an expired key, got %v", policy, err)
+			}
+		}
+		if warned := strings.Contains(logs.String(), "outside its validity window"); warned != (policy == DecryptWarn) {
+			t.Errorf("Policy %d: unexpected logs %q", policy, logs.String())
+		}
+		if _, err := s.ForEncrypt("old"); !errors.Is(err, ErrExpired) {
+			t.Errorf("Expected encryption to refuse an expired key with policy %d, got %v", policy, err)
+		}
+	}
+}
+
+func TestSetValidity(t *testing.T) {
+	s := New()
+	addKey(t, s, "backup", time.Time{}, time.Time{})
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := s.SetValidity("backup", start, start.Add(-time.Second)); err == nil {
+		t.Error("Expected an inverted window to be rejected")
+	}
+	if entries := s.List(); !entries[0].NotBefore.IsZero() || !entries[0].NotAfter.IsZero() {
+		t.Errorf("Expected a rejected window to leave the key unbounded, got %+v", entries[0])
+	}
+	if err := s.SetValidity("missing", time.Time{}, start); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
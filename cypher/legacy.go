@@ -0,0 +1,237 @@
+package cypher
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+var (
+	// ErrLegacyMAC is returned for legacy data whose HMAC doesn't match
+	ErrLegacyMAC = errors.New("legacy data failed authentication")
+	// ErrLegacyFormat is returned for legacy data that is too short or
+	// badly padded
+	ErrLegacyFormat = errors.New("invalid legacy data")
+)
+
+// LegacyMode is the cipher mode of legacy data
+type LegacyMode int
+
+const (
+	// LegacyCBC is AES-CBC with PKCS#7 padding
+	LegacyCBC LegacyMode = iota + 1
+	// LegacyCTR is AES-CTR
+	LegacyCTR
+)
+
+// LegacyFormat describes data written by older tools as IV || ciphertext ||
+// HMAC, authenticated encrypt-then-MAC. It can only be read, use
+// ReEncryptFile to migrate such data to the native format.
+type LegacyFormat struct {
+	Mode LegacyMode
+	// Key is the AES key, 16, 24 or 32 bytes
+	Key []byte
+	// MACKey is the HMAC key
+	MACKey []byte
+	// Hash is the HMAC hash (default: SHA-256)
+	Hash func() hash.Hash
+	// MACExcludesIV is set when the HMAC only covers the ciphertext
+	MACExcludesIV bool
+}
+
+// DecryptLegacy decrypts data in the legacy format after checking its HMAC
+func DecryptLegacy(format LegacyFormat, data []byte) ([]byte, error) {
+	src, err := format.reader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(src)
+}
+
+// ReEncryptFile decrypts inputPath, written in the legacy format, and
+// encrypts it into outputPath. The HMAC is checked before anything is
+// encrypted, and outputPath is removed when the migration fails.
+func (c Cypher) ReEncryptFile(ctx context.Context, inputPath, outputPath string, legacy LegacyFormat) (*Result, error) {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer inputFile.Close()
+	info, err := inputFile.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat input file: %w", err)
+	}
+	src, err := legacy.reader(inputFile, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outputFile.Close()
+
+	op := c.encryptOperation()
+	c.log().Debug("re-encrypting legacy file", "input", inputPath, "output", outputPath)
+	op.total = info.Size() - aes.BlockSize - int64(legacy.hash()().Size())
+	op.inputPath, op.outputPath = inputPath, outputPath
+	op.attributes = map[string]any{"gocypher.input": inputPath, "gocypher.output": outputPath}
+	stats, err := c.process(ctx, op, src, outputFile)
+	if err != nil {
+		outputFile.Close()
+		os.Remove(outputPath)
+		return nil, err
+	}
+	return &Result{OutputPath: outputPath, Stats: stats}, nil
+}
+
+func (f LegacyFormat) hash() func() hash.Hash {
+	if f.Hash == nil {
+		return sha256.New
+	}
+	return f.Hash
+}
+
+// newMAC returns the HMAC of data with iv
+func (f LegacyFormat) newMAC(iv []byte) hash.Hash {
+	mac := hmac.New(f.hash(), f.MACKey)
+	if !f.MACExcludesIV {
+		mac.Write(iv)
+	}
+	return mac
+}
+
+// reader checks the HMAC of the size bytes of r and returns a reader of
+// their plaintext. It checks the HMAC again as it reads, in case the data
+// changed meanwhile.
+func (f LegacyFormat) reader(r io.ReaderAt, size int64) (io.Reader, error) {
+	block, err := aes.NewCipher(f.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	if f.Mode != LegacyCBC && f.Mode != LegacyCTR {
+		return nil, fmt.Errorf("%w: unknown mode %d", ErrLegacyFormat, f.Mode)
+	}
+	tagSize := int64(f.hash()().Size())
+	if size < aes.BlockSize+tagSize {
+		return nil, ErrLegacyFormat
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	tag := make([]byte, tagSize)
+	if _, err := r.ReadAt(iv, 0); err != nil {
+		return nil, fmt.Errorf("failed to read legacy data: %w", err)
+	}
+	if _, err := r.ReadAt(tag, size-tagSize); err != nil {
+		return nil, fmt.Errorf("failed to read legacy data: %w", err)
+	}
+	ciphertext := func() io.Reader { return io.NewSectionReader(r, aes.BlockSize, size-aes.BlockSize-tagSize) }
+	mac := f.newMAC(iv)
+	if _, err := io.Copy(mac, ciphertext()); err != nil {
+		return nil, fmt.Errorf("failed to read legacy data: %w", err)
+	}
+	if !hmac.Equal(mac.Sum(nil), tag) {
+		return nil, ErrLegacyMAC
+	}
+
+	l := &legacyReader{r: ciphertext(), mac: f.newMAC(iv), tag: tag, buf: make([]byte, 64*1024)}
+	if f.Mode == LegacyCTR {
+		l.stream = cipher.NewCTR(block, iv)
+	} else {
+		l.mode = cipher.NewCBCDecrypter(block, iv)
+	}
+	return l, nil
+}
+
+// legacyReader decrypts legacy ciphertext, holding back the last CBC block
+// until its padding can be removed
+type legacyReader struct {
+	r      io.Reader
+	mac    hash.Hash
+	tag    []byte
+	stream cipher.Stream
+	mode   cipher.BlockMode
+
+	buf  []byte
+	out  []byte
+	held []byte
+	err  error
+}
+
+func (l *legacyReader) Read(p []byte) (int, error) {
+	for len(l.out) == 0 {
+		if l.err != nil {
+			return 0, l.err
+		}
+		l.fill()
+	}
+	n := copy(p, l.out)
+	l.out = l.out[n:]
+	return n, nil
+}
+
+// fill decrypts the next part of the ciphertext into out
+func (l *legacyReader) fill() {
+	n, err := io.ReadFull(l.r, l.buf)
+	chunk := l.buf[:n]
+	eof := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !eof {
+		l.err = fmt.Errorf("failed to read legacy data: %w", err)
+		return
+	}
+	l.mac.Write(chunk)
+	if eof && !hmac.Equal(l.mac.Sum(nil), l.tag) {
+		l.err = ErrLegacyMAC
+		return
+	}
+
+	if l.stream != nil {
+		l.stream.XORKeyStream(chunk, chunk)
+		l.out = chunk
+	} else {
+		if len(chunk)%aes.BlockSize != 0 {
+			l.err = ErrLegacyFormat
+			return
+		}
+		l.mode.CryptBlocks(chunk, chunk)
+		out := append(l.held, chunk...)
+		if !eof {
+			l.held = append([]byte(nil), out[len(out)-aes.BlockSize:]...)
+			l.out = out[:len(out)-aes.BlockSize]
+			return
+		}
+		if l.out, err = unpad(out); err != nil {
+			l.err = err
+			return
+		}
+	}
+	if eof {
+		l.err = io.EOF
+	}
+}
+
+// unpad removes the PKCS#7 padding of data
+func unpad(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrLegacyFormat
+	}
+	n := int(data[len(data)-1])
+	if n == 0 || n > aes.BlockSize || n > len(data) {
+		return nil, ErrLegacyFormat
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, ErrLegacyFormat
+		}
+	}
+	return data[:len(data)-n], nil
+}
@@ -0,0 +1,44 @@
+// Package logruscypher plugs the encrypted log writer of the logs package
+// into logrus.
+package logruscypher
+
+import (
+	"github.com/nikola43/gocypher/cypher/logs"
+	"github.com/sirupsen/logrus"
+)
+
+// Hook writes every entry of its levels to an encrypted log, one record per
+// entry
+type Hook struct {
+	w         *logs.Writer
+	formatter logrus.Formatter
+	levels    []logrus.Level
+}
+
+// NewHook returns a hook formatting entries with formatter (JSON when nil)
+// and writing them to w. It fires for levels, or all levels when none are
+// given.
+func NewHook(w *logs.Writer, formatter logrus.Formatter, levels ...logrus.Level) *Hook {
+	if formatter == nil {
+		formatter = &logrus.JSONFormatter{}
+	}
+	if len(levels) == 0 {
+		levels = logrus.AllLevels
+	}
+	return &Hook{w: w, formatter: formatter, levels: levels}
+}
+
+// Levels implements logrus.Hook
+func (h *Hook) Levels() []logrus.Level {
+	return h.levels
+}
+
+// Fire implements logrus.Hook
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = h.w.Write(line)
+	return err
+}
@@ -0,0 +1,35 @@
+package logruscypher
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+	"github.com/nikola43/gocypher/cypher/logs"
+	"github.com/sirupsen/logrus"
+)
+
+func TestHook(t *testing.T) {
+	c := cypher.NewCypher("test-key")
+	var file bytes.Buffer
+	w, err := logs.NewWriter(c, &file)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.AddHook(NewHook(w, nil, logrus.ErrorLevel))
+	logger.WithField("n", 1).Error("failed")
+	logger.Info("not hooked")
+
+	var plain bytes.Buffer
+	if err := logs.Decrypt(c, &file, &plain); err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(plain.String()), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"msg":"failed"`) {
+		t.Errorf("Unexpected records %q", lines)
+	}
+}
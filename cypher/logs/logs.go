@@ -0,0 +1,211 @@
+// Package logs writes application logs encrypted with a cypher.Cypher, one
+// sealed record per Write, so log files at rest don't leak their content.
+// Adapters plug the Writer into log/slog (NewSlogHandler), zap
+// (zapcypher) and logrus (logruscypher); Reader and Decrypt read the
+// records back, as does "gocypher logs cat".
+//
+// Every Writer starts a stream with a random id, so several processes or
+// restarts may append to the same file. Records are numbered within their
+// stream: removing, reordering or editing records is detected, removing
+// whole trailing records is not.
+package logs
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/nikola43/gocypher/cypher"
+	"golang.org/x/crypto/hkdf"
+)
+
+// streamMagic starts every stream
+const streamMagic = "GOCYLOG1"
+
+const (
+	streamIDSize = 16
+	// MaxRecordSize bounds the size of a single Write
+	MaxRecordSize = 16 << 20
+)
+
+var (
+	// ErrTampered is returned for records that fail to authenticate
+	ErrTampered = errors.New("encrypted log has been tampered with")
+	// ErrRecordTooLarge is returned for writes over MaxRecordSize
+	ErrRecordTooLarge = fmt.Errorf("log record exceeds %d bytes", MaxRecordSize)
+)
+
+// streamAEAD returns the AEAD sealing the records of stream id
+func streamAEAD(c *cypher.Cypher, id []byte) (cipher.AEAD, error) {
+	secret, err := c.DeriveSecret("encrypted logs")
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, id, []byte("gocypher v1 log stream")), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// recordNonce returns the nonce of record seq, unique within the stream key
+func recordNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+// Writer seals every Write into a record of w. It is safe for concurrent
+// use.
+type Writer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	aead cipher.AEAD
+	seq  uint64
+	err  error
+}
+
+// NewWriter starts a stream on w, usually a file opened for appending
+func NewWriter(c *cypher.Cypher, w io.Writer) (*Writer, error) {
+	id := make([]byte, streamIDSize)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, fmt.Errorf("failed to generate stream id: %w", err)
+	}
+	aead, err := streamAEAD(c, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append([]byte(streamMagic), id...)); err != nil {
+		return nil, fmt.Errorf("failed to write log header: %w", err)
+	}
+	return &Writer{w: w, aead: aead}, nil
+}
+
+// Write seals p into a single record. After a failed write the stream is
+// broken and every later Write fails.
+func (w *Writer) Write(p []byte) (int, error) {
+	if len(p) > MaxRecordSize {
+		return 0, ErrRecordTooLarge
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	record := make([]byte, 4, 4+len(p)+w.aead.Overhead())
+	record = w.aead.Seal(record, recordNonce(w.aead, w.seq), p, nil)
+	binary.BigEndian.PutUint32(record, uint32(len(record)-4))
+	if _, err := w.w.Write(record); err != nil {
+		w.err = fmt.Errorf("failed to write log record: %w", err)
+		return 0, w.err
+	}
+	w.seq++
+	return len(p), nil
+}
+
+// Sync flushes the underlying writer to stable storage when it supports it
+func (w *Writer) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if syncer, ok := w.w.(interface{ Sync() error }); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+// Reader returns the records of one or more streams in order
+type Reader struct {
+	c    *cypher.Cypher
+	r    *bufio.Reader
+	aead cipher.AEAD
+	seq  uint64
+}
+
+// NewReader reads the records of r
+func NewReader(c *cypher.Cypher, r io.Reader) *Reader {
+	return &Reader{c: c, r: bufio.NewReader(r)}
+}
+
+// Next returns the next record, or io.EOF after the last one
+func (r *Reader) Next() ([]byte, error) {
+	for {
+		prefix, err := r.r.Peek(len(streamMagic))
+		if err == io.EOF && len(prefix) == 0 {
+			return nil, io.EOF
+		}
+		if string(prefix) == streamMagic {
+			if err := r.startStream(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if r.aead == nil {
+			return nil, fmt.Errorf("%w: missing log header", ErrTampered)
+		}
+		return r.record()
+	}
+}
+
+func (r *Reader) startStream() error {
+	header := make([]byte, len(streamMagic)+streamIDSize)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		return fmt.Errorf("%w: truncated log header", ErrTampered)
+	}
+	aead, err := streamAEAD(r.c, header[len(streamMagic):])
+	if err != nil {
+		return err
+	}
+	r.aead, r.seq = aead, 0
+	return nil
+}
+
+func (r *Reader) record() ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r.r, prefix[:]); err != nil {
+		return nil, fmt.Errorf("%w: truncated record", ErrTampered)
+	}
+	size := binary.BigEndian.Uint32(prefix[:])
+	if size < uint32(r.aead.Overhead()) || size > MaxRecordSize+uint32(r.aead.Overhead()) {
+		return nil, fmt.Errorf("%w: invalid record size %d", ErrTampered, size)
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r.r, sealed); err != nil {
+		return nil, fmt.Errorf("%w: truncated record", ErrTampered)
+	}
+	record, err := r.aead.Open(sealed[:0], recordNonce(r.aead, r.seq), sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: record %d", ErrTampered, r.seq)
+	}
+	r.seq++
+	return record, nil
+}
+
+// Decrypt writes the records of r to w, in order
+func Decrypt(c *cypher.Cypher, r io.Reader, w io.Writer) error {
+	reader := NewReader(c, r)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(record); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package logs
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+func TestWriterReader(t *testing.T) {
+	c := cypher.NewCypher("test-key")
+	var file bytes.Buffer
+
+	// Two streams appended to the same file, like a restarted service
+	for _, line := range []string{"first run\n", "second run\n"} {
+		w, err := NewWriter(c, &file)
+		if err != nil {
+			t.Fatalf("NewWriter failed: %v", err)
+		}
+		logger := slog.New(NewSlogHandler(w, nil))
+		logger.Info(strings.TrimSpace(line), "n", 1)
+		w.Write([]byte(line))
+	}
+	if bytes.Contains(file.Bytes(), []byte("run")) {
+		t.Fatal("Expected the log to be encrypted")
+	}
+
+	var plain bytes.Buffer
+	if err := Decrypt(c, bytes.NewReader(file.Bytes()), &plain); err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(plain.String()), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[0], `"msg":"first run"`) || lines[3] != "second run" {
+		t.Errorf("Unexpected records %q", lines)
+	}
+
+	tampered := append([]byte(nil), file.Bytes()...)
+	tampered[len(tampered)-1] ^= 1
+	if err := Decrypt(c, bytes.NewReader(tampered), &plain); !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected ErrTampered, got %v", err)
+	}
+	if err := Decrypt(cypher.NewCypher("other-key"), bytes.NewReader(file.Bytes()), &plain); !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected ErrTampered with another key, got %v", err)
+	}
+}
@@ -0,0 +1,9 @@
+package logs
+
+import "log/slog"
+
+// NewSlogHandler returns a slog.Handler writing JSON records to w, one
+// encrypted record per log entry
+func NewSlogHandler(w *Writer, opts *slog.HandlerOptions) slog.Handler {
+	return slog.NewJSONHandler(w, opts)
+}
@@ -0,0 +1,57 @@
+package cypher
+
+// lowMemoryChunkSize is the chunk size set by WithLowMemory
+const lowMemoryChunkSize = 64 * 1024
+
+// WithLowMemory selects a profile for devices with little RAM: a single
+// worker and 64 KB chunks, so an operation needs a few hundred KB whatever
+// the input size. Set it after WithChunkSize and WithNumWorkers, which it overrides.
+func (c *Cypher) WithLowMemory() *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.ChunkSize = lowMemoryChunkSize
+	c.NumWorkers = 1
+	c.NumCores = 1
+	return c
+}
+
+// bufferSlack is the room left after every buffer, enough for the framing of
+// a sealed chunk, so the buffer of a plaintext chunk can hold a sealed one
+// and the other way round
+const bufferSlack = 64
+
+// bufferPool reuses chunk buffers. A nil pool allocates every buffer.
+type bufferPool struct {
+	free chan []byte
+}
+
+// newBufferPool keeps up to size unused buffers
+func newBufferPool(size int) *bufferPool {
+	return &bufferPool{free: make(chan []byte, size)}
+}
+
+// get returns a buffer of size bytes
+func (p *bufferPool) get(size int) []byte {
+	if p != nil {
+		select {
+		case buf := <-p.free:
+			if cap(buf) >= size {
+				return buf[:size]
+			}
+		default:
+		}
+	}
+	return make([]byte, size, size+bufferSlack)
+}
+
+// put returns buf to the pool once it is no longer used
+func (p *bufferPool) put(buf []byte) {
+	if p == nil || cap(buf) == 0 {
+		return
+	}
+	select {
+	case p.free <- buf[:0]:
+	default:
+	}
+}
@@ -0,0 +1,71 @@
+package cypher
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// ErrNoMachineID is returned when no identifier of this machine can be read
+var ErrNoMachineID = errors.New("no machine identifier available")
+
+// MachineID returns a SHA-256 digest identifying this machine, from an
+// identifier every user can read: the systemd machine-id on Linux, the
+// MachineGuid on Windows and the IOPlatformUUID on macOS. Clones of a
+// virtual machine or container image may share it.
+func MachineID() ([]byte, error) {
+	source, id, err := machineID()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(append([]byte("gocypher machine id "+source+"\x00"), id...))
+	return sum[:], nil
+}
+
+// BindToMachine returns a Cypher with the settings of c and a key mixing
+// the key of c with MachineID, so encrypted caches and credentials only
+// decrypt on the machine that created them. Data is lost when the
+// identifier changes, such as after reinstalling the system, so don't bind
+// data without another copy.
+func (c Cypher) BindToMachine() (*Cypher, error) {
+	id, err := MachineID()
+	if err != nil {
+		return nil, err
+	}
+	return c.BindTo(id)
+}
+
+// BindTo is like BindToMachine with the identifier id, such as the hash of
+// a TPM endorsement key or DMI product UUID, which are usually readable by
+// root only
+func (c Cypher) BindTo(id []byte) (*Cypher, error) {
+	if c.key == nil {
+		return nil, ErrClosed
+	}
+	if len(id) == 0 {
+		return nil, ErrNoMachineID
+	}
+
+	var derived *keyMaterial
+	err := c.key.use(func(master []byte) error {
+		key := make([]byte, KeySize)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, master, id, []byte("gocypher v1 machine bound")), key); err != nil {
+			return fmt.Errorf("failed to derive key: %w", err)
+		}
+		derived = c.key.derived(key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bound := c.copySettings()
+	bound.key = derived
+	bound.nonceCounter = nil
+	bound.keyUsage = nil
+	bound.passphraseStrength = nil
+	return bound, nil
+}
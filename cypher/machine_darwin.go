@@ -0,0 +1,22 @@
+package cypher
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+)
+
+var platformUUID = regexp.MustCompile(`"IOPlatformUUID" = "([^"]+)"`)
+
+// machineID returns the IOPlatformUUID of the hardware
+func machineID() (string, []byte, error) {
+	out, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %w", ErrNoMachineID, err)
+	}
+	match := platformUUID.FindSubmatch(out)
+	if match == nil {
+		return "", nil, ErrNoMachineID
+	}
+	return "platform-uuid", match[1], nil
+}
@@ -0,0 +1,20 @@
+package cypher
+
+import (
+	"bytes"
+	"os"
+)
+
+// machineID returns the systemd or D-Bus machine-id. The TPM endorsement
+// key and DMI product UUID in sysfs are readable by root only on most
+// distributions, so root and other users would derive different keys from
+// them; BindTo takes them instead.
+func machineID() (string, []byte, error) {
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		data, err := os.ReadFile(path)
+		if data = bytes.TrimSpace(data); err == nil && len(data) > 0 {
+			return "machine-id", data, nil
+		}
+	}
+	return "", nil, ErrNoMachineID
+}
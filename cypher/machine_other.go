@@ -0,0 +1,8 @@
+//go:build !linux && !windows && !darwin
+
+package cypher
+
+// machineID reports that this platform has no known identifier, see BindTo
+func machineID() (string, []byte, error) {
+	return "", nil, ErrNoMachineID
+}
@@ -0,0 +1,21 @@
+package cypher
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// machineID returns the MachineGuid set when Windows was installed
+func machineID() (string, []byte, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Cryptography`, registry.QUERY_VALUE|registry.WOW64_64KEY)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %w", ErrNoMachineID, err)
+	}
+	defer key.Close()
+	guid, _, err := key.GetStringValue("MachineGuid")
+	if err != nil || guid == "" {
+		return "", nil, fmt.Errorf("%w: MachineGuid: %v", ErrNoMachineID, err)
+	}
+	return "machine-guid", []byte(guid), nil
+}
@@ -0,0 +1,86 @@
+package cypher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// ManifestFile is the encrypted manifest mapping the random names of a
+// directory encrypted WithObfuscatedNames to the original paths
+const ManifestFile = ".gocypher-manifest"
+
+// WithObfuscatedNames makes EncryptDir write every file directly below the
+// destination under a random UUID name, so cloud-synced encrypted folders
+// don't leak names or directory structure. The original paths are kept in
+// ManifestFile, encrypted with the same key, which DecryptDir reads to
+// restore them. Files encrypted again keep their name.
+func (c *Cypher) WithObfuscatedNames() *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.obfuscateNames = true
+	return c
+}
+
+// readManifest returns the manifest of dir, mapping random names to
+// portable paths, or an empty one when dir has none
+func (c Cypher) readManifest(dir string) (map[string]string, error) {
+	sealed, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	data, err := c.Decrypt(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt manifest: %w", err)
+	}
+	names := map[string]string{}
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return names, nil
+}
+
+// writeManifest encrypts names into the manifest of dir, replacing it
+// atomically so an interrupted write keeps the previous one
+func (c Cypher) writeManifest(dir string, names map[string]string) error {
+	data, err := json.Marshal(names)
+	if err != nil {
+		return err
+	}
+	sealed, err := c.Encrypt(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt manifest: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	tmp := filepath.Join(dir, ManifestFile+".tmp")
+	if err := os.WriteFile(tmp, sealed, 0600); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, ManifestFile)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// obfuscatedName returns the random name of the file at the portable path
+// rel, adding one to names and byPath, its reverse, when it has none
+func obfuscatedName(names, byPath map[string]string, rel string) string {
+	if name, ok := byPath[rel]; ok {
+		return name
+	}
+	name := uuid.NewString()
+	names[name], byPath[rel] = rel, name
+	return name
+}
@@ -0,0 +1,53 @@
+package cypher
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrMemoryLock is returned when the pages holding the key can't be locked
+	ErrMemoryLock = errors.New("failed to lock key memory")
+	// ErrMemoryLockUnsupported is returned on platforms without mlock
+	ErrMemoryLockUnsupported = errors.New("memory locking is not supported on this platform")
+)
+
+// LockMemory locks the pages holding the key in RAM so they are never
+// written to swap. The lock is released by Close. On Unix the amount of locked
+// memory is bounded by RLIMIT_MEMLOCK (see ulimit -l).
+func (c *Cypher) LockMemory() error {
+	if c.key == nil {
+		return ErrClosed
+	}
+	return c.key.lock()
+}
+
+// WithLockedMemory tries to lock the key in RAM like LockMemory, logging a
+// warning instead of failing when it can't.
+func (c *Cypher) WithLockedMemory() *Cypher {
+	if err := c.LockMemory(); err != nil {
+		c.log().Warn("key memory is not locked and may be swapped to disk", "error", err)
+	}
+	return c
+}
+
+func (k *keyMaterial) lock() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.closed {
+		return k.err
+	}
+	k.wantLock = true
+	if k.locked || len(k.key) == 0 {
+		return nil
+	}
+	if err := lockMemory(k.key); err != nil {
+		if errors.Is(err, ErrMemoryLockUnsupported) {
+			return err
+		}
+		return fmt.Errorf("%w: %w%s", ErrMemoryLock, err, lockHint(err))
+	}
+	k.locked = true
+	return nil
+}
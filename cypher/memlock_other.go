@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package cypher
+
+func lockMemory(b []byte) error {
+	return ErrMemoryLockUnsupported
+}
+
+func unlockMemory(b []byte) error {
+	return nil
+}
+
+func lockHint(err error) string {
+	return ""
+}
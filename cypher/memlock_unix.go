@@ -0,0 +1,24 @@
+//go:build unix
+
+package cypher
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+func lockMemory(b []byte) error {
+	return unix.Mlock(b)
+}
+
+func unlockMemory(b []byte) error {
+	return unix.Munlock(b)
+}
+
+func lockHint(err error) string {
+	if errors.Is(err, unix.ENOMEM) || errors.Is(err, unix.EPERM) || errors.Is(err, unix.EAGAIN) {
+		return " (RLIMIT_MEMLOCK is too low, raise it with ulimit -l or LimitMEMLOCK)"
+	}
+	return ""
+}
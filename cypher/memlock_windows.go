@@ -0,0 +1,24 @@
+//go:build windows
+
+package cypher
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func lockMemory(b []byte) error {
+	return windows.VirtualLock(uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+}
+
+func unlockMemory(b []byte) error {
+	return windows.VirtualUnlock(uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+}
+
+func lockHint(err error) string {
+	if err == windows.ERROR_WORKING_SET_QUOTA {
+		return " (the process working set is too small, raise it with SetProcessWorkingSetSize)"
+	}
+	return ""
+}
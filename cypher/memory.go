@@ -0,0 +1,72 @@
+package cypher
+
+import (
+	"context"
+	"sync"
+)
+
+// WithMaxMemory caps the chunk buffers an operation holds at once, in the
+// reader, the workers and the reorder buffer of the writer, at about bytes.
+// The reader waits for memory before handing out the next chunk, so
+// NumWorkers and the chunk size can't make one operation use gigabytes. A
+// chunk counts twice its size while it is transformed and once afterwards.
+// A budget below two chunks processes them one at a time. 0 disables the
+// limit (the default).
+func (c *Cypher) WithMaxMemory(bytes int64) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.maxMemory = bytes
+	return c
+}
+
+// memoryBudget is a weighted semaphore of bytes, acquired by the reader of
+// an operation and released by its workers and writer. A nil budget is
+// unlimited.
+type memoryBudget struct {
+	mu        sync.Mutex
+	size      int64
+	available int64
+	// released is closed and replaced whenever memory is released
+	released chan struct{}
+}
+
+func newMemoryBudget(size int64) *memoryBudget {
+	return &memoryBudget{size: size, available: size, released: make(chan struct{})}
+}
+
+// acquire waits until n bytes are available and takes them. It returns how
+// many it took, at most the whole budget.
+func (b *memoryBudget) acquire(ctx context.Context, n int64) (int64, error) {
+	if b == nil {
+		return 0, nil
+	}
+	n = min(n, b.size)
+	for {
+		b.mu.Lock()
+		if b.available >= n {
+			b.available -= n
+			b.mu.Unlock()
+			return n, nil
+		}
+		released := b.released
+		b.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// release returns n bytes taken by acquire
+func (b *memoryBudget) release(n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.available += n
+	close(b.released)
+	b.released = make(chan struct{})
+	b.mu.Unlock()
+}
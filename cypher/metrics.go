@@ -0,0 +1,41 @@
+package cypher
+
+import "time"
+
+// Metrics receives measurements from the chunk pipeline. Implementations
+// must be safe for concurrent use; see the promcypher package for a
+// Prometheus backed implementation.
+type Metrics interface {
+	// AddBytes records n input bytes processed by op
+	AddBytes(op string, n int64)
+	// ObserveChunk records a chunk of size bytes transformed in d
+	ObserveChunk(op string, size int, d time.Duration)
+	// AddBusyWorkers adjusts the number of workers currently transforming a chunk
+	AddBusyWorkers(op string, delta int)
+	// CountError records a failed operation, kind is the pipeline stage that failed
+	CountError(op, kind string)
+}
+
+// Error kinds reported to Metrics.CountError
+const (
+	ErrorKindRead     = "read"
+	ErrorKindCrypto   = "crypto"
+	ErrorKindWrite    = "write"
+	ErrorKindCanceled = "canceled"
+	ErrorKindHeader   = "header"
+	ErrorKindPanic    = "panic"
+)
+
+type noopMetrics struct{}
+
+func (noopMetrics) AddBytes(string, int64)                  {}
+func (noopMetrics) ObserveChunk(string, int, time.Duration) {}
+func (noopMetrics) AddBusyWorkers(string, int)              {}
+func (noopMetrics) CountError(string, string)               {}
+
+func (c Cypher) meter() Metrics {
+	if c.metrics == nil {
+		return noopMetrics{}
+	}
+	return c.metrics
+}
@@ -0,0 +1,284 @@
+// Package minisign creates and verifies detached signatures in the minisign
+// format, so encrypted artifacts signed by release pipelines can be verified
+// with the minisign and signify-compatible tools.
+//
+// Signatures are Ed25519 over the BLAKE2b-512 hash of the file, as minisign
+// creates by default, and carry a trusted comment covered by a second
+// signature. Legacy signatures over the whole file are verified too.
+package minisign
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	// ErrInvalidSignature is returned for signatures that don't verify
+	ErrInvalidSignature = errors.New("invalid minisign signature")
+	// ErrWrongKey is returned for signatures made by another key
+	ErrWrongKey = errors.New("signature was made by another key")
+	// ErrMalformed is returned for keys and signatures that can't be parsed
+	ErrMalformed = errors.New("malformed minisign data")
+	// ErrWrongPassphrase is returned when a secret key fails to decrypt
+	ErrWrongPassphrase = errors.New("wrong passphrase for minisign secret key")
+	// ErrPassphraseRequired is returned when parsing an encrypted secret key
+	// without a passphrase
+	ErrPassphraseRequired = errors.New("minisign secret key is encrypted")
+)
+
+const (
+	untrustedPrefix = "untrusted comment: "
+	trustedPrefix   = "trusted comment: "
+
+	algEd25519   = "Ed"
+	algPrehashed = "ED"
+	algScrypt    = "Sc"
+	algBlake2b   = "B2"
+
+	// minisign's default scrypt cost, opslimit 33554432 and memlimit
+	// 1073741824, which libsodium maps to N=2^20, r=8, p=1
+	scryptOps = 33554432
+	scryptMem = 1073741824
+	scryptN   = 1 << 20
+)
+
+// PublicKey is a minisign public key
+type PublicKey struct {
+	ID  uint64
+	Key ed25519.PublicKey
+}
+
+// PrivateKey is a minisign secret key
+type PrivateKey struct {
+	ID  uint64
+	Key ed25519.PrivateKey
+}
+
+// Public returns the public key of k
+func (k *PrivateKey) Public() *PublicKey {
+	return &PublicKey{ID: k.ID, Key: k.Key.Public().(ed25519.PublicKey)}
+}
+
+// GenerateKey returns a new key pair with a random key id
+func GenerateKey() (*PublicKey, *PrivateKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	var id [8]byte
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key id: %w", err)
+	}
+	keyID := binary.LittleEndian.Uint64(id[:])
+	return &PublicKey{ID: keyID, Key: public}, &PrivateKey{ID: keyID, Key: private}, nil
+}
+
+// keyID formats id the way minisign prints it
+func keyID(id uint64) string {
+	return fmt.Sprintf("%016X", id)
+}
+
+// String returns the base64 line of the public key, as passed to minisign -P
+func (k *PublicKey) String() string {
+	data := append([]byte(algEd25519), binary.LittleEndian.AppendUint64(nil, k.ID)...)
+	return base64.StdEncoding.EncodeToString(append(data, k.Key...))
+}
+
+// MarshalText returns the contents of a minisign public key file
+func (k *PublicKey) MarshalText() ([]byte, error) {
+	return []byte(untrustedPrefix + "minisign public key " + keyID(k.ID) + "\n" + k.String() + "\n"), nil
+}
+
+// ParsePublicKey parses a public key file or its base64 line
+func ParsePublicKey(text []byte) (*PublicKey, error) {
+	line, err := dataLine(text)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(line)
+	if err != nil || len(data) != 2+8+ed25519.PublicKeySize || string(data[:2]) != algEd25519 {
+		return nil, fmt.Errorf("%w: public key", ErrMalformed)
+	}
+	return &PublicKey{ID: binary.LittleEndian.Uint64(data[2:10]), Key: ed25519.PublicKey(data[10:])}, nil
+}
+
+// dataLine returns the first line of text that isn't a comment
+func dataLine(text []byte) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, untrustedPrefix) {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("%w: missing key", ErrMalformed)
+}
+
+// Secret key files hold the algorithms, the scrypt salt and cost, then the
+// key id, the Ed25519 key and a BLAKE2b checksum, XORed with the scrypt
+// output
+const (
+	secretHeaderSize = 2 + 2 + 2 + 32 + 8 + 8
+	secretKeySize    = 8 + ed25519.PrivateKeySize + 32
+)
+
+// MarshalSecret returns the contents of a minisign secret key file. The key
+// is encrypted with passphrase, or left unencrypted if it is empty, like
+// minisign -W.
+func (k *PrivateKey) MarshalSecret(passphrase []byte) ([]byte, error) {
+	data := make([]byte, secretHeaderSize, secretHeaderSize+secretKeySize)
+	copy(data, algEd25519+"\x00\x00"+algBlake2b)
+	data = binary.LittleEndian.AppendUint64(data, k.ID)
+	data = append(data, k.Key...)
+	data = append(data, k.checksum()...)
+	if len(passphrase) > 0 {
+		copy(data[2:], algScrypt)
+		salt := data[6:38]
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+		binary.LittleEndian.PutUint64(data[38:], scryptOps)
+		binary.LittleEndian.PutUint64(data[46:], scryptMem)
+		stream, err := scrypt.Key(passphrase, salt, scryptN, 8, 1, secretKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key: %w", err)
+		}
+		subtle.XORBytes(data[secretHeaderSize:], data[secretHeaderSize:], stream)
+	}
+	return []byte(untrustedPrefix + "minisign secret key\n" + base64.StdEncoding.EncodeToString(data) + "\n"), nil
+}
+
+func (k *PrivateKey) checksum() []byte {
+	sum := blake2b.Sum256(append(append([]byte(algEd25519), binary.LittleEndian.AppendUint64(nil, k.ID)...), k.Key...))
+	return sum[:]
+}
+
+// ParsePrivateKey parses a secret key file, decrypting it with passphrase.
+// Encrypted keys must use minisign's default scrypt cost.
+func ParsePrivateKey(text, passphrase []byte) (*PrivateKey, error) {
+	line, err := dataLine(text)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(line)
+	if err != nil || len(data) != secretHeaderSize+secretKeySize || string(data[:2]) != algEd25519 || string(data[4:6]) != algBlake2b {
+		return nil, fmt.Errorf("%w: secret key", ErrMalformed)
+	}
+	secret := data[secretHeaderSize:]
+	switch string(data[2:4]) {
+	case "\x00\x00":
+	case algScrypt:
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+		if binary.LittleEndian.Uint64(data[38:]) != scryptOps || binary.LittleEndian.Uint64(data[46:]) != scryptMem {
+			return nil, fmt.Errorf("%w: unsupported scrypt cost", ErrMalformed)
+		}
+		stream, err := scrypt.Key(passphrase, data[6:38], scryptN, 8, 1, secretKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key: %w", err)
+		}
+		subtle.XORBytes(secret, secret, stream)
+	default:
+		return nil, fmt.Errorf("%w: unsupported key derivation", ErrMalformed)
+	}
+	k := &PrivateKey{
+		ID:  binary.LittleEndian.Uint64(secret[:8]),
+		Key: ed25519.PrivateKey(secret[8 : 8+ed25519.PrivateKeySize]),
+	}
+	if subtle.ConstantTimeCompare(k.checksum(), secret[8+ed25519.PrivateKeySize:]) != 1 {
+		return nil, ErrWrongPassphrase
+	}
+	return k, nil
+}
+
+// Sign returns the signature file of the data read from r. The trusted
+// comment, such as "timestamp:1700000000\tfile:app.tar.gz", is signed too
+// and can't contain newlines.
+func Sign(key *PrivateKey, r io.Reader, trustedComment string) ([]byte, error) {
+	if strings.ContainsAny(trustedComment, "\r\n") {
+		return nil, errors.New("trusted comment can't contain newlines")
+	}
+	hash, err := hashReader(r)
+	if err != nil {
+		return nil, err
+	}
+	signature := append([]byte(algPrehashed), binary.LittleEndian.AppendUint64(nil, key.ID)...)
+	signature = append(signature, ed25519.Sign(key.Key, hash)...)
+	global := ed25519.Sign(key.Key, globalMessage(signature, trustedComment))
+
+	var b strings.Builder
+	b.WriteString(untrustedPrefix + "signature from minisign secret key " + keyID(key.ID) + "\n")
+	b.WriteString(base64.StdEncoding.EncodeToString(signature) + "\n")
+	b.WriteString(trustedPrefix + trustedComment + "\n")
+	b.WriteString(base64.StdEncoding.EncodeToString(global) + "\n")
+	return []byte(b.String()), nil
+}
+
+// Verify verifies the signature file of the data read from r and returns the
+// trusted comment
+func Verify(key *PublicKey, r io.Reader, signatureFile []byte) (string, error) {
+	lines := strings.Split(strings.ReplaceAll(string(signatureFile), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], untrustedPrefix) || !strings.HasPrefix(lines[2], trustedPrefix) {
+		return "", fmt.Errorf("%w: signature", ErrMalformed)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(signature) != 2+8+ed25519.SignatureSize {
+		return "", fmt.Errorf("%w: signature", ErrMalformed)
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return "", fmt.Errorf("%w: global signature", ErrMalformed)
+	}
+	if id := binary.LittleEndian.Uint64(signature[2:10]); id != key.ID {
+		return "", fmt.Errorf("%w: key id %s", ErrWrongKey, keyID(id))
+	}
+
+	var message []byte
+	switch string(signature[:2]) {
+	case algPrehashed:
+		if message, err = hashReader(r); err != nil {
+			return "", err
+		}
+	case algEd25519:
+		if message, err = io.ReadAll(r); err != nil {
+			return "", fmt.Errorf("failed to read data: %w", err)
+		}
+	default:
+		return "", fmt.Errorf("%w: unsupported signature algorithm", ErrMalformed)
+	}
+	if !ed25519.Verify(key.Key, message, signature[10:]) {
+		return "", ErrInvalidSignature
+	}
+	trustedComment := strings.TrimPrefix(lines[2], trustedPrefix)
+	if !ed25519.Verify(key.Key, globalMessage(signature, trustedComment), global) {
+		return "", fmt.Errorf("%w: trusted comment", ErrInvalidSignature)
+	}
+	return trustedComment, nil
+}
+
+// globalMessage returns what the global signature covers, the signature of
+// the data and the trusted comment
+func globalMessage(signature []byte, trustedComment string) []byte {
+	return append(append([]byte(nil), signature[10:]...), trustedComment...)
+}
+
+func hashReader(r io.Reader) ([]byte, error) {
+	hash, _ := blake2b.New512(nil)
+	if _, err := io.Copy(hash, r); err != nil {
+		return nil, fmt.Errorf("failed to read data: %w", err)
+	}
+	return hash.Sum(nil), nil
+}
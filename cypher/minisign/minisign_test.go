@@ -0,0 +1,68 @@
+package minisign
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Signatures of "test" made by the minisign tool, prehashed and legacy
+const (
+	minisignKey       = "RWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y7GFO3"
+	minisignHashed    = "untrusted comment: signature from minisign secret key\nRUQf6LRCGA9i559r3g7V1qNyJDApGip8MfqcadIgT9CuhV3EMhHoN1mGTkUidF/z7SrlQgXdy8ofjb7bNJJylDOocrCo8KLzZwo=\ntrusted comment: timestamp:1635443258\tfile:test\thashed\n/cj37GK60vryibFn+ftOgbCvW9NKhKYgjVpFFQUcWPAnjO23wrvVDTt7cloNC06maoBli9q6qwZDXXoaxweICQ==\n"
+	minisignLegacy    = "untrusted comment: signature from minisign secret key\nRWQf6LRCGA9i59SLOFxz6NxvASXDJeRtuZykwQepbDEGt87ig1BNpWaVWuNrm73YiIiJbq71Wi+dP9eKL8OC351vwIasSSbXxwA=\ntrusted comment: timestamp:1635442742\tfile:test\n0YteLgV960ia80vnA/fHbvkyjl/IoP/HNOCaZfrF0CdhAlp7ok+Tpkya+VpWPX5C/Is3q8a/kEDSY7fBmmgJCg==\n"
+	minisignEncrypted = "untrusted comment: minisign encrypted secret key\nRWRTY0IyxYlIT2FS5i8PqThE9swBemvY94JDIMqo75UBK3XO/aUAAAACAAAAAAAAAEAAAAAAUhlw8nsT1tuVUekS6Je3iUwoWFdb1xiLonO35G66RiVvM/QgrBtnDa0Dhbt7H3oYMh4aFLiNxMs24gzXqHVsvRVthMeF08fN8r6siRdBpiBZ36B7rox2lmYIYgg5T8qt7tOxo9doAxk=\n"
+)
+
+func TestVerifyMinisign(t *testing.T) {
+	key, err := ParsePublicKey([]byte(minisignKey))
+	if err != nil {
+		t.Fatalf("ParsePublicKey failed: %v", err)
+	}
+	for _, signature := range []string{minisignHashed, minisignLegacy} {
+		if _, err := Verify(key, strings.NewReader("test"), []byte(signature)); err != nil {
+			t.Errorf("Verify failed: %v", err)
+		}
+		if _, err := Verify(key, strings.NewReader("tests"), []byte(signature)); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Expected ErrInvalidSignature, got %v", err)
+		}
+	}
+	if _, err := ParsePrivateKey([]byte(minisignEncrypted), nil); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("Expected ErrPassphraseRequired, got %v", err)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	public, private, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := private.MarshalSecret(nil)
+	private, err = ParsePrivateKey(secret, nil)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey failed: %v", err)
+	}
+	text, _ := public.MarshalText()
+	public, err = ParsePublicKey(text)
+	if err != nil {
+		t.Fatalf("ParsePublicKey failed: %v", err)
+	}
+
+	signature, err := Sign(private, strings.NewReader("artifact"), "file:app.tar.gz.encrypted")
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	trusted, err := Verify(public, strings.NewReader("artifact"), signature)
+	if err != nil || trusted != "file:app.tar.gz.encrypted" {
+		t.Errorf("Expected the trusted comment, got %q: %v", trusted, err)
+	}
+
+	forged := strings.Replace(string(signature), "file:app", "file:evil", 1)
+	if _, err := Verify(public, strings.NewReader("artifact"), []byte(forged)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature, got %v", err)
+	}
+	other, _ := ParsePublicKey([]byte(minisignKey))
+	if _, err := Verify(other, strings.NewReader("artifact"), signature); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package cypher
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Placeholders of WithNameTemplate
+const (
+	namePlaceholder      = "{name}"
+	timestampPlaceholder = "{ts}"
+	timestampLayout      = "20060102T150405Z"
+)
+
+var placeholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// WithFileSuffix names the files EncryptFile and EncryptDir write with suffix
+// appended, such as ".gc" or ".aes", instead of ".encrypted". DecryptFile and
+// DecryptDir remove it again.
+func (c *Cypher) WithFileSuffix(suffix string) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.nameTemplate = namePlaceholder + suffix
+	return c
+}
+
+// WithNameTemplate names the files EncryptFile and EncryptDir write after
+// template, such as "{name}.{ts}.enc", with {name} replaced by the name of
+// the plaintext file and {ts} by the UTC time of the encryption, like
+// 20240102T150405Z. DecryptFile and DecryptDir recover the plaintext name
+// from names matching it.
+func (c *Cypher) WithNameTemplate(template string) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.nameTemplate = template
+	return c
+}
+
+func (c Cypher) template() string {
+	if c.nameTemplate == "" {
+		return namePlaceholder + encryptedSuffix
+	}
+	return c.nameTemplate
+}
+
+// encryptedName returns the name of the encryption of path, in the same
+// directory
+func (c Cypher) encryptedName(path string, now time.Time) string {
+	name := strings.NewReplacer(namePlaceholder, filepath.Base(path),
+		timestampPlaceholder, now.UTC().Format(timestampLayout)).Replace(c.template())
+	return filepath.Join(filepath.Dir(path), name)
+}
+
+// decryptedName returns the name of the decryption of path, or false when
+// path isn't named after the template
+func (c Cypher) decryptedName(path string) (string, bool) {
+	pattern, err := compileNameTemplate(c.template())
+	if err != nil {
+		return "", false
+	}
+	match := pattern.FindStringSubmatch(filepath.Base(path))
+	if match == nil {
+		return "", false
+	}
+	return filepath.Join(filepath.Dir(path), match[1]), true
+}
+
+// decryptFileName is where DecryptFile writes the decryption of path: path
+// without the template, or with ".decrypted" appended when it doesn't match
+func (c Cypher) decryptFileName(path string) string {
+	if name, ok := c.decryptedName(path); ok {
+		return name
+	}
+	return path + decryptedSuffix
+}
+
+// WithOverwrite lets DecryptFile replace an existing file of the name it
+// restores, such as an older plaintext next to the encrypted file
+func (c *Cypher) WithOverwrite() *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.overwrite = true
+	return c
+}
+
+// sameFile reports whether a and b name the same file
+func sameFile(a, b string) bool {
+	if filepath.Clean(a) == filepath.Clean(b) {
+		return true
+	}
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+// compileNameTemplate returns the regular expression of names following
+// template, whose first group is the plaintext name
+func compileNameTemplate(template string) (*regexp.Regexp, error) {
+	if strings.Count(template, namePlaceholder) != 1 {
+		return nil, fmt.Errorf("need %s exactly once", namePlaceholder)
+	}
+	if template == namePlaceholder {
+		return nil, fmt.Errorf("must differ from %s", namePlaceholder)
+	}
+	if strings.ContainsAny(template, `/\`) {
+		return nil, fmt.Errorf("must not contain a path separator")
+	}
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	last := 0
+	for _, loc := range placeholderPattern.FindAllStringIndex(template, -1) {
+		pattern.WriteString(regexp.QuoteMeta(template[last:loc[0]]))
+		switch placeholder := template[loc[0]:loc[1]]; placeholder {
+		case namePlaceholder:
+			pattern.WriteString("(.+)")
+		case timestampPlaceholder:
+			pattern.WriteString(`\d{8}T\d{6}Z`)
+		default:
+			return nil, fmt.Errorf("unknown placeholder %s", placeholder)
+		}
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(template[last:]))
+	pattern.WriteString("$")
+	return regexp.Compile(pattern.String())
+}
+
+// WithOriginalName records the name of the plaintext file, sealed, in the
+// header of EncryptFile and EncryptDir output, and makes DecryptFile write
+// next to its input under the recorded name: report.pdf.encrypted, even
+// renamed to 3f2a.bin, decrypts back to report.pdf, and an input that has the
+// recorded name already is replaced like DecryptFileInPlace. Data without a
+// recorded name decrypts as usual. The header grows by the name, which
+// EncryptedSize doesn't count.
+func (c *Cypher) WithOriginalName() *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.originalName = true
+	return c
+}
+
+// sealName returns the RecordName value of name for h
+func (c Cypher) sealName(h *header, name string) ([]byte, error) {
+	return c.sealRecord(h, RecordName, []byte(name))
+}
+
+// openName returns the name recorded in h, "" when there is none. Names that
+// aren't a single path element are rejected, so a crafted header can't make
+// DecryptFile write outside the directory of its input.
+func (c Cypher) openName(h *header) (string, error) {
+	if h.name == nil {
+		return "", nil
+	}
+	name, err := c.openRecord(h, RecordName, h.name)
+	if err != nil {
+		return "", err
+	}
+	if s := string(name); s == "" || s == "." || s == ".." || strings.ContainsAny(s, `/\`) || filepath.Base(s) != s {
+		return "", fmt.Errorf("%w: bad name %q", ErrInvalidHeader, name)
+	}
+	return string(name), nil
+}
+
+// OriginalName returns the name of the plaintext file recorded in the header
+// of r WithOriginalName, "" when there is none
+func (c Cypher) OriginalName(r io.Reader) (string, error) {
+	magic := make([]byte, len(FormatMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != FormatMagic {
+		return "", nil
+	}
+	h, err := readHeader(r, c.parseMode)
+	if err != nil {
+		return "", err
+	}
+	if _, err := c.fileGCM(h); err != nil {
+		return "", err
+	}
+	return c.openName(h)
+}
+
+// restoredName returns where DecryptFile writes the decryption of path
+func (c Cypher) restoredName(path string) (string, error) {
+	if c.originalName {
+		file, err := os.Open(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to open input file: %w", err)
+		}
+		defer file.Close()
+		name, err := c.OriginalName(file)
+		if err != nil {
+			return "", err
+		}
+		if name != "" {
+			return filepath.Join(filepath.Dir(path), name), nil
+		}
+	}
+	return c.decryptFileName(path), nil
+}
@@ -0,0 +1,185 @@
+package cypher
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// nonceCounterBatch is how many counter values are reserved on disk at once
+const nonceCounterBatch = 1 << 16
+
+var (
+	// ErrNonceRollback is returned once the persisted nonce counter is found to
+	// be behind a value it already handed out, such as after restoring a VM
+	// snapshot
+	ErrNonceRollback = errors.New("nonce counter moved backwards")
+	// ErrNonceCounterKey is returned when a nonce counter is used with a key
+	// other than the one it was opened for
+	ErrNonceCounterKey = errors.New("nonce counter belongs to another key")
+)
+
+// NonceCounter hands out nonces from a counter persisted in a file, so no
+// nonce is used twice under a key even across restarts. Values are reserved
+// on disk in batches before they are used; values of a batch left unused
+// when the process exits are skipped.
+type NonceCounter struct {
+	mu          sync.Mutex
+	path        string
+	fingerprint string
+	next        uint64
+	reserved    uint64
+	err         error
+}
+
+type nonceCounterState struct {
+	Fingerprint string `json:"fingerprint"`
+	Next        uint64 `json:"next"`
+}
+
+// OpenNonceCounter opens the counter state at path for the key identified
+// by fingerprint (see KeyFingerprint), creating it when missing
+func OpenNonceCounter(path, fingerprint string) (*NonceCounter, error) {
+	n := &NonceCounter{path: path, fingerprint: fingerprint}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return n, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read nonce counter: %w", err)
+	}
+
+	var state nonceCounterState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse nonce counter: %w", err)
+	}
+	if !EqualFingerprint(state.Fingerprint, fingerprint) {
+		return nil, ErrNonceCounterKey
+	}
+	n.next, n.reserved = state.Next, state.Next
+	return n, nil
+}
+
+// Next returns the next counter value, reserving a new batch on disk first
+// when needed
+func (n *NonceCounter) Next() (uint64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.err != nil {
+		return 0, n.err
+	}
+	if n.next == n.reserved {
+		if err := n.persist(n.reserved + nonceCounterBatch); err != nil {
+			return 0, err
+		}
+		n.reserved += nonceCounterBatch
+	}
+	value := n.next
+	n.next++
+	return value, nil
+}
+
+// Observe reports a counter value known to be in use, such as one recorded
+// in encrypted data. If the persisted counter is not past it the state was
+// rolled back, and every later Next fails with ErrNonceRollback.
+func (n *NonceCounter) Observe(value uint64) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if value >= n.next && n.err == nil {
+		n.err = fmt.Errorf("%w: %d was already used but the counter is at %d", ErrNonceRollback, value, n.next)
+	}
+	return n.err
+}
+
+// Err returns ErrNonceRollback once a rollback was detected
+func (n *NonceCounter) Err() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.err
+}
+
+// persist atomically replaces the state file
+func (n *NonceCounter) persist(next uint64) error {
+	data, err := json.Marshal(nonceCounterState{Fingerprint: n.fingerprint, Next: next})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(n.path), ".nonce-counter-*")
+	if err != nil {
+		return fmt.Errorf("failed to persist nonce counter: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to persist nonce counter: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to persist nonce counter: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to persist nonce counter: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), n.path); err != nil {
+		return fmt.Errorf("failed to persist nonce counter: %w", err)
+	}
+	return nil
+}
+
+// nonce returns a 12 byte GCM nonce holding the next counter value
+func (n *NonceCounter) nonce([]byte) ([]byte, error) {
+	value, err := n.Next()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], value)
+	return nonce, nil
+}
+
+// WithNonceCounter makes encryption use nonces from counter instead of random
+// ones. The first counter value of every operation is recorded in its
+// header; decrypting data whose value the counter hasn't passed yet reveals
+// that the counter state was rolled back, after which encryption fails with
+// ErrNonceRollback.
+func (c *Cypher) WithNonceCounter(counter *NonceCounter) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.nonceCounter = counter
+	return c
+}
+
+// startCounter takes the value recorded in the header of a new operation
+func (c Cypher) startCounter() (*uint64, error) {
+	if c.nonceCounter == nil {
+		return nil, nil
+	}
+	if !EqualFingerprint(c.nonceCounter.fingerprint, c.KeyFingerprint()) {
+		return nil, ErrNonceCounterKey
+	}
+	start, err := c.nonceCounter.Next()
+	if err != nil {
+		return nil, err
+	}
+	return &start, nil
+}
+
+// observeCounter checks the counter value recorded in decrypted data
+func (c Cypher) observeCounter(start *uint64) {
+	if c.nonceCounter == nil || start == nil || !EqualFingerprint(c.nonceCounter.fingerprint, c.KeyFingerprint()) {
+		return
+	}
+	if err := c.nonceCounter.Observe(*start); err != nil {
+		c.log().Error("nonce counter rolled back, refusing to encrypt", "error", err)
+	}
+}
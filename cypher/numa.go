@@ -0,0 +1,66 @@
+package cypher
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrNUMAUnsupported is returned where worker placement isn't available
+var ErrNUMAUnsupported = errors.New("NUMA placement is only supported on Linux")
+
+// WithNUMANode pins the workers and the writer of every operation to the
+// CPUs of NUMA node, so chunk buffers are allocated on and processed by that
+// node. Use NUMANodeOf to find the node of the device holding the input. A
+// negative node disables pinning (the default). Placement is best effort: if
+// it fails a warning is logged and the operation runs unpinned.
+func (c *Cypher) WithNUMANode(node int) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.numaNode = node
+	return c
+}
+
+// parseCPUList parses a Linux CPU list such as "0-3,8,10-11"
+func parseCPUList(list string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(strings.TrimSpace(list), ",") {
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("bad CPU list %q: %w", list, err)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil {
+				return nil, fmt.Errorf("bad CPU list %q: %w", list, err)
+			}
+		}
+		if end < start {
+			return nil, fmt.Errorf("bad CPU list %q", list)
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// numaPinner returns the function pinning a pipeline goroutine to the NUMA
+// node of c, or nil when pinning is off or unavailable
+func (c Cypher) numaPinner() func() {
+	if c.numaNode < 0 {
+		return nil
+	}
+	pin, err := pinToNode(c.numaNode)
+	if err != nil {
+		c.log().Warn("not pinning workers to NUMA node", "node", c.numaNode, "error", err)
+		return nil
+	}
+	return pin
+}
@@ -0,0 +1,65 @@
+package cypher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// pinToNode returns a function locking the calling goroutine to its thread
+// and that thread to the CPUs of node. The thread is discarded when the
+// goroutine exits, so the affinity never leaks to other goroutines.
+func pinToNode(node int) (func(), error) {
+	data, err := os.ReadFile(fmt.Sprintf("/sys/devices/system/node/node%d/cpulist", node))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CPUs of node %d: %w", node, err)
+	}
+	cpus, err := parseCPUList(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("node %d has no CPUs", node)
+	}
+
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	return func() {
+		runtime.LockOSThread()
+		// Best effort, an unpinned worker is only slower
+		unix.SchedSetaffinity(0, &set)
+	}, nil
+}
+
+// NUMANodeOf returns the NUMA node of the block device holding path, or -1
+// when it is unknown, e.g. on single node machines or for network file
+// systems
+func NUMANodeOf(path string) (int, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return -1, err
+	}
+	dev := fmt.Sprintf("/sys/dev/block/%d:%d", unix.Major(st.Dev), unix.Minor(st.Dev))
+
+	// Partitions inherit the node of their disk, NVMe namespaces that of
+	// their controller
+	for _, candidate := range []string{"device/numa_node", "../device/numa_node", "device/device/numa_node"} {
+		data, err := os.ReadFile(filepath.Join(dev, candidate))
+		if err != nil {
+			continue
+		}
+		node, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return -1, fmt.Errorf("bad NUMA node of %s: %w", path, err)
+		}
+		return node, nil
+	}
+	return -1, nil
+}
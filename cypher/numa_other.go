@@ -0,0 +1,13 @@
+//go:build !linux
+
+package cypher
+
+func pinToNode(node int) (func(), error) {
+	return nil, ErrNUMAUnsupported
+}
+
+// NUMANodeOf returns the NUMA node of the block device holding path. It is
+// only supported on Linux, elsewhere it returns -1 and ErrNUMAUnsupported.
+func NUMANodeOf(path string) (int, error) {
+	return -1, ErrNUMAUnsupported
+}
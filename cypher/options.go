@@ -0,0 +1,248 @@
+package cypher
+
+import (
+	"crypto/ed25519"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// The functions below are the Option forms of the With methods, so a Cypher
+// can be configured when it is created:
+//
+//	c := cypher.NewCypher(key, cypher.WithChunkSize(1<<20), cypher.WithNumWorkers(4))
+//
+// Options are applied in order and behave exactly like the method they are
+// named after.
+
+// WithChunkSize sets the size of the chunks data is split into
+func WithChunkSize(chunkSize int) Option {
+	return func(c *Cypher) { c.WithChunkSize(chunkSize) }
+}
+
+// WithNumWorkers sets the number of chunks processed concurrently
+func WithNumWorkers(numWorkers int) Option {
+	return func(c *Cypher) { c.WithNumWorkers(numWorkers) }
+}
+
+// WithNumCores bounds the number of workers transforming chunks at once
+func WithNumCores(numCores int) Option {
+	return func(c *Cypher) { c.WithNumCores(numCores) }
+}
+
+// WithLogger sets the logger used to report operation events
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Cypher) { c.WithLogger(logger) }
+}
+
+// WithMetrics sets the recorder of operation measurements
+func WithMetrics(metrics Metrics) Option {
+	return func(c *Cypher) { c.WithMetrics(metrics) }
+}
+
+// WithTracer sets the tracer creating a span for every operation
+func WithTracer(tracer Tracer) Option {
+	return func(c *Cypher) { c.WithTracer(tracer) }
+}
+
+// WithSlowChunkThreshold sets how long a chunk may take before it is
+// recorded on the operation span
+func WithSlowChunkThreshold(threshold time.Duration) Option {
+	return func(c *Cypher) { c.WithSlowChunkThreshold(threshold) }
+}
+
+// WithProgress sets the progress callback
+func WithProgress(progress ProgressFunc) Option {
+	return func(c *Cypher) { c.WithProgress(progress) }
+}
+
+// WithAuditor sets the auditor recording every operation
+func WithAuditor(auditor Auditor) Option {
+	return func(c *Cypher) { c.WithAuditor(auditor) }
+}
+
+// WithAlgorithm selects the AEAD new data is sealed with
+func WithAlgorithm(algorithm Algorithm) Option {
+	return func(c *Cypher) { c.WithAlgorithm(algorithm) }
+}
+
+// WithBackend sets the backend creating the AEADs
+func WithBackend(backend Backend) Option {
+	return func(c *Cypher) { c.WithBackend(backend) }
+}
+
+// WithContentDefinedChunking splits data at content defined boundaries
+func WithContentDefinedChunking(min, avg, max int) Option {
+	return func(c *Cypher) { c.WithContentDefinedChunking(min, avg, max) }
+}
+
+// WithCompression compresses chunks before sealing them
+func WithCompression(algo Compression, level int) Option {
+	return func(c *Cypher) { c.WithCompression(algo, level) }
+}
+
+// WithDeltaFriendlyOutput seals equal chunks into equal ciphertexts
+func WithDeltaFriendlyOutput() Option {
+	return func(c *Cypher) { c.WithDeltaFriendlyOutput() }
+}
+
+// WithGeneration sets the key rotation counter recorded in new headers
+func WithGeneration(generation uint64) Option {
+	return func(c *Cypher) { c.WithGeneration(generation) }
+}
+
+// WithMinGeneration rejects data older than generation
+func WithMinGeneration(generation uint64) Option {
+	return func(c *Cypher) { c.WithMinGeneration(generation) }
+}
+
+// WithInclude limits directory operations to files matching patterns
+func WithInclude(patterns ...string) Option {
+	return func(c *Cypher) { c.WithInclude(patterns...) }
+}
+
+// WithExclude makes directory operations skip paths matching patterns
+func WithExclude(patterns ...string) Option {
+	return func(c *Cypher) { c.WithExclude(patterns...) }
+}
+
+// WithLowMemory selects the profile for devices with little RAM
+func WithLowMemory() Option {
+	return func(c *Cypher) { c.WithLowMemory() }
+}
+
+// WithLockedMemory tries to keep the key out of swap
+func WithLockedMemory() Option {
+	return func(c *Cypher) { c.WithLockedMemory() }
+}
+
+// WithNonceCounter derives nonces from counter instead of random bytes
+func WithNonceCounter(counter *NonceCounter) Option {
+	return func(c *Cypher) { c.WithNonceCounter(counter) }
+}
+
+// WithNUMANode pins the pipeline to the CPUs of a NUMA node
+func WithNUMANode(node int) Option {
+	return func(c *Cypher) { c.WithNUMANode(node) }
+}
+
+// WithMinPassphraseStrength rejects passphrases scoring below minScore
+func WithMinPassphraseStrength(minScore int) Option {
+	return func(c *Cypher) { c.WithMinPassphraseStrength(minScore) }
+}
+
+// WithSpill caps the memory held by out of order chunks
+func WithSpill(budget int64, dir string) Option {
+	return func(c *Cypher) { c.WithSpill(budget, dir) }
+}
+
+// WithParseMode sets how headers with unknown records are decrypted
+func WithParseMode(mode ParseMode) Option {
+	return func(c *Cypher) { c.WithParseMode(mode) }
+}
+
+// WithObfuscatedNames writes directory outputs under random names
+func WithObfuscatedNames() Option {
+	return func(c *Cypher) { c.WithObfuscatedNames() }
+}
+
+// WithShredSource overwrites and removes sources once encrypted
+func WithShredSource(passes int) Option {
+	return func(c *Cypher) { c.WithShredSource(passes) }
+}
+
+// WithExpiry records in the header when encrypted data expires
+func WithExpiry(expiry time.Time) Option {
+	return func(c *Cypher) { c.WithExpiry(expiry) }
+}
+
+// WithEnforceExpiry refuses to decrypt expired data
+func WithEnforceExpiry() Option {
+	return func(c *Cypher) { c.WithEnforceExpiry() }
+}
+
+// WithWorkerPool transforms chunks on the goroutines of a shared pool
+func WithWorkerPool(pool *WorkerPool) Option {
+	return func(c *Cypher) { c.WithWorkerPool(pool) }
+}
+
+// WithMaxMemory caps the chunk buffers each operation holds at once
+func WithMaxMemory(bytes int64) Option {
+	return func(c *Cypher) { c.WithMaxMemory(bytes) }
+}
+
+// WithAdaptiveWorkers scales the chunks in flight with the bottleneck
+func WithAdaptiveWorkers() Option {
+	return func(c *Cypher) { c.WithAdaptiveWorkers() }
+}
+
+// WithEncryptStages runs custom stages on every chunk of encryptions
+func WithEncryptStages(stages ...Stage) Option {
+	return func(c *Cypher) { c.WithEncryptStages(stages...) }
+}
+
+// WithDecryptStages runs custom stages on every chunk of decryptions
+func WithDecryptStages(stages ...Stage) Option {
+	return func(c *Cypher) { c.WithDecryptStages(stages...) }
+}
+
+// WithExecutionThresholds picks direct, moderate or full execution by input
+// size
+func WithExecutionThresholds(direct, full int64) Option {
+	return func(c *Cypher) { c.WithExecutionThresholds(direct, full) }
+}
+
+// WithFileSuffix names encrypted files with suffix instead of ".encrypted"
+func WithFileSuffix(suffix string) Option {
+	return func(c *Cypher) { c.WithFileSuffix(suffix) }
+}
+
+// WithNameTemplate names encrypted files after template
+func WithNameTemplate(template string) Option {
+	return func(c *Cypher) { c.WithNameTemplate(template) }
+}
+
+// WithOriginalName records the plaintext name and restores it on DecryptFile
+func WithOriginalName() Option {
+	return func(c *Cypher) { c.WithOriginalName() }
+}
+
+// WithOverwrite lets DecryptFile replace existing files
+func WithOverwrite() Option {
+	return func(c *Cypher) { c.WithOverwrite() }
+}
+
+// WithXattrs records extended attributes and restores them on decryption
+func WithXattrs() Option {
+	return func(c *Cypher) { c.WithXattrs() }
+}
+
+// WithFileLocking holds advisory locks on the files of file operations
+func WithFileLocking(policy LockPolicy) Option {
+	return func(c *Cypher) { c.WithFileLocking(policy) }
+}
+
+// WithJournal journals file encryptions so interrupted ones resume
+func WithJournal() Option {
+	return func(c *Cypher) { c.WithJournal() }
+}
+
+// WithSignedContents signs the listing of EncryptDir output with key
+func WithSignedContents(key ed25519.PrivateKey) Option {
+	return func(c *Cypher) { c.WithSignedContents(key) }
+}
+
+// WithVerifiedContents verifies DecryptDir input against its signed listing
+func WithVerifiedContents(key ed25519.PublicKey) Option {
+	return func(c *Cypher) { c.WithVerifiedContents(key) }
+}
+
+// WithKeyUsage counts what the key encrypts and enforces the limits of usage
+func WithKeyUsage(usage *KeyUsage) Option {
+	return func(c *Cypher) { c.WithKeyUsage(usage) }
+}
+
+// WithRandReader reads salts and nonces from r instead of crypto/rand
+func WithRandReader(r io.Reader) Option {
+	return func(c *Cypher) { c.WithRandReader(r) }
+}
@@ -0,0 +1,72 @@
+// Package otelcypher traces cypher operations with OpenTelemetry.
+package otelcypher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer implements cypher.Tracer on top of an OpenTelemetry tracer
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// New wraps tracer, usually obtained from otel.Tracer("gocypher")
+func New(tracer trace.Tracer) *Tracer {
+	return &Tracer{tracer: tracer}
+}
+
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, cypher.Span) {
+	ctx, span := t.tracer.Start(ctx, name)
+	return ctx, &Span{span: span}
+}
+
+// Span implements cypher.Span on top of an OpenTelemetry span
+type Span struct {
+	span trace.Span
+}
+
+func (s *Span) SetAttributes(attrs map[string]any) {
+	s.span.SetAttributes(attributes(attrs)...)
+}
+
+func (s *Span) AddEvent(name string, attrs map[string]any) {
+	s.span.AddEvent(name, trace.WithAttributes(attributes(attrs)...))
+}
+
+func (s *Span) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+func attributes(attrs map[string]any) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for key, value := range attrs {
+		switch v := value.(type) {
+		case string:
+			kvs = append(kvs, attribute.String(key, v))
+		case int:
+			kvs = append(kvs, attribute.Int(key, v))
+		case int64:
+			kvs = append(kvs, attribute.Int64(key, v))
+		case bool:
+			kvs = append(kvs, attribute.Bool(key, v))
+		case float64:
+			kvs = append(kvs, attribute.Float64(key, v))
+		case time.Duration:
+			kvs = append(kvs, attribute.Float64(key+"_seconds", v.Seconds()))
+		default:
+			kvs = append(kvs, attribute.String(key, fmt.Sprint(v)))
+		}
+	}
+	return kvs
+}
@@ -0,0 +1,70 @@
+package otelcypher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	c := cypher.NewCypher("test-key").
+		WithChunkSize(16).
+		WithTracer(New(provider.Tracer("test"))).
+		WithSlowChunkThreshold(time.Nanosecond)
+
+	if _, err := c.Encrypt(make([]byte, 40)); err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Name() != "gocypher.encrypt" {
+		t.Errorf("Expected span gocypher.encrypt, got %s", spans[0].Name())
+	}
+	if events := len(spans[0].Events()); events != 3 {
+		t.Errorf("Expected 3 slow chunk events, got %d", events)
+	}
+}
+
+func TestTracerFiles(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	c := cypher.NewCypher("test-key").
+		WithChunkSize(16).
+		WithTracer(New(provider.Tracer("test"))).
+		WithSlowChunkThreshold(time.Nanosecond)
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(input, make([]byte, 40), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.EncryptFileToPath(context.Background(), input, input+".enc"); err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	if _, err := c.DecryptFileToPath(context.Background(), input+".enc", input+".dec"); err != nil {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	for _, span := range spans {
+		if events := len(span.Events()); events != 3 {
+			t.Errorf("Expected 3 slow chunk events in %s, got %d", span.Name(), events)
+		}
+	}
+}
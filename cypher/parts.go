@@ -0,0 +1,124 @@
+package cypher
+
+import (
+	"context"
+	"io"
+)
+
+// SealedChunk is a piece of an encrypted stream, concatenated in Index order
+// with the others it is the output of EncryptStream
+type SealedChunk struct {
+	Index int
+	// Offset is the position of Data in the encrypted stream
+	Offset int64
+	// Data is a sealed chunk, with the header before the first one and the
+	// trailer after the last one. It belongs to the caller.
+	Data []byte
+}
+
+// ChunkIterator returns the sealed chunks of an encryption one at a time,
+// see SealChunks
+type ChunkIterator struct {
+	chunks chan SealedChunk
+	done   chan struct{}
+	cancel context.CancelFunc
+	err    error
+}
+
+// SealChunks encrypts src and returns its sealed chunks as they are ready,
+// so uploaders can map them 1:1 to the parts of an S3 or GCS multipart
+// upload and retry a failed part with its Data instead of encrypting the
+// whole stream again. Set ChunkSize to at least the minimum part size of the
+// store. The encryption waits for the caller to take each chunk; Close
+// stops it early.
+func (c Cypher) SealChunks(ctx context.Context, src io.Reader) *ChunkIterator {
+	ctx, cancel := context.WithCancel(ctx)
+	it := &ChunkIterator{chunks: make(chan SealedChunk), done: make(chan struct{}), cancel: cancel}
+	collector := &chunkCollector{ctx: ctx, chunks: it.chunks}
+	op := c.encryptOperation()
+	prepare := op.prepare
+	op.prepare = func(op *operation, src io.Reader, dst io.Writer) (io.Reader, error) {
+		frames, err := prepare(op, src, dst)
+		written := op.written
+		op.written = func(frame []byte) {
+			if written != nil {
+				written(frame)
+			}
+			collector.frame = true
+		}
+		return frames, err
+	}
+	go func() {
+		defer close(it.done)
+		_, err := c.process(ctx, op, src, collector)
+		if err == nil {
+			err = collector.flush()
+		}
+		it.err = err
+		close(it.chunks)
+	}()
+	return it
+}
+
+// Next returns the next sealed chunk, or io.EOF after the last one
+func (it *ChunkIterator) Next() (*SealedChunk, error) {
+	chunk, ok := <-it.chunks
+	if !ok {
+		<-it.done
+		if it.err != nil {
+			return nil, it.err
+		}
+		return nil, io.EOF
+	}
+	return &chunk, nil
+}
+
+// Close stops the encryption once the chunks still wanted are taken
+func (it *ChunkIterator) Close() {
+	it.cancel()
+	for range it.chunks {
+	}
+	<-it.done
+}
+
+// chunkCollector is the output of SealChunks. It groups the writes of the
+// pipeline into chunks, holding back the last one until it knows whether a
+// trailer follows.
+type chunkCollector struct {
+	ctx    context.Context
+	chunks chan<- SealedChunk
+	// frame is set by the writer right before it writes a frame
+	frame   bool
+	pending []byte
+	started bool
+	index   int
+	offset  int64
+}
+
+func (w *chunkCollector) Write(p []byte) (int, error) {
+	if w.frame && w.started {
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+	}
+	// Writes before the first frame are the header, after the last one the
+	// trailer
+	w.started = w.started || w.frame
+	w.frame = false
+	w.pending = append(w.pending, p...)
+	return len(p), nil
+}
+
+// flush hands the pending chunk to the iterator
+func (w *chunkCollector) flush() error {
+	chunk := SealedChunk{Index: w.index, Offset: w.offset, Data: w.pending}
+	select {
+	case w.chunks <- chunk:
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+	w.index++
+	w.offset += int64(len(w.pending))
+	w.pending = nil
+	return nil
+}
@@ -0,0 +1,203 @@
+package cypher
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// ErrWeakPassphrase is returned when a passphrase is below the minimum
+// strength set with WithMinPassphraseStrength
+var ErrWeakPassphrase = errors.New("passphrase is too weak")
+
+// Strength is an estimate of how hard a passphrase is to guess
+type Strength struct {
+	// Score goes from 0 (trivially guessable) to 4 (very strong), like zxcvbn
+	Score int
+	// Entropy is the estimated number of bits an attacker has to guess
+	Entropy float64
+	// Warning explains the weakest part of the passphrase, if any
+	Warning string
+}
+
+// commonPassphrases are frequent passwords, in rough order of popularity
+var commonPassphrases = []string{
+	"password", "123456", "12345678", "qwerty", "abc123", "111111", "letmein",
+	"monkey", "dragon", "iloveyou", "admin", "welcome", "login", "master",
+	"sunshine", "princess", "football", "baseball", "shadow", "superman",
+	"trustno1", "secret", "freedom", "whatever", "starwars", "hello",
+	"charlie", "michael", "jessica", "ninja", "mustang", "access", "flower",
+	"passw0rd", "changeme", "default", "root", "toor", "test", "guest",
+}
+
+// keyboardRows are scanned for runs of adjacent keys such as "asdf"
+var keyboardRows = []string{"qwertyuiop", "asdfghjkl", "zxcvbnm", "1234567890"}
+
+// leet maps common character substitutions back to letters
+var leet = strings.NewReplacer("0", "o", "1", "l", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s", "!", "i")
+
+// EstimatePassphraseStrength estimates how many guesses passphrase takes to
+// crack. Like zxcvbn it splits the passphrase into patterns (common
+// passwords, repeats, sequences, keyboard runs) and charges each by the
+// guesses needed to find it rather than by its length.
+func EstimatePassphraseStrength(passphrase string) Strength {
+	runes := []rune(passphrase)
+	if len(runes) == 0 {
+		return Strength{Warning: "passphrase is empty"}
+	}
+
+	charset := math.Log2(float64(charsetSize(runes)))
+	lower := []rune(strings.ToLower(passphrase))
+	unleet := []rune(leet.Replace(string(lower)))
+
+	var bits float64
+	var warning string
+	for i := 0; i < len(runes); {
+		size, cost, reason := longestPattern(lower, unleet, i)
+		if size == 0 {
+			bits += charset
+			i++
+			continue
+		}
+		if reason != "" && warning == "" {
+			warning = reason
+		}
+		bits += cost
+		i += size
+	}
+
+	return Strength{Score: strengthScore(bits), Entropy: bits, Warning: warning}
+}
+
+// longestPattern returns the longest pattern starting at i, with its cost
+// in bits and a warning describing it. unleet is s with leet substitutions
+// undone, it has the same length.
+func longestPattern(s, unleet []rune, i int) (size int, bits float64, warning string) {
+	rest := string(s[i:])
+	for rank, common := range commonPassphrases {
+		for _, candidate := range []string{rest, string(unleet[i:])} {
+			if len(common) > size && strings.HasPrefix(candidate, common) {
+				size, bits, warning = len(common), math.Log2(float64(rank+2))+1, "contains a common password"
+			}
+		}
+	}
+
+	if n := runLength(s, i, func(a, b rune) bool { return a == b }); n >= 3 && n > size {
+		size, bits, warning = n, math.Log2(float64(charsetSize(s[i:i+1])*n)), "contains repeated characters"
+	}
+	for _, step := range []rune{1, -1} {
+		if n := runLength(s, i, func(a, b rune) bool { return b-a == step }); n >= 3 && n > size {
+			size, bits, warning = n, math.Log2(float64(26*n*2)), "contains a sequence"
+		}
+	}
+	for _, row := range keyboardRows {
+		for _, r := range []string{row, reverse(row)} {
+			if n := commonPrefix(rest, r); n >= 4 && n > size {
+				size, bits, warning = n, math.Log2(float64(len(keyboardRows)*len(row)*n*2)), "contains a keyboard pattern"
+			}
+		}
+	}
+	return size, bits, warning
+}
+
+// runLength returns how many runes from i on are linked by next
+func runLength(s []rune, i int, next func(a, b rune) bool) int {
+	n := 1
+	for i+n < len(s) && next(s[i+n-1], s[i+n]) {
+		n++
+	}
+	return n
+}
+
+// commonPrefix returns the length of the longest prefix of s found anywhere
+// in row
+func commonPrefix(s, row string) int {
+	best := 0
+	for start := range row {
+		n := 0
+		for n < len(s) && start+n < len(row) && s[n] == row[start+n] {
+			n++
+		}
+		best = max(best, n)
+	}
+	return best
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+// charsetSize estimates the alphabet the runes were drawn from
+func charsetSize(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.present {
+			size += class.size
+		}
+	}
+	return size
+}
+
+// strengthScore maps bits of entropy to a score using the zxcvbn guess
+// thresholds of 10^3, 10^6, 10^8 and 10^10
+func strengthScore(bits float64) int {
+	guesses := bits * math.Log10(2)
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// WithMinPassphraseStrength rejects the passphrase c was created with when
+// its score is below minScore. A rejected Cypher has its key wiped and every
+// operation fails with ErrWeakPassphrase, which Err reports right away.
+// Cyphers created from raw keys are not affected.
+func (c *Cypher) WithMinPassphraseStrength(minScore int) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	if c.passphraseStrength == nil || c.key == nil || c.passphraseStrength.Score >= minScore {
+		return c
+	}
+
+	strength := c.passphraseStrength
+	err := fmt.Errorf("%w: score %d, need %d", ErrWeakPassphrase, strength.Score, minScore)
+	if strength.Warning != "" {
+		err = fmt.Errorf("%w: %s", err, strength.Warning)
+	}
+	c.key.close(err)
+	return c
+}
@@ -0,0 +1,728 @@
+package cypher
+
+import (
+	"context"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type DataChunk struct {
+	data     []byte
+	position int
+	// size is the number of input bytes the chunk was read from
+	size int
+	// reserved is the memory the chunk holds of the budget of the operation
+	reserved int64
+	// offset is where the transformed chunk goes after the header, when the
+	// workers write it themselves
+	offset int64
+}
+
+// chunkFunc transforms a single chunk (seal or open) with the cipher of op.
+// aad binds the chunk to its header and position, it is nil for the legacy
+// format.
+type chunkFunc func(op *operation, aad, data []byte) ([]byte, error)
+
+// frameReader reads the next frame from src and reports how many input bytes
+// it consumed. It returns io.EOF when there are no more frames.
+type frameReader func(src io.Reader) (data []byte, consumed int, err error)
+
+// operation describes a single run of the chunk pipeline
+type operation struct {
+	name string
+	// prepare writes or consumes the container header and sets up the cipher,
+	// the frame reader and the transform. It returns the reader the frames
+	// are read from.
+	prepare   func(op *operation, src io.Reader, dst io.Writer) (io.Reader, error)
+	gcm       cipher.AEAD
+	readFrame frameReader
+	transform chunkFunc
+	// nonce returns the nonce sealing data, random when nil
+	nonce func(data []byte) ([]byte, error)
+	// compress and decompress are applied to the plaintext of every chunk
+	// when the data is compressed
+	compress   func([]byte) ([]byte, error)
+	decompress func([]byte) ([]byte, error)
+	chunkFlags bool
+	// positionless leaves the chunk position out of its additional data
+	positionless bool
+	// written is called with every frame in output order, and trailer
+	// returns a last frame written once all chunks succeeded
+	written    func(frame []byte)
+	trailer    func() []byte
+	aad        []byte
+	headerSize int
+	attributes map[string]any
+	// holes are the holes of a sparse input skipped when encrypting, and
+	// holeWriter recreates them when decrypting
+	holes      []hole
+	holeWriter *holeWriter
+	// total is the input size when sized is set, used for progress
+	// reporting and to pick the execution strategy
+	total int64
+	sized bool
+	// inputPath and outputPath are set for file operations
+	inputPath  string
+	outputPath string
+	// newOutput makes file operations fail with ErrOutputExists rather than
+	// replace an existing output file
+	newOutput bool
+	// outputFile is set when writing to a file, so holes can be skipped
+	outputFile seekTruncater
+	// sparse makes file inputs skip their holes
+	sparse bool
+	// wipeInput and wipeOutput zero chunk buffers holding plaintext once
+	// they have been sealed or written
+	wipeInput  bool
+	wipeOutput bool
+	// buffers holds the chunk buffers reused between chunks
+	buffers *bufferPool
+	// spillBudget and spillDir configure spilling out of order chunks, the
+	// writer reports how many it spilled in spilled
+	spillBudget int64
+	spillDir    string
+	spilled     int
+	// memory bounds the chunk buffers in flight, unlimited when nil
+	memory *memoryBudget
+	// processed counts the input bytes whose output the writer wrote
+	processed int64
+	// scaler moves the chunks in flight with the bottleneck, see
+	// WithAdaptiveWorkers
+	scaler *workerScaler
+	// stagesBefore and stagesAfter are the custom stages run around
+	// transform, framed is set when its output has a length prefix
+	stagesBefore []Stage
+	stagesAfter  []Stage
+	framed       bool
+	// outputSize returns the size of a frame once transformed, when it
+	// doesn't depend on its content. positioned is then set for outputs the
+	// workers write at the offsets of their chunks.
+	outputSize func(frame []byte) int
+	positioned *positionedWriter
+	// xattrs receives the extended attributes of decrypted files, to
+	// restore on the output WithXattrs
+	xattrs *[]xattr
+	// committed is called with the size of every frame once written, and
+	// fails the operation with its error
+	committed func(size int) error
+	// firstPosition is the position of the first chunk, past those of an
+	// interrupted encryption being resumed, and firstOffset the size of
+	// their frames
+	firstPosition int
+	firstOffset   int64
+	// streamTrailer returns the trailer frame ending chunks frames of size
+	// bytes, see RecordTrailer. Unlike trailer it only needs their count and
+	// size, so the workers may still write the frames at their offsets.
+	streamTrailer func(chunks int, size int64) []byte
+	// usage counts the chunks sealed, see WithKeyUsage
+	usage *KeyUsage
+	// random is the source of random nonces, crypto/rand when nil
+	random io.Reader
+}
+
+// chunkAAD returns the additional data of the chunk at position
+func (op *operation) chunkAAD(position int) []byte {
+	return op.appendChunkAAD(nil, position)
+}
+
+// appendChunkAAD is like chunkAAD but builds the additional data in the
+// space of dst, so workers reuse one buffer for all their chunks
+func (op *operation) appendChunkAAD(dst []byte, position int) []byte {
+	if op.aad == nil || op.positionless {
+		return op.aad
+	}
+	return binary.BigEndian.AppendUint64(append(dst[:0], op.aad...), uint64(position))
+}
+
+// run holds the per-call state shared by the pipeline stages
+type run struct {
+	op        *operation
+	metrics   Metrics
+	span      Span
+	slowChunk time.Duration
+	fail      func(kind string, err error)
+	// busy and aad are indexed by worker, each worker only touches its own
+	// slot. aad holds the additional data of the chunk of the worker.
+	busy []time.Duration
+	aad  [][]byte
+	// pin places worker and writer goroutines on a NUMA node when set
+	pin func()
+	// cores holds a token for every worker transforming a chunk, bounding
+	// them to NumCores
+	cores chan struct{}
+}
+
+// process prepares op on src and dst, transforms the frames of src with a
+// pool of workers and writes the results to dst in their original order
+func (c Cypher) process(ctx context.Context, op operation, src io.Reader, dst io.Writer) (stats Stats, err error) {
+	defer recoverError(&err)
+	if err := c.Validate(); err != nil {
+		return stats, err
+	}
+	c.markUsed()
+	if c.key != nil {
+		// Lazy keys are acquired with the context of the operation
+		if err := c.key.acquire(ctx); err != nil {
+			return stats, err
+		}
+	}
+
+	// Small inputs need fewer workers, or none. Stats still cover all of
+	// them, those not started stay idle.
+	configuredWorkers := c.NumWorkers
+	workers, direct := c.strategy(&op)
+	c.NumWorkers = workers
+
+	startTime := time.Now()
+	logger := c.log().With("op", op.name)
+	logger.Debug("operation started", "chunk_size", c.ChunkSize, "workers", c.NumWorkers)
+	metrics := c.meter()
+
+	ctx, span := c.startSpan(ctx, "gocypher."+op.name)
+	defer func() { span.End(err) }()
+	span.SetAttributes(op.attributes)
+
+	trail, src, dst := c.startAudit(src, dst)
+	defer func() {
+		if auditErr := c.finishAudit(ctx, trail, op, stats, err); auditErr != nil && err == nil {
+			err = auditErr
+		}
+	}()
+
+	in := &countingReader{r: src}
+	out := &countingWriter{w: dst}
+	// Chunk buffers are reused, enough for the chunks in flight between the
+	// stages
+	op.buffers = newBufferPool(4*c.NumWorkers + 4)
+	op.spillBudget, op.spillDir = c.spillBudget, c.spillDir
+	if c.maxMemory > 0 {
+		op.memory = newMemoryBudget(c.maxMemory)
+	}
+	frames, err := op.prepare(&op, in, out)
+	if err != nil {
+		metrics.CountError(op.name, ErrorKindHeader)
+		logger.Error("operation failed", "error", err)
+		return stats, err
+	}
+	headerBytes := out.n
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Create channels
+	input := make(chan DataChunk, c.NumWorkers)
+	output := make(chan DataChunk, c.NumWorkers)
+	errorChan := make(chan error, 1)
+
+	// fail records the first error and stops every stage of the pipeline
+	fail := func(kind string, err error) {
+		select {
+		case errorChan <- err:
+			metrics.CountError(op.name, kind)
+		default:
+		}
+		cancel()
+	}
+	r := &run{
+		op:        &op,
+		metrics:   metrics,
+		span:      span,
+		slowChunk: c.slowChunk(),
+		fail:      fail,
+		busy:      make([]time.Duration, configuredWorkers),
+		aad:       make([][]byte, c.NumWorkers),
+		pin:       c.numaPinner(),
+		cores:     make(chan struct{}, min(c.NumCores, c.NumWorkers)),
+	}
+
+	stopScaling := func() {}
+	if c.adaptive && !direct {
+		op.scaler = newWorkerScaler(min(c.NumCores, c.NumWorkers), c.NumWorkers)
+		stopScaling = c.startScaling(ctx, op.scaler, op.name)
+	}
+
+	var progress *progressTracker
+	if c.progress != nil {
+		progress = newProgressTracker(op.name, op.total, c.progress)
+		progress.done = int64(op.headerSize)
+	}
+
+	// Chunks whose output size is known are written by the workers at their
+	// offsets, each worker taking every NumWorkers-th chunk, so none waits in
+	// the reorder buffer of the writer
+	if !direct {
+		op.positioned = newPositionedWriter(&op, dst, progress)
+	}
+	var inputs []chan DataChunk
+	if op.positioned != nil && c.pool == nil {
+		inputs = make([]chan DataChunk, c.NumWorkers)
+		for i := range inputs {
+			inputs[i] = make(chan DataChunk, 1)
+		}
+	}
+
+	// Start the workers, or hand the chunks to the shared pool
+	var wg sync.WaitGroup
+	if direct {
+		// The reader transforms and writes every chunk itself
+	} else if c.pool != nil {
+		wg.Add(1)
+		go c.pooledWorkers(ctx, &wg, r, input, output)
+	} else {
+		for i := 0; i < c.NumWorkers; i++ {
+			workerInput := input
+			if inputs != nil {
+				workerInput = inputs[i]
+			}
+			wg.Add(1)
+			go worker(ctx, &wg, r, i, workerInput, output)
+		}
+	}
+
+	var sink io.Writer = out
+	if op.holeWriter != nil {
+		sink = op.holeWriter
+	}
+
+	// Start the writer goroutine
+	writeComplete := make(chan struct{})
+	if direct {
+		close(writeComplete)
+	} else {
+		go func() {
+			defer close(writeComplete)
+			defer r.recoverStage()
+			if r.pin != nil {
+				r.pin()
+			}
+			writeChunks(ctx, sink, output, &op, progress, fail)
+		}()
+	}
+	writeDirect := func(chunk DataChunk) error {
+		return writeChunk(sink, chunk, &op, progress)
+	}
+
+	// Read and send chunks for processing
+	position := op.firstPosition
+	var offset int64
+read:
+	for ctx.Err() == nil {
+		if err := op.scaler.acquire(ctx); err != nil {
+			break
+		}
+		readStart := time.Now()
+		data, consumed, err := readFrame(&op, frames)
+		op.scaler.addRead(time.Since(readStart))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(ErrorKindRead, fmt.Errorf("failed to read input: %w", err))
+			break
+		}
+
+		// The chunk and its transformed copy live at once in a worker
+		reserved, err := op.memory.acquire(ctx, 2*int64(len(data))+chunkOverhead)
+		if err != nil {
+			if op.wipeInput {
+				wipe(data)
+			}
+			op.buffers.put(data)
+			break
+		}
+
+		chunk := DataChunk{data: data, position: position, size: consumed, reserved: reserved, offset: offset}
+		if direct {
+			if !r.transformDirect(ctx, chunk, output, writeDirect) {
+				break
+			}
+			position++
+			metrics.AddBytes(op.name, int64(consumed))
+			continue
+		}
+		next := input
+		if op.positioned != nil {
+			offset += int64(op.outputSize(data))
+		}
+		if inputs != nil {
+			next = inputs[position%len(inputs)]
+		}
+		select {
+		case next <- chunk:
+			position++
+			metrics.AddBytes(op.name, int64(consumed))
+		case <-ctx.Done():
+			if op.wipeInput {
+				wipe(data)
+			}
+			op.buffers.put(data)
+			break read
+		}
+	}
+
+	// Close the input channel to signal no more data
+	close(input)
+	for _, input := range inputs {
+		close(input)
+	}
+
+	// Wait for all workers to complete
+	wg.Wait()
+
+	// Close output channel and wait for writer to complete
+	close(output)
+	<-writeComplete
+	stopScaling()
+
+	if ctx.Err() == nil && op.positioned != nil {
+		if err := op.positioned.finish(); err != nil {
+			fail(ErrorKindWrite, fmt.Errorf("failed to seek output: %w", err))
+		}
+	}
+	if ctx.Err() == nil && op.holeWriter != nil {
+		if err := op.holeWriter.finish(); err != nil {
+			fail(ErrorKindWrite, err)
+		}
+	}
+	if ctx.Err() == nil && op.trailer != nil {
+		if _, err := out.Write(op.trailer()); err != nil {
+			fail(ErrorKindWrite, fmt.Errorf("failed to write trailer: %w", err))
+		}
+	}
+	if ctx.Err() == nil && op.streamTrailer != nil {
+		size := op.firstOffset + out.n - headerBytes + op.positioned.writtenBytes()
+		if _, err := out.Write(op.streamTrailer(position, size)); err != nil {
+			fail(ErrorKindWrite, fmt.Errorf("failed to write trailer: %w", err))
+		}
+	}
+
+	// The parent context may have been cancelled while the stages drained
+	if ctx.Err() != nil {
+		fail(ErrorKindCanceled, &CanceledError{Processed: op.processedBytes(), Err: ctx.Err()})
+	}
+
+	chunks := position - op.firstPosition
+	stats = Stats{
+		BytesRead:     in.n,
+		BytesWritten:  out.n + op.positioned.writtenBytes(),
+		Chunks:        chunks,
+		SpilledChunks: op.spilled,
+		Duration:      time.Since(startTime),
+		WorkerBusy:    r.busy,
+		ActiveWorkers: op.scaler.activeWorkers(),
+	}
+
+	select {
+	case err := <-errorChan:
+		logger.Error("operation failed", "chunks", chunks, "error", err)
+		return stats, err
+	default:
+	}
+
+	logger.Info("operation completed",
+		"chunks", chunks,
+		"bytes_read", stats.BytesRead,
+		"bytes_written", stats.BytesWritten,
+		"duration", stats.Duration,
+		"mb_per_second", stats.Throughput(),
+	)
+	span.SetAttributes(map[string]any{
+		"gocypher.chunks":        chunks,
+		"gocypher.bytes_read":    stats.BytesRead,
+		"gocypher.bytes_written": stats.BytesWritten,
+	})
+	return stats, nil
+}
+
+func worker(ctx context.Context, wg *sync.WaitGroup, r *run, id int, input <-chan DataChunk, output chan<- DataChunk) {
+	defer wg.Done()
+	defer r.recoverStage()
+	if r.pin != nil {
+		r.pin()
+	}
+
+	for {
+		select {
+		case chunk, ok := <-input:
+			if !ok || !r.transform(ctx, id, chunk, output) {
+				return
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// transform transforms chunk as the worker id and sends the result to
+// output. It returns false when the operation failed or was cancelled.
+func (r *run) transform(ctx context.Context, id int, chunk DataChunk, output chan<- DataChunk) bool {
+	op := r.op
+	// A cancelled operation leaves the chunks it hasn't started
+	if ctx.Err() != nil {
+		r.discard(chunk)
+		return false
+	}
+	select {
+	case r.cores <- struct{}{}:
+	case <-ctx.Done():
+		r.discard(chunk)
+		return false
+	}
+	r.metrics.AddBusyWorkers(op.name, 1)
+	startTime := time.Now()
+	r.aad[id] = op.appendChunkAAD(r.aad[id], chunk.position)
+	data, err := op.transformStages(ctx, chunk.position, r.aad[id], chunk.data)
+	elapsed := time.Since(startTime)
+	<-r.cores
+	r.busy[id] += elapsed
+	op.scaler.addBusy(elapsed)
+	r.metrics.ObserveChunk(op.name, len(chunk.data), elapsed)
+	r.metrics.AddBusyWorkers(op.name, -1)
+	r.discard(chunk)
+	if err != nil {
+		r.fail(ErrorKindCrypto, err)
+		return false
+	}
+	if elapsed >= r.slowChunk {
+		r.span.AddEvent("slow chunk", map[string]any{
+			"gocypher.position": chunk.position,
+			"gocypher.size":     len(chunk.data),
+			"gocypher.duration": elapsed,
+		})
+	}
+
+	// Only the transformed copy is left
+	reserved := min(chunk.reserved, int64(len(data)))
+	op.memory.release(chunk.reserved - reserved)
+	if op.positioned != nil {
+		return r.writeAt(DataChunk{data: data, position: chunk.position, size: chunk.size, reserved: reserved, offset: chunk.offset})
+	}
+
+	select {
+	case output <- DataChunk{data: data, position: chunk.position, size: chunk.size, reserved: reserved}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// writeAt writes a transformed chunk at its offset, as the writer would
+func (r *run) writeAt(chunk DataChunk) bool {
+	op := r.op
+	err := op.positioned.write(chunk)
+	if op.wipeOutput {
+		wipe(chunk.data)
+	}
+	op.buffers.put(chunk.data)
+	op.memory.release(chunk.reserved)
+	op.scaler.release()
+	if err != nil {
+		r.fail(ErrorKindWrite, err)
+		return false
+	}
+	return true
+}
+
+// discard releases the buffer of an input chunk, wiping it when it holds
+// plaintext
+func (r *run) discard(chunk DataChunk) {
+	if r.op.wipeInput {
+		wipe(chunk.data)
+	}
+	r.op.buffers.put(chunk.data)
+}
+
+// ErrPanic is returned by operations when a stage of the pipeline or a
+// callback, such as a Backend or a ProgressFunc, panicked
+var ErrPanic = errors.New("operation panicked")
+
+// CanceledError is returned by operations stopped by their context. It
+// wraps the error of the context, so errors.Is(err, context.Canceled) holds.
+type CanceledError struct {
+	// Processed is the number of input bytes whose output was written
+	// before the operation stopped
+	Processed int64
+	Err       error
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("operation stopped after %d bytes: %v", e.Processed, e.Err)
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// recoverError turns a panic of the calling function into an ErrPanic
+// stored in err
+func recoverError(err *error) {
+	if p := recover(); p != nil {
+		*err = fmt.Errorf("%w: %v", ErrPanic, p)
+	}
+}
+
+// recoverStage fails the operation when the calling pipeline goroutine
+// panics, instead of crashing the program
+func (r *run) recoverStage() {
+	if p := recover(); p != nil {
+		r.fail(ErrorKindPanic, fmt.Errorf("%w: %v", ErrPanic, p))
+	}
+}
+
+// readFrame reads the next frame of op, turning a panic of the reader into
+// an error so the pipeline shuts down in order
+func readFrame(op *operation, frames io.Reader) (data []byte, consumed int, err error) {
+	defer recoverError(&err)
+	return op.readFrame(frames)
+}
+
+func writeChunks(ctx context.Context, w io.Writer, input <-chan DataChunk, op *operation, progress *progressTracker, fail func(string, error)) {
+	wipeData := op.wipeOutput
+	pending := make(map[int]DataChunk)
+	nextPosition := op.firstPosition
+	// pendingBytes is the size of the chunks held in pending, beyond
+	// op.spillBudget they go to spill
+	var pendingBytes int64
+	var spill *spillFile
+	defer func() { spill.close() }()
+
+	// take returns the chunk at nextPosition from memory or the spill file
+	take := func() (DataChunk, bool) {
+		if next, ok := pending[nextPosition]; ok {
+			return next, true
+		}
+		if spill == nil {
+			return DataChunk{}, false
+		}
+		next, ok, err := spill.take(nextPosition)
+		if err != nil {
+			fail(ErrorKindWrite, err)
+			return DataChunk{}, false
+		}
+		return next, ok
+	}
+
+	for chunk := range input {
+		if ctx.Err() != nil {
+			if wipeData {
+				wipe(chunk.data)
+			}
+			continue
+		}
+		if op.spillBudget > 0 && chunk.position != nextPosition && pendingBytes+int64(len(chunk.data)) > op.spillBudget {
+			if spill == nil {
+				var err error
+				if spill, err = newSpillFile(op.spillDir); err != nil {
+					fail(ErrorKindWrite, err)
+					continue
+				}
+			}
+			err := spill.put(chunk)
+			if wipeData {
+				wipe(chunk.data)
+			}
+			op.buffers.put(chunk.data)
+			op.memory.release(chunk.reserved)
+			if err != nil {
+				fail(ErrorKindWrite, err)
+				continue
+			}
+			op.spilled++
+			continue
+		}
+		pending[chunk.position] = chunk
+		pendingBytes += int64(len(chunk.data))
+
+		// Write chunks in order
+		for next, ok := take(); ok; next, ok = take() {
+			size := len(next.data)
+			if err := writeChunk(w, next, op, progress); err != nil {
+				fail(ErrorKindWrite, err)
+				break
+			}
+			if _, ok := pending[nextPosition]; ok {
+				delete(pending, nextPosition)
+				pendingBytes -= int64(size)
+			}
+			nextPosition++
+		}
+	}
+
+	if wipeData {
+		for _, chunk := range pending {
+			wipe(chunk.data)
+		}
+	}
+}
+
+// writeChunk writes next, the chunk whose turn it is, and releases it
+func writeChunk(w io.Writer, next DataChunk, op *operation, progress *progressTracker) error {
+	if op.written != nil {
+		op.written(next.data)
+	}
+	size := len(next.data)
+	writeStart := time.Now()
+	_, err := w.Write(next.data)
+	op.scaler.addWrite(time.Since(writeStart))
+	op.scaler.release()
+	if op.wipeOutput {
+		wipe(next.data)
+	}
+	op.buffers.put(next.data)
+	op.memory.release(next.reserved)
+	if err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	if op.committed != nil {
+		if err := op.committed(size); err != nil {
+			return err
+		}
+	}
+	op.processed += int64(next.size)
+	progress.add(next.size)
+	return nil
+}
+
+// fixedFrames reads frames of size bytes, the last one may be shorter
+func fixedFrames(size int, buffers *bufferPool) frameReader {
+	return func(src io.Reader) ([]byte, int, error) {
+		frame := buffers.get(size)
+		n, err := io.ReadFull(src, frame)
+		if err == io.EOF {
+			buffers.put(frame)
+			return nil, 0, io.EOF
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, n, err
+		}
+		return frame[:n], n, nil
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,83 @@
+package cypher
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// positionedWriter lets the workers write their chunks themselves at their
+// offsets, with WriteAt, instead of handing them to a writer that buffers
+// those finished out of order
+type positionedWriter struct {
+	w      io.WriterAt
+	seeker io.Seeker
+	// base is the offset of the first chunk
+	base    int64
+	written atomic.Int64
+
+	// mu orders the progress updates of the workers
+	mu        sync.Mutex
+	progress  *progressTracker
+	processed int64
+}
+
+// newPositionedWriter returns the positioned writer of op on dst, or nil
+// when the output size of the chunks of op isn't known in advance or dst
+// can't write at an offset, such as a pipe
+func newPositionedWriter(op *operation, dst io.Writer, progress *progressTracker) *positionedWriter {
+	if op.outputSize == nil || len(op.stagesBefore) > 0 || len(op.stagesAfter) > 0 ||
+		op.holeWriter != nil || op.written != nil || op.committed != nil || op.trailer != nil {
+		return nil
+	}
+	w, ok := dst.(io.WriterAt)
+	seeker, seekable := dst.(io.Seeker)
+	if !ok || !seekable {
+		return nil
+	}
+	// The header was written at the current offset, the chunks follow it
+	base, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	return &positionedWriter{w: w, seeker: seeker, base: base, progress: progress}
+}
+
+// write writes chunk at its offset
+func (p *positionedWriter) write(chunk DataChunk) error {
+	if _, err := p.w.WriteAt(chunk.data, p.base+chunk.offset); err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	p.written.Add(int64(len(chunk.data)))
+	p.mu.Lock()
+	p.processed += int64(chunk.size)
+	p.progress.add(chunk.size)
+	p.mu.Unlock()
+	return nil
+}
+
+// writtenBytes returns the bytes the workers wrote, 0 for a nil writer
+func (p *positionedWriter) writtenBytes() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.written.Load()
+}
+
+// processedBytes returns the input bytes whose output op wrote
+func (op *operation) processedBytes() int64 {
+	if op.positioned == nil {
+		return op.processed
+	}
+	op.positioned.mu.Lock()
+	defer op.positioned.mu.Unlock()
+	return op.processed + op.positioned.processed
+}
+
+// finish moves the offset of the output after the last chunk, as writing
+// them in order would have
+func (p *positionedWriter) finish() error {
+	_, err := p.seeker.Seek(p.base+p.written.Load(), io.SeekStart)
+	return err
+}
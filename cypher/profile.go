@@ -0,0 +1,109 @@
+package cypher
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Profile bundles the key derivation cost, cipher, chunk size and
+// passphrase requirements of a security/performance trade-off, see
+// NewCypherWithProfile
+type Profile int
+
+const (
+	// Fast derives keys in a few milliseconds and uses large chunks, for
+	// bulk data protected by strong machine generated passphrases
+	Fast Profile = iota
+	// Balanced suits most uses: a key derivation costing about a tenth of a
+	// second and 64 MB, and passphrases of at least fair strength
+	Balanced
+	// Paranoid makes every passphrase guess cost 256 MB and several
+	// Argon2id passes, needs a very strong passphrase and keeps the key out
+	// of swap
+	Paranoid
+)
+
+func (p Profile) String() string {
+	switch p {
+	case Fast:
+		return "fast"
+	case Balanced:
+		return "balanced"
+	case Paranoid:
+		return "paranoid"
+	}
+	return fmt.Sprintf("profile(%d)", int(p))
+}
+
+// profileSettings are the settings a Profile stands for
+type profileSettings struct {
+	// Argon2id cost: passes, memory in KiB and lanes
+	time, memory uint32
+	threads      uint8
+	algorithm    Algorithm
+	chunkSize    int
+	// minStrength is the minimum passphrase score
+	minStrength int
+	lockMemory  bool
+}
+
+var profiles = map[Profile]profileSettings{
+	Fast:     {time: 1, memory: 16 * 1024, threads: 4, algorithm: AlgorithmAuto, chunkSize: 16 * 1024 * 1024},
+	Balanced: {time: 3, memory: 64 * 1024, threads: 4, algorithm: AlgorithmAuto, chunkSize: 10 * 1024 * 1024, minStrength: 2},
+	Paranoid: {time: 4, memory: 256 * 1024, threads: 4, algorithm: AlgorithmAuto, chunkSize: 1024 * 1024, minStrength: 4, lockMemory: true},
+}
+
+// ProfileSaltSize is the size of the salts of NewProfileSalt, and the
+// minimum NewCypherWithProfile accepts
+const ProfileSaltSize = 16
+
+// NewProfileSalt returns a random salt for NewCypherWithProfile. Generate one
+// per profile and store it with it: the key can't be derived again without it.
+func NewProfileSalt() ([]byte, error) {
+	salt := make([]byte, ProfileSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return salt, nil
+}
+
+// NewCypherWithProfile derives the key from passphrase and salt, see
+// NewProfileSalt, with Argon2id at the cost of profile and applies its
+// settings, then opts. Data must be decrypted with the profile and salt it
+// was encrypted with, another profile derives another key. It fails with
+// ErrWeakPassphrase when the passphrase is weaker than the profile requires.
+func NewCypherWithProfile(passphrase string, profile Profile, salt []byte, opts ...Option) (*Cypher, error) {
+	if passphrase == "" {
+		return nil, ErrEmptyKey
+	}
+	settings, ok := profiles[profile]
+	if !ok {
+		return nil, &ConfigError{Field: "profile", Value: profile, Reason: "unknown profile"}
+	}
+	if len(salt) < ProfileSaltSize {
+		return nil, &ConfigError{Field: "salt", Value: len(salt), Reason: fmt.Sprintf("must be at least %d bytes", ProfileSaltSize)}
+	}
+
+	key := argon2.IDKey([]byte(passphrase), salt, settings.time, settings.memory, settings.threads, KeySize)
+	strength := EstimatePassphraseStrength(passphrase)
+
+	profileOpts := []Option{
+		WithAlgorithm(settings.algorithm),
+		WithChunkSize(settings.chunkSize),
+		WithMinPassphraseStrength(settings.minStrength),
+	}
+	if settings.lockMemory {
+		profileOpts = append(profileOpts, WithLockedMemory())
+	}
+	c := newCypher(key, &strength, append(profileOpts, opts...)...)
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,80 @@
+package cypher
+
+import "time"
+
+// Progress is delivered to the progress callback after every chunk is written
+type Progress struct {
+	Op         string
+	BytesDone  int64
+	BytesTotal int64 // 0 when the input size is unknown
+	Elapsed    time.Duration
+	// Throughput is the smoothed input rate in bytes per second
+	Throughput float64
+	// ETA is the estimated time remaining, 0 when it can't be estimated
+	ETA time.Duration
+}
+
+// Percent returns the completed fraction in the range [0, 100], or 0 when
+// the total is unknown
+func (p Progress) Percent() float64 {
+	if p.BytesTotal <= 0 {
+		return 0
+	}
+	return float64(p.BytesDone) / float64(p.BytesTotal) * 100
+}
+
+// ProgressFunc receives progress updates. It is called from the pipeline's
+// writer goroutine, or by one worker at a time when they write their chunks
+// to a file themselves, so it should return quickly.
+type ProgressFunc func(Progress)
+
+// progressSmoothing is the weight given to the newest rate sample
+const progressSmoothing = 0.3
+
+// progressTracker turns chunk completions into smoothed Progress updates
+type progressTracker struct {
+	op        string
+	total     int64
+	done      int64
+	startTime time.Time
+	lastTime  time.Time
+	rate      float64
+	callback  ProgressFunc
+}
+
+func newProgressTracker(op string, total int64, callback ProgressFunc) *progressTracker {
+	now := time.Now()
+	return &progressTracker{op: op, total: total, startTime: now, lastTime: now, callback: callback}
+}
+
+func (p *progressTracker) add(n int) {
+	if p == nil {
+		return
+	}
+
+	now := time.Now()
+	p.done += int64(n)
+
+	// Exponentially weighted moving average of the instantaneous rate
+	if interval := now.Sub(p.lastTime).Seconds(); interval > 0 {
+		sample := float64(n) / interval
+		if p.rate == 0 {
+			p.rate = sample
+		} else {
+			p.rate = progressSmoothing*sample + (1-progressSmoothing)*p.rate
+		}
+	}
+	p.lastTime = now
+
+	progress := Progress{
+		Op:         p.op,
+		BytesDone:  p.done,
+		BytesTotal: p.total,
+		Elapsed:    now.Sub(p.startTime),
+		Throughput: p.rate,
+	}
+	if p.total > 0 && p.rate > 0 && p.done < p.total {
+		progress.ETA = time.Duration(float64(p.total-p.done) / p.rate * float64(time.Second))
+	}
+	p.callback(progress)
+}
@@ -0,0 +1,73 @@
+// Package promcypher exposes cypher pipeline measurements as Prometheus metrics.
+package promcypher
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics implements cypher.Metrics on top of Prometheus collectors
+type Metrics struct {
+	bytes         *prometheus.CounterVec
+	chunks        *prometheus.CounterVec
+	chunkDuration *prometheus.HistogramVec
+	busyWorkers   *prometheus.GaugeVec
+	errors        *prometheus.CounterVec
+}
+
+// New creates the collectors and registers them with reg
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gocypher",
+			Name:      "bytes_total",
+			Help:      "Input bytes processed, by operation.",
+		}, []string{"op"}),
+		chunks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gocypher",
+			Name:      "chunks_total",
+			Help:      "Chunks processed, by operation.",
+		}, []string{"op"}),
+		chunkDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "gocypher",
+			Name:      "chunk_duration_seconds",
+			Help:      "Time spent encrypting or decrypting a single chunk.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"op"}),
+		busyWorkers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "gocypher",
+			Name:      "busy_workers",
+			Help:      "Workers currently transforming a chunk, by operation.",
+		}, []string{"op"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gocypher",
+			Name:      "errors_total",
+			Help:      "Failed operations, by operation and failing stage.",
+		}, []string{"op", "kind"}),
+	}
+
+	for _, collector := range []prometheus.Collector{m.bytes, m.chunks, m.chunkDuration, m.busyWorkers, m.errors} {
+		if err := reg.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Metrics) AddBytes(op string, n int64) {
+	m.bytes.WithLabelValues(op).Add(float64(n))
+}
+
+func (m *Metrics) ObserveChunk(op string, size int, d time.Duration) {
+	m.chunks.WithLabelValues(op).Inc()
+	m.chunkDuration.WithLabelValues(op).Observe(d.Seconds())
+}
+
+func (m *Metrics) AddBusyWorkers(op string, delta int) {
+	m.busyWorkers.WithLabelValues(op).Add(float64(delta))
+}
+
+func (m *Metrics) CountError(op, kind string) {
+	m.errors.WithLabelValues(op, kind).Inc()
+}
@@ -0,0 +1,43 @@
+package promcypher
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	if err != nil {
+		t.Fatalf("Failed to create metrics: %v", err)
+	}
+
+	c := cypher.NewCypher("test-key").WithChunkSize(16).WithMetrics(m)
+	encrypted, err := c.Encrypt(make([]byte, 40))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	if got := testutil.ToFloat64(m.bytes.WithLabelValues("encrypt")); got != 40 {
+		t.Errorf("Expected 40 encrypted bytes, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.chunks.WithLabelValues("encrypt")); got != 3 {
+		t.Errorf("Expected 3 encrypted chunks, got %v", got)
+	}
+
+	h, err := cypher.ParseHeader(bytes.NewReader(encrypted), cypher.ParseStrict)
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	encrypted[len(h.Raw)+cypher.FrameLengthSize] ^= 0xff
+	if _, err := c.Decrypt(encrypted); err == nil {
+		t.Fatal("Expected error decrypting tampered data, got nil")
+	}
+	if got := testutil.ToFloat64(m.errors.WithLabelValues("decrypt", cypher.ErrorKindCrypto)); got != 1 {
+		t.Errorf("Expected 1 decrypt crypto error, got %v", got)
+	}
+}
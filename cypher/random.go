@@ -0,0 +1,53 @@
+package cypher
+
+import (
+	"crypto/rand"
+	"io"
+	"sync"
+)
+
+// WithRandReader makes encryption read salts and nonces from r instead of
+// crypto/rand, such as the DRBG of an HSM, or a seeded source in tests that
+// need reproducible output; nil restores crypto/rand. r is read from many
+// workers, it doesn't have to be safe for concurrent use. With more than one
+// worker chunks take their nonces in the order workers reach them, so
+// reproducible output also needs WithNumWorkers(1). A predictable r makes
+// the output predictable: never use one outside tests.
+func (c *Cypher) WithRandReader(r io.Reader) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.randReader = nil
+	if r != nil {
+		c.randReader = &lockedReader{r: r}
+	}
+	return c
+}
+
+// random returns the source of the salts and nonces of c
+func (c Cypher) random() io.Reader {
+	if c.randReader == nil {
+		return rand.Reader
+	}
+	return c.randReader
+}
+
+// randomSource returns the source of the random nonces of op
+func (op *operation) randomSource() io.Reader {
+	if op.random == nil {
+		return rand.Reader
+	}
+	return op.random
+}
+
+// lockedReader serializes the reads of r
+type lockedReader struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+func (l *lockedReader) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Read(p)
+}
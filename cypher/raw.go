@@ -0,0 +1,56 @@
+package cypher
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrRawTooShort is returned by RawOpen for data shorter than a nonce and a
+// tag
+var ErrRawTooShort = errors.New("raw sealed data is too short")
+
+// RawSeal encrypts a small payload as a single AES-256-GCM message, nonce ||
+// ciphertext || tag, with a random 12 bytes nonce and no header or chunk
+// framing. It interoperates with the single-shot AES-GCM of other libraries
+// sharing the key, such as one passed to NewCypherFromKey, but has none of the
+// features of Encrypt and always uses AES-256-GCM. additionalData is
+// authenticated but not encrypted, pass nil when unused.
+func (c Cypher) RawSeal(plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := c.newGCM()
+	if err != nil {
+		return nil, err
+	}
+	usage, err := c.usage()
+	if err != nil {
+		return nil, err
+	}
+	if usage != nil {
+		if err := usage.add(Usage{Bytes: uint64(len(plaintext)), Chunks: 1, Operations: 1}); err != nil {
+			return nil, err
+		}
+	}
+	sealed := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := io.ReadFull(c.random(), sealed); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(sealed, sealed, plaintext, additionalData), nil
+}
+
+// RawOpen decrypts a message sealed by RawSeal or another AES-256-GCM
+// implementation using the nonce || ciphertext || tag layout
+func (c Cypher) RawOpen(sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := c.newGCM()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrRawTooShort
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
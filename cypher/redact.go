@@ -0,0 +1,61 @@
+package cypher
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// String describes c by its key fingerprint, the key itself is never printed
+func (c Cypher) String() string {
+	return fmt.Sprintf("Cypher(key=%s, chunk_size=%d, workers=%d)", c.redactedKey(), c.ChunkSize, c.NumWorkers)
+}
+
+// GoString is like String for the %#v verb
+func (c Cypher) GoString() string {
+	return fmt.Sprintf("cypher.Cypher{key:%q, ChunkSize:%d, NumWorkers:%d, NumCores:%d}",
+		c.redactedKey(), c.ChunkSize, c.NumWorkers, c.NumCores)
+}
+
+// Format makes every fmt verb, including %+v and %x, print the redacted form
+// instead of walking the struct fields
+func (c Cypher) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('#'):
+		fmt.Fprint(f, c.GoString())
+	case verb == 'q':
+		fmt.Fprintf(f, "%q", c.String())
+	default:
+		fmt.Fprint(f, c.String())
+	}
+}
+
+// LogValue keeps slog handlers from reflecting over the struct
+func (c Cypher) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("key", c.redactedKey()),
+		slog.Int("chunk_size", c.ChunkSize),
+		slog.Int("workers", c.NumWorkers),
+	)
+}
+
+func (c Cypher) redactedKey() string {
+	if c.key == nil {
+		return "none"
+	}
+	return c.key.String()
+}
+
+// String returns a placeholder holding the fingerprint of k
+func (k *keyMaterial) String() string {
+	return "redacted:" + k.getFingerprint()
+}
+
+// GoString is like String for the %#v verb
+func (k *keyMaterial) GoString() string {
+	return k.String()
+}
+
+// Format prints the placeholder for every verb
+func (k *keyMaterial) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, k.String())
+}
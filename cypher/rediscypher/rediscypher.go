@@ -0,0 +1,217 @@
+// Package rediscypher wraps go-redis commands so values are encrypted before
+// they are sent to Redis and decrypted when they are read back.
+//
+// Every value is prefixed with the ID of the key that sealed it, so keys can
+// be rotated: values written under an old key stay readable as long as it is
+// registered with WithDecryptKey, and Reencrypt moves them to the current
+// key. Values are bound to the Redis key (and hash field) they were written
+// to, so they can't be swapped. Hash field names can be replaced by a blind
+// index, an HMAC that allows lookups without revealing the name.
+package rediscypher
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrUnknownKey is returned for values sealed by a key that isn't
+	// registered
+	ErrUnknownKey = errors.New("value was encrypted with an unknown key")
+	// ErrTampered is returned for values that fail to decrypt or were
+	// written to another key or field
+	ErrTampered = errors.New("value has been tampered with")
+)
+
+// Client encrypts the values of the Redis commands it wraps. It is safe for
+// concurrent use.
+type Client struct {
+	rdb     redis.Cmdable
+	keyID   string
+	current *cypher.Cypher
+	keys    map[string]*cypher.Cypher
+	// indexKey hashes field names when the blind index is enabled
+	indexKey []byte
+}
+
+// Option configures a Client
+type Option func(*Client) error
+
+// WithDecryptKey makes values sealed by c under id readable, such as the
+// key used before a rotation
+func WithDecryptKey(id string, c *cypher.Cypher) Option {
+	return func(cl *Client) error {
+		cl.keys[id] = c
+		return nil
+	}
+}
+
+// WithBlindIndex replaces hash field names by their HMAC under a secret
+// derived from c. Keep c when rotating the value keys, or the fields
+// written before can't be found anymore.
+func WithBlindIndex(c *cypher.Cypher) Option {
+	return func(cl *Client) error {
+		key, err := c.DeriveSecret("redis blind index")
+		if err != nil {
+			return err
+		}
+		cl.indexKey = key
+		return nil
+	}
+}
+
+// New returns a client sending commands to rdb and encrypting values with c,
+// recorded under keyID
+func New(rdb redis.Cmdable, keyID string, c *cypher.Cypher, opts ...Option) (*Client, error) {
+	if keyID == "" || bytes.IndexByte([]byte(keyID), ':') >= 0 {
+		return nil, fmt.Errorf("invalid key ID %q: must be non-empty and not contain ':'", keyID)
+	}
+	cl := &Client{rdb: rdb, keyID: keyID, current: c, keys: map[string]*cypher.Cypher{keyID: c}}
+	for _, opt := range opts {
+		if err := opt(cl); err != nil {
+			return nil, err
+		}
+	}
+	return cl, nil
+}
+
+// Field returns the name field is stored under, its blind index when
+// enabled
+func (cl *Client) Field(field string) string {
+	if cl.indexKey == nil {
+		return field
+	}
+	mac := hmac.New(sha256.New, cl.indexKey)
+	mac.Write([]byte(field))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// location identifies where a value is stored, it prefixes the plaintext so
+// values can't be moved
+func location(key, field string) []byte {
+	loc := binary.BigEndian.AppendUint32(nil, uint32(len(key)))
+	loc = append(loc, key...)
+	loc = binary.BigEndian.AppendUint32(loc, uint32(len(field)))
+	return append(loc, field...)
+}
+
+// seal returns value encrypted with the current key for key and field
+func (cl *Client) seal(key, field string, value []byte) ([]byte, error) {
+	sealed, err := cl.current.Encrypt(append(location(key, field), value...))
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(cl.keyID+":"), sealed...), nil
+}
+
+// open decrypts a value read from key and field
+func (cl *Client) open(key, field string, stored []byte) (value []byte, keyID string, err error) {
+	id, sealed, ok := bytes.Cut(stored, []byte(":"))
+	if !ok {
+		return nil, "", ErrTampered
+	}
+	c, ok := cl.keys[string(id)]
+	if !ok {
+		return nil, "", fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	plain, err := c.Decrypt(sealed)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrTampered, err)
+	}
+	loc := location(key, field)
+	if !bytes.HasPrefix(plain, loc) {
+		return nil, "", ErrTampered
+	}
+	return plain[len(loc):], string(id), nil
+}
+
+// Set encrypts value and stores it under key, see redis.Cmdable.Set
+func (cl *Client) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	sealed, err := cl.seal(key, "", value)
+	if err != nil {
+		return err
+	}
+	return cl.rdb.Set(ctx, key, sealed, expiration).Err()
+}
+
+// Get returns the decrypted value of key, or redis.Nil when it doesn't
+// exist
+func (cl *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	stored, err := cl.rdb.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	value, _, err := cl.open(key, "", stored)
+	return value, err
+}
+
+// HSet encrypts value and stores it in field of the hash key
+func (cl *Client) HSet(ctx context.Context, key, field string, value []byte) error {
+	sealed, err := cl.seal(key, field, value)
+	if err != nil {
+		return err
+	}
+	return cl.rdb.HSet(ctx, key, cl.Field(field), sealed).Err()
+}
+
+// HGet returns the decrypted value of field in the hash key, or redis.Nil
+// when it doesn't exist
+func (cl *Client) HGet(ctx context.Context, key, field string) ([]byte, error) {
+	stored, err := cl.rdb.HGet(ctx, key, cl.Field(field)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	value, _, err := cl.open(key, field, stored)
+	return value, err
+}
+
+// HDel removes fields from the hash key
+func (cl *Client) HDel(ctx context.Context, key string, fields ...string) error {
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = cl.Field(field)
+	}
+	return cl.rdb.HDel(ctx, key, names...).Err()
+}
+
+// Reencrypt seals the value of key again with the current key when an older
+// one wrote it, keeping its expiry. It reports whether the value changed.
+func (cl *Client) Reencrypt(ctx context.Context, key string) (bool, error) {
+	stored, err := cl.rdb.Get(ctx, key).Bytes()
+	if err != nil {
+		return false, err
+	}
+	value, keyID, err := cl.open(key, "", stored)
+	if err != nil || keyID == cl.keyID {
+		return false, err
+	}
+	sealed, err := cl.seal(key, "", value)
+	if err != nil {
+		return false, err
+	}
+	// Values written meanwhile are kept
+	err = swapScript.Run(ctx, cl.rdb, []string{key}, stored, sealed).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// swapScript replaces the value of KEYS[1] by ARGV[2] if it still is
+// ARGV[1], keeping its expiry
+var swapScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("SET", KEYS[1], ARGV[2], "KEEPTTL")
+end
+return false
+`)
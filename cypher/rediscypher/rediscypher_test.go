@@ -0,0 +1,71 @@
+package rediscypher
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nikola43/gocypher/cypher"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer rdb.Close()
+
+	oldKey := cypher.NewCypher("old-key")
+	old, err := New(rdb, "k1", oldKey)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := old.Set(ctx, "session", []byte("alice"), time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if raw, _ := server.Get("session"); !strings.HasPrefix(raw, "k1:") || strings.Contains(raw, "alice") {
+		t.Errorf("Expected an encrypted value with its key ID, got %q", raw)
+	}
+
+	// After a rotation old values stay readable and can be moved over
+	index := cypher.NewCypher("index-key")
+	cl, err := New(rdb, "k2", cypher.NewCypher("new-key"), WithDecryptKey("k1", oldKey), WithBlindIndex(index))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if value, err := cl.Get(ctx, "session"); err != nil || string(value) != "alice" {
+		t.Fatalf("Expected the old value, got %q: %v", value, err)
+	}
+	if changed, err := cl.Reencrypt(ctx, "session"); err != nil || !changed {
+		t.Fatalf("Expected Reencrypt to rewrap the value, got %v: %v", changed, err)
+	}
+	if raw, _ := server.Get("session"); !strings.HasPrefix(raw, "k2:") || server.TTL("session") != time.Hour {
+		t.Errorf("Expected the value under k2 with its TTL, got %q and %v", raw, server.TTL("session"))
+	}
+	if _, err := old.Get(ctx, "session"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+
+	if err := cl.HSet(ctx, "user:1", "email", []byte("alice@example.com")); err != nil {
+		t.Fatalf("HSet failed: %v", err)
+	}
+	if fields, _ := server.HKeys("user:1"); len(fields) != 1 || fields[0] == "email" {
+		t.Errorf("Expected a blind index field name, got %q", fields)
+	}
+	if value, err := cl.HGet(ctx, "user:1", "email"); err != nil || string(value) != "alice@example.com" {
+		t.Errorf("Expected the email, got %q: %v", value, err)
+	}
+
+	// Values can't be copied to another key
+	raw, _ := server.Get("session")
+	server.Set("other", raw)
+	if _, err := cl.Get(ctx, "other"); !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected ErrTampered, got %v", err)
+	}
+	if _, err := cl.Get(ctx, "missing"); !errors.Is(err, redis.Nil) {
+		t.Errorf("Expected redis.Nil, got %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package saltpack
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+)
+
+// The saltpack armor encodes messages in base62 blocks of 32 bytes, split
+// into words of 15 characters and lines of 200 words, framed by a header and
+// footer
+
+const (
+	alphabet      = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	blockSize     = 32
+	wordSize      = 15
+	wordsPerLine  = 200
+	messageHeader = "BEGIN SALTPACK ENCRYPTED MESSAGE"
+	messageFooter = "END SALTPACK ENCRYPTED MESSAGE"
+)
+
+// EncryptArmored is like Encrypt and writes the armored form of the message
+func EncryptArmored(dst io.Writer, src io.Reader, sender *Keypair, recipients [][32]byte) error {
+	w := &armorWriter{dst: dst}
+	if _, err := io.WriteString(dst, messageHeader+". "); err != nil {
+		return err
+	}
+	if err := Encrypt(w, src, sender, recipients); err != nil {
+		return err
+	}
+	return w.close()
+}
+
+// DecryptArmored is like Decrypt for armored messages, which are read whole
+// into memory. Messages with a brand, such as "BEGIN KEYBASE SALTPACK
+// ENCRYPTED MESSAGE.", are accepted.
+func DecryptArmored(dst io.Writer, src io.Reader, keys []*Keypair) (*MessageInfo, error) {
+	armored, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	data, err := dearmor(string(armored))
+	if err != nil {
+		return nil, err
+	}
+	return Decrypt(dst, bytes.NewReader(data), keys)
+}
+
+// charsFor returns the number of base62 characters encoding n bytes
+func charsFor(n int) int {
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(8*n))
+	chars, power := 0, big.NewInt(1)
+	for power.Cmp(limit) < 0 {
+		power.Mul(power, big.NewInt(62))
+		chars++
+	}
+	return chars
+}
+
+// bytesFor returns the number of bytes encoded by c base62 characters
+func bytesFor(c int) int {
+	limit := new(big.Int).Exp(big.NewInt(62), big.NewInt(int64(c)), nil)
+	n := 0
+	for new(big.Int).Lsh(big.NewInt(1), uint(8*(n+1))).Cmp(limit) <= 0 {
+		n++
+	}
+	return n
+}
+
+func encodeBlock(block []byte) string {
+	value := new(big.Int).SetBytes(block)
+	chars := make([]byte, charsFor(len(block)))
+	base, digit := big.NewInt(62), new(big.Int)
+	for i := len(chars) - 1; i >= 0; i-- {
+		value.DivMod(value, base, digit)
+		chars[i] = alphabet[digit.Int64()]
+	}
+	return string(chars)
+}
+
+func decodeBlock(chars string) ([]byte, error) {
+	value, base := new(big.Int), big.NewInt(62)
+	for i := 0; i < len(chars); i++ {
+		digit := strings.IndexByte(alphabet, chars[i])
+		if digit < 0 {
+			return nil, fmt.Errorf("%w: invalid armor character %q", ErrInvalidMessage, chars[i])
+		}
+		value.Mul(value, base).Add(value, big.NewInt(int64(digit)))
+	}
+	n := bytesFor(len(chars))
+	if value.BitLen() > 8*n {
+		return nil, fmt.Errorf("%w: invalid armor block", ErrInvalidMessage)
+	}
+	return value.FillBytes(make([]byte, n)), nil
+}
+
+// armorWriter armors the bytes written to it
+type armorWriter struct {
+	dst     io.Writer
+	pending []byte
+	words   int
+	chars   int
+	err     error
+}
+
+func (w *armorWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for len(w.pending) >= blockSize {
+		w.writeChars(encodeBlock(w.pending[:blockSize]))
+		w.pending = w.pending[blockSize:]
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+// writeChars splits chars into words and lines
+func (w *armorWriter) writeChars(chars string) {
+	var b strings.Builder
+	for i := 0; i < len(chars); i++ {
+		if w.chars == wordSize {
+			w.chars = 0
+			w.words++
+			if w.words == wordsPerLine {
+				w.words = 0
+				b.WriteByte('\n')
+			} else {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteByte(chars[i])
+		w.chars++
+	}
+	if w.err == nil {
+		_, w.err = io.WriteString(w.dst, b.String())
+	}
+}
+
+func (w *armorWriter) close() error {
+	if len(w.pending) > 0 {
+		w.writeChars(encodeBlock(w.pending))
+	}
+	if w.err != nil {
+		return w.err
+	}
+	_, err := io.WriteString(w.dst, ". "+messageFooter+".\n")
+	return err
+}
+
+// dearmor returns the message framed in armored
+func dearmor(armored string) ([]byte, error) {
+	parts := strings.SplitN(armored, ".", 4)
+	if len(parts) < 3 || !validFrame(parts[0], "BEGIN") || !validFrame(parts[2], "END") {
+		return nil, fmt.Errorf("%w: missing armor header or footer", ErrInvalidMessage)
+	}
+	if strings.Join(strings.Fields(parts[0])[1:], " ") != strings.Join(strings.Fields(parts[2])[1:], " ") {
+		return nil, fmt.Errorf("%w: armor header and footer don't match", ErrInvalidMessage)
+	}
+	chars := strings.Join(strings.Fields(strings.ReplaceAll(parts[1], ">", " ")), "")
+	var data []byte
+	for len(chars) > 0 {
+		size := min(len(chars), charsFor(blockSize))
+		block, err := decodeBlock(chars[:size])
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, block...)
+		chars = chars[size:]
+	}
+	return data, nil
+}
+
+// validFrame reports whether frame is the header or footer of an encrypted
+// message, with an optional brand
+func validFrame(frame, begin string) bool {
+	words := strings.Fields(strings.ReplaceAll(frame, ">", " "))
+	switch len(words) {
+	case 4:
+		return words[0] == begin && strings.Join(words[1:], " ") == "SALTPACK ENCRYPTED MESSAGE"
+	case 5:
+		return words[0] == begin && strings.Join(words[2:], " ") == "SALTPACK ENCRYPTED MESSAGE"
+	}
+	return false
+}
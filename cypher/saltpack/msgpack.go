@@ -0,0 +1,175 @@
+package saltpack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The subset of MessagePack saltpack messages are made of
+
+var errMsgpack = errors.New("invalid saltpack message encoding")
+
+// maxMsgpackLength bounds the length of decoded arrays and byte strings,
+// payload packets hold at most a chunk and its authenticators
+const maxMsgpackLength = 2 * chunkSize
+
+type encoder []byte
+
+func (e *encoder) array(n int) {
+	switch {
+	case n < 16:
+		*e = append(*e, 0x90|byte(n))
+	default:
+		*e = append(*e, 0xdc)
+		*e = binary.BigEndian.AppendUint16(*e, uint16(n))
+	}
+}
+
+func (e *encoder) bin(b []byte) {
+	switch {
+	case len(b) < 1<<8:
+		*e = append(*e, 0xc4, byte(len(b)))
+	case len(b) < 1<<16:
+		*e = append(*e, 0xc5)
+		*e = binary.BigEndian.AppendUint16(*e, uint16(len(b)))
+	default:
+		*e = append(*e, 0xc6)
+		*e = binary.BigEndian.AppendUint32(*e, uint32(len(b)))
+	}
+	*e = append(*e, b...)
+}
+
+func (e *encoder) str(s string) {
+	*e = append(*e, 0xa0|byte(len(s)))
+	*e = append(*e, s...)
+}
+
+// uint encodes small integers
+func (e *encoder) uint(n int) {
+	*e = append(*e, byte(n))
+}
+
+func (e *encoder) bool(b bool) {
+	if b {
+		*e = append(*e, 0xc3)
+	} else {
+		*e = append(*e, 0xc2)
+	}
+}
+
+// decode reads the next value of r: a []any, []byte for strings and byte
+// strings, int64, bool or nil
+func decode(r *bufio.Reader) (any, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x90:
+		return decodeArray(r, int(b&0x0f))
+	case b&0xe0 == 0xa0:
+		return decodeBytes(r, int(b&0x1f))
+	}
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xd9:
+		n, err := readUint(r, 1)
+		if err != nil {
+			return nil, err
+		}
+		return decodeBytes(r, int(n))
+	case 0xc5, 0xda:
+		n, err := readUint(r, 2)
+		if err != nil {
+			return nil, err
+		}
+		return decodeBytes(r, int(n))
+	case 0xc6, 0xdb:
+		n, err := readUint(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		return decodeBytes(r, int(n))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := readUint(r, 1<<(b-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return int64(n), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := readUint(r, size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign extend from size bytes
+		shift := 64 - 8*size
+		return int64(n<<shift) >> shift, nil
+	case 0xdc:
+		n, err := readUint(r, 2)
+		if err != nil {
+			return nil, err
+		}
+		return decodeArray(r, int(n))
+	case 0xdd:
+		n, err := readUint(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		return decodeArray(r, int(n))
+	}
+	return nil, fmt.Errorf("%w: unsupported type 0x%02x", errMsgpack, b)
+}
+
+func readUint(r *bufio.Reader, size int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[8-size:]); err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func decodeArray(r *bufio.Reader, n int) ([]any, error) {
+	if n > maxMsgpackLength {
+		return nil, errMsgpack
+	}
+	values := make([]any, n)
+	for i := range values {
+		var err error
+		if values[i], err = decode(r); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	return values, nil
+}
+
+func decodeBytes(r *bufio.Reader, n int) ([]byte, error) {
+	if n > maxMsgpackLength {
+		return nil, errMsgpack
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return b, nil
+}
+
+// unexpectedEOF reports values cut short as ErrTruncated
+func unexpectedEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
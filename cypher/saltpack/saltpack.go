@@ -0,0 +1,305 @@
+// Package saltpack encrypts and decrypts messages in the saltpack encryption
+// format, version 2, binary or armored, as produced by Keybase and other
+// saltpack implementations.
+//
+// Keys are Curve25519 NaCl box keys. Messages are split into 1 MiB packets,
+// each authenticated for every recipient before its plaintext is released,
+// and truncated messages are detected.
+package saltpack
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+var (
+	// ErrNoKey is returned when none of the keys is a recipient
+	ErrNoKey = errors.New("no key matches a saltpack recipient")
+	// ErrInvalidMessage is returned for malformed headers and packets, and
+	// for packets failing authentication
+	ErrInvalidMessage = errors.New("invalid saltpack message")
+	// ErrTruncated is returned for messages that end before their final
+	// packet
+	ErrTruncated = errors.New("saltpack message is truncated")
+)
+
+const (
+	formatName    = "saltpack"
+	majorVersion  = 2
+	minorVersion  = 0
+	modeEncrypted = 0
+	chunkSize     = 1 << 20
+)
+
+// Keypair is a Curve25519 NaCl box key pair
+type Keypair struct {
+	Public  [32]byte
+	Private [32]byte
+}
+
+// GenerateKeypair returns a new random key pair
+func GenerateKeypair() (*Keypair, error) {
+	public, private, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key pair: %w", err)
+	}
+	return &Keypair{Public: *public, Private: *private}, nil
+}
+
+// MessageInfo describes a decrypted message
+type MessageInfo struct {
+	// Sender is the public key of the sender, unset when Anonymous
+	Sender    [32]byte
+	Anonymous bool
+	// Recipient is the key that decrypted the message
+	Recipient *Keypair
+}
+
+func nonce(prefix string, n uint64) *[24]byte {
+	var nonce [24]byte
+	copy(nonce[:], prefix)
+	binary.BigEndian.PutUint64(nonce[16:], n)
+	return &nonce
+}
+
+var senderKeyNonce = func() *[24]byte {
+	var nonce [24]byte
+	copy(nonce[:], "saltpack_sender_key_sbox")
+	return &nonce
+}()
+
+// macKey returns the MAC key of recipient index, private is the sender's
+// long-term or the recipient's key and ephemeralPrivate the ephemeral or
+// the recipient's key, public those of the other side
+func macKey(headerHash []byte, index uint64, public, private, ephemeralPublic, ephemeralPrivate *[32]byte) []byte {
+	var zeros [32]byte
+	n := nonce(string(headerHash[:16]), index)
+	n[15] &^= 1
+	long := box.Seal(nil, zeros[:], n, public, private)
+	n[15] |= 1
+	ephemeral := box.Seal(nil, zeros[:], n, ephemeralPublic, ephemeralPrivate)
+	sum := sha512.Sum512(append(long[len(long)-32:], ephemeral[len(ephemeral)-32:]...))
+	return sum[:32]
+}
+
+// authenticator returns the authenticator of a payload packet for macKey
+func authenticator(macKey, headerHash []byte, nonce *[24]byte, final bool, sealed []byte) []byte {
+	flag := byte(0)
+	if final {
+		flag = 1
+	}
+	h := sha512.New()
+	h.Write(headerHash)
+	h.Write(nonce[:])
+	h.Write([]byte{flag})
+	h.Write(sealed)
+	mac := hmac.New(sha512.New, macKey)
+	mac.Write(h.Sum(nil))
+	return mac.Sum(nil)[:32]
+}
+
+// Encrypt encrypts src for recipients into dst. A nil sender keeps the
+// sender anonymous.
+func Encrypt(dst io.Writer, src io.Reader, sender *Keypair, recipients [][32]byte) error {
+	if len(recipients) == 0 {
+		return errors.New("at least one recipient is required")
+	}
+	ephemeral, err := GenerateKeypair()
+	if err != nil {
+		return err
+	}
+	if sender == nil {
+		sender = ephemeral
+	}
+	var payloadKey [32]byte
+	if _, err := io.ReadFull(rand.Reader, payloadKey[:]); err != nil {
+		return fmt.Errorf("failed to generate payload key: %w", err)
+	}
+
+	var header encoder
+	header.array(6)
+	header.str(formatName)
+	header.array(2)
+	header.uint(majorVersion)
+	header.uint(minorVersion)
+	header.uint(modeEncrypted)
+	header.bin(ephemeral.Public[:])
+	header.bin(secretbox.Seal(nil, sender.Public[:], senderKeyNonce, &payloadKey))
+	header.array(len(recipients))
+	for i := range recipients {
+		header.array(2)
+		header.bin(recipients[i][:])
+		header.bin(box.Seal(nil, payloadKey[:], nonce("saltpack_recipsb", uint64(i)), &recipients[i], &ephemeral.Private))
+	}
+	headerHash := sha512.Sum512(header)
+	macKeys := make([][]byte, len(recipients))
+	for i := range recipients {
+		macKeys[i] = macKey(headerHash[:], uint64(i), &recipients[i], &sender.Private, &recipients[i], &ephemeral.Private)
+	}
+
+	// The header is encoded twice, as a byte string of its encoding
+	var out encoder
+	out.bin(header)
+	if _, err := dst.Write(out); err != nil {
+		return err
+	}
+
+	// Reading a byte more than a chunk tells whether it is the last one
+	chunk := make([]byte, chunkSize+1)
+	size, err := io.ReadFull(src, chunk)
+	for n := uint64(0); ; n++ {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		final := size <= chunkSize
+
+		packetNonce := nonce("saltpack_ploadsb", n)
+		sealed := secretbox.Seal(nil, chunk[:min(size, chunkSize)], packetNonce, &payloadKey)
+		out = out[:0]
+		out.array(3)
+		out.bool(final)
+		out.array(len(macKeys))
+		for _, key := range macKeys {
+			out.bin(authenticator(key, headerHash[:], packetNonce, final, sealed))
+		}
+		out.bin(sealed)
+		if _, err := dst.Write(out); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+		chunk[0] = chunk[chunkSize]
+		size, err = io.ReadFull(src, chunk[1:])
+		size++
+	}
+}
+
+// Decrypt decrypts a binary message from src into dst with the first of keys
+// that is a recipient
+func Decrypt(dst io.Writer, src io.Reader, keys []*Keypair) (*MessageInfo, error) {
+	r := bufio.NewReader(src)
+	value, err := decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+	headerBytes, ok := value.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: header is not a byte string", ErrInvalidMessage)
+	}
+	value, err = decode(bufio.NewReader(bytes.NewReader(headerBytes)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+	header, ok := value.([]any)
+	if !ok || len(header) < 6 {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidMessage)
+	}
+	name, _ := header[0].([]byte)
+	version, _ := header[1].([]any)
+	if string(name) != formatName || len(version) < 1 || version[0] != int64(majorVersion) || header[2] != int64(modeEncrypted) {
+		return nil, fmt.Errorf("%w: not a saltpack version %d encrypted message", ErrInvalidMessage, majorVersion)
+	}
+	ephemeralPublic, ok1 := key32(header[3])
+	senderBox, ok2 := header[4].([]byte)
+	recipients, ok3 := header[5].([]any)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidMessage)
+	}
+	headerHash := sha512.Sum512(headerBytes)
+
+	info, index, payloadKey, err := openPayloadKey(recipients, keys, ephemeralPublic)
+	if err != nil {
+		return nil, err
+	}
+	sender, ok := secretbox.Open(nil, senderBox, senderKeyNonce, payloadKey)
+	if !ok || len(sender) != 32 {
+		return nil, fmt.Errorf("%w: sender key", ErrInvalidMessage)
+	}
+	copy(info.Sender[:], sender)
+	if info.Sender == *ephemeralPublic {
+		info.Sender, info.Anonymous = [32]byte{}, true
+	}
+	mac := macKey(headerHash[:], uint64(index), (*[32]byte)(sender), &info.Recipient.Private, ephemeralPublic, &info.Recipient.Private)
+
+	for n := uint64(0); ; n++ {
+		value, err := decode(r)
+		if err == io.EOF {
+			return nil, ErrTruncated
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+		}
+		packet, ok := value.([]any)
+		if !ok || len(packet) < 3 {
+			return nil, fmt.Errorf("%w: malformed packet %d", ErrInvalidMessage, n)
+		}
+		final, ok1 := packet[0].(bool)
+		authenticators, ok2 := packet[1].([]any)
+		sealed, ok3 := packet[2].([]byte)
+		if !ok1 || !ok2 || !ok3 || len(authenticators) <= index {
+			return nil, fmt.Errorf("%w: malformed packet %d", ErrInvalidMessage, n)
+		}
+		auth, _ := authenticators[index].([]byte)
+		packetNonce := nonce("saltpack_ploadsb", n)
+		if subtle.ConstantTimeCompare(auth, authenticator(mac, headerHash[:], packetNonce, final, sealed)) != 1 {
+			return nil, fmt.Errorf("%w: packet %d failed authentication", ErrInvalidMessage, n)
+		}
+		plain, ok := secretbox.Open(nil, sealed, packetNonce, payloadKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: packet %d failed to decrypt", ErrInvalidMessage, n)
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return nil, err
+		}
+		if final {
+			if _, err := r.ReadByte(); err != io.EOF {
+				return nil, fmt.Errorf("%w: data after the final packet", ErrInvalidMessage)
+			}
+			return info, nil
+		}
+	}
+}
+
+// openPayloadKey finds the recipient entry of one of keys and opens the
+// payload key. Entries without a public key are anonymous recipients, every
+// key is tried on them.
+func openPayloadKey(recipients []any, keys []*Keypair, ephemeralPublic *[32]byte) (*MessageInfo, int, *[32]byte, error) {
+	for i, value := range recipients {
+		entry, ok := value.([]any)
+		if !ok || len(entry) < 2 {
+			return nil, 0, nil, fmt.Errorf("%w: malformed recipient", ErrInvalidMessage)
+		}
+		keyBox, _ := entry[1].([]byte)
+		public, named := key32(entry[0])
+		for _, key := range keys {
+			if named && *public != key.Public {
+				continue
+			}
+			payloadKey, ok := box.Open(nil, keyBox, nonce("saltpack_recipsb", uint64(i)), ephemeralPublic, &key.Private)
+			if ok && len(payloadKey) == 32 {
+				return &MessageInfo{Recipient: key}, i, (*[32]byte)(payloadKey), nil
+			}
+		}
+	}
+	return nil, 0, nil, ErrNoKey
+}
+
+func key32(value any) (*[32]byte, bool) {
+	b, ok := value.([]byte)
+	if !ok || len(b) != 32 {
+		return nil, false
+	}
+	return (*[32]byte)(b), true
+}
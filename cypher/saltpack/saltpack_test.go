@@ -0,0 +1,82 @@
+package saltpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	sender, _ := GenerateKeypair()
+	alice, _ := GenerateKeypair()
+	bob, _ := GenerateKeypair()
+	other, _ := GenerateKeypair()
+	plaintext := bytes.Repeat([]byte("saltpack "), chunkSize/4)
+
+	var sealed bytes.Buffer
+	if err := Encrypt(&sealed, bytes.NewReader(plaintext), sender, [][32]byte{alice.Public, bob.Public}); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	for _, key := range []*Keypair{alice, bob} {
+		var plain bytes.Buffer
+		info, err := Decrypt(&plain, bytes.NewReader(sealed.Bytes()), []*Keypair{other, key})
+		if err != nil {
+			t.Fatalf("Decrypt failed: %v", err)
+		}
+		if !bytes.Equal(plain.Bytes(), plaintext) || info.Sender != sender.Public || info.Anonymous || info.Recipient != key {
+			t.Errorf("Unexpected message %+v", info)
+		}
+	}
+
+	if _, err := Decrypt(&bytes.Buffer{}, bytes.NewReader(sealed.Bytes()), []*Keypair{other}); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Expected ErrNoKey, got %v", err)
+	}
+	if _, err := Decrypt(&bytes.Buffer{}, bytes.NewReader(sealed.Bytes()[:sealed.Len()-100]), []*Keypair{alice}); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+	tampered := append([]byte(nil), sealed.Bytes()...)
+	tampered[len(tampered)-10] ^= 1
+	if _, err := Decrypt(&bytes.Buffer{}, bytes.NewReader(tampered), []*Keypair{alice}); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("Expected ErrInvalidMessage, got %v", err)
+	}
+}
+
+func TestArmored(t *testing.T) {
+	alice, _ := GenerateKeypair()
+	var armored bytes.Buffer
+	if err := EncryptArmored(&armored, strings.NewReader("hello"), nil, [][32]byte{alice.Public}); err != nil {
+		t.Fatalf("EncryptArmored failed: %v", err)
+	}
+	if !strings.HasPrefix(armored.String(), "BEGIN SALTPACK ENCRYPTED MESSAGE. ") || !strings.HasSuffix(armored.String(), ". END SALTPACK ENCRYPTED MESSAGE.\n") {
+		t.Errorf("Unexpected armor %q", armored.String())
+	}
+	var plain bytes.Buffer
+	info, err := DecryptArmored(&plain, &armored, []*Keypair{alice})
+	if err != nil || plain.String() != "hello" || !info.Anonymous {
+		t.Errorf("Expected an anonymous hello, got %q %+v: %v", plain.String(), info, err)
+	}
+}
+
+// A message sealed by the Keybase implementation
+func TestKeybaseMessage(t *testing.T) {
+	key := &Keypair{}
+	hex.Decode(key.Public[:], []byte("18b598432e16c521f4deb58b9b160cfd51ebaaf22735c5129d0a0a8bf1e6fe3e"))
+	hex.Decode(key.Private[:], []byte("376e0d6ed1e8c05f1d16c6b55fd6b94f4d69259df64ad0d81a692051d9eacf57"))
+	armored := `BEGIN KEYBASE SALTPACK ENCRYPTED MESSAGE. keDIDMQWYvVR58B FTfTeD305xZSPoT
+scMVZSbGprbjvqE aVqxeycxw9Iuac1 iEkTc4XaMsZI4lf kiZN5tA0N0HUgHS LHRyMiWBMOpemHW
+DvzICQtBE5KC9rV 0RgyZgA0Hafjm1O FCgvy5TR4LKjvOs E2mEn7DFsxeYaul TPjwdmsbEo5S9ng
+zz7URv7yeLH0K3I Q7bFdx99eGYThXX LSjVsRb70QX8tyb PtG4fdHC46hvsuQ 7KrM02Ot0CMcct7
+adyal2y1TuiW9Ms qSqMhEsM02HwC00 tshO1921acEx0Dw K8AeQYEB4a4L8vR uIXWSO3yfcBjk65
+A0MB6ZY8QbyaTg0 nTIy. END KEYBASE SALTPACK ENCRYPTED MESSAGE.`
+
+	var plain bytes.Buffer
+	info, err := DecryptArmored(&plain, strings.NewReader(armored), []*Keypair{key})
+	if err != nil {
+		t.Fatalf("DecryptArmored failed: %v", err)
+	}
+	if plain.String() != "hello from keybase" || hex.EncodeToString(info.Sender[:]) != "18e92bb87855fa091b77582298edde677dc9814e6aa9eda2a43f5b1212f33d5f" {
+		t.Errorf("Unexpected message %q from %x", plain.String(), info.Sender)
+	}
+}
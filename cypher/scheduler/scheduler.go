@@ -0,0 +1,351 @@
+// Package scheduler queues encrypt and decrypt jobs and runs them within
+// global concurrency and memory budgets, highest priority first.
+package scheduler
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+var (
+	ErrClosed   = errors.New("scheduler is closed")
+	ErrCanceled = errors.New("job canceled")
+)
+
+// Op is the operation performed by a job
+type Op string
+
+const (
+	Encrypt Op = "encrypt"
+	Decrypt Op = "decrypt"
+)
+
+// Status is the lifecycle state of a job
+type Status string
+
+const (
+	Queued   Status = "queued"
+	Running  Status = "running"
+	Done     Status = "done"
+	Failed   Status = "failed"
+	Canceled Status = "canceled"
+)
+
+// Job is a single queued operation
+type Job struct {
+	cypher   *cypher.Cypher
+	id       string
+	op       Op
+	input    string
+	output   string
+	priority int
+	seq      uint64
+	memory   int64
+
+	mu       sync.Mutex
+	status   Status
+	progress cypher.Progress
+	result   *cypher.Result
+	err      error
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func (j *Job) ID() string    { return j.id }
+func (j *Job) Op() Op        { return j.op }
+func (j *Job) Input() string { return j.input }
+func (j *Job) Priority() int { return j.priority }
+
+func (j *Job) Status() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// Progress returns the latest progress update of a running job
+func (j *Job) Progress() cypher.Progress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.progress
+}
+
+// Err returns why a job failed or was canceled
+func (j *Job) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// Result returns the result of a finished job
+func (j *Job) Result() *cypher.Result {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.result
+}
+
+func (j *Job) setProgress(p cypher.Progress) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.progress = p
+}
+
+// Wait blocks until the job finishes or ctx is done
+func (j *Job) Wait(ctx context.Context) (*cypher.Result, error) {
+	select {
+	case <-j.done:
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		return j.result, j.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// finish records the outcome of the job, it returns false if the job had
+// already finished
+func (j *Job) finish(status Status, result *cypher.Result, err error) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.status == Done || j.status == Failed || j.status == Canceled {
+		return false
+	}
+	j.status, j.result, j.err = status, result, err
+	close(j.done)
+	return true
+}
+
+// Scheduler runs jobs with a shared Cypher
+type Scheduler struct {
+	cypher    *cypher.Cypher
+	maxJobs   int
+	maxMemory int64
+
+	mu         sync.Mutex
+	queue      jobQueue
+	jobs       map[string]*Job
+	running    int
+	memoryUsed int64
+	nextSeq    uint64
+	closed     bool
+	wg         sync.WaitGroup
+}
+
+type Option func(*Scheduler)
+
+// WithMaxJobs limits how many jobs run at the same time (default: NumCPU)
+func WithMaxJobs(n int) Option {
+	return func(s *Scheduler) { s.maxJobs = n }
+}
+
+// WithMaxMemory limits the estimated chunk buffer memory of all running jobs.
+// A job that alone exceeds the budget still runs, but only by itself.
+func WithMaxMemory(bytes int64) Option {
+	return func(s *Scheduler) { s.maxMemory = bytes }
+}
+
+// New creates a scheduler whose Submit runs jobs with c. c may be nil when
+// every job is submitted with SubmitWith.
+func New(c *cypher.Cypher, opts ...Option) *Scheduler {
+	s := &Scheduler{
+		cypher:  c,
+		maxJobs: runtime.NumCPU(),
+		jobs:    make(map[string]*Job),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Submit queues a job that reads input and writes output
+func (s *Scheduler) Submit(op Op, input, output string, priority int) (*Job, error) {
+	return s.SubmitWith(s.cypher, op, input, output, priority)
+}
+
+// SubmitWith is like Submit but runs the job with c instead of the
+// scheduler's Cypher, so jobs using different keys share the same budgets
+func (s *Scheduler) SubmitWith(c *cypher.Cypher, op Op, input, output string, priority int) (*Job, error) {
+	if op != Encrypt && op != Decrypt {
+		return nil, fmt.Errorf("unknown operation %q", op)
+	}
+	if c == nil {
+		return nil, errors.New("job has no cypher")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	s.nextSeq++
+	job := &Job{
+		cypher:   c,
+		id:       fmt.Sprintf("job-%d", s.nextSeq),
+		op:       op,
+		input:    input,
+		output:   output,
+		priority: priority,
+		seq:      s.nextSeq,
+		memory:   memoryEstimate(c),
+		status:   Queued,
+		done:     make(chan struct{}),
+	}
+	s.jobs[job.id] = job
+	heap.Push(&s.queue, job)
+	s.dispatch()
+	return job, nil
+}
+
+// Job returns the job with the given id
+func (s *Scheduler) Job(id string) (*Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	return job, ok
+}
+
+// Jobs returns every job submitted to the scheduler
+func (s *Scheduler) Jobs() []*Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// Cancel stops a queued or running job
+func (s *Scheduler) Cancel(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return false
+	}
+
+	switch job.Status() {
+	case Queued:
+		for i, queued := range s.queue {
+			if queued == job {
+				heap.Remove(&s.queue, i)
+				break
+			}
+		}
+		return job.finish(Canceled, nil, ErrCanceled)
+	case Running:
+		job.cancel()
+		return true
+	}
+	return false
+}
+
+// Close stops accepting jobs, cancels the queued ones and waits for the
+// running ones to finish
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for s.queue.Len() > 0 {
+		job := heap.Pop(&s.queue).(*Job)
+		job.finish(Canceled, nil, ErrCanceled)
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// dispatch starts queued jobs while the budgets allow it; s.mu must be held
+func (s *Scheduler) dispatch() {
+	for s.queue.Len() > 0 && s.running < s.maxJobs {
+		job := s.queue[0]
+		if s.maxMemory > 0 && s.running > 0 && s.memoryUsed+job.memory > s.maxMemory {
+			return
+		}
+
+		heap.Pop(&s.queue)
+		ctx, cancel := context.WithCancel(context.Background())
+		job.mu.Lock()
+		job.status, job.cancel = Running, cancel
+		job.mu.Unlock()
+
+		s.running++
+		s.memoryUsed += job.memory
+		s.wg.Add(1)
+		go s.run(ctx, job)
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, job *Job) {
+	defer s.wg.Done()
+	defer job.cancel()
+
+	// Each job reports progress through its own clone of the Cypher, the
+	// settings of a Cypher in use can't change
+	c := job.cypher.Clone().WithProgress(job.setProgress)
+	defer c.Close()
+
+	var result *cypher.Result
+	var err error
+	if job.op == Encrypt {
+		result, err = c.EncryptFileToPath(ctx, job.input, job.output)
+	} else {
+		result, err = c.DecryptFileToPath(ctx, job.input, job.output)
+	}
+
+	switch {
+	case err == nil:
+		job.finish(Done, result, nil)
+	case ctx.Err() != nil:
+		job.finish(Canceled, nil, ErrCanceled)
+	default:
+		job.finish(Failed, nil, err)
+	}
+
+	s.mu.Lock()
+	s.running--
+	s.memoryUsed -= job.memory
+	s.dispatch()
+	s.mu.Unlock()
+}
+
+// memoryEstimate approximates the chunk buffers held by one operation: the
+// reader buffer, both channel buffers and the chunks held by each worker
+func memoryEstimate(c *cypher.Cypher) int64 {
+	return int64(c.ChunkSize) * int64(4*c.NumWorkers+1)
+}
+
+// jobQueue orders jobs by priority, then submission order
+type jobQueue []*Job
+
+func (q jobQueue) Len() int { return len(q) }
+
+func (q jobQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q jobQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *jobQueue) Push(x any) { *q = append(*q, x.(*Job)) }
+
+func (q *jobQueue) Pop() any {
+	old := *q
+	job := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return job
+}
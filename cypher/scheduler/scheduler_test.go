@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+func TestQueueOrder(t *testing.T) {
+	var q jobQueue
+	for i, priority := range []int{1, 5, 1, 10, 5} {
+		heap.Push(&q, &Job{id: fmt.Sprint(i), priority: priority, seq: uint64(i)})
+	}
+
+	var order []string
+	for q.Len() > 0 {
+		order = append(order, heap.Pop(&q).(*Job).id)
+	}
+	if fmt.Sprint(order) != "[3 1 4 0 2]" {
+		t.Errorf("Unexpected queue order %v", order)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	dir := t.TempDir()
+	c := cypher.NewCypher("test-key").WithChunkSize(1024)
+	s := New(c, WithMaxJobs(2), WithMaxMemory(1))
+
+	var jobs []*Job
+	for i := 0; i < 5; i++ {
+		input := filepath.Join(dir, fmt.Sprintf("input-%d.txt", i))
+		if err := os.WriteFile(input, bytes.Repeat([]byte{byte(i)}, 4096), 0644); err != nil {
+			t.Fatal(err)
+		}
+		job, err := s.Submit(Encrypt, input, input+".encrypted", i)
+		if err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	for _, job := range jobs {
+		result, err := job.Wait(context.Background())
+		if errors.Is(err, ErrCanceled) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Job %s failed: %v", job.ID(), err)
+		}
+		if job.Status() != Done || result.OutputPath != job.Input()+".encrypted" {
+			t.Errorf("Unexpected job state %s, result %+v", job.Status(), result)
+		}
+	}
+
+	s.Close()
+	if _, err := s.Submit(Encrypt, "a", "b", 0); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestCancelQueuedJob(t *testing.T) {
+	c := cypher.NewCypher("test-key")
+	s := New(c, WithMaxJobs(0))
+	defer s.Close()
+
+	job, err := s.Submit(Decrypt, "missing.encrypted", "missing", 0)
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if job.Status() != Queued {
+		t.Fatalf("Expected queued job, got %s", job.Status())
+	}
+	if !s.Cancel(job.ID()) {
+		t.Fatal("Expected Cancel to succeed")
+	}
+	if _, err := job.Wait(context.Background()); !errors.Is(err, ErrCanceled) {
+		t.Errorf("Expected ErrCanceled, got %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package cypher
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// recordKeys derives the key sealing the records of h and the key of the
+// synthetic nonces they are sealed with, which keep delta friendly output
+// deterministic
+func (c Cypher) recordKeys(h *header) (aead cipher.AEAD, nonceKey []byte, err error) {
+	if c.key == nil {
+		return nil, nil, ErrClosed
+	}
+	keys := make([]byte, 2*KeySize)
+	defer wipe(keys)
+	err = c.key.use(func(key []byte) error {
+		_, err := io.ReadFull(hkdf.New(sha256.New, key, h.salt, []byte("gocypher v1 record key")), keys)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if aead, err = c.fileAEAD(h.algorithm, keys[:KeySize]); err != nil {
+		return nil, nil, err
+	}
+	return aead, append([]byte(nil), keys[KeySize:]...), nil
+}
+
+// sealRecord returns the value of the sealed record of recordType holding
+// value in h
+func (c Cypher) sealRecord(h *header, recordType byte, value []byte) ([]byte, error) {
+	aead, nonceKey, err := c.recordKeys(h)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(nonceKey)
+	mac := hmac.New(sha256.New, nonceKey)
+	mac.Write([]byte{recordType})
+	mac.Write(value)
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+	return aead.Seal(nonce, nonce, value, []byte{recordType}), nil
+}
+
+// openRecord returns the value of the sealed record of recordType in h
+func (c Cypher) openRecord(h *header, recordType byte, sealed []byte) ([]byte, error) {
+	aead, nonceKey, err := c.recordKeys(h)
+	if err != nil {
+		return nil, err
+	}
+	wipe(nonceKey)
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: bad sealed record type %d", ErrInvalidHeader, recordType)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	value, err := aead.Open(nil, nonce, ciphertext, []byte{recordType})
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad sealed record type %d", ErrInvalidHeader, recordType)
+	}
+	return value, nil
+}
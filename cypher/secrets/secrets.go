@@ -0,0 +1,263 @@
+// Package secrets keeps named, versioned secrets in a single encrypted file,
+// a lightweight alternative to a secrets server for small deployments.
+//
+// Every operation reads the file again, so a Store sees the changes of other
+// processes, and writes replace it atomically. Writers in several processes
+// at once may lose each other's changes.
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+var (
+	ErrNotFound = errors.New("secret not found")
+	// ErrInvalidName is returned for names that are empty or have empty
+	// segments, such as "db//password"
+	ErrInvalidName = errors.New("invalid secret name")
+)
+
+// Version describes a version of a secret
+type Version struct {
+	Number  int       `json:"number"`
+	Created time.Time `json:"created"`
+}
+
+// Info describes a secret by its latest version
+type Info struct {
+	Name string
+	Version
+}
+
+// version is a stored version of a secret
+type version struct {
+	Version
+	Value []byte `json:"value"`
+}
+
+// file is the decrypted content of a store
+type file struct {
+	Secrets map[string][]version `json:"secrets"`
+}
+
+// Store keeps secrets in the file at its path. It is safe for concurrent
+// use.
+type Store struct {
+	mu   sync.Mutex
+	c    *cypher.Cypher
+	path string
+
+	maxVersions int
+	now         func() time.Time
+}
+
+// Option configures a Store
+type Option func(*Store)
+
+// WithMaxVersions sets how many versions of every secret are kept, older
+// ones are removed by Put (default: 10, 0 keeps all)
+func WithMaxVersions(n int) Option {
+	return func(s *Store) { s.maxVersions = n }
+}
+
+// WithClock sets the function returning the creation time of versions
+func WithClock(now func() time.Time) Option {
+	return func(s *Store) { s.now = now }
+}
+
+// Open returns the store kept in the file at path, encrypted with c. The file
+// is created by the first Put.
+func Open(c *cypher.Cypher, path string, opts ...Option) (*Store, error) {
+	s := &Store{c: c, path: path, maxVersions: 10, now: time.Now}
+	for _, opt := range opts {
+		opt(s)
+	}
+	// Fail early for a wrong key or a damaged file
+	if _, err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func validName(name string) error {
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "" {
+			return fmt.Errorf("%w %q", ErrInvalidName, name)
+		}
+	}
+	return nil
+}
+
+// Put stores value as the new version of the secret name and returns its
+// version number
+func (s *Store) Put(name string, value []byte) (int, error) {
+	if err := validName(name); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+	versions := f.Secrets[name]
+	v := version{Version: Version{Number: 1, Created: s.now().UTC()}, Value: append([]byte(nil), value...)}
+	if len(versions) > 0 {
+		v.Number = versions[len(versions)-1].Number + 1
+	}
+	versions = append(versions, v)
+	if s.maxVersions > 0 && len(versions) > s.maxVersions {
+		versions = versions[len(versions)-s.maxVersions:]
+	}
+	f.Secrets[name] = versions
+	if err := s.save(f); err != nil {
+		return 0, err
+	}
+	return v.Number, nil
+}
+
+// Get returns the latest version of the secret name
+func (s *Store) Get(name string) ([]byte, error) {
+	return s.GetVersion(name, 0)
+}
+
+// GetVersion returns the given version of the secret name, 0 is the latest
+func (s *Store) GetVersion(name string, n int) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	versions := f.Secrets[name]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if n == 0 || versions[i].Number == n {
+			return versions[i].Value, nil
+		}
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return nil, fmt.Errorf("%w: %s version %d", ErrNotFound, name, n)
+}
+
+// List returns the secrets whose names start with prefix, such as "db/",
+// sorted by name
+func (s *Store) List(prefix string) ([]Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	var infos []Info
+	for name, versions := range f.Secrets {
+		if strings.HasPrefix(name, prefix) {
+			infos = append(infos, Info{Name: name, Version: versions[len(versions)-1].Version})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// History returns the kept versions of the secret name, oldest first
+func (s *Store) History(name string) ([]Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	versions, ok := f.Secrets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	history := make([]Version, len(versions))
+	for i, v := range versions {
+		history[i] = v.Version
+	}
+	return history, nil
+}
+
+// Delete removes the secret name with all its versions
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := f.Secrets[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	delete(f.Secrets, name)
+	return s.save(f)
+}
+
+// load reads and decrypts the file, a missing file is empty
+func (s *Store) load() (*file, error) {
+	f := &file{Secrets: map[string][]version{}}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets: %w", err)
+	}
+	plain, err := s.c.Decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secrets: %w", err)
+	}
+	if err := json.Unmarshal(plain, f); err != nil {
+		return nil, fmt.Errorf("failed to parse secrets: %w", err)
+	}
+	if f.Secrets == nil {
+		f.Secrets = map[string][]version{}
+	}
+	return f, nil
+}
+
+// save encrypts f and replaces the file with it
+func (s *Store) save(f *file) error {
+	plain, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("failed to encode secrets: %w", err)
+	}
+	data, err := s.c.Encrypt(plain)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secrets: %w", err)
+	}
+	temp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write secrets: %w", err)
+	}
+	if _, err = temp.Write(data); err == nil {
+		err = temp.Sync()
+	}
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to write secrets: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := cypher.NewCypher("test-key")
+	store, err := Open(c, path, WithMaxVersions(2), WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	for i, value := range []string{"first", "second", "third"} {
+		version, err := store.Put("db/password", []byte(value))
+		if err != nil || version != i+1 {
+			t.Fatalf("Expected version %d, got %d: %v", i+1, version, err)
+		}
+		now = now.Add(time.Hour)
+	}
+	if _, err := store.Put("api/token", []byte("token")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); bytes.Contains(data, []byte("db/password")) {
+		t.Error("Expected secret names to be encrypted")
+	}
+
+	// Another store sees the changes
+	other, err := Open(c, path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if value, err := other.Get("db/password"); err != nil || string(value) != "third" {
+		t.Errorf("Expected the latest version, got %q: %v", value, err)
+	}
+	if value, err := other.GetVersion("db/password", 2); err != nil || string(value) != "second" {
+		t.Errorf("Expected version 2, got %q: %v", value, err)
+	}
+	if _, err := other.GetVersion("db/password", 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected version 1 to be removed, got %v", err)
+	}
+	history, err := other.History("db/password")
+	if err != nil || len(history) != 2 || history[0].Number != 2 || !history[1].Created.Equal(time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected history %+v: %v", history, err)
+	}
+	if infos, err := other.List("db/"); err != nil || len(infos) != 1 || infos[0].Name != "db/password" || infos[0].Number != 3 {
+		t.Errorf("Unexpected list %+v: %v", infos, err)
+	}
+
+	if err := store.Delete("db/password"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := other.Get("db/password"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := store.Put("db//password", nil); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Expected ErrInvalidName, got %v", err)
+	}
+	if _, err := Open(cypher.NewCypher("wrong-key"), path); err == nil {
+		t.Error("Expected error opening with the wrong key, got nil")
+	}
+}
@@ -0,0 +1,290 @@
+package cypher
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// ErrNotSeekable is returned for encrypted data whose chunks can't be found
+// without reading the ones before them
+var ErrNotSeekable = errors.New("encrypted data isn't seekable")
+
+// SeekableReader decrypts encrypted data at random offsets, opening only the
+// chunks a read covers, see NewSeekableReader
+type SeekableReader struct {
+	r          io.ReaderAt
+	gcm        cipher.AEAD
+	aad        []byte
+	headerSize int64
+	// end is where the chunk frames end
+	end       int64
+	chunkSize int64
+	overhead  int64
+	chunks    int
+	// fixed is set while every chunk but the last is taken to be full size,
+	// so chunks are found by arithmetic. The first chunk that doesn't match
+	// makes the reader index the length prefixes instead.
+	fixed bool
+	// offsets holds where the frame of every chunk starts and starts where
+	// its plaintext does, each with an entry past the last chunk, once the
+	// chunks are indexed
+	offsets []int64
+	starts  []int64
+	size    int64
+	// offset is where Read continues
+	offset int64
+
+	mu sync.Mutex
+	// chunk is the plaintext of the chunk at position, the last one opened
+	position int64
+	chunk    []byte
+}
+
+// NewSeekableReader returns a reader of the plaintext of the size bytes of
+// encrypted data in r, which seeks by looking up where chunks start instead
+// of decrypting the data before them, so media servers and range requests
+// read large files encrypted at rest. Data encrypted in one go has full
+// chunks but the last, which its trailer confirms without reading more. The
+// length prefixes of other data, such as the short chunks every
+// AppendWriter.Flush ends or content defined chunks, are read up front. The
+// data must have been written without compression, delta friendly output or
+// holes; others return ErrNotSeekable. The trailer is checked up front, so
+// data truncated at a chunk boundary returns ErrTruncated; data written
+// without one can't be told from its truncation.
+func (c Cypher) NewSeekableReader(r io.ReaderAt, size int64) (*SeekableReader, error) {
+	c.markUsed()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	src := io.NewSectionReader(r, 0, size)
+	magic := make([]byte, len(FormatMagic))
+	if _, err := io.ReadFull(src, magic); err != nil || string(magic) != FormatMagic {
+		return nil, fmt.Errorf("%w: data without a header", ErrNotSeekable)
+	}
+	h, err := readHeader(src, c.parseMode)
+	if err != nil {
+		return nil, err
+	}
+	if h.compression != CompressionNone || h.deterministic || len(h.holes) > 0 {
+		return nil, fmt.Errorf("%w: compressed, delta friendly or sparse data", ErrNotSeekable)
+	}
+	if err := c.checkGeneration(h.generation); err != nil {
+		return nil, err
+	}
+	if err := c.checkExpiry(h.expiry); err != nil {
+		return nil, err
+	}
+	gcm, err := c.fileGCM(h)
+	if err != nil {
+		return nil, err
+	}
+	c.observeCounter(h.counter)
+
+	s := &SeekableReader{
+		r:          r,
+		gcm:        gcm,
+		aad:        headerAAD(h),
+		headerSize: int64(len(h.raw)),
+		end:        size,
+		chunkSize:  int64(h.chunkSize),
+		overhead:   int64(gcm.NonceSize() + gcm.Overhead()),
+		position:   -1,
+	}
+	if !h.trailer {
+		if err := s.index(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	s.end -= streamTrailerSize
+	if s.end < s.headerSize {
+		return nil, ErrTruncated
+	}
+	trailer := make([]byte, streamTrailerSize)
+	if _, err := r.ReadAt(trailer, s.end); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read trailer: %w", err)
+	}
+	if !isStreamTrailer(trailer) {
+		return nil, fmt.Errorf("%w: no trailer", ErrTruncated)
+	}
+	key, err := c.streamTrailerKey(h)
+	if err != nil {
+		return nil, err
+	}
+	// The trailer holds the number of chunks, which is only the one of full
+	// chunks when they are
+	total, frame := s.end-s.headerSize, FrameLengthSize+s.chunkSize+s.overhead
+	chunks := (total + frame - 1) / frame
+	if last := total - (chunks-1)*frame; total == 0 || last >= FrameLengthSize+s.overhead {
+		if checkStreamTrailer(trailer, key, s.aad, int(chunks), total) == nil {
+			s.fixed, s.chunks = true, int(chunks)
+			s.size = total - chunks*(FrameLengthSize+s.overhead)
+			return s, nil
+		}
+	}
+	if err := s.index(); err != nil {
+		return nil, err
+	}
+	if err := checkStreamTrailer(trailer, key, s.aad, s.chunks, total); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// index reads the length prefixes of the chunks, ending fixed. The number of
+// chunks the trailer confirmed for fixed must stay the same.
+func (s *SeekableReader) index() error {
+	maxFrame := s.chunkSize + s.overhead
+	offset, plain := s.headerSize, int64(0)
+	var offsets, starts []int64
+	var prefix [FrameLengthSize]byte
+	for offset < s.end {
+		if _, err := s.r.ReadAt(prefix[:], offset); err != nil {
+			return fmt.Errorf("truncated chunk length at %d: %w", offset, err)
+		}
+		frame := int64(binary.BigEndian.Uint32(prefix[:]))
+		if frame < s.overhead || frame > maxFrame || offset+FrameLengthSize+frame > s.end {
+			return fmt.Errorf("truncated chunk at %d", offset)
+		}
+		offsets, starts = append(offsets, offset), append(starts, plain)
+		offset += FrameLengthSize + frame
+		plain += frame - s.overhead
+	}
+	if s.fixed && len(offsets) != s.chunks {
+		return fmt.Errorf("%w: %d chunks, the trailer counts %d", ErrChunksModified, len(offsets), s.chunks)
+	}
+	s.offsets, s.starts = append(offsets, offset), append(starts, plain)
+	s.chunks, s.size, s.fixed = len(offsets), plain, false
+	return nil
+}
+
+// locate returns the chunk holding the plaintext at off and where its
+// plaintext starts
+func (s *SeekableReader) locate(off int64) (position, start int64) {
+	if s.fixed {
+		position = off / s.chunkSize
+		return position, position * s.chunkSize
+	}
+	// The last chunk starting at or before off holds it
+	position = int64(sort.Search(len(s.starts)-1, func(i int) bool { return s.starts[i+1] > off }))
+	return position, s.starts[position]
+}
+
+// frame returns where the frame of the chunk at position starts and its size
+// with the length prefix
+func (s *SeekableReader) frame(position int64) (offset, size int64) {
+	if s.fixed {
+		full := FrameLengthSize + s.chunkSize + s.overhead
+		offset = s.headerSize + position*full
+		return offset, min(full, s.end-offset)
+	}
+	return s.offsets[position], s.offsets[position+1] - s.offsets[position]
+}
+
+// Size returns the size of the plaintext
+func (s *SeekableReader) Size() int64 {
+	return s.size
+}
+
+// ReadAt reads the plaintext at off into p
+func (s *SeekableReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for n < len(p) && off < s.size {
+		position, start := s.locate(off)
+		if err := s.open(position); err != nil {
+			if !s.fixed {
+				return n, err
+			}
+			// Not all chunks are full, find them by their prefixes
+			if err := s.index(); err != nil {
+				return n, err
+			}
+			continue
+		}
+		copied := copy(p[n:], s.chunk[off-start:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// open decrypts the chunk at position, unless it is the last one opened
+func (s *SeekableReader) open(position int64) error {
+	if position == s.position {
+		return nil
+	}
+	offset, size := s.frame(position)
+	frame := make([]byte, size)
+	if _, err := s.r.ReadAt(frame, offset); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read chunk %d: %w", position, err)
+	}
+	// The length prefix was read up front or is assumed, the data may differ
+	if int(binary.BigEndian.Uint32(frame)) != len(frame)-FrameLengthSize {
+		return fmt.Errorf("chunk %d doesn't have the length it was indexed with", position)
+	}
+	nonce, sealed := frame[FrameLengthSize:FrameLengthSize+s.gcm.NonceSize()], frame[FrameLengthSize+s.gcm.NonceSize():]
+	aad := binary.BigEndian.AppendUint64(append([]byte(nil), s.aad...), uint64(position))
+	wipe(s.chunk)
+	s.position = -1
+	chunk, err := s.gcm.Open(s.chunk[:0], nonce, sealed, aad)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt chunk %d: %w", position, err)
+	}
+	s.chunk, s.position = chunk, position
+	return nil
+}
+
+// Read reads the plaintext from the offset of the last Seek
+func (s *SeekableReader) Read(p []byte) (int, error) {
+	if s.offset >= s.size {
+		return 0, io.EOF
+	}
+	n, err := s.ReadAt(p, s.offset)
+	s.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset of the next Read in the plaintext
+func (s *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += s.size
+	case io.SeekStart:
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	s.offset = offset
+	return offset, nil
+}
+
+// Close wipes the plaintext of the last chunk opened
+func (s *SeekableReader) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wipe(s.chunk)
+	s.chunk, s.position = nil, -1
+	return nil
+}
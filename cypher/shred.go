@@ -0,0 +1,136 @@
+package cypher
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrShredVerify is returned when the encrypted output doesn't decrypt to
+// the source, which is then kept
+var ErrShredVerify = errors.New("encrypted output doesn't match the source")
+
+// ShredReport tells how the source of an encryption WithShredSource was
+// removed
+type ShredReport struct {
+	Passes  int
+	Removed bool
+	// Caveats lists why overwriting may not have destroyed every copy of
+	// the data, such as SSDs or copy-on-write filesystems that write new
+	// blocks instead of overwriting the old ones
+	Caveats []string
+}
+
+// WithShredSource makes file and directory encryption overwrite the source
+// with passes of random data and remove it, once the output is synced and
+// decrypts to the source. Overwriting only destroys the data where the
+// storage writes in place: SSDs, copy-on-write filesystems, snapshots and
+// backups may keep copies, the Result reports the caveats found.
+func (c *Cypher) WithShredSource(passes int) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.shredPasses = passes
+	return c
+}
+
+// shredSource verifies that outputPath decrypts to the content of
+// inputPath, then shreds inputPath
+func (c Cypher) shredSource(ctx context.Context, inputPath, outputPath string) (*ShredReport, error) {
+	info, err := os.Lstat(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat source: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("can't shred %s, not a regular file", inputPath)
+	}
+
+	if err := c.verifyOutput(ctx, inputPath, outputPath); err != nil {
+		return nil, err
+	}
+
+	report := &ShredReport{Passes: c.shredPasses, Caveats: shredCaveats(inputPath)}
+	if err := overwrite(inputPath, info.Size(), c.shredPasses); err != nil {
+		return report, fmt.Errorf("failed to overwrite source: %w", err)
+	}
+	// A random name hides the original one from the directory entries
+	name := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, name); err != nil {
+		return report, err
+	}
+	hidden := filepath.Join(filepath.Dir(inputPath), hex.EncodeToString(name))
+	if err := os.Rename(inputPath, hidden); err != nil {
+		hidden = inputPath
+	}
+	if err := os.Remove(hidden); err != nil {
+		return report, fmt.Errorf("failed to remove source: %w", err)
+	}
+	report.Removed = true
+	return report, nil
+}
+
+// verifyOutput checks that outputPath decrypts to the content of inputPath
+func (c Cypher) verifyOutput(ctx context.Context, inputPath, outputPath string) error {
+	sourceSum, err := fileSum(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to hash source: %w", err)
+	}
+	output, err := os.Open(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	defer output.Close()
+	hash := sha256.New()
+	if _, err := c.DecryptStream(ctx, output, hash); err != nil {
+		return fmt.Errorf("%w: %w", ErrShredVerify, err)
+	}
+	if !bytes.Equal(hash.Sum(nil), sourceSum) {
+		return ErrShredVerify
+	}
+	return nil
+}
+
+// overwrite writes passes of random data over the size bytes of path,
+// syncing after each, and truncates it
+func overwrite(path string, size int64, passes int) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for i := 0; i < passes; i++ {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(file, rand.Reader, size); err != nil {
+			return err
+		}
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func fileSum(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,48 @@
+package cypher
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Filesystems that write changed blocks elsewhere instead of in place, by
+// statfs magic
+var copyOnWriteFilesystems = map[int64]string{
+	0x9123683e: "btrfs",
+	0x2fc12fc1: "zfs",
+	0xca451a4e: "bcachefs",
+	0xf2f52010: "f2fs",
+	0x3434:     "nilfs2",
+	0x794c7630: "overlayfs",
+}
+
+// shredCaveats returns why overwriting path may leave copies of its data
+func shredCaveats(path string) []string {
+	var caveats []string
+	var fs unix.Statfs_t
+	if err := unix.Statfs(path, &fs); err == nil {
+		if name, ok := copyOnWriteFilesystems[int64(fs.Type)]; ok {
+			caveats = append(caveats, fmt.Sprintf("%s doesn't overwrite in place, the old blocks and any snapshots keep the data", name))
+		}
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return append(caveats, "unknown storage, it may keep copies of the data")
+	}
+	// Partitions have no queue of their own, their disk has
+	dev := fmt.Sprintf("/sys/dev/block/%d:%d", unix.Major(st.Dev), unix.Minor(st.Dev))
+	rotational, err := os.ReadFile(dev + "/queue/rotational")
+	if err != nil {
+		rotational, err = os.ReadFile(dev + "/../queue/rotational")
+	}
+	switch {
+	case err != nil:
+		caveats = append(caveats, "unknown storage, it may keep copies of the data")
+	case strings.TrimSpace(string(rotational)) == "0":
+		caveats = append(caveats, "solid state storage remaps writes, worn out and spare blocks may keep the data")
+	}
+	return caveats
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package cypher
+
+// shredCaveats returns why overwriting path may leave copies of its data.
+// The storage can't be told outside Linux.
+func shredCaveats(path string) []string {
+	return []string{"unknown storage, SSDs and copy-on-write filesystems may keep copies of the data"}
+}
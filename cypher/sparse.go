@@ -0,0 +1,169 @@
+package cypher
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// hole is a range of a sparse file that holds no data and reads as zeros
+type hole struct {
+	offset int64
+	length int64
+}
+
+const (
+	holeRecordSize = 16
+	// maxHoles is the number of holes fitting in a header record, files with
+	// more keep their largest holes and store the others as zeros
+	maxHoles = math.MaxUint16 / holeRecordSize
+)
+
+func encodeHoles(holes []hole) []byte {
+	value := make([]byte, 0, len(holes)*holeRecordSize)
+	for _, h := range holes {
+		value = binary.BigEndian.AppendUint64(value, uint64(h.offset))
+		value = binary.BigEndian.AppendUint64(value, uint64(h.length))
+	}
+	return value
+}
+
+// decodeHoles parses a hole record, holes must be ordered and not overlap
+func decodeHoles(value []byte) ([]hole, error) {
+	if len(value)%holeRecordSize != 0 {
+		return nil, fmt.Errorf("%w: bad hole record", ErrInvalidHeader)
+	}
+	holes := make([]hole, 0, len(value)/holeRecordSize)
+	end := int64(0)
+	for i := 0; i < len(value); i += holeRecordSize {
+		offset := binary.BigEndian.Uint64(value[i:])
+		length := binary.BigEndian.Uint64(value[i+8:])
+		if offset > math.MaxInt64 || length == 0 || length > math.MaxInt64-offset || int64(offset) < end {
+			return nil, fmt.Errorf("%w: bad hole at offset %d", ErrInvalidHeader, offset)
+		}
+		h := hole{offset: int64(offset), length: int64(length)}
+		holes = append(holes, h)
+		end = h.offset + h.length
+	}
+	return holes, nil
+}
+
+// limitHoles keeps the largest maxHoles holes
+func limitHoles(holes []hole) []hole {
+	if len(holes) <= maxHoles {
+		return holes
+	}
+	sort.Slice(holes, func(i, j int) bool { return holes[i].length > holes[j].length })
+	holes = holes[:maxHoles]
+	sort.Slice(holes, func(i, j int) bool { return holes[i].offset < holes[j].offset })
+	return holes
+}
+
+// seekTruncater is implemented by files holes can be skipped in
+type seekTruncater interface {
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// sparseReader reads the data of a file, skipping its holes
+type sparseReader struct {
+	file  io.ReadSeeker
+	holes []hole
+	pos   int64
+}
+
+func (r *sparseReader) Read(p []byte) (int, error) {
+	for len(r.holes) > 0 && r.pos >= r.holes[0].offset {
+		next := r.holes[0].offset + r.holes[0].length
+		if _, err := r.file.Seek(next, io.SeekStart); err != nil {
+			return 0, err
+		}
+		r.pos = next
+		r.holes = r.holes[1:]
+	}
+	if len(r.holes) > 0 && int64(len(p)) > r.holes[0].offset-r.pos {
+		p = p[:r.holes[0].offset-r.pos]
+	}
+	n, err := r.file.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+// holeWriter writes decrypted data around the holes recorded in the header.
+// Holes are skipped when writing to a file, leaving it sparse, and written as
+// zeros otherwise.
+type holeWriter struct {
+	w     io.Writer
+	file  seekTruncater
+	holes []hole
+	pos   int64
+}
+
+func newHoleWriter(w io.Writer, file seekTruncater, holes []hole) *holeWriter {
+	return &holeWriter{w: w, file: file, holes: holes}
+}
+
+func (hw *holeWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if err := hw.skipHoles(); err != nil {
+			return written, err
+		}
+		n := len(p)
+		if len(hw.holes) > 0 && int64(n) > hw.holes[0].offset-hw.pos {
+			n = int(hw.holes[0].offset - hw.pos)
+		}
+		m, err := hw.w.Write(p[:n])
+		written += m
+		hw.pos += int64(m)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (hw *holeWriter) skipHoles() error {
+	for len(hw.holes) > 0 && hw.holes[0].offset == hw.pos {
+		length := hw.holes[0].length
+		if hw.file != nil {
+			if _, err := hw.file.Seek(length, io.SeekCurrent); err != nil {
+				return fmt.Errorf("failed to skip hole: %w", err)
+			}
+		} else if err := writeZeros(hw.w, length); err != nil {
+			return err
+		}
+		hw.pos += length
+		hw.holes = hw.holes[1:]
+	}
+	return nil
+}
+
+// finish writes the holes after the last data and sets the file size
+func (hw *holeWriter) finish() error {
+	if err := hw.skipHoles(); err != nil {
+		return err
+	}
+	if len(hw.holes) > 0 {
+		return fmt.Errorf("%w: data ends before the hole at offset %d", ErrTruncated, hw.holes[0].offset)
+	}
+	if hw.file != nil {
+		return hw.file.Truncate(hw.pos)
+	}
+	return nil
+}
+
+func writeZeros(w io.Writer, n int64) error {
+	zeros := make([]byte, min(n, 64*1024))
+	for n > 0 {
+		size := min(n, int64(len(zeros)))
+		if _, err := w.Write(zeros[:size]); err != nil {
+			return err
+		}
+		n -= size
+	}
+	return nil
+}
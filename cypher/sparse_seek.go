@@ -0,0 +1,41 @@
+//go:build linux || darwin || freebsd
+
+package cypher
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// findHoles returns the holes of the size bytes of file with SEEK_DATA and
+// SEEK_HOLE, or none when the file system doesn't report them
+func findHoles(file *os.File, size int64) ([]hole, error) {
+	defer file.Seek(0, io.SeekStart)
+
+	var holes []hole
+	for offset := int64(0); offset < size; {
+		data, err := file.Seek(offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// no data up to the end of the file
+			holes = append(holes, hole{offset: offset, length: size - offset})
+			break
+		}
+		if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOTSUP) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if data > offset {
+			holes = append(holes, hole{offset: offset, length: min(data, size) - offset})
+		}
+
+		if offset, err = file.Seek(data, unix.SEEK_HOLE); err != nil {
+			return nil, err
+		}
+	}
+	return holes, nil
+}
@@ -0,0 +1,84 @@
+//go:build linux
+
+package cypher
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestSparseFile(t *testing.T) {
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, "disk.img")
+	const size = 64 * 1024 * 1024
+
+	file, err := os.Create(plainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, middle := randomBytes(t, 4096), randomBytes(t, 8192)
+	if _, err := file.Write(head); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt(middle, 32*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	holes, err := findHoles(file, size)
+	file.Close()
+	if err != nil {
+		t.Fatalf("findHoles failed: %v", err)
+	}
+	if len(holes) == 0 {
+		t.Skip("file system doesn't report holes")
+	}
+
+	c := NewCypher("test-key").WithChunkSize(1024 * 1024)
+	encPath := plainPath + ".encrypted"
+	if _, err := c.EncryptFileToPath(context.Background(), plainPath, encPath); err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	info, err := os.Stat(encPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 1024*1024 {
+		t.Errorf("Expected the holes to be left out of the ciphertext, got %d bytes", info.Size())
+	}
+
+	decPath := filepath.Join(dir, "restored.img")
+	if _, err := c.DecryptFileToPath(context.Background(), encPath, decPath); err != nil {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+	restored, err := os.ReadFile(decPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, size)
+	copy(want, head)
+	copy(want[32*1024*1024:], middle)
+	if !bytes.Equal(restored, want) {
+		t.Fatal("Decrypted file doesn't match")
+	}
+	if info, err := os.Stat(decPath); err == nil {
+		if blocks := info.Sys().(*syscall.Stat_t).Blocks * 512; blocks >= size/2 {
+			t.Errorf("Expected the decrypted file to be sparse, %d bytes allocated", blocks)
+		}
+	}
+
+	// In memory decryption fills the holes with zeros
+	encrypted, err := os.ReadFile(encPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := c.Decrypt(encrypted)
+	if err != nil || !bytes.Equal(decrypted, want) {
+		t.Errorf("Expected in memory decryption to match, got error %v", err)
+	}
+}
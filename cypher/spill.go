@@ -0,0 +1,109 @@
+package cypher
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// WithSpill caps the memory the writer uses for chunks finished out of order
+// at budget bytes. Chunks beyond it are written to a temporary file in dir
+// (the system temp directory when empty) until their turn comes. Spilled
+// chunks are sealed with a random key that only lives for the operation and
+// the file is removed when it ends. A budget of 0 disables spilling.
+func (c *Cypher) WithSpill(budget int64, dir string) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.spillBudget = budget
+	c.spillDir = dir
+	return c
+}
+
+// spilledChunk locates a chunk in a spill file
+type spilledChunk struct {
+	offset int64
+	length int
+	size   int
+}
+
+// spillFile holds the out of order chunks the writer has no memory for
+type spillFile struct {
+	file   *os.File
+	gcm    cipher.AEAD
+	offset int64
+	chunks map[int]spilledChunk
+}
+
+func newSpillFile(dir string) (*spillFile, error) {
+	key := make([]byte, KeySize)
+	defer wipe(key)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate spill key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(dir, "gocypher-spill-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill file: %w", err)
+	}
+	return &spillFile{file: file, gcm: gcm, chunks: make(map[int]spilledChunk)}, nil
+}
+
+// nonce returns the nonce of the chunk at position, every position is
+// spilled at most once under the key
+func (s *spillFile) nonce(position int) []byte {
+	nonce := make([]byte, s.gcm.NonceSize())
+	binary.BigEndian.PutUint64(nonce, uint64(position))
+	return nonce
+}
+
+// put seals chunk into the file
+func (s *spillFile) put(chunk DataChunk) error {
+	sealed := s.gcm.Seal(nil, s.nonce(chunk.position), chunk.data, nil)
+	if _, err := s.file.WriteAt(sealed, s.offset); err != nil {
+		return fmt.Errorf("failed to spill chunk: %w", err)
+	}
+	s.chunks[chunk.position] = spilledChunk{offset: s.offset, length: len(sealed), size: chunk.size}
+	s.offset += int64(len(sealed))
+	return nil
+}
+
+// take reads back the chunk at position if it was spilled
+func (s *spillFile) take(position int) (DataChunk, bool, error) {
+	spilled, ok := s.chunks[position]
+	if !ok {
+		return DataChunk{}, false, nil
+	}
+	delete(s.chunks, position)
+
+	sealed := make([]byte, spilled.length)
+	if _, err := s.file.ReadAt(sealed, spilled.offset); err != nil {
+		return DataChunk{}, false, fmt.Errorf("failed to read spilled chunk: %w", err)
+	}
+	data, err := s.gcm.Open(sealed[:0], s.nonce(position), sealed, nil)
+	if err != nil {
+		return DataChunk{}, false, fmt.Errorf("spilled chunk was modified: %w", err)
+	}
+	return DataChunk{data: data, position: position, size: spilled.size}, true, nil
+}
+
+// close removes the file
+func (s *spillFile) close() {
+	if s == nil {
+		return
+	}
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
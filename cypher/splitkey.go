@@ -0,0 +1,71 @@
+package cypher
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// ErrSameKey is returned when both halves of a split knowledge key are the
+// same key
+var ErrSameKey = errors.New("split knowledge needs two different keys")
+
+// NewCypherWithSplitKnowledge returns a Cypher whose key is derived with
+// HKDF-SHA256 from the keys of a and b, such as ones held by different
+// teams, so data it encrypts can only be decrypted with both: neither key
+// alone gets past the key commitment. The order of a and b doesn't matter.
+// Like the other constructors it starts from the default settings and
+// applies opts; closing a or b doesn't close it.
+func NewCypherWithSplitKnowledge(a, b *Cypher, opts ...Option) (*Cypher, error) {
+	if a == nil || b == nil || a.key == nil || b.key == nil {
+		return nil, ErrClosed
+	}
+	if a.key == b.key {
+		return nil, ErrSameKey
+	}
+
+	// Copy the first key out so the locks of a and b are never held together
+	var first []byte
+	if err := a.key.use(func(k []byte) error {
+		first = bytes.Clone(k)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	defer wipe(first)
+
+	key := make([]byte, KeySize)
+	err := b.key.use(func(second []byte) error {
+		low, high := first, second
+		switch bytes.Compare(low, high) {
+		case 0:
+			return ErrSameKey
+		case 1:
+			low, high = high, low
+		}
+		secret := binary.BigEndian.AppendUint32(nil, uint32(len(low)))
+		secret = append(append(secret, low...), high...)
+		defer wipe(secret)
+		_, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte("gocypher v1 split knowledge")), key)
+		return err
+	})
+	if err != nil {
+		wipe(key)
+		if errors.Is(err, ErrSameKey) || errors.Is(err, ErrClosed) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	c := newCypher(key, nil, opts...)
+	if err := c.Validate(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,63 @@
+package sshkeys
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// EnvEscrowKeys names a file of escrow public keys, in the authorized_keys
+// format, that Encrypt adds to the recipients of every file
+const EnvEscrowKeys = "GOCYPHER_ESCROW_KEYS"
+
+var (
+	escrowMu         sync.RWMutex
+	escrowRecipients []*Recipient
+)
+
+// SetEscrow makes every Encrypt of the process also encrypt to recipients,
+// the recovery keys of an organization, so data stays recoverable when an
+// employee loses their key. It replaces the recipients set before, none
+// disables escrow. Those of EnvEscrowKeys are added as well.
+//
+// EncryptThreshold doesn't add escrow recipients, which could decrypt alone
+// and defeat dual control: list the escrow key as one of its recipients.
+func SetEscrow(recipients ...*Recipient) {
+	escrowMu.Lock()
+	defer escrowMu.Unlock()
+	escrowRecipients = append([]*Recipient(nil), recipients...)
+}
+
+// withEscrow returns recipients and the escrow recipients missing from
+// them. A set EnvEscrowKeys that can't be read fails the encryption rather
+// than encrypt without escrow.
+func withEscrow(recipients []*Recipient) ([]*Recipient, error) {
+	escrowMu.RLock()
+	escrow := escrowRecipients
+	escrowMu.RUnlock()
+	if path := os.Getenv(EnvEscrowKeys); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read escrow keys: %w", err)
+		}
+		defer file.Close()
+		keys, err := ParseRecipients(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read escrow keys %s: %w", path, err)
+		}
+		escrow = append(append([]*Recipient(nil), escrow...), keys...)
+	}
+
+	all := append([]*Recipient(nil), recipients...)
+	for _, e := range escrow {
+		present := false
+		for _, r := range all {
+			present = present || bytes.Equal(r.key.Marshal(), e.key.Marshal())
+		}
+		if !present {
+			all = append(all, e)
+		}
+	}
+	return all, nil
+}
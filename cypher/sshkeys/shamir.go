@@ -0,0 +1,74 @@
+package sshkeys
+
+import (
+	"crypto/rand"
+	"io"
+)
+
+// Shamir's secret sharing over GF(2^8) with the AES polynomial, splitting a
+// secret bytewise. Share i is the polynomials evaluated at x = i, so shares
+// are numbered from 1.
+
+// gfMul multiplies in GF(2^8) without branching on secret data
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		a = a<<1 ^ -(a>>7)&0x1b
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns the inverse of a non-zero a, a^254
+func gfInv(a byte) byte {
+	b := a
+	for i := 0; i < 6; i++ {
+		b = gfMul(gfMul(b, b), a)
+	}
+	return gfMul(b, b)
+}
+
+// split returns n shares of secret, any threshold of which recover it
+func split(secret []byte, n, threshold int) ([][]byte, error) {
+	coefficients := make([]byte, threshold-1)
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret))
+	}
+	for j, s := range secret {
+		if _, err := io.ReadFull(rand.Reader, coefficients); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			x, y := byte(i+1), byte(0)
+			// Horner's method, from the highest coefficient down to s
+			for k := len(coefficients) - 1; k >= 0; k-- {
+				y = gfMul(y^coefficients[k], x)
+			}
+			shares[i][j] = y ^ s
+		}
+	}
+	return shares, nil
+}
+
+// combine recovers the secret from shares by their distinct, non-zero index
+func combine(shares map[byte][]byte) []byte {
+	var secret []byte
+	for xi, yi := range shares {
+		// The Lagrange basis polynomial of xi at 0
+		basis := byte(1)
+		for xj := range shares {
+			if xj != xi {
+				basis = gfMul(basis, gfMul(xj, gfInv(xj^xi)))
+			}
+		}
+		if secret == nil {
+			secret = make([]byte, len(yi))
+		}
+		for j := range secret {
+			secret[j] ^= gfMul(yi[j], basis)
+		}
+	}
+	return secret
+}
@@ -0,0 +1,338 @@
+// Package sshkeys encrypts data to SSH public keys, the ssh-ed25519 and
+// ssh-rsa keys teams already distribute through authorized_keys files or
+// https://github.com/<user>.keys, and decrypts it with the matching private
+// keys.
+//
+// A random file key encrypts the data in the gocypher format and is wrapped
+// for every recipient in a header: with X25519 on the Curve25519 form of
+// ed25519 keys and ChaCha20-Poly1305, or with RSA-OAEP. The header is
+// authenticated with the file key. EncryptThreshold instead wraps a Shamir
+// share of the file key for every recipient, for m-of-n decryption.
+package sshkeys
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/edwards25519"
+	"github.com/nikola43/gocypher/cypher"
+	"github.com/nikola43/gocypher/cypher/internal/envelope"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrUnsupportedKey is returned for SSH keys other than ssh-ed25519 and
+	// ssh-rsa
+	ErrUnsupportedKey = errors.New("unsupported SSH key type")
+	// ErrNoIdentity is returned when none of the identities is a recipient
+	ErrNoIdentity    = errors.New("no identity matches a recipient")
+	ErrInvalidHeader = errors.New("invalid SSH recipients header")
+)
+
+const magic = "GOCYSSH1"
+
+// envelopeLabel derives the keys of the header MAC and the data, see envelope
+const envelopeLabel = "gocypher ssh"
+
+// Stanza types of the header
+const (
+	typeEd25519 byte = 1
+	typeRSA     byte = 2
+)
+
+const (
+	ed25519Label = "gocypher ssh-ed25519"
+	rsaLabel     = "gocypher ssh-rsa"
+)
+
+// Recipient is an SSH public key data is encrypted to
+type Recipient struct {
+	key     ssh.PublicKey
+	comment string
+}
+
+// ParseRecipient parses a public key in the authorized_keys format, such as
+// the content of ~/.ssh/id_ed25519.pub
+func ParseRecipient(line string) (*Recipient, error) {
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH public key: %w", err)
+	}
+	if t := key.Type(); t != ssh.KeyAlgoED25519 && t != ssh.KeyAlgoRSA {
+		return nil, fmt.Errorf("%w %s", ErrUnsupportedKey, t)
+	}
+	return &Recipient{key: key, comment: comment}, nil
+}
+
+// NewRecipient returns the recipient of an ed25519.PublicKey or an
+// *rsa.PublicKey, such as one imported from a JWK
+func NewRecipient(key crypto.PublicKey) (*Recipient, error) {
+	switch key.(type) {
+	case ed25519.PublicKey, *rsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("%w %T", ErrUnsupportedKey, key)
+	}
+	sshKey, err := ssh.NewPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Recipient{key: sshKey}, nil
+}
+
+// PublicKey returns the ed25519.PublicKey or *rsa.PublicKey of r
+func (r *Recipient) PublicKey() crypto.PublicKey {
+	return r.key.(ssh.CryptoPublicKey).CryptoPublicKey()
+}
+
+// ParseRecipients parses a public key per line, skipping blank lines and
+// comments, such as an authorized_keys file or the keys GitHub publishes
+// for a user
+func ParseRecipients(r io.Reader) ([]*Recipient, error) {
+	var recipients []*Recipient
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		recipient, err := ParseRecipient(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read SSH public keys: %w", err)
+	}
+	return recipients, nil
+}
+
+// String returns the SHA256 fingerprint and comment of the key
+func (r *Recipient) String() string {
+	return strings.TrimSpace(ssh.FingerprintSHA256(r.key) + " " + r.comment)
+}
+
+// Identity is an SSH private key data is decrypted with
+type Identity struct {
+	key any
+	tag [4]byte
+}
+
+// ParseIdentity parses an unencrypted private key, such as the content of
+// ~/.ssh/id_ed25519. Encrypted keys return an *ssh.PassphraseMissingError,
+// use ParseIdentityWithPassphrase for them.
+func ParseIdentity(pemBytes []byte) (*Identity, error) {
+	key, err := ssh.ParseRawPrivateKey(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+	return newIdentity(key)
+}
+
+// ParseIdentityWithPassphrase parses a private key encrypted with passphrase
+func ParseIdentityWithPassphrase(pemBytes, passphrase []byte) (*Identity, error) {
+	key, err := ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return newIdentity(key)
+}
+
+// NewIdentity returns the identity of an ed25519.PrivateKey or an
+// *rsa.PrivateKey, such as one imported from a JWK
+func NewIdentity(key crypto.PrivateKey) (*Identity, error) {
+	return newIdentity(key)
+}
+
+// PrivateKey returns the ed25519.PrivateKey or *rsa.PrivateKey of id
+func (id *Identity) PrivateKey() crypto.PrivateKey {
+	return id.key
+}
+
+func newIdentity(key any) (*Identity, error) {
+	var public any
+	switch k := key.(type) {
+	case *ed25519.PrivateKey:
+		key, public = *k, k.Public()
+	case ed25519.PrivateKey:
+		public = k.Public()
+	case *rsa.PrivateKey:
+		public = &k.PublicKey
+	default:
+		return nil, fmt.Errorf("%w %T", ErrUnsupportedKey, key)
+	}
+	sshKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key, tag: keyTag(sshKey)}, nil
+}
+
+// keyTag is a short hint at the key a stanza is wrapped for
+func keyTag(key ssh.PublicKey) [4]byte {
+	sum := sha256.Sum256(key.Marshal())
+	return [4]byte(sum[:4])
+}
+
+// Encrypt encrypts src to recipients and the escrow recipients, see
+// SetEscrow, into dst. opts configure the Cypher encrypting the data.
+func Encrypt(ctx context.Context, dst io.Writer, src io.Reader, recipients []*Recipient, opts ...cypher.Option) (*cypher.Stats, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("expected 1 to 255 recipients, got %d", len(recipients))
+	}
+	recipients, err := withEscrow(recipients)
+	if err != nil {
+		return nil, err
+	}
+	if len(recipients) > 255 {
+		return nil, fmt.Errorf("expected 1 to 255 recipients, got %d", len(recipients))
+	}
+	fileKey, err := cypher.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	header := bytes.NewBufferString(magic)
+	header.WriteByte(byte(len(recipients)))
+	for _, r := range recipients {
+		typ, body, err := r.wrap(fileKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap the file key for %s: %w", r, err)
+		}
+		tag := keyTag(r.key)
+		header.WriteByte(typ)
+		header.Write(tag[:])
+		header.Write(binary.BigEndian.AppendUint16(nil, uint16(len(body))))
+		header.Write(body)
+	}
+	return envelope.Encrypt(ctx, dst, src, envelopeLabel, fileKey, header.Bytes(), opts...)
+}
+
+// Decrypt decrypts src, encrypted by Encrypt to one of identities, into dst
+func Decrypt(ctx context.Context, dst io.Writer, src io.Reader, identities []*Identity, opts ...cypher.Option) (*cypher.Stats, error) {
+	var header bytes.Buffer
+	r := io.TeeReader(src, &header)
+	prefix := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(r, prefix); err != nil || string(prefix[:len(magic)]) != magic {
+		return nil, ErrInvalidHeader
+	}
+
+	var fileKey []byte
+	for i := 0; i < int(prefix[len(magic)]); i++ {
+		stanza := make([]byte, 7)
+		if _, err := io.ReadFull(r, stanza); err != nil {
+			return nil, ErrInvalidHeader
+		}
+		body := make([]byte, binary.BigEndian.Uint16(stanza[5:]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, ErrInvalidHeader
+		}
+		for _, id := range identities {
+			if fileKey == nil && id.tag == [4]byte(stanza[1:5]) {
+				// A tag may collide, so failures try the next identity
+				fileKey, _ = id.unwrap(stanza[0], body)
+			}
+		}
+	}
+	if fileKey == nil {
+		return nil, ErrNoIdentity
+	}
+	stats, err := envelope.Decrypt(ctx, dst, src, envelopeLabel, fileKey, header.Bytes(), opts...)
+	if errors.Is(err, envelope.ErrInvalidMAC) {
+		return nil, ErrInvalidHeader
+	}
+	return stats, err
+}
+
+// wrap encrypts fileKey for r and returns the stanza type and body
+func (r *Recipient) wrap(fileKey []byte) (byte, []byte, error) {
+	public := r.key.(ssh.CryptoPublicKey).CryptoPublicKey()
+	switch k := public.(type) {
+	case ed25519.PublicKey:
+		point, err := new(edwards25519.Point).SetBytes(k)
+		if err != nil {
+			return 0, nil, err
+		}
+		recipient := point.BytesMontgomery()
+		ephemeral := make([]byte, curve25519.ScalarSize)
+		if _, err := io.ReadFull(rand.Reader, ephemeral); err != nil {
+			return 0, nil, err
+		}
+		share, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+		if err != nil {
+			return 0, nil, err
+		}
+		shared, err := curve25519.X25519(ephemeral, recipient)
+		if err != nil {
+			return 0, nil, err
+		}
+		aead, err := wrapAEAD(shared, share, recipient)
+		if err != nil {
+			return 0, nil, err
+		}
+		return typeEd25519, aead.Seal(share, make([]byte, aead.NonceSize()), fileKey, nil), nil
+	case *rsa.PublicKey:
+		body, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, k, fileKey, []byte(rsaLabel))
+		return typeRSA, body, err
+	}
+	return 0, nil, ErrUnsupportedKey
+}
+
+// unwrap decrypts the file key of a stanza wrapped for id
+func (id *Identity) unwrap(typ byte, body []byte) ([]byte, error) {
+	switch k := id.key.(type) {
+	case ed25519.PrivateKey:
+		if typ != typeEd25519 || len(body) < curve25519.PointSize {
+			return nil, ErrInvalidHeader
+		}
+		// The X25519 scalar of an ed25519 key is the hash of its seed
+		h := sha512.Sum512(k.Seed())
+		point, err := new(edwards25519.Point).SetBytes(k.Public().(ed25519.PublicKey))
+		if err != nil {
+			return nil, err
+		}
+		share := body[:curve25519.PointSize]
+		shared, err := curve25519.X25519(h[:curve25519.ScalarSize], share)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := wrapAEAD(shared, share, point.BytesMontgomery())
+		if err != nil {
+			return nil, err
+		}
+		return aead.Open(nil, make([]byte, aead.NonceSize()), body[curve25519.PointSize:], nil)
+	case *rsa.PrivateKey:
+		if typ != typeRSA {
+			return nil, ErrInvalidHeader
+		}
+		return rsa.DecryptOAEP(sha256.New(), nil, k, body, []byte(rsaLabel))
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// wrapAEAD returns the AEAD wrapping the file key with the shared secret of
+// an X25519 exchange, bound to the ephemeral share and the recipient
+func wrapAEAD(shared, share, recipient []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte(nil), share...), recipient...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(ed25519Label)), key); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
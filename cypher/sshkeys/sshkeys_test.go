@@ -0,0 +1,164 @@
+package sshkeys
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newKey returns the authorized_keys line and the identity of key
+func newKey(t *testing.T, key crypto.Signer, passphrase string) (string, *Identity) {
+	t.Helper()
+	public, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(key, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id *Identity
+	if passphrase == "" {
+		id, err = ParseIdentity(pem.EncodeToMemory(block))
+	} else {
+		id, err = ParseIdentityWithPassphrase(pem.EncodeToMemory(block), []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatalf("Failed to parse identity: %v", err)
+	}
+	return string(ssh.MarshalAuthorizedKey(public)), id
+}
+
+func TestEncryptToSSHKeys(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	edLine, edID := newKey(t, edKey, "")
+	rsaLine, rsaID := newKey(t, rsaKey, "secret")
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherID := newKey(t, otherKey, "")
+
+	recipients, err := ParseRecipients(strings.NewReader("# team keys\n" + edLine + "\n" + rsaLine))
+	if err != nil || len(recipients) != 2 {
+		t.Fatalf("Expected 2 recipients, got %d: %v", len(recipients), err)
+	}
+
+	plaintext := []byte("release notes for the team")
+	var sealed bytes.Buffer
+	if _, err := Encrypt(context.Background(), &sealed, bytes.NewReader(plaintext), recipients); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	for name, id := range map[string]*Identity{"ed25519": edID, "rsa": rsaID} {
+		var plain bytes.Buffer
+		if _, err := Decrypt(context.Background(), &plain, bytes.NewReader(sealed.Bytes()), []*Identity{otherID, id}); err != nil {
+			t.Fatalf("%s: Decrypt failed: %v", name, err)
+		}
+		if !bytes.Equal(plain.Bytes(), plaintext) {
+			t.Errorf("%s: expected the plaintext, got %q", name, plain.Bytes())
+		}
+	}
+
+	if _, err := Decrypt(context.Background(), &bytes.Buffer{}, bytes.NewReader(sealed.Bytes()), []*Identity{otherID}); !errors.Is(err, ErrNoIdentity) {
+		t.Errorf("Expected ErrNoIdentity, got %v", err)
+	}
+	// Dropping a recipient breaks the header MAC
+	tampered := append([]byte(nil), sealed.Bytes()...)
+	tampered[len(magic)] = 1
+	if _, err := Decrypt(context.Background(), &bytes.Buffer{}, bytes.NewReader(tampered), []*Identity{edID}); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Expected ErrInvalidHeader, got %v", err)
+	}
+	if _, err := ParseRecipient("ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBEmKSENjQEezOmxkZMy7opKgwFB9nkt5YRrYMjNuG5N87uRgg6CLrbo5wAdT/y6v0mKV0U2w0WZ2YB/++Tpockg="); !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("Expected ErrUnsupportedKey, got %v", err)
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	var lines []string
+	var ids []*Identity
+	for i := 0; i < 3; i++ {
+		_, key, _ := ed25519.GenerateKey(rand.Reader)
+		line, id := newKey(t, key, "")
+		lines, ids = append(lines, line), append(ids, id)
+	}
+	recipients, err := ParseRecipients(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("archive under dual control")
+	var sealed bytes.Buffer
+	if _, err := EncryptThreshold(context.Background(), &sealed, bytes.NewReader(plaintext), 2, recipients); err != nil {
+		t.Fatalf("EncryptThreshold failed: %v", err)
+	}
+	for _, pair := range [][2]int{{0, 1}, {2, 0}} {
+		combiner, err := NewCombiner(bytes.NewReader(sealed.Bytes()))
+		if err != nil {
+			t.Fatalf("NewCombiner failed: %v", err)
+		}
+		if err := combiner.AddIdentity(ids[pair[0]]); err != nil {
+			t.Fatalf("AddIdentity failed: %v", err)
+		}
+		if _, err := combiner.Decrypt(context.Background(), &bytes.Buffer{}); !errors.Is(err, ErrNotEnoughShares) {
+			t.Errorf("Expected ErrNotEnoughShares, got %v", err)
+		}
+		// The second holder opens their share elsewhere
+		share, err := OpenShare(combiner.Header(), ids[pair[1]])
+		if err != nil {
+			t.Fatalf("OpenShare failed: %v", err)
+		}
+		forged := bytes.Clone(share)
+		forged[1]++
+		if err := combiner.Add(forged); !errors.Is(err, ErrInvalidShare) {
+			t.Errorf("Expected ErrInvalidShare, got %v", err)
+		}
+		if err := combiner.Add(share); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		var plain bytes.Buffer
+		if _, err := combiner.Decrypt(context.Background(), &plain); err != nil {
+			t.Fatalf("Decrypt failed: %v", err)
+		}
+		if !bytes.Equal(plain.Bytes(), plaintext) {
+			t.Errorf("Expected the plaintext, got %q", plain.Bytes())
+		}
+	}
+}
+
+func TestEscrow(t *testing.T) {
+	_, userKey, _ := ed25519.GenerateKey(rand.Reader)
+	userLine, _ := newKey(t, userKey, "")
+	_, escrowKey, _ := ed25519.GenerateKey(rand.Reader)
+	escrowLine, escrowID := newKey(t, escrowKey, "")
+	user, _ := ParseRecipient(userLine)
+	escrow, _ := ParseRecipient(escrowLine)
+
+	SetEscrow(escrow)
+	defer SetEscrow()
+	var sealed bytes.Buffer
+	if _, err := Encrypt(context.Background(), &sealed, strings.NewReader("payroll"), []*Recipient{user}); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	var plain bytes.Buffer
+	if _, err := Decrypt(context.Background(), &plain, bytes.NewReader(sealed.Bytes()), []*Identity{escrowID}); err != nil || plain.String() != "payroll" {
+		t.Errorf("Expected the escrow key to decrypt, got %q: %v", plain.String(), err)
+	}
+
+	SetEscrow()
+	t.Setenv(EnvEscrowKeys, t.TempDir()+"/missing.keys")
+	if _, err := Encrypt(context.Background(), &bytes.Buffer{}, strings.NewReader("payroll"), []*Recipient{user}); err == nil {
+		t.Error("Expected unreadable escrow keys to fail the encryption")
+	}
+}
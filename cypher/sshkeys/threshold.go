@@ -0,0 +1,214 @@
+package sshkeys
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nikola43/gocypher/cypher"
+	"github.com/nikola43/gocypher/cypher/internal/envelope"
+)
+
+// Threshold headers split the file key into a Shamir share per recipient,
+// so decrypting takes the shares of threshold recipients, for dual control
+// of sensitive archives. The header commits to every share, so a wrong
+// share is rejected when it is added instead of yielding a wrong key.
+
+var (
+	// ErrInvalidShare is returned for shares not committed to by the header
+	ErrInvalidShare = errors.New("invalid share")
+	// ErrNotEnoughShares is returned when decrypting with fewer shares than
+	// the threshold
+	ErrNotEnoughShares = errors.New("not enough shares")
+)
+
+const thresholdMagic = "GOCYSHT1"
+
+const shareLabel = "gocypher ssh share"
+
+type shareStanza struct {
+	typ        byte
+	tag        [4]byte
+	commitment []byte
+	body       []byte
+}
+
+// EncryptThreshold encrypts src into dst so that decrypting it takes the
+// shares of threshold of recipients, see Combiner. opts configure the
+// Cypher encrypting the data.
+func EncryptThreshold(ctx context.Context, dst io.Writer, src io.Reader, threshold int, recipients []*Recipient, opts ...cypher.Option) (*cypher.Stats, error) {
+	if len(recipients) == 0 || len(recipients) > 255 {
+		return nil, fmt.Errorf("expected 1 to 255 recipients, got %d", len(recipients))
+	}
+	if threshold < 1 || threshold > len(recipients) {
+		return nil, fmt.Errorf("expected a threshold of 1 to %d, got %d", len(recipients), threshold)
+	}
+	fileKey, err := cypher.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	shares, err := split(fileKey, len(recipients), threshold)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split the file key: %w", err)
+	}
+
+	header := bytes.NewBufferString(thresholdMagic)
+	header.WriteByte(byte(threshold))
+	header.WriteByte(byte(len(recipients)))
+	for i, r := range recipients {
+		share := append([]byte{byte(i + 1)}, shares[i]...)
+		typ, body, err := r.wrap(share)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap a share for %s: %w", r, err)
+		}
+		tag := keyTag(r.key)
+		header.WriteByte(typ)
+		header.Write(tag[:])
+		header.Write(shareCommitment(share))
+		header.Write(binary.BigEndian.AppendUint16(nil, uint16(len(body))))
+		header.Write(body)
+	}
+	return envelope.Encrypt(ctx, dst, src, envelopeLabel, fileKey, header.Bytes(), opts...)
+}
+
+// shareCommitment returns the commitment of the header to share
+func shareCommitment(share []byte) []byte {
+	h := hmac.New(sha256.New, []byte(shareLabel))
+	h.Write(share)
+	return h.Sum(nil)
+}
+
+// readThresholdHeader reads a threshold header without its MAC, returning
+// the threshold and the stanzas
+func readThresholdHeader(r io.Reader) (int, []shareStanza, error) {
+	prefix := make([]byte, len(thresholdMagic)+2)
+	if _, err := io.ReadFull(r, prefix); err != nil || string(prefix[:len(thresholdMagic)]) != thresholdMagic {
+		return 0, nil, ErrInvalidHeader
+	}
+	threshold, count := int(prefix[len(thresholdMagic)]), int(prefix[len(thresholdMagic)+1])
+	if threshold < 1 || threshold > count {
+		return 0, nil, ErrInvalidHeader
+	}
+	stanzas := make([]shareStanza, count)
+	for i := range stanzas {
+		fixed := make([]byte, 5+sha256.Size+2)
+		if _, err := io.ReadFull(r, fixed); err != nil {
+			return 0, nil, ErrInvalidHeader
+		}
+		body := make([]byte, binary.BigEndian.Uint16(fixed[5+sha256.Size:]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			return 0, nil, ErrInvalidHeader
+		}
+		stanzas[i] = shareStanza{typ: fixed[0], tag: [4]byte(fixed[1:5]), commitment: fixed[5 : 5+sha256.Size], body: body}
+	}
+	return threshold, stanzas, nil
+}
+
+// OpenShare returns the share of id in header, the Combiner.Header of data
+// encrypted by EncryptThreshold. Share holders run it with their own key
+// and hand the share to the Combiner, so no one holds several keys.
+func OpenShare(header []byte, id *Identity) ([]byte, error) {
+	_, stanzas, err := readThresholdHeader(bytes.NewReader(header))
+	if err != nil {
+		return nil, err
+	}
+	for i, stanza := range stanzas {
+		if id.tag != stanza.tag {
+			continue
+		}
+		// A tag may collide, so failures try the next stanza
+		share, err := id.unwrap(stanza.typ, stanza.body)
+		if err == nil && len(share) > 0 && int(share[0]) == i+1 && hmac.Equal(shareCommitment(share), stanza.commitment) {
+			return share, nil
+		}
+	}
+	return nil, ErrNoIdentity
+}
+
+// Combiner collects the shares of data encrypted by EncryptThreshold and
+// decrypts it once it holds enough
+type Combiner struct {
+	src       io.Reader
+	header    []byte
+	threshold int
+	stanzas   []shareStanza
+	shares    map[byte][]byte
+}
+
+// NewCombiner reads the header of src, encrypted by EncryptThreshold
+func NewCombiner(src io.Reader) (*Combiner, error) {
+	var header bytes.Buffer
+	threshold, stanzas, err := readThresholdHeader(io.TeeReader(src, &header))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(&header, src, sha256.Size); err != nil {
+		return nil, ErrInvalidHeader
+	}
+	return &Combiner{src: src, header: header.Bytes(), threshold: threshold, stanzas: stanzas, shares: map[byte][]byte{}}, nil
+}
+
+// Header returns the header share holders open their share from, see
+// OpenShare
+func (c *Combiner) Header() []byte {
+	return c.header
+}
+
+// Threshold returns the number of shares decrypting takes
+func (c *Combiner) Threshold() int {
+	return c.threshold
+}
+
+// Needed returns the number of shares still missing
+func (c *Combiner) Needed() int {
+	return max(c.threshold-len(c.shares), 0)
+}
+
+// Add adds a share returned by OpenShare. Shares not committed to by the
+// header return ErrInvalidShare, adding a share twice is a no-op.
+func (c *Combiner) Add(share []byte) error {
+	if len(share) != cypher.KeySize+1 || share[0] == 0 || int(share[0]) > len(c.stanzas) {
+		return ErrInvalidShare
+	}
+	if !hmac.Equal(shareCommitment(share), c.stanzas[share[0]-1].commitment) {
+		return ErrInvalidShare
+	}
+	c.shares[share[0]] = bytes.Clone(share[1:])
+	return nil
+}
+
+// AddIdentity adds the share of id, for share holders present at the
+// Combiner
+func (c *Combiner) AddIdentity(id *Identity) error {
+	share, err := OpenShare(c.header, id)
+	if err != nil {
+		return err
+	}
+	return c.Add(share)
+}
+
+// Decrypt decrypts the data into dst with the shares added. It returns
+// ErrNotEnoughShares while shares are missing.
+func (c *Combiner) Decrypt(ctx context.Context, dst io.Writer, opts ...cypher.Option) (*cypher.Stats, error) {
+	if n := c.Needed(); n > 0 {
+		return nil, fmt.Errorf("%w: %d of %d missing", ErrNotEnoughShares, n, c.threshold)
+	}
+	shares := map[byte][]byte{}
+	for x, y := range c.shares {
+		if len(shares) < c.threshold {
+			shares[x] = y
+		}
+	}
+	// The MAC was read with the header
+	header, sum := c.header[:len(c.header)-sha256.Size], c.header[len(c.header)-sha256.Size:]
+	stats, err := envelope.Decrypt(ctx, dst, io.MultiReader(bytes.NewReader(sum), c.src), envelopeLabel, combine(shares), header, opts...)
+	if errors.Is(err, envelope.ErrInvalidMAC) {
+		return nil, ErrInvalidHeader
+	}
+	return stats, err
+}
@@ -0,0 +1,129 @@
+package cypher
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+)
+
+// Chunk is a chunk of an operation handed to a Stage
+type Chunk struct {
+	// Position is the index of the chunk in the stream
+	Position int
+	Data     []byte
+}
+
+// Stage is a custom transform of every chunk of an operation, such as
+// compression, hashing or content inspection, run by the workers of the
+// pipeline. Chunks are transformed concurrently and out of order, the
+// pipeline writes them in order. Transform returns the new data of the chunk,
+// or chunk.Data to only look at it; it must not keep chunk.Data.
+type Stage interface {
+	Transform(ctx context.Context, chunk Chunk) ([]byte, error)
+}
+
+// StageFunc is a Stage of a function
+type StageFunc func(ctx context.Context, chunk Chunk) ([]byte, error)
+
+func (f StageFunc) Transform(ctx context.Context, chunk Chunk) ([]byte, error) {
+	return f(ctx, chunk)
+}
+
+// CryptoStage marks where chunks are sealed or opened among the stages of
+// WithEncryptStages and WithDecryptStages. Without it, custom stages see the
+// plaintext: before sealing when encrypting, after opening when decrypting.
+var CryptoStage Stage = cryptoStage{}
+
+type cryptoStage struct{}
+
+func (cryptoStage) Transform(context.Context, Chunk) ([]byte, error) {
+	return nil, errors.New("CryptoStage is run by the pipeline")
+}
+
+// WithEncryptStages runs stages on every chunk of encryptions, in order.
+// Stages after CryptoStage get the sealed chunks, without their length
+// prefix; whatever they change, decryption has to undo with
+// WithDecryptStages before CryptoStage.
+func (c *Cypher) WithEncryptStages(stages ...Stage) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.encryptStages = append([]Stage(nil), stages...)
+	return c
+}
+
+// WithDecryptStages runs stages on every chunk of decryptions, in order.
+// Stages before CryptoStage get the sealed chunks, the others the plaintext.
+func (c *Cypher) WithDecryptStages(stages ...Stage) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.decryptStages = append([]Stage(nil), stages...)
+	return c
+}
+
+// countStage returns how many times stage appears in stages
+func countStage(stages []Stage, stage Stage) int {
+	n := 0
+	for _, s := range stages {
+		if s == stage {
+			n++
+		}
+	}
+	return n
+}
+
+// splitStages returns the stages before and after CryptoStage. Without it
+// they all go on the plaintext side.
+func splitStages(stages []Stage, encrypt bool) (before, after []Stage) {
+	for i, stage := range stages {
+		if stage == CryptoStage {
+			return stages[:i], stages[i+1:]
+		}
+	}
+	if encrypt {
+		return stages, nil
+	}
+	return nil, stages
+}
+
+// runStages runs stages on data, the chunk at position
+func runStages(ctx context.Context, stages []Stage, position int, data []byte) ([]byte, error) {
+	for _, stage := range stages {
+		var err error
+		if data, err = stage.Transform(ctx, Chunk{Position: position, Data: data}); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// transformStages transforms data with op and the custom stages around it
+func (op *operation) transformStages(ctx context.Context, position int, aad, data []byte) ([]byte, error) {
+	data, err := runStages(ctx, op.stagesBefore, position, data)
+	if err != nil {
+		return nil, err
+	}
+	data, err = op.transform(op, aad, data)
+	if err != nil || len(op.stagesAfter) == 0 {
+		return data, err
+	}
+	if !op.framed {
+		return runStages(ctx, op.stagesAfter, position, data)
+	}
+
+	// Sealed frames get their length prefix back after the stages
+	body := data[FrameLengthSize:]
+	staged, err := runStages(ctx, op.stagesAfter, position, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(staged) == len(body) && &staged[0] == &body[0] {
+		return data, nil
+	}
+	frame := binary.BigEndian.AppendUint32(op.buffers.get(FrameLengthSize + len(staged))[:0], uint32(len(staged)))
+	frame = append(frame, staged...)
+	// staged may share the buffer of data
+	op.buffers.put(data)
+	return frame, nil
+}
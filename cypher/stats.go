@@ -0,0 +1,51 @@
+package cypher
+
+import "time"
+
+// Stats describes a completed operation
+type Stats struct {
+	BytesRead    int64
+	BytesWritten int64
+	Chunks       int
+	// SpilledChunks is the number of chunks that waited for their turn on
+	// disk, see WithSpill
+	SpilledChunks int
+	Duration      time.Duration
+	// WorkerBusy holds the time each worker spent transforming chunks
+	WorkerBusy []time.Duration
+	// ActiveWorkers is the number of chunks in flight the operation ended
+	// with, 0 unless WithAdaptiveWorkers
+	ActiveWorkers int
+}
+
+// Throughput returns the effective input rate in MB/s
+func (s Stats) Throughput() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.BytesRead) / (1024 * 1024) / s.Duration.Seconds()
+}
+
+// Utilization returns the fraction of the wall time workers spent busy,
+// averaged over the pool
+func (s Stats) Utilization() float64 {
+	if s.Duration <= 0 || len(s.WorkerBusy) == 0 {
+		return 0
+	}
+	var busy time.Duration
+	for _, d := range s.WorkerBusy {
+		busy += d
+	}
+	return busy.Seconds() / (s.Duration.Seconds() * float64(len(s.WorkerBusy)))
+}
+
+// Result is returned by the file operations that report statistics
+type Result struct {
+	OutputPath string
+	Stats      Stats
+	// Shred reports how the source was removed WithShredSource
+	Shred *ShredReport
+	// ResumedBytes is the input an interrupted encryption had already
+	// encrypted, resumed WithJournal
+	ResumedBytes int64
+}
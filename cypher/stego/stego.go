@@ -0,0 +1,130 @@
+// Package stego hides encrypted data in the least significant bits of PNG
+// images, so it can travel through channels that block binary attachments
+// but pass pictures.
+//
+// The output of EncryptStream, prefixed with its length, is spread over the
+// low bit of the red, green and blue channels of every pixel, row by row,
+// so an image holds 3 bits per pixel. Ciphertext looks like noise, but the
+// changed bits can still be found by statistical analysis: this hides data
+// from filters, not from a determined analyst.
+package stego
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+var (
+	// ErrCapacity is returned when the data doesn't fit the image
+	ErrCapacity = errors.New("data exceeds the image capacity")
+	// ErrNoPayload is returned when an image holds no hidden data
+	ErrNoPayload = errors.New("image holds no hidden data")
+)
+
+// lengthSize is the size of the length prefix of the hidden data
+const lengthSize = 4
+
+// Capacity returns the number of bytes of ciphertext img can hold
+func Capacity(img image.Image) int {
+	b := img.Bounds()
+	return max(b.Dx()*b.Dy()*3/8-lengthSize, 0)
+}
+
+// Embed encrypts src with c and writes cover with the ciphertext hidden in
+// it to dst as a PNG. It returns ErrCapacity when the ciphertext exceeds
+// Capacity(cover), encrypted in memory first.
+func Embed(ctx context.Context, c *cypher.Cypher, dst io.Writer, cover image.Image, src io.Reader) (*cypher.Stats, error) {
+	var sealed bytes.Buffer
+	stats, err := c.EncryptStream(ctx, src, &sealed)
+	if err != nil {
+		return nil, err
+	}
+	img, err := Hide(cover, sealed.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if err := png.Encode(dst, img); err != nil {
+		return nil, fmt.Errorf("failed to write PNG: %w", err)
+	}
+	return stats, nil
+}
+
+// Extract reads a PNG written by Embed from src and decrypts the hidden
+// ciphertext with c into dst
+func Extract(ctx context.Context, c *cypher.Cypher, dst io.Writer, src io.Reader) (*cypher.Stats, error) {
+	img, err := png.Decode(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PNG: %w", err)
+	}
+	data, err := Reveal(img)
+	if err != nil {
+		return nil, err
+	}
+	return c.DecryptStream(ctx, bytes.NewReader(data), dst)
+}
+
+// Hide returns a copy of cover with data in the low bits of its pixels.
+// Encode it losslessly, as PNG, or the data is lost.
+func Hide(cover image.Image, data []byte) (*image.NRGBA, error) {
+	if n := Capacity(cover); len(data) > n {
+		return nil, fmt.Errorf("%w: %d bytes in %d", ErrCapacity, len(data), n)
+	}
+	img := image.NewNRGBA(cover.Bounds())
+	draw.Draw(img, img.Bounds(), cover, cover.Bounds().Min, draw.Src)
+
+	payload := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	payload = append(payload, data...)
+	channel := 0
+	for _, b := range payload {
+		for bit := 7; bit >= 0; bit-- {
+			i := nextChannel(img, &channel)
+			img.Pix[i] = img.Pix[i]&^1 | b>>bit&1
+		}
+	}
+	return img, nil
+}
+
+// Reveal returns the data hidden in img by Hide
+func Reveal(img image.Image) ([]byte, error) {
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok {
+		nrgba = image.NewNRGBA(img.Bounds())
+		draw.Draw(nrgba, nrgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	channel := 0
+	read := func(n int) []byte {
+		out := make([]byte, n)
+		for j := range out {
+			for bit := 0; bit < 8; bit++ {
+				out[j] = out[j]<<1 | nrgba.Pix[nextChannel(nrgba, &channel)]&1
+			}
+		}
+		return out
+	}
+	if Capacity(nrgba) == 0 {
+		return nil, ErrNoPayload
+	}
+	n := binary.BigEndian.Uint32(read(lengthSize))
+	if n == 0 || int64(n) > int64(Capacity(nrgba)) {
+		return nil, ErrNoPayload
+	}
+	return read(int(n)), nil
+}
+
+// nextChannel returns the index in img.Pix of the color channel number
+// *channel, counting red, green and blue row by row, and advances it
+func nextChannel(img *image.NRGBA, channel *int) int {
+	pixel, c := *channel/3, *channel%3
+	*channel++
+	width := img.Rect.Dx()
+	return (pixel/width)*img.Stride + (pixel%width)*4 + c
+}
@@ -0,0 +1,41 @@
+package stego
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+func TestEmbedExtract(t *testing.T) {
+	cover := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			cover.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 4), 128, 255})
+		}
+	}
+	c := cypher.NewCypher("secret")
+	var picture bytes.Buffer
+	if _, err := Embed(context.Background(), c, &picture, cover, strings.NewReader("meet at noon")); err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	var plain bytes.Buffer
+	if _, err := Extract(context.Background(), c, &plain, bytes.NewReader(picture.Bytes())); err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	if plain.String() != "meet at noon" {
+		t.Errorf("Expected %q, got %q", "meet at noon", plain.String())
+	}
+
+	if _, err := Hide(cover, make([]byte, Capacity(cover)+1)); !errors.Is(err, ErrCapacity) {
+		t.Errorf("Expected ErrCapacity, got %v", err)
+	}
+	if _, err := Reveal(image.NewNRGBA(image.Rect(0, 0, 64, 64))); !errors.Is(err, ErrNoPayload) {
+		t.Errorf("Expected ErrNoPayload, got %v", err)
+	}
+}
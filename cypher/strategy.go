@@ -0,0 +1,60 @@
+package cypher
+
+import "context"
+
+// defaultFullThreshold is the input size from which operations start all
+// NumWorkers by default
+const defaultFullThreshold = 64 * 1024 * 1024
+
+// WithExecutionThresholds picks how operations run by the size of their
+// input, when it is known. Inputs of at most direct bytes are processed on
+// the calling goroutine without any worker, those below full bytes start a
+// worker per chunk up to NumWorkers and NumCores, and larger ones all
+// NumWorkers. The defaults are one chunk and 64 MB. A negative direct makes
+// every operation use workers, and a full of 0 all NumWorkers. Streams of
+// unknown size always use NumWorkers.
+func (c *Cypher) WithExecutionThresholds(direct, full int64) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.thresholds = &executionThresholds{direct: direct, full: full}
+	return c
+}
+
+type executionThresholds struct {
+	direct, full int64
+}
+
+// strategy returns how many workers op runs on, and whether it runs on the
+// calling goroutine instead
+func (c Cypher) strategy(op *operation) (workers int, direct bool) {
+	if !op.sized {
+		return c.NumWorkers, false
+	}
+	thresholds := executionThresholds{direct: int64(c.ChunkSize), full: defaultFullThreshold}
+	if c.thresholds != nil {
+		thresholds = *c.thresholds
+	}
+	if op.total <= thresholds.direct {
+		return 1, true
+	}
+	if op.total >= thresholds.full {
+		return c.NumWorkers, false
+	}
+	chunks := (op.total + int64(c.ChunkSize) - 1) / int64(c.ChunkSize)
+	return int(max(min(int64(c.NumWorkers), int64(c.NumCores), chunks), 1)), false
+}
+
+// transformDirect transforms and writes chunk on the calling goroutine, for
+// operations too small for workers. It returns false when the operation
+// failed.
+func (r *run) transformDirect(ctx context.Context, chunk DataChunk, sink chan DataChunk, write func(DataChunk) error) bool {
+	if !r.transform(ctx, 0, chunk, sink) {
+		return false
+	}
+	if err := write(<-sink); err != nil {
+		r.fail(ErrorKindWrite, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package cypher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// EncryptFileTo encrypts inputPath once and writes the same ciphertext to
+// every output concurrently, such as a local file and an object store
+// upload, instead of encrypting it once per copy. The first output to fail
+// fails the operation; the others may then be incomplete.
+func (c Cypher) EncryptFileTo(ctx context.Context, inputPath string, outputs ...io.Writer) (*Stats, error) {
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("no output to encrypt %s to", inputPath)
+	}
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer inputFile.Close()
+
+	tee := newTeeWriter(outputs)
+	defer tee.close()
+	op := c.encryptOperation()
+	op.inputPath = inputPath
+	op.attributes = map[string]any{"gocypher.input": inputPath, "gocypher.outputs": len(outputs)}
+	stats, err := c.processHandles(ctx, op, inputFile, tee)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// teeWriter writes every buffer to all its outputs at once, each from its
+// own goroutine, and returns once they all wrote it
+type teeWriter struct {
+	writes  []chan []byte
+	results chan teeResult
+}
+
+type teeResult struct {
+	output int
+	err    error
+}
+
+func newTeeWriter(outputs []io.Writer) *teeWriter {
+	t := &teeWriter{writes: make([]chan []byte, len(outputs)), results: make(chan teeResult, len(outputs))}
+	for i, output := range outputs {
+		t.writes[i] = make(chan []byte)
+		go func() {
+			for p := range t.writes[i] {
+				_, err := output.Write(p)
+				t.results <- teeResult{output: i, err: err}
+			}
+		}()
+	}
+	return t
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	for _, write := range t.writes {
+		write <- p
+	}
+	// p is only returned once no output still reads it
+	var err error
+	for range t.writes {
+		if result := <-t.results; result.err != nil && err == nil {
+			err = fmt.Errorf("failed to write output %d: %w", result.output, result.err)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// close stops the goroutines of the outputs
+func (t *teeWriter) close() {
+	for _, write := range t.writes {
+		close(write)
+	}
+}
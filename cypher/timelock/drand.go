@@ -0,0 +1,82 @@
+package timelock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drand/tlock"
+)
+
+var (
+	// ErrNoNetwork is returned when decrypting data locked to a drand round
+	// without a network
+	ErrNoNetwork = errors.New("drand lock needs a network")
+	// ErrWrongNetwork is returned when decrypting data locked to another
+	// drand network
+	ErrWrongNetwork = errors.New("wrong drand network")
+)
+
+type drandLock struct {
+	network tlock.Network
+	round   uint64
+}
+
+// Drand returns a Lock opened by the first round network publishes at or
+// after at, such as a network of github.com/drand/tlock/networks/http
+func Drand(network tlock.Network, at time.Time) Lock {
+	return DrandRound(network, network.Current(at))
+}
+
+// DrandRound returns a Lock opened by round of network
+func DrandRound(network tlock.Network, round uint64) Lock {
+	return drandLock{network: network, round: round}
+}
+
+func (l drandLock) lock(fileKey []byte) (byte, []byte, error) {
+	chainHash := l.network.ChainHash()
+	if len(chainHash) > 255 {
+		return 0, nil, fmt.Errorf("chain hash of %d bytes", len(chainHash))
+	}
+	body := binary.BigEndian.AppendUint64(nil, l.round)
+	body = append(body, byte(len(chainHash)))
+	buf := bytes.NewBuffer(append(body, chainHash...))
+	if err := tlock.New(l.network).Strict().Encrypt(buf, bytes.NewReader(fileKey), l.round); err != nil {
+		return 0, nil, err
+	}
+	return typeDrand, buf.Bytes(), nil
+}
+
+// drandRound returns the round and chain hash of the drand lock in body
+func drandRound(body []byte) (uint64, string, error) {
+	if len(body) < 9 || len(body) < 9+int(body[8]) {
+		return 0, "", fmt.Errorf("%w: short drand lock", ErrInvalidHeader)
+	}
+	return binary.BigEndian.Uint64(body), string(body[9 : 9+int(body[8])]), nil
+}
+
+// unlockDrand fetches the round of the drand lock in body from network and
+// opens the file key
+func unlockDrand(network tlock.Network, body []byte) ([]byte, error) {
+	round, chainHash, err := drandRound(body)
+	if err != nil {
+		return nil, err
+	}
+	if network == nil {
+		return nil, ErrNoNetwork
+	}
+	if network.ChainHash() != chainHash {
+		return nil, fmt.Errorf("%w: locked to chain %s, not %s", ErrWrongNetwork, chainHash, network.ChainHash())
+	}
+	var fileKey bytes.Buffer
+	err = tlock.New(network).Strict().Decrypt(&fileKey, bytes.NewReader(body[9+len(chainHash):]))
+	if errors.Is(err, tlock.ErrTooEarly) {
+		return nil, fmt.Errorf("%w: round %d isn't published yet", ErrTooEarly, round)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock the file key: %w", err)
+	}
+	return fileKey.Bytes(), nil
+}
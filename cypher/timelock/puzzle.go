@@ -0,0 +1,178 @@
+package timelock
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// A puzzle is a modulus n = p*q, a base a and a number of squarings t. The
+// writer knows the factors of n and computes a^(2^t) mod n with a single
+// exponentiation modulo phi(n); without them, it takes t squarings one
+// after the other, which can't be spread over more cores. The file key is
+// sealed with a key derived from the result.
+
+const (
+	modulusBits = 2048
+	// checkInterval is the number of squarings between checks of the context
+	checkInterval = 1 << 14
+)
+
+type puzzle struct {
+	squarings uint64
+	n, a      *big.Int
+	sealed    []byte
+}
+
+type puzzleLock struct {
+	squarings uint64
+}
+
+// Puzzle returns a Lock that takes squarings sequential squarings modulo a
+// 2048-bit number to open
+func Puzzle(squarings uint64) Lock {
+	return puzzleLock{squarings: squarings}
+}
+
+// PuzzleFor returns a Puzzle taking about delay to open on a machine as
+// fast as this one, see Calibrate. The delay is an estimate: faster
+// hardware opens it sooner, so leave a margin for embargoes.
+func PuzzleFor(delay time.Duration) Lock {
+	return Puzzle(uint64(delay.Seconds() * float64(Calibrate())))
+}
+
+// Calibrate measures the number of puzzle squarings this machine does per
+// second
+func Calibrate() uint64 {
+	n, _, err := newModulus()
+	if err != nil {
+		return 0
+	}
+	x := big.NewInt(3)
+	start := time.Now()
+	count := uint64(0)
+	for time.Since(start) < 100*time.Millisecond {
+		for i := 0; i < 1000; i++ {
+			x.Mul(x, x).Mod(x, n)
+		}
+		count += 1000
+	}
+	return uint64(float64(count) / time.Since(start).Seconds())
+}
+
+// newModulus returns a random modulus and its totient
+func newModulus() (n, phi *big.Int, err error) {
+	p, err := rand.Prime(rand.Reader, modulusBits/2)
+	if err != nil {
+		return nil, nil, err
+	}
+	q, err := rand.Prime(rand.Reader, modulusBits/2)
+	if err != nil {
+		return nil, nil, err
+	}
+	one := big.NewInt(1)
+	n = new(big.Int).Mul(p, q)
+	phi = new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+	return n, phi, nil
+}
+
+func (l puzzleLock) lock(fileKey []byte) (byte, []byte, error) {
+	if l.squarings == 0 {
+		return 0, nil, errors.New("a puzzle needs at least one squaring")
+	}
+	n, phi, err := newModulus()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to generate modulus: %w", err)
+	}
+	a, err := rand.Int(rand.Reader, new(big.Int).Sub(n, big.NewInt(3)))
+	if err != nil {
+		return 0, nil, err
+	}
+	a.Add(a, big.NewInt(2))
+
+	e := new(big.Int).Exp(big.NewInt(2), new(big.Int).SetUint64(l.squarings), phi)
+	p := &puzzle{squarings: l.squarings, n: n, a: a}
+	body := p.params()
+	aead, err := p.aead(new(big.Int).Exp(a, e, n))
+	if err != nil {
+		return 0, nil, err
+	}
+	return typePuzzle, aead.Seal(body, make([]byte, aead.NonceSize()), fileKey, body), nil
+}
+
+// params encodes the squarings, modulus and base of p
+func (p *puzzle) params() []byte {
+	size := (p.n.BitLen() + 7) / 8
+	body := binary.BigEndian.AppendUint64(nil, p.squarings)
+	body = binary.BigEndian.AppendUint16(body, uint16(size))
+	body = append(body, p.n.FillBytes(make([]byte, size))...)
+	return append(body, p.a.FillBytes(make([]byte, size))...)
+}
+
+// aead returns the AEAD sealing the file key under the solution
+func (p *puzzle) aead(solution *big.Int) (cipher.AEAD, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	secret := solution.FillBytes(make([]byte, (p.n.BitLen()+7)/8))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte("gocypher timelock puzzle")), key); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
+
+func parsePuzzle(body []byte) (*puzzle, error) {
+	if len(body) < 10 {
+		return nil, fmt.Errorf("%w: short puzzle", ErrInvalidHeader)
+	}
+	size := int(binary.BigEndian.Uint16(body[8:]))
+	if size == 0 || len(body) < 10+2*size {
+		return nil, fmt.Errorf("%w: short puzzle", ErrInvalidHeader)
+	}
+	p := &puzzle{
+		squarings: binary.BigEndian.Uint64(body),
+		n:         new(big.Int).SetBytes(body[10 : 10+size]),
+		a:         new(big.Int).SetBytes(body[10+size : 10+2*size]),
+		sealed:    body[10+2*size:],
+	}
+	if p.n.Sign() == 0 {
+		return nil, fmt.Errorf("%w: zero modulus", ErrInvalidHeader)
+	}
+	return p, nil
+}
+
+// solvePuzzle does the squarings of the puzzle in body and opens the file
+// key
+func solvePuzzle(ctx context.Context, body []byte) ([]byte, error) {
+	p, err := parsePuzzle(body)
+	if err != nil {
+		return nil, err
+	}
+	x := new(big.Int).Set(p.a)
+	for i := uint64(0); i < p.squarings; i++ {
+		if i%checkInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		x.Mul(x, x).Mod(x, p.n)
+	}
+	aead, err := p.aead(x)
+	if err != nil {
+		return nil, err
+	}
+	params := body[:len(body)-len(p.sealed)]
+	fileKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), p.sealed, params)
+	if err != nil {
+		return nil, fmt.Errorf("%w: puzzle solution doesn't open the file key", ErrInvalidHeader)
+	}
+	return fileKey, nil
+}
@@ -0,0 +1,148 @@
+// Package timelock encrypts data that can only be decrypted after a chosen
+// time, for embargoed releases and dead man's switches.
+//
+// A random file key encrypts the data in the gocypher format and is locked
+// in a header, either in a Rivest-Shamir-Wagner time-lock puzzle, which
+// takes a number of sequential squarings to open, or to a future round of a
+// drand randomness beacon with tlock, opened once the beacon publishes the
+// round. Nobody, including the writer, holds a key to decrypt earlier.
+package timelock
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/drand/tlock"
+	"github.com/nikola43/gocypher/cypher"
+	"github.com/nikola43/gocypher/cypher/internal/envelope"
+)
+
+var (
+	// ErrInvalidHeader is returned for data without a valid time-lock
+	// header
+	ErrInvalidHeader = errors.New("invalid time-lock header")
+	// ErrTooEarly is returned when decrypting data locked to a drand round
+	// that hasn't been published yet
+	ErrTooEarly = errors.New("too early to decrypt")
+)
+
+const magic = "GOCYTLK1"
+
+// envelopeLabel derives the keys of the header MAC and the data, see envelope
+const envelopeLabel = "gocypher timelock"
+
+// Lock types of the header
+const (
+	typePuzzle byte = 1
+	typeDrand  byte = 2
+)
+
+// Lock locks the file key of the data until some time, see Puzzle and Drand
+type Lock interface {
+	lock(fileKey []byte) (typ byte, body []byte, err error)
+}
+
+// Info describes the lock of encrypted data, see Inspect
+type Info struct {
+	// Squarings is the work a puzzle takes to open, 0 for drand locks
+	Squarings uint64
+	// Round and ChainHash are the drand round and network of drand locks
+	Round     uint64
+	ChainHash string
+}
+
+// Encrypt encrypts src into dst under lock. opts configure the Cypher
+// encrypting the data.
+func Encrypt(ctx context.Context, dst io.Writer, src io.Reader, lock Lock, opts ...cypher.Option) (*cypher.Stats, error) {
+	fileKey, err := cypher.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	typ, body, err := lock.lock(fileKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock the file key: %w", err)
+	}
+
+	header := bytes.NewBufferString(magic)
+	header.WriteByte(typ)
+	header.Write(binary.BigEndian.AppendUint32(nil, uint32(len(body))))
+	header.Write(body)
+	return envelope.Encrypt(ctx, dst, src, envelopeLabel, fileKey, header.Bytes(), opts...)
+}
+
+// Decrypt decrypts src, encrypted by Encrypt, into dst. Puzzles are solved
+// first, which takes as long as set when encrypting and can be cancelled
+// with ctx. Drand locks fetch their round from network, which may be nil
+// for puzzles.
+func Decrypt(ctx context.Context, dst io.Writer, src io.Reader, network tlock.Network, opts ...cypher.Option) (*cypher.Stats, error) {
+	var header bytes.Buffer
+	typ, body, err := readLock(io.TeeReader(src, &header))
+	if err != nil {
+		return nil, err
+	}
+
+	var fileKey []byte
+	switch typ {
+	case typePuzzle:
+		fileKey, err = solvePuzzle(ctx, body)
+	case typeDrand:
+		fileKey, err = unlockDrand(network, body)
+	default:
+		err = fmt.Errorf("%w: unknown lock type %d", ErrInvalidHeader, typ)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := envelope.Decrypt(ctx, dst, src, envelopeLabel, fileKey, header.Bytes(), opts...)
+	if errors.Is(err, envelope.ErrInvalidMAC) {
+		return nil, ErrInvalidHeader
+	}
+	return stats, err
+}
+
+// Inspect reads the header of src and describes its lock without opening it
+func Inspect(src io.Reader) (*Info, error) {
+	typ, body, err := readLock(src)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case typePuzzle:
+		p, err := parsePuzzle(body)
+		if err != nil {
+			return nil, err
+		}
+		return &Info{Squarings: p.squarings}, nil
+	case typeDrand:
+		round, chainHash, err := drandRound(body)
+		if err != nil {
+			return nil, err
+		}
+		return &Info{Round: round, ChainHash: chainHash}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown lock type %d", ErrInvalidHeader, typ)
+}
+
+// maxLockSize bounds the lock read from a header
+const maxLockSize = 1 << 16
+
+func readLock(r io.Reader) (byte, []byte, error) {
+	prefix := make([]byte, len(magic)+5)
+	if _, err := io.ReadFull(r, prefix); err != nil || string(prefix[:len(magic)]) != magic {
+		return 0, nil, ErrInvalidHeader
+	}
+	size := binary.BigEndian.Uint32(prefix[len(magic)+1:])
+	if size > maxLockSize {
+		return 0, nil, fmt.Errorf("%w: lock of %d bytes", ErrInvalidHeader, size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, ErrInvalidHeader
+	}
+	return prefix[len(magic)], body, nil
+}
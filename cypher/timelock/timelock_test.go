@@ -0,0 +1,100 @@
+package timelock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	chain "github.com/drand/drand/v2/common"
+	"github.com/drand/drand/v2/crypto"
+	"github.com/drand/kyber"
+	"github.com/drand/kyber/util/random"
+)
+
+// fakeNetwork is a drand network whose rounds up to published are out
+type fakeNetwork struct {
+	scheme    *crypto.Scheme
+	secret    kyber.Scalar
+	published uint64
+}
+
+func newFakeNetwork(published uint64) *fakeNetwork {
+	scheme := crypto.NewPedersenBLSUnchainedG1()
+	return &fakeNetwork{scheme: scheme, secret: scheme.KeyGroup.Scalar().Pick(random.New()), published: published}
+}
+
+func (n *fakeNetwork) ChainHash() string            { return "fake" }
+func (n *fakeNetwork) Current(t time.Time) uint64   { return uint64(t.Unix()) }
+func (n *fakeNetwork) Scheme() crypto.Scheme        { return *n.scheme }
+func (n *fakeNetwork) SwitchChainHash(string) error { return errors.New("fixed chain") }
+func (n *fakeNetwork) PublicKey() kyber.Point       { return n.scheme.KeyGroup.Point().Mul(n.secret, nil) }
+func (n *fakeNetwork) Signature(round uint64) ([]byte, error) {
+	if round > n.published {
+		return nil, fmt.Errorf("round %d not published", round)
+	}
+	return n.scheme.AuthScheme.Sign(n.secret, n.scheme.DigestBeacon(&chain.Beacon{Round: round}))
+}
+
+func TestPuzzle(t *testing.T) {
+	ctx := context.Background()
+	var encrypted bytes.Buffer
+	if _, err := Encrypt(ctx, &encrypted, strings.NewReader("embargoed"), Puzzle(1000)); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	info, err := Inspect(bytes.NewReader(encrypted.Bytes()))
+	if err != nil || info.Squarings != 1000 {
+		t.Errorf("Expected 1000 squarings, got %+v: %v", info, err)
+	}
+
+	var decrypted bytes.Buffer
+	if _, err := Decrypt(ctx, &decrypted, bytes.NewReader(encrypted.Bytes()), nil); err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if decrypted.String() != "embargoed" {
+		t.Errorf("Expected %q, got %q", "embargoed", decrypted.String())
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := Decrypt(cancelled, &decrypted, bytes.NewReader(encrypted.Bytes()), nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	tampered := bytes.Clone(encrypted.Bytes())
+	tampered[len(magic)+5+7]++ // fewer squarings
+	if _, err := Decrypt(ctx, &decrypted, bytes.NewReader(tampered), nil); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Expected ErrInvalidHeader, got %v", err)
+	}
+}
+
+func TestDrand(t *testing.T) {
+	ctx := context.Background()
+	network := newFakeNetwork(100)
+	var encrypted bytes.Buffer
+	if _, err := Encrypt(ctx, &encrypted, strings.NewReader("embargoed"), DrandRound(network, 200)); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	info, err := Inspect(bytes.NewReader(encrypted.Bytes()))
+	if err != nil || info.Round != 200 || info.ChainHash != "fake" {
+		t.Errorf("Expected round 200 of fake, got %+v: %v", info, err)
+	}
+
+	var decrypted bytes.Buffer
+	if _, err := Decrypt(ctx, &decrypted, bytes.NewReader(encrypted.Bytes()), network); !errors.Is(err, ErrTooEarly) {
+		t.Errorf("Expected ErrTooEarly, got %v", err)
+	}
+	if _, err := Decrypt(ctx, &decrypted, bytes.NewReader(encrypted.Bytes()), nil); !errors.Is(err, ErrNoNetwork) {
+		t.Errorf("Expected ErrNoNetwork, got %v", err)
+	}
+	network.published = 200
+	if _, err := Decrypt(ctx, &decrypted, bytes.NewReader(encrypted.Bytes()), network); err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if decrypted.String() != "embargoed" {
+		t.Errorf("Expected %q, got %q", "embargoed", decrypted.String())
+	}
+}
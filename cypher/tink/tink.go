@@ -0,0 +1,223 @@
+// Package tink reads and writes Google Tink JSON keysets holding AES-GCM
+// keys, so key material can be shared with deployments using Tink.
+//
+// Only cleartext keysets are supported; keysets encrypted with a KMS must be
+// decrypted with Tink first. Keys of other types are rejected.
+package tink
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+const (
+	// TypeURL is the type of Tink AES-GCM keys
+	TypeURL = "type.googleapis.com/google.crypto.tink.AesGcmKey"
+
+	symmetric = "SYMMETRIC"
+)
+
+// Key statuses
+const (
+	StatusEnabled   = "ENABLED"
+	StatusDisabled  = "DISABLED"
+	StatusDestroyed = "DESTROYED"
+)
+
+// Output prefix types, which set how Tink prefixes its ciphertexts
+const (
+	PrefixTink   = "TINK"
+	PrefixLegacy = "LEGACY"
+	PrefixRaw    = "RAW"
+)
+
+var (
+	// ErrUnsupportedKey is returned for keys that aren't AES-GCM
+	ErrUnsupportedKey = errors.New("unsupported Tink key")
+	// ErrEncryptedKeyset is returned for keysets encrypted with a KMS
+	ErrEncryptedKeyset = errors.New("encrypted Tink keysets are not supported")
+	// ErrNoPrimary is returned when the primary key is missing or not
+	// enabled
+	ErrNoPrimary = errors.New("keyset has no enabled primary key")
+)
+
+// Key is an AES-GCM key of a keyset
+type Key struct {
+	ID     uint32
+	Value  []byte
+	Status string
+	// OutputPrefix is kept for Tink, gocypher output has its own header
+	OutputPrefix string
+}
+
+// Cypher returns a Cypher using the key, which must be 256 bits
+func (k *Key) Cypher(opts ...cypher.Option) (*cypher.Cypher, error) {
+	return cypher.NewCypherFromKey(k.Value, opts...)
+}
+
+// Keyset is a set of keys, one of them the primary key used for encryption
+type Keyset struct {
+	PrimaryID uint32
+	Keys      []Key
+}
+
+// NewKeyset returns a keyset with key, such as one returned by
+// cypher.GenerateKey, as its primary key
+func NewKeyset(key []byte) (*Keyset, error) {
+	if len(key) != 16 && len(key) != 32 {
+		return nil, fmt.Errorf("%w: AES-GCM keys are 16 or 32 bytes", ErrUnsupportedKey)
+	}
+	var id [4]byte
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate key id: %w", err)
+	}
+	primaryID := binary.BigEndian.Uint32(id[:])
+	return &Keyset{PrimaryID: primaryID, Keys: []Key{{
+		ID:           primaryID,
+		Value:        append([]byte(nil), key...),
+		Status:       StatusEnabled,
+		OutputPrefix: PrefixTink,
+	}}}, nil
+}
+
+// Primary returns the primary key
+func (k *Keyset) Primary() (*Key, error) {
+	for i := range k.Keys {
+		if k.Keys[i].ID == k.PrimaryID && k.Keys[i].Status == StatusEnabled {
+			return &k.Keys[i], nil
+		}
+	}
+	return nil, ErrNoPrimary
+}
+
+// Enabled returns the enabled keys, for decrypting data sealed by any of
+// them
+func (k *Keyset) Enabled() []Key {
+	var keys []Key
+	for _, key := range k.Keys {
+		if key.Status == StatusEnabled {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+type jsonKeyset struct {
+	PrimaryKeyID    uint32    `json:"primaryKeyId"`
+	Key             []jsonKey `json:"key"`
+	EncryptedKeyset string    `json:"encryptedKeyset,omitempty"`
+}
+
+type jsonKey struct {
+	KeyData          jsonKeyData `json:"keyData"`
+	Status           string      `json:"status"`
+	KeyID            uint32      `json:"keyId"`
+	OutputPrefixType string      `json:"outputPrefixType"`
+}
+
+type jsonKeyData struct {
+	TypeURL         string `json:"typeUrl"`
+	Value           []byte `json:"value"`
+	KeyMaterialType string `json:"keyMaterialType"`
+}
+
+// Read reads a cleartext JSON keyset. Destroyed keys are kept without their
+// value.
+func Read(r io.Reader) (*Keyset, error) {
+	var keyset jsonKeyset
+	if err := json.NewDecoder(r).Decode(&keyset); err != nil {
+		return nil, fmt.Errorf("failed to decode keyset: %w", err)
+	}
+	if keyset.EncryptedKeyset != "" {
+		return nil, ErrEncryptedKeyset
+	}
+	k := &Keyset{PrimaryID: keyset.PrimaryKeyID}
+	for _, key := range keyset.Key {
+		parsed := Key{ID: key.KeyID, Status: key.Status, OutputPrefix: key.OutputPrefixType}
+		if key.Status != StatusDestroyed {
+			if key.KeyData.TypeURL != TypeURL {
+				return nil, fmt.Errorf("%w: key %d is a %s", ErrUnsupportedKey, key.KeyID, key.KeyData.TypeURL)
+			}
+			value, err := unmarshalAESGCMKey(key.KeyData.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: key %d: %w", ErrUnsupportedKey, key.KeyID, err)
+			}
+			parsed.Value = value
+		}
+		k.Keys = append(k.Keys, parsed)
+	}
+	if _, err := k.Primary(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Write writes the keyset as cleartext JSON, which Tink reads with
+// insecurecleartextkeyset. The output holds the keys unencrypted.
+func (k *Keyset) Write(w io.Writer) error {
+	keyset := jsonKeyset{PrimaryKeyID: k.PrimaryID, Key: []jsonKey{}}
+	for _, key := range k.Keys {
+		encoded := jsonKey{Status: key.Status, KeyID: key.ID, OutputPrefixType: key.OutputPrefix}
+		if key.Status != StatusDestroyed {
+			encoded.KeyData = jsonKeyData{TypeURL: TypeURL, Value: marshalAESGCMKey(key.Value), KeyMaterialType: symmetric}
+		}
+		keyset.Key = append(keyset.Key, encoded)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(keyset)
+}
+
+// The AesGcmKey protocol buffer is {uint32 version = 1; bytes key_value = 3}
+
+const (
+	versionTag = 1 << 3
+	valueTag   = 3<<3 | 2
+)
+
+func marshalAESGCMKey(key []byte) []byte {
+	data := []byte{valueTag}
+	data = binary.AppendUvarint(data, uint64(len(key)))
+	return append(data, key...)
+}
+
+func unmarshalAESGCMKey(data []byte) ([]byte, error) {
+	var key []byte
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errors.New("malformed key")
+		}
+		data = data[n:]
+		switch tag {
+		case versionTag:
+			version, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errors.New("malformed key version")
+			}
+			if version != 0 {
+				return nil, fmt.Errorf("unsupported key version %d", version)
+			}
+			data = data[n:]
+		case valueTag:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return nil, errors.New("malformed key value")
+			}
+			key = append([]byte(nil), data[n:n+int(length)]...)
+			data = data[n+int(length):]
+		default:
+			return nil, fmt.Errorf("unexpected field %d", tag>>3)
+		}
+	}
+	if len(key) != 16 && len(key) != 32 {
+		return nil, fmt.Errorf("AES-GCM keys are 16 or 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
@@ -0,0 +1,69 @@
+package tink
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+// A keyset written by Tink with an enabled primary and a disabled key
+const tinkKeyset = `{"primaryKeyId":3912862879,"key":[{"keyData":{"typeUrl":"type.googleapis.com/google.crypto.tink.AesGcmKey","value":"GiDhytFEkMJKH8p3i1NdgLqaB6RtXX/Ix8pEYehmGpylQg==","keyMaterialType":"SYMMETRIC"},"status":"ENABLED","keyId":3912862879,"outputPrefixType":"TINK"},{"keyData":{"typeUrl":"type.googleapis.com/google.crypto.tink.AesGcmKey","value":"GiDf2BRagQVSPmTjQBYS8vOpGLBZj5BG2dxiJqkJxh394g==","keyMaterialType":"SYMMETRIC"},"status":"DISABLED","keyId":4163883369,"outputPrefixType":"TINK"}]}`
+
+func TestReadWrite(t *testing.T) {
+	keyset, err := Read(strings.NewReader(tinkKeyset))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	primary, err := keyset.Primary()
+	if err != nil || primary.ID != 3912862879 || len(primary.Value) != 32 {
+		t.Fatalf("Unexpected primary %+v: %v", primary, err)
+	}
+	if enabled := keyset.Enabled(); len(enabled) != 1 {
+		t.Errorf("Expected 1 enabled key, got %d", len(enabled))
+	}
+
+	var out bytes.Buffer
+	if err := keyset.Write(&out); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	again, err := Read(&out)
+	if err != nil || len(again.Keys) != 2 || !bytes.Equal(again.Keys[1].Value, keyset.Keys[1].Value) || again.Keys[1].Status != StatusDisabled {
+		t.Errorf("Expected the keyset back, got %+v: %v", again, err)
+	}
+
+	_, err = Read(strings.NewReader(strings.ReplaceAll(tinkKeyset, "AesGcmKey", "AesSivKey")))
+	if !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("Expected ErrUnsupportedKey, got %v", err)
+	}
+	_, err = Read(strings.NewReader(`{"encryptedKeyset":"AAAA","keysetInfo":{}}`))
+	if !errors.Is(err, ErrEncryptedKeyset) {
+		t.Errorf("Expected ErrEncryptedKeyset, got %v", err)
+	}
+}
+
+func TestNewKeyset(t *testing.T) {
+	key, _ := cypher.GenerateKey()
+	keyset, err := NewKeyset(key)
+	if err != nil {
+		t.Fatalf("NewKeyset failed: %v", err)
+	}
+	var out bytes.Buffer
+	keyset.Write(&out)
+	imported, err := Read(&out)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	primary, _ := imported.Primary()
+	c, err := primary.Cypher()
+	if err != nil {
+		t.Fatalf("Cypher failed: %v", err)
+	}
+	sealed, _ := c.Encrypt([]byte("shared"))
+	original, _ := cypher.NewCypherFromKey(key)
+	if plain, err := original.Decrypt(sealed); err != nil || string(plain) != "shared" {
+		t.Errorf("Expected the same key, got %q: %v", plain, err)
+	}
+}
@@ -0,0 +1,44 @@
+package cypher
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer starts spans around pipeline operations; see the otelcypher package
+// for an OpenTelemetry backed implementation.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation
+type Span interface {
+	SetAttributes(attrs map[string]any)
+	AddEvent(name string, attrs map[string]any)
+	// End finishes the span, err is nil when the operation succeeded
+	End(err error)
+}
+
+// defaultSlowChunkThreshold is how long a chunk may take before it is
+// reported as a span event
+const defaultSlowChunkThreshold = time.Second
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(map[string]any)    {}
+func (noopSpan) AddEvent(string, map[string]any) {}
+func (noopSpan) End(error)                       {}
+
+func (c Cypher) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if c.tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return c.tracer.Start(ctx, name)
+}
+
+func (c Cypher) slowChunk() time.Duration {
+	if c.slowChunkThreshold <= 0 {
+		return defaultSlowChunkThreshold
+	}
+	return c.slowChunkThreshold
+}
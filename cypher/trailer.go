@@ -0,0 +1,112 @@
+package cypher
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// streamTrailerSize is the size of the trailer frame of data with
+// RecordTrailer: its length prefix, the chunk count, the size of the chunk
+// frames and their MAC
+const streamTrailerSize = FrameLengthSize + 8 + 8 + sha256.Size
+
+// streamTrailerKey returns the key of the MAC of the stream trailer of h
+func (c Cypher) streamTrailerKey(h *header) ([]byte, error) {
+	return c.deriveSecret(h.salt, "gocypher v1 stream trailer")
+}
+
+// streamTrailerMAC returns the MAC of a trailer after chunks frames of size
+// bytes following the header whose hash is aad
+func streamTrailerMAC(key, aad []byte, chunks, size uint64) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(aad)
+	mac.Write(binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, chunks), size))
+	return mac.Sum(nil)
+}
+
+// encodeStreamTrailer returns the trailer frame ending chunks frames of size
+// bytes
+func encodeStreamTrailer(key, aad []byte, chunks int, size int64) []byte {
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, streamTrailerSize), trailerFlag|(streamTrailerSize-FrameLengthSize))
+	frame = binary.BigEndian.AppendUint64(frame, uint64(chunks))
+	frame = binary.BigEndian.AppendUint64(frame, uint64(size))
+	return append(frame, streamTrailerMAC(key, aad, uint64(chunks), uint64(size))...)
+}
+
+// checkStreamTrailer checks frame is the trailer frame ending chunks frames
+// of size bytes. Anything without its length prefix is taken for the end of
+// data cut before it.
+func checkStreamTrailer(frame, key, aad []byte, chunks int, size int64) error {
+	if !isStreamTrailer(frame) {
+		return fmt.Errorf("%w: no trailer", ErrTruncated)
+	}
+	count, total := binary.BigEndian.Uint64(frame[FrameLengthSize:]), binary.BigEndian.Uint64(frame[FrameLengthSize+8:])
+	if !Equal(frame[FrameLengthSize+16:], streamTrailerMAC(key, aad, count, total)) {
+		return fmt.Errorf("%w: bad trailer", ErrChunksModified)
+	}
+	if count != uint64(chunks) || total != uint64(size) {
+		return fmt.Errorf("%w: %d of %d chunks", ErrTruncated, chunks, count)
+	}
+	return nil
+}
+
+// isStreamTrailer reports whether frame has the size and length prefix of a
+// stream trailer
+func isStreamTrailer(frame []byte) bool {
+	return len(frame) == streamTrailerSize && binary.BigEndian.Uint32(frame) == trailerFlag|(streamTrailerSize-FrameLengthSize)
+}
+
+// isTrailer reports whether prefix is the length prefix of a trailer frame
+func isTrailer(prefix []byte) bool {
+	return binary.BigEndian.Uint32(prefix)&trailerFlag != 0
+}
+
+// streamFrames reads length prefixed frames of at most maxSize bytes up to
+// the stream trailer, which must count the frames before it. It returns
+// ErrTruncated when the data ends before the trailer; with last set, nothing
+// may follow the trailer either.
+func streamFrames(maxSize int, buffers *bufferPool, key, aad []byte, last bool) frameReader {
+	// The frames are read by one goroutine, which reuses prefix
+	prefix := make([]byte, FrameLengthSize)
+	chunks, size, done := 0, int64(0), false
+	return func(src io.Reader) ([]byte, int, error) {
+		if done {
+			return nil, 0, io.EOF
+		}
+		if _, err := io.ReadFull(src, prefix); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, 0, ErrTruncated
+			}
+			return nil, 0, err
+		}
+
+		if !isTrailer(prefix) {
+			frame, consumed, err := frameBody(src, prefix, maxSize, buffers)
+			if err != nil {
+				return nil, 0, err
+			}
+			chunks++
+			size += int64(consumed)
+			return frame, consumed, nil
+		}
+
+		frame := append(make([]byte, 0, streamTrailerSize), prefix...)
+		frame = frame[:streamTrailerSize]
+		if _, err := io.ReadFull(src, frame[FrameLengthSize:]); err != nil {
+			return nil, 0, ErrTruncated
+		}
+		if err := checkStreamTrailer(frame, key, aad, chunks, size); err != nil {
+			return nil, 0, err
+		}
+		if last {
+			if _, err := io.ReadFull(src, make([]byte, 1)); err != io.EOF {
+				return nil, 0, fmt.Errorf("%w: data after the trailer", ErrChunksModified)
+			}
+		}
+		done = true
+		return nil, 0, io.EOF
+	}
+}
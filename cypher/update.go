@@ -0,0 +1,217 @@
+package cypher
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// UpdateResult reports what UpdateEncryptedFile did
+type UpdateResult struct {
+	// Chunks is the number of chunks of the updated file, Rewritten those
+	// sealed again because they changed or were appended
+	Chunks    int
+	Rewritten int
+	// InPlace is false when the file had to be encrypted again as a whole
+	InPlace      bool
+	BytesWritten int64
+}
+
+// UpdateEncryptedFile makes the encrypted file at encPath hold the content of
+// newPlainPath. Each chunk is compared with the matching chunk of the new
+// content and only chunks that changed, were appended or removed are
+// written, so small edits to large files don't re-encrypt everything.
+//
+// The file is modified in place and keeps its header. Sparse files, files
+// written with compression, content defined chunking, delta friendly output
+// or without a trailer, or with another chunk size or generation than c, are
+// encrypted again as a whole. Files without a header, such as plaintext or
+// the legacy format, and files encrypted with another key fail before
+// anything is written.
+// An interrupted in-place update can leave a mix of old and new chunks.
+func (c Cypher) UpdateEncryptedFile(encPath, newPlainPath string) (*UpdateResult, error) {
+	return c.UpdateEncryptedFileContext(context.Background(), encPath, newPlainPath)
+}
+
+// UpdateEncryptedFileContext is like UpdateEncryptedFile but stops when ctx
+// is done
+func (c Cypher) UpdateEncryptedFileContext(ctx context.Context, encPath, newPlainPath string) (*UpdateResult, error) {
+	c.markUsed()
+	plain, err := os.Open(newPlainPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer plain.Close()
+
+	enc, err := os.OpenFile(encPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open encrypted file: %w", err)
+	}
+	defer enc.Close()
+
+	op, h, err := c.prepareUpdate(enc)
+	if err != nil {
+		return nil, err
+	}
+	if op == nil {
+		enc.Close()
+		return c.reencrypt(ctx, encPath, newPlainPath)
+	}
+
+	info, err := enc.Stat()
+	if err != nil {
+		return nil, err
+	}
+	frameSize := int64(FrameLengthSize + op.gcm.NonceSize() + c.ChunkSize + op.gcm.Overhead())
+	headerSize := int64(len(h.raw))
+	oldChunks := int(chunkCount(info.Size()-headerSize-streamTrailerSize, frameSize))
+
+	result := &UpdateResult{InPlace: true}
+	offset := headerSize
+	chunk := make([]byte, c.ChunkSize)
+	defer wipe(chunk)
+	for position := 0; ; position++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		n, err := io.ReadFull(plain, chunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("failed to read input: %w", err)
+		}
+		data := chunk[:n]
+
+		changed := true
+		if position < oldChunks {
+			if changed, err = chunkChanged(enc, op, offset, position, data); err != nil {
+				return nil, err
+			}
+		}
+
+		frameLen := int64(FrameLengthSize + op.gcm.NonceSize() + n + op.gcm.Overhead())
+		if changed {
+			frame, err := sealFrame(op, op.chunkAAD(position), data)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := enc.WriteAt(frame, offset); err != nil {
+				return nil, fmt.Errorf("failed to write chunk: %w", err)
+			}
+			result.Rewritten++
+			result.BytesWritten += frameLen
+		}
+		offset += frameLen
+		result.Chunks++
+	}
+
+	trailer := op.streamTrailer(result.Chunks, offset-headerSize)
+	if _, err := enc.WriteAt(trailer, offset); err != nil {
+		return nil, fmt.Errorf("failed to write trailer: %w", err)
+	}
+	offset += int64(len(trailer))
+	result.BytesWritten += int64(len(trailer))
+
+	if err := enc.Truncate(offset); err != nil {
+		return nil, fmt.Errorf("failed to truncate encrypted file: %w", err)
+	}
+	if err := enc.Sync(); err != nil {
+		return nil, err
+	}
+	c.log().Info("encrypted file updated", "path", encPath, "chunks", result.Chunks, "rewritten", result.Rewritten)
+	return result, nil
+}
+
+// prepareUpdate reads the header of enc and sets up sealing chunks under it.
+// It returns a nil operation when the file can't be updated in place, only
+// once the header showed it is data encrypted with the key of c: anything
+// else fails rather than being replaced.
+func (c Cypher) prepareUpdate(enc *os.File) (*operation, *header, error) {
+	magic := make([]byte, len(FormatMagic))
+	if _, err := io.ReadFull(enc, magic); err != nil || string(magic) != FormatMagic {
+		return nil, nil, fmt.Errorf("%w: can't update data without a header", ErrInvalidHeader)
+	}
+	h, err := readHeader(enc, c.parseMode)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.checkGeneration(h.generation); err != nil {
+		return nil, nil, err
+	}
+	if err := c.checkExpiry(h.expiry); err != nil {
+		return nil, nil, err
+	}
+	gcm, err := c.fileGCM(h)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.compression != CompressionNone || c.chunking != nil || c.deltaFriendly {
+		return nil, nil, nil
+	}
+	if h.compression != CompressionNone || h.deterministic || !h.trailer || len(h.holes) > 0 || h.chunkSize != uint32(c.ChunkSize) ||
+		h.generation != c.generation || (h.counter != nil) != (c.nonceCounter != nil) || h.expiry != c.expiryUnix() {
+		return nil, nil, nil
+	}
+
+	op := &operation{gcm: gcm, aad: headerAAD(h), random: c.random()}
+	if op.usage, err = c.usage(); err != nil {
+		return nil, nil, err
+	}
+	if c.nonceCounter != nil {
+		op.nonce = c.nonceCounter.nonce
+	}
+	key, err := c.streamTrailerKey(h)
+	if err != nil {
+		return nil, nil, err
+	}
+	op.streamTrailer = func(chunks int, size int64) []byte {
+		return encodeStreamTrailer(key, op.aad, chunks, size)
+	}
+	return op, h, nil
+}
+
+// chunkChanged reports whether the chunk at offset differs from data
+func chunkChanged(enc *os.File, op *operation, offset int64, position int, data []byte) (bool, error) {
+	var prefix [FrameLengthSize]byte
+	if _, err := enc.ReadAt(prefix[:], offset); err != nil {
+		return false, fmt.Errorf("failed to read chunk: %w", err)
+	}
+	size := binary.BigEndian.Uint32(prefix[:])
+	if int(size) != op.gcm.NonceSize()+len(data)+op.gcm.Overhead() {
+		return true, nil
+	}
+
+	frame := make([]byte, size)
+	if _, err := enc.ReadAt(frame, offset+FrameLengthSize); err != nil {
+		return false, fmt.Errorf("failed to read chunk: %w", err)
+	}
+	old, err := openChunk(op, op.chunkAAD(position), frame)
+	if err != nil {
+		return false, err
+	}
+	defer wipe(old)
+	return !bytes.Equal(old, data), nil
+}
+
+// reencrypt replaces encPath with a fresh encryption of newPlainPath
+func (c Cypher) reencrypt(ctx context.Context, encPath, newPlainPath string) (*UpdateResult, error) {
+	temp := encPath + ".tmp"
+	result, err := c.EncryptFileToPath(ctx, newPlainPath, temp)
+	if err != nil {
+		os.Remove(temp)
+		return nil, err
+	}
+	if err := os.Rename(temp, encPath); err != nil {
+		os.Remove(temp)
+		return nil, fmt.Errorf("failed to replace encrypted file: %w", err)
+	}
+	return &UpdateResult{
+		Chunks:       result.Stats.Chunks,
+		Rewritten:    result.Stats.Chunks,
+		BytesWritten: result.Stats.BytesWritten,
+	}, nil
+}
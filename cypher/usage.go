@@ -0,0 +1,218 @@
+package cypher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// GCMChunkLimit is the number of chunks NIST SP 800-38D allows to seal with
+// random nonces under one AES-GCM key, a Chunks limit for keys used with
+// RawSeal or shared with other implementations
+const GCMChunkLimit = 1 << 32
+
+// Usage counted in memory before the next state is persisted
+const (
+	usageChunkBatch     = 1 << 16
+	usageByteBatch      = 1 << 30
+	usageOperationBatch = 1 << 10
+)
+
+var (
+	// ErrKeyOveruse is returned by encryptions that would take a key past a
+	// limit of its KeyUsage
+	ErrKeyOveruse = errors.New("key usage limit exceeded")
+	// ErrKeyUsageKey is returned when a key usage is used with a key other
+	// than the one it was opened for
+	ErrKeyUsageKey = errors.New("key usage belongs to another key")
+)
+
+// Usage is what a key encrypted
+type Usage struct {
+	// Bytes counts the plaintext bytes, Chunks the chunks they were sealed
+	// in and Operations the messages, such as files or RawSeal payloads
+	Bytes      uint64 `json:"bytes"`
+	Chunks     uint64 `json:"chunks"`
+	Operations uint64 `json:"operations"`
+}
+
+// UsageLimits bounds the Usage of a key, zero fields are unlimited
+type UsageLimits Usage
+
+// KeyUsage counts what a key encrypts in a file, so limits hold across
+// restarts. Counts are reserved on disk in batches before they are used;
+// counts of a batch left unused when the process exits without Close are
+// taken as used, so the persisted usage never falls behind.
+type KeyUsage struct {
+	mu          sync.Mutex
+	path        string
+	fingerprint string
+	limits      UsageLimits
+	used        Usage
+	reserved    Usage
+}
+
+type keyUsageState struct {
+	Fingerprint string `json:"fingerprint"`
+	Usage
+}
+
+// OpenKeyUsage opens the usage state at path for the key identified by
+// fingerprint (see KeyFingerprint), creating it when missing, and enforces
+// limits on it, such as UsageLimits{Chunks: GCMChunkLimit}
+func OpenKeyUsage(path, fingerprint string, limits UsageLimits) (*KeyUsage, error) {
+	u := &KeyUsage{path: path, fingerprint: fingerprint, limits: limits}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return u, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read key usage: %w", err)
+	}
+
+	var state keyUsageState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse key usage: %w", err)
+	}
+	if !EqualFingerprint(state.Fingerprint, fingerprint) {
+		return nil, ErrKeyUsageKey
+	}
+	u.used, u.reserved = state.Usage, state.Usage
+	return u, nil
+}
+
+// Usage returns what the key encrypted so far
+func (u *KeyUsage) Usage() Usage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.used
+}
+
+// Close persists the exact usage, so the unused part of the current batch
+// isn't counted
+func (u *KeyUsage) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := u.persist(u.used); err != nil {
+		return err
+	}
+	u.reserved = u.used
+	return nil
+}
+
+// add counts delta, failing with ErrKeyOveruse when it exceeds a limit
+func (u *KeyUsage) add(delta Usage) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	next := Usage{
+		Bytes:      u.used.Bytes + delta.Bytes,
+		Chunks:     u.used.Chunks + delta.Chunks,
+		Operations: u.used.Operations + delta.Operations,
+	}
+	for _, check := range []struct {
+		name         string
+		value, limit uint64
+	}{
+		{"bytes", next.Bytes, u.limits.Bytes},
+		{"chunks", next.Chunks, u.limits.Chunks},
+		{"operations", next.Operations, u.limits.Operations},
+	} {
+		if check.limit > 0 && check.value > check.limit {
+			return fmt.Errorf("%w: %d %s, the limit is %d", ErrKeyOveruse, check.value, check.name, check.limit)
+		}
+	}
+
+	if next.Bytes > u.reserved.Bytes || next.Chunks > u.reserved.Chunks || next.Operations > u.reserved.Operations {
+		reserved := Usage{
+			Bytes:      reserve(next.Bytes, u.reserved.Bytes, usageByteBatch, u.limits.Bytes),
+			Chunks:     reserve(next.Chunks, u.reserved.Chunks, usageChunkBatch, u.limits.Chunks),
+			Operations: reserve(next.Operations, u.reserved.Operations, usageOperationBatch, u.limits.Operations),
+		}
+		if err := u.persist(reserved); err != nil {
+			return err
+		}
+		u.reserved = reserved
+	}
+	u.used = next
+	return nil
+}
+
+// reserve returns the count to persist for value: reserved while it covers
+// value, otherwise a batch past value, short of limit
+func reserve(value, reserved, batch, limit uint64) uint64 {
+	if value <= reserved {
+		return reserved
+	}
+	if limit > 0 {
+		return min(value+batch, limit)
+	}
+	return value + batch
+}
+
+// persist atomically replaces the state file
+func (u *KeyUsage) persist(usage Usage) error {
+	data, err := json.Marshal(keyUsageState{Fingerprint: u.fingerprint, Usage: usage})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(u.path), ".key-usage-*")
+	if err != nil {
+		return fmt.Errorf("failed to persist key usage: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to persist key usage: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to persist key usage: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to persist key usage: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), u.path); err != nil {
+		return fmt.Errorf("failed to persist key usage: %w", err)
+	}
+	return nil
+}
+
+// WithKeyUsage makes encryption count every message, chunk and plaintext
+// byte in usage, and fail with ErrKeyOveruse once one would exceed its
+// limits, so a long-lived key can't silently pass safe bounds and gets
+// rotated instead. Sub cyphers and machine bound cyphers use other keys and
+// don't count.
+func (c *Cypher) WithKeyUsage(usage *KeyUsage) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.keyUsage = usage
+	return c
+}
+
+// usage returns the key usage of c, checking it belongs to its key
+func (c Cypher) usage() (*KeyUsage, error) {
+	if c.keyUsage == nil {
+		return nil, nil
+	}
+	if !EqualFingerprint(c.keyUsage.fingerprint, c.KeyFingerprint()) {
+		return nil, ErrKeyUsageKey
+	}
+	return c.keyUsage, nil
+}
+
+// countOperation counts a new message encrypted by c
+func (c Cypher) countOperation() error {
+	usage, err := c.usage()
+	if usage == nil {
+		return err
+	}
+	return usage.add(Usage{Operations: 1})
+}
@@ -0,0 +1,129 @@
+package cypher
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidConfig is wrapped by every ConfigError
+	ErrInvalidConfig = errors.New("invalid configuration")
+	// ErrEmptyKey is returned by NewCypherE for an empty passphrase
+	ErrEmptyKey = errors.New("key is empty")
+)
+
+// maxNumWorkers bounds NumWorkers, every worker holds chunks in flight
+const maxNumWorkers = 4096
+
+// ConfigError describes a setting Validate rejected
+type ConfigError struct {
+	Field  string
+	Value  any
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid %s %v: %s", e.Field, e.Value, e.Reason)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return ErrInvalidConfig
+}
+
+// NewCypherE is like NewCypher but rejects an empty passphrase, invalid
+// environment defaults (see EnvChunkSize) and returns the configuration
+// errors of Validate right away, instead of failing every operation later
+func NewCypherE(key string, opts ...Option) (*Cypher, error) {
+	if key == "" {
+		return nil, ErrEmptyKey
+	}
+	c := NewCypher(key, opts...)
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	if c.envErr != nil {
+		c.Close()
+		return nil, c.envErr
+	}
+	if err := c.Validate(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks the configuration of c. It returns the ConfigErrors of all
+// invalid settings joined, each of which matches ErrInvalidConfig, and
+// ErrConfiguredAfterUse when c was changed after it was used. Every operation
+// validates c before it starts.
+func (c Cypher) Validate() error {
+	var errs []error
+	invalid := func(field string, value any, reason string) {
+		errs = append(errs, &ConfigError{Field: field, Value: value, Reason: reason})
+	}
+
+	if c.ChunkSize <= 0 || c.ChunkSize > MaxChunkSize {
+		invalid("chunk size", c.ChunkSize, fmt.Sprintf("need 0 < size <= %d", MaxChunkSize))
+	}
+	if c.NumWorkers <= 0 || c.NumWorkers > maxNumWorkers {
+		invalid("number of workers", c.NumWorkers, fmt.Sprintf("need 0 < workers <= %d", maxNumWorkers))
+	}
+	if c.NumCores <= 0 {
+		invalid("number of cores", c.NumCores, "need at least 1")
+	}
+	if c.slowChunkThreshold < 0 {
+		invalid("slow chunk threshold", c.slowChunkThreshold, "must not be negative")
+	}
+	if c.spillBudget < 0 {
+		invalid("spill budget", c.spillBudget, "must not be negative")
+	}
+	for _, stages := range [][]Stage{c.encryptStages, c.decryptStages} {
+		if n := countStage(stages, CryptoStage); n > 1 {
+			invalid("stages", n, "CryptoStage can only appear once")
+		}
+	}
+	if c.maxMemory < 0 {
+		invalid("max memory", c.maxMemory, "must not be negative")
+	}
+	if c.nameTemplate != "" {
+		if _, err := compileNameTemplate(c.nameTemplate); err != nil {
+			invalid("name template", c.nameTemplate, err.Error())
+		}
+	}
+	if c.lockPolicy < LockNone || c.lockPolicy > LockFail {
+		invalid("lock policy", c.lockPolicy, "unknown policy")
+	}
+	if c.contentsKey != nil && len(c.contentsKey) != ed25519.PrivateKeySize {
+		invalid("contents key", len(c.contentsKey), fmt.Sprintf("need %d bytes", ed25519.PrivateKeySize))
+	}
+	if c.contentsPublicKey != nil && len(c.contentsPublicKey) != ed25519.PublicKeySize {
+		invalid("contents public key", len(c.contentsPublicKey), fmt.Sprintf("need %d bytes", ed25519.PublicKeySize))
+	}
+	if c.shredPasses < 0 {
+		invalid("shred passes", c.shredPasses, "must not be negative")
+	}
+	switch c.algorithm {
+	case AlgorithmAuto, AlgorithmAES256GCM, AlgorithmChaCha20Poly1305:
+	default:
+		invalid("algorithm", c.algorithm, "unknown algorithm")
+	}
+	if c.compression != CompressionNone {
+		if _, err := compressor(c.compression, c.compressionLevel); err != nil {
+			invalid("compression", c.compression, err.Error())
+		}
+	}
+	if c.chunking != nil {
+		if p := c.chunking; c.chunking.validate() != nil {
+			invalid("content defined chunking sizes", fmt.Sprintf("%d/%d/%d", p.min, p.avg, p.max),
+				fmt.Sprintf("need 0 < min < avg < max <= %d", MaxChunkSize))
+		}
+	}
+	if c.deltaFriendly && c.nonceCounter != nil {
+		invalid("delta friendly output", true, "can't be combined with a nonce counter")
+	}
+	if err := c.checkConfigured(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
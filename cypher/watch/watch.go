@@ -0,0 +1,297 @@
+// Package watch turns a Cypher into a drop-folder encryption agent: files
+// created or modified below a source directory are encrypted into a target
+// directory once they stop changing.
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/nikola43/gocypher/cypher"
+)
+
+const (
+	defaultDebounce  = 500 * time.Millisecond
+	defaultStability = time.Second
+	tempSuffix       = ".tmp"
+)
+
+// Watcher encrypts files from a source directory into a target directory
+type Watcher struct {
+	cypher *cypher.Cypher
+	srcDir string
+	dstDir string
+
+	debounce    time.Duration
+	stability   time.Duration
+	initialScan bool
+	onEncrypted func(cypher.Result)
+	onError     func(path string, err error)
+}
+
+type Option func(*Watcher)
+
+// WithDebounce sets how long to wait after the last event for a file before
+// checking it (default: 500ms)
+func WithDebounce(d time.Duration) Option {
+	return func(w *Watcher) { w.debounce = d }
+}
+
+// WithStability sets how long a file's size and modification time must stay
+// unchanged before it is encrypted (default: 1s)
+func WithStability(d time.Duration) Option {
+	return func(w *Watcher) { w.stability = d }
+}
+
+// WithInitialScan encrypts files already present in the source directory
+// whose target is missing or older
+func WithInitialScan() Option {
+	return func(w *Watcher) { w.initialScan = true }
+}
+
+// OnEncrypted is called after each file has been encrypted
+func OnEncrypted(fn func(cypher.Result)) Option {
+	return func(w *Watcher) { w.onEncrypted = fn }
+}
+
+// OnError is called when a file can't be encrypted; the watcher keeps running
+func OnError(fn func(path string, err error)) Option {
+	return func(w *Watcher) { w.onError = fn }
+}
+
+func New(c *cypher.Cypher, srcDir, dstDir string, opts ...Option) *Watcher {
+	w := &Watcher{
+		cypher:    c,
+		srcDir:    srcDir,
+		dstDir:    dstDir,
+		debounce:  defaultDebounce,
+		stability: defaultStability,
+	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w
+}
+
+// Run watches until ctx is done
+func (w *Watcher) Run(ctx context.Context) error {
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer notify.Close()
+
+	if err := os.MkdirAll(w.dstDir, 0755); err != nil {
+		return fmt.Errorf("failed to create target directory: %w", err)
+	}
+	if err := w.addTree(notify, w.srcDir); err != nil {
+		return err
+	}
+
+	ready := make(chan string)
+	timers := make(map[string]*time.Timer)
+	defer func() {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+	}()
+
+	// schedule (re)starts the debounce timer of path
+	schedule := func(path string, delay time.Duration) {
+		if timer, ok := timers[path]; ok {
+			timer.Stop()
+		}
+		timers[path] = time.AfterFunc(delay, func() {
+			select {
+			case ready <- path:
+			case <-ctx.Done():
+			}
+		})
+	}
+
+	if w.initialScan {
+		for _, path := range w.pending() {
+			schedule(path, 0)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-notify.Events:
+			if !ok {
+				return nil
+			}
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			if w.isTarget(event.Name) {
+				continue
+			}
+
+			info, err := os.Stat(event.Name)
+			if err != nil {
+				continue
+			}
+			if info.IsDir() {
+				if err := w.addTree(notify, event.Name); err != nil {
+					w.reportError(event.Name, err)
+				}
+				// Files may have landed before the watch was in place
+				for _, path := range w.pending() {
+					schedule(path, w.debounce)
+				}
+				continue
+			}
+			schedule(event.Name, w.debounce)
+
+		case err, ok := <-notify.Errors:
+			if !ok {
+				return nil
+			}
+			w.reportError(w.srcDir, err)
+
+		case path := <-ready:
+			delete(timers, path)
+			stable, err := w.isStable(ctx, path)
+			if err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					w.reportError(path, err)
+				}
+				continue
+			}
+			if !stable {
+				schedule(path, w.debounce)
+				continue
+			}
+			w.encrypt(ctx, path)
+		}
+	}
+}
+
+// addTree watches root and every directory below it
+func (w *Watcher) addTree(notify *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if err := notify.Add(path); err != nil {
+				return fmt.Errorf("failed to watch %s: %w", path, err)
+			}
+		}
+		return nil
+	})
+}
+
+// pending lists source files whose target is missing or older
+func (w *Watcher) pending() []string {
+	var paths []string
+	filepath.WalkDir(w.srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || w.isTarget(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		target, err := w.target(path)
+		if err != nil {
+			return nil
+		}
+		if targetInfo, err := os.Stat(target); err == nil && !targetInfo.ModTime().Before(info.ModTime()) {
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	return paths
+}
+
+// isStable reports whether path keeps its size and modification time for the
+// stability interval
+func (w *Watcher) isStable(ctx context.Context, path string) (bool, error) {
+	before, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+
+	select {
+	case <-time.After(w.stability):
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return before.Size() == after.Size() && before.ModTime().Equal(after.ModTime()), nil
+}
+
+// encrypt writes path's ciphertext to a temporary file and renames it into
+// place, so consumers of the target directory never see partial output
+func (w *Watcher) encrypt(ctx context.Context, path string) {
+	target, err := w.target(path)
+	if err != nil {
+		w.reportError(path, err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		w.reportError(path, fmt.Errorf("failed to create target directory: %w", err))
+		return
+	}
+
+	temp := target + tempSuffix
+	result, err := w.cypher.EncryptFileToPath(ctx, path, temp)
+	if err != nil {
+		os.Remove(temp)
+		w.reportError(path, err)
+		return
+	}
+	if err := os.Rename(temp, target); err != nil {
+		os.Remove(temp)
+		w.reportError(path, fmt.Errorf("failed to move encrypted file into place: %w", err))
+		return
+	}
+
+	result.OutputPath = target
+	if w.onEncrypted != nil {
+		w.onEncrypted(*result)
+	}
+}
+
+func (w *Watcher) target(path string) (string, error) {
+	rel, err := filepath.Rel(w.srcDir, path)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s is outside the watched directory", path)
+	}
+	return filepath.Join(w.dstDir, rel+".encrypted"), nil
+}
+
+// isTarget reports whether path lies in the target directory, which may be
+// nested inside the source directory
+func (w *Watcher) isTarget(path string) bool {
+	rel, err := filepath.Rel(w.dstDir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (w *Watcher) reportError(path string, err error) {
+	if w.onError != nil {
+		w.onError(path, err)
+	}
+}
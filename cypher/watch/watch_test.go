@@ -0,0 +1,73 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher"
+)
+
+func TestWatcher(t *testing.T) {
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	c := cypher.NewCypher("test-key")
+
+	if err := os.WriteFile(filepath.Join(srcDir, "existing.txt"), []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted := make(chan cypher.Result, 10)
+	w := New(c, srcDir, dstDir,
+		WithDebounce(10*time.Millisecond),
+		WithStability(10*time.Millisecond),
+		WithInitialScan(),
+		OnEncrypted(func(r cypher.Result) { encrypted <- r }),
+		OnError(func(path string, err error) { t.Errorf("Watcher error for %s: %v", path, err) }),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor := func(name string, want []byte) {
+		t.Helper()
+		select {
+		case r := <-encrypted:
+			if r.OutputPath != filepath.Join(dstDir, name+".encrypted") {
+				t.Fatalf("Unexpected output path %s", r.OutputPath)
+			}
+			data, err := os.ReadFile(r.OutputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := c.Decrypt(data)
+			if err != nil {
+				t.Fatalf("Decryption failed: %v", err)
+			}
+			if !bytes.Equal(decrypted, want) {
+				t.Errorf("Decrypted %s doesn't match input", name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", name)
+		}
+	}
+
+	waitFor("existing.txt", []byte("existing"))
+
+	// Give the watcher a moment to establish its watches
+	time.Sleep(50 * time.Millisecond)
+	if err := os.MkdirAll(filepath.Join(srcDir, "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "nested", "new.txt"), []byte("new file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(filepath.Join("nested", "new.txt"), []byte("new file"))
+}
@@ -0,0 +1,111 @@
+package cypher
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrWorkerPoolClosed is returned by operations submitting chunks to a
+// closed WorkerPool
+var ErrWorkerPoolClosed = errors.New("worker pool is closed")
+
+// WorkerPool is a fixed set of goroutines transforming the chunks of every
+// operation of the Cyphers using it, see WithWorkerPool. Servers running
+// many small operations reuse its goroutines instead of starting NumWorkers
+// of them per call.
+type WorkerPool struct {
+	tasks  chan func()
+	closed chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// NewWorkerPool starts a pool of size goroutines, stopped by Close
+func NewWorkerPool(size int) *WorkerPool {
+	p := &WorkerPool{tasks: make(chan func()), closed: make(chan struct{})}
+	for i := 0; i < max(size, 1); i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for {
+				select {
+				case task := <-p.tasks:
+					task()
+				case <-p.closed:
+					return
+				}
+			}
+		}()
+	}
+	return p
+}
+
+// Close stops the goroutines of p once their current chunks are done.
+// Operations still running fail with ErrWorkerPoolClosed.
+func (p *WorkerPool) Close() {
+	p.once.Do(func() { close(p.closed) })
+	p.wg.Wait()
+}
+
+// submit runs task on a goroutine of p, waiting for one to be free
+func (p *WorkerPool) submit(ctx context.Context, task func()) error {
+	select {
+	case p.tasks <- task:
+		return nil
+	case <-p.closed:
+		return ErrWorkerPoolClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WithWorkerPool makes operations transform their chunks on the goroutines
+// of pool, shared with other Cyphers, instead of their own. NumWorkers still
+// bounds the chunks each operation has in flight. NUMA pinning doesn't apply
+// to the shared goroutines.
+func (c *Cypher) WithWorkerPool(pool *WorkerPool) *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.pool = pool
+	return c
+}
+
+// pooledWorkers transforms the chunks received from input on the
+// goroutines of c.pool, at most NumWorkers at a time
+func (c Cypher) pooledWorkers(ctx context.Context, wg *sync.WaitGroup, r *run, input <-chan DataChunk, output chan<- DataChunk) {
+	defer wg.Done()
+	// ids hands out the busy slots of r, one per chunk in flight
+	ids := make(chan int, c.NumWorkers)
+	for i := 0; i < c.NumWorkers; i++ {
+		ids <- i
+	}
+	var tasks sync.WaitGroup
+	defer tasks.Wait()
+	for chunk := range input {
+		var id int
+		select {
+		case id = <-ids:
+		case <-ctx.Done():
+			r.discard(chunk)
+			continue
+		}
+		tasks.Add(1)
+		err := c.pool.submit(ctx, func() {
+			defer tasks.Done()
+			defer func() { ids <- id }()
+			defer r.recoverStage()
+			r.transform(ctx, id, chunk, output)
+		})
+		if err != nil {
+			tasks.Done()
+			ids <- id
+			r.discard(chunk)
+			// Cancellation is reported by the operation once it stopped
+			if ctx.Err() == nil {
+				r.fail(ErrorKindCanceled, err)
+			}
+		}
+	}
+}
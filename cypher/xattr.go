@@ -0,0 +1,112 @@
+package cypher
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// errXattrsUnsupported is returned restoring extended attributes where the
+// platform has none
+var errXattrsUnsupported = errors.New("extended attributes are not supported on this platform")
+
+// WithXattrs records the extended attributes of the plaintext file in the
+// header of EncryptFile and EncryptDir output, sealed, and restores them on
+// the output of DecryptFile and DecryptDir. On Linux they include POSIX ACLs
+// and SELinux labels, on macOS resource forks and Finder information. They
+// share the 64 KB of a header record, larger ones fail the encryption.
+// Restoring some, such as security.* on Linux, needs privileges.
+func (c *Cypher) WithXattrs() *Cypher {
+	if !c.configure() {
+		return c
+	}
+	c.xattrs = true
+	return c
+}
+
+// xattr is an extended attribute of a file
+type xattr struct {
+	name  string
+	value []byte
+}
+
+func encodeXattrs(attrs []xattr) ([]byte, error) {
+	var buf []byte
+	for _, attr := range attrs {
+		if len(attr.name) == 0 || len(attr.name) > math.MaxUint8 || len(attr.value) > math.MaxUint16 {
+			return nil, fmt.Errorf("extended attribute %q too large", attr.name)
+		}
+		buf = append(buf, byte(len(attr.name)))
+		buf = append(buf, attr.name...)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(attr.value)))
+		buf = append(buf, attr.value...)
+	}
+	return buf, nil
+}
+
+func decodeXattrs(buf []byte) ([]xattr, error) {
+	var attrs []xattr
+	for len(buf) > 0 {
+		n := int(buf[0])
+		if n == 0 || len(buf) < 1+n+2 {
+			return nil, fmt.Errorf("%w: bad extended attributes record", ErrInvalidHeader)
+		}
+		name := string(buf[1 : 1+n])
+		buf = buf[1+n:]
+		size := int(binary.BigEndian.Uint16(buf))
+		if len(buf) < 2+size {
+			return nil, fmt.Errorf("%w: bad extended attributes record", ErrInvalidHeader)
+		}
+		attrs = append(attrs, xattr{name: name, value: buf[2 : 2+size]})
+		buf = buf[2+size:]
+	}
+	return attrs, nil
+}
+
+// sealXattrs records the extended attributes of path in h
+func (c Cypher) sealXattrs(h *header, path string) error {
+	attrs, err := listXattrs(path)
+	if err != nil {
+		return fmt.Errorf("failed to read extended attributes: %w", err)
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	value, err := encodeXattrs(attrs)
+	if err != nil {
+		return err
+	}
+	// The sealed record takes a nonce and a tag more
+	if len(value) > math.MaxUint16-64 {
+		return fmt.Errorf("extended attributes of %s take %d bytes, more than a header record holds", path, len(value))
+	}
+	h.xattrs, err = c.sealRecord(h, RecordXattrs, value)
+	return err
+}
+
+// openXattrs returns the extended attributes recorded in h
+func (c Cypher) openXattrs(h *header) ([]xattr, error) {
+	if h.xattrs == nil {
+		return nil, nil
+	}
+	value, err := c.openRecord(h, RecordXattrs, h.xattrs)
+	if err != nil {
+		return nil, err
+	}
+	return decodeXattrs(value)
+}
+
+// restoreXattrs sets the extended attributes op decrypted on path
+func (c Cypher) restoreXattrs(op operation, path string) error {
+	if op.xattrs == nil || len(*op.xattrs) == 0 {
+		return nil
+	}
+	var errs []error
+	for _, attr := range *op.xattrs {
+		if err := setXattr(path, attr); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore extended attribute %s: %w", attr.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package cypher
+
+func listXattrs(path string) ([]xattr, error) {
+	return nil, nil
+}
+
+func setXattr(path string, attr xattr) error {
+	return errXattrsUnsupported
+}
@@ -0,0 +1,54 @@
+//go:build linux
+
+package cypher
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestXattrs(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "plain.txt")
+	os.WriteFile(input, []byte("attributed"), 0644)
+	if err := unix.Setxattr(input, "user.origin", []byte("scanner"), 0); err != nil {
+		t.Skipf("Extended attributes not supported here: %v", err)
+	}
+
+	c := NewCypher("test-key", WithXattrs())
+	encrypted, err := c.EncryptFile(input)
+	if err != nil {
+		t.Fatalf("EncryptFile failed: %v", err)
+	}
+	if attrs, _ := listXattrs(*encrypted); len(attrs) != 0 {
+		t.Errorf("Expected the output without attributes, got %v", attrs)
+	}
+	sealed, _ := os.ReadFile(*encrypted)
+	if bytes.Contains(sealed, []byte("scanner")) {
+		t.Error("Expected the attributes sealed")
+	}
+
+	output := filepath.Join(dir, "restored.txt")
+	if _, err := c.DecryptFileToPath(context.Background(), *encrypted, output); err != nil {
+		t.Fatalf("DecryptFileToPath failed: %v", err)
+	}
+	value := make([]byte, 64)
+	n, err := unix.Getxattr(output, "user.origin", value)
+	if err != nil || string(value[:n]) != "scanner" {
+		t.Errorf("Expected user.origin restored, got %q, %v", value[:n], err)
+	}
+
+	// Without the option they are left out
+	plain := filepath.Join(dir, "plain.out")
+	if _, err := NewCypher("test-key").DecryptFileToPath(context.Background(), *encrypted, plain); err != nil {
+		t.Fatalf("DecryptFileToPath failed: %v", err)
+	}
+	if attrs, _ := listXattrs(plain); len(attrs) != 0 {
+		t.Errorf("Expected no attributes restored, got %v", attrs)
+	}
+}
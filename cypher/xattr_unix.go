@@ -0,0 +1,64 @@
+//go:build linux || darwin
+
+package cypher
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// listXattrs returns the extended attributes of path
+func listXattrs(path string) ([]xattr, error) {
+	names, err := readXattr(func(dest []byte) (int, error) { return unix.Listxattr(path, dest) })
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var attrs []xattr
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := readXattr(func(dest []byte) (int, error) { return unix.Getxattr(path, string(name), dest) })
+		if errors.Is(err, unix.ENODATA) {
+			// Removed since it was listed
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, xattr{name: string(name), value: value})
+	}
+	return attrs, nil
+}
+
+// readXattr calls read with a buffer of the size it reports, again while
+// the attribute grows in between
+func readXattr(read func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size)
+		n, err := read(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+func setXattr(path string, attr xattr) error {
+	return unix.Setxattr(path, attr.name, attr.value, 0)
+}
@@ -0,0 +1,20 @@
+// Package zapcypher plugs the encrypted log writer of the logs package into
+// zap.
+package zapcypher
+
+import (
+	"github.com/nikola43/gocypher/cypher/logs"
+	"go.uber.org/zap/zapcore"
+)
+
+// WriteSyncer returns w as a zapcore.WriteSyncer. zap writes every entry in
+// a single call, so each entry becomes one encrypted record.
+func WriteSyncer(w *logs.Writer) zapcore.WriteSyncer {
+	return w
+}
+
+// NewCore returns a core encoding entries of at least level with enc and
+// writing them to w
+func NewCore(w *logs.Writer, enc zapcore.Encoder, level zapcore.LevelEnabler) zapcore.Core {
+	return zapcore.NewCore(enc, WriteSyncer(w), level)
+}
@@ -0,0 +1,34 @@
+package zapcypher
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nikola43/gocypher/cypher"
+	"github.com/nikola43/gocypher/cypher/logs"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestCore(t *testing.T) {
+	c := cypher.NewCypher("test-key")
+	var file bytes.Buffer
+	w, err := logs.NewWriter(c, &file)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	logger := zap.New(NewCore(w, zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.InfoLevel))
+	logger.Info("hello", zap.Int("n", 1))
+	logger.Debug("hidden")
+	logger.Sync()
+
+	var plain bytes.Buffer
+	if err := logs.Decrypt(c, &file, &plain); err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(plain.String()), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"msg":"hello"`) {
+		t.Errorf("Unexpected records %q", lines)
+	}
+}
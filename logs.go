@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nikola43/gocypher/cypher"
+	"github.com/nikola43/gocypher/cypher/logs"
+)
+
+func cmdLogs(args []string) error {
+	if len(args) == 0 || args[0] != "cat" {
+		return errors.New(`usage: gocypher logs cat [flags] <file>...`)
+	}
+
+	fs := flag.NewFlagSet("logs cat", flag.ExitOnError)
+	var keys keyFlags
+	keys.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gocypher logs cat [flags] <file>...")
+		fmt.Fprintln(fs.Output(), "Decrypts encrypted log files to stdout, - reads stdin.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := keys.cypher()
+	if err != nil {
+		return err
+	}
+	for _, path := range fs.Args() {
+		if err := catLog(c, path); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func catLog(c *cypher.Cypher, path string) error {
+	if path == "-" {
+		return logs.Decrypt(c, os.Stdin, os.Stdout)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return logs.Decrypt(c, file, os.Stdout)
+}
@@ -1,19 +1,69 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"runtime"
-	"time"
+	"os"
 
 	"github.com/nikola43/gocypher/cypher"
 )
 
+const usage = `Usage: gocypher <command> [flags]
+
+Commands:
+  encrypt   encrypt a file
+  decrypt   decrypt a file
+  keygen    generate a random key file
+  serve     run the encryption daemon
+  logs cat  decrypt encrypted log files
+  csv       encrypt or decrypt columns of CSV and TSV files
+  sign      write minisign signatures of files
+  verify    verify a minisign signature
+  demo      run the encryption round-trip demo (default)
+
+Run "gocypher <command> -h" for the flags of a command.
+`
+
 func main() {
-	// Set GOMAXPROCS to the maximum number of CPUs
-	maxCPUs := runtime.NumCPU()
-	runtime.GOMAXPROCS(maxCPUs)
+	if len(os.Args) < 2 {
+		runDemo()
+		return
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "encrypt":
+		err = cmdEncrypt(os.Args[2:])
+	case "decrypt":
+		err = cmdDecrypt(os.Args[2:])
+	case "keygen":
+		err = cmdKeygen(os.Args[2:])
+	case "serve":
+		err = cmdServe(os.Args[2:])
+	case "logs":
+		err = cmdLogs(os.Args[2:])
+	case "csv":
+		err = cmdCSV(os.Args[2:])
+	case "sign":
+		err = cmdSign(os.Args[2:])
+	case "verify":
+		err = cmdVerify(os.Args[2:])
+	case "demo":
+		runDemo()
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
 
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runDemo() {
 	// Encrypt and decrypt data
 	c := cypher.NewCypher("my-secret-key")
 	fmt.Printf("Cypher: %v\n", c)
@@ -21,13 +71,13 @@ func main() {
 	// Encrypt data
 	encrypted, err := c.Encrypt([]byte("your data"))
 	if err != nil {
-		panic(err)
+		log.Fatalf("Encryption failed: %v", err)
 	}
 
 	// Decrypt data
 	decrypted, err := c.Decrypt(encrypted)
 	if err != nil {
-		panic(err)
+		log.Fatalf("Decryption failed: %v", err)
 	}
 
 	if string(decrypted) == "your data" {
@@ -39,32 +89,30 @@ func main() {
 	inputFile := "./data/file.txt"
 
 	// Get original file hash
-	inputHash, err := cypher.MD5HashFromFile(inputFile)
+	inputHash, err := MD5Hash(inputFile)
 	if err != nil {
 		log.Fatalf("Failed to get input file hash: %v", err)
 	}
 	fmt.Printf("Input file hash: %s\n", inputHash)
 
 	// Encrypt the file
-	startTime := time.Now()
-	encryptedFilepath, err := c.EncryptFile(inputFile)
+	encryptedFile, err := c.EncryptFileWithStats(context.Background(), inputFile)
 	if err != nil {
 		log.Fatalf("Encryption failed: %v", err)
 	}
 
-	fmt.Printf("Encryption completed in %v\n", time.Since(startTime))
+	fmt.Printf("Encryption completed in %v (%.2f MB/s)\n", encryptedFile.Stats.Duration, encryptedFile.Stats.Throughput())
 
-	// Decrypt the file
-	startTime = time.Now()
-	decryptedFilepath, err := c.DecryptFile(*encryptedFilepath)
+	// Decrypt the file, next to the original instead of over it
+	decryptedFile, err := c.DecryptFileToPath(context.Background(), encryptedFile.OutputPath, inputFile+".decrypted")
 	if err != nil {
 		log.Fatalf("Decryption failed: %v", err)
 	}
 
-	fmt.Printf("Decryption completed in %v\n", time.Since(startTime))
+	fmt.Printf("Decryption completed in %v (%.2f MB/s)\n", decryptedFile.Stats.Duration, decryptedFile.Stats.Throughput())
 
 	// Verify the decrypted file matches the original
-	decryptedHash, err := cypher.MD5HashFromFile(*decryptedFilepath)
+	decryptedHash, err := MD5Hash(decryptedFile.OutputPath)
 	if err != nil {
 		log.Fatalf("Failed to get decrypted file hash: %v", err)
 	}
@@ -10,10 +10,6 @@ import (
 	"testing"
 )
 
-const (
-	testDataDir = "data"
-)
-
 // TestSetup represents the test files and cleanup function
 type TestSetup struct {
 	InputFile      string
@@ -27,16 +23,13 @@ type TestSetup struct {
 
 // setupTest creates test files and returns cleanup function
 func setupTest(t *testing.T, size int) (*TestSetup, error) {
-	// Create test directory if it doesn't exist
-	if err := os.MkdirAll(testDataDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create test directory: %w", err)
-	}
-
-	// Create unique test file names
+	// Every setup gets its own directory, so setups of the same size don't
+	// share files
+	dir := t.TempDir()
 	setup := &TestSetup{
-		InputFile:     filepath.Join(testDataDir, fmt.Sprintf("test_input_%d.txt", size)),
-		EncryptedFile: filepath.Join(testDataDir, fmt.Sprintf("test_encrypted_%d.bin", size)),
-		DecryptedFile: filepath.Join(testDataDir, fmt.Sprintf("test_decrypted_%d.txt", size)),
+		InputFile:     filepath.Join(dir, fmt.Sprintf("test_input_%d.txt", size)),
+		EncryptedFile: filepath.Join(dir, fmt.Sprintf("test_encrypted_%d.bin", size)),
+		DecryptedFile: filepath.Join(dir, fmt.Sprintf("test_decrypted_%d.txt", size)),
 	}
 
 	// Generate test data
@@ -306,4 +299,4 @@ func TestEmptyFile(t *testing.T) {
 	if info.Size() != 0 {
 		t.Errorf("Expected decrypted file size to be 0, got %d", info.Size())
 	}
-}
\ No newline at end of file
+}
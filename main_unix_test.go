@@ -0,0 +1,56 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestKeyFromFDAndEnv(t *testing.T) {
+	key, _ := GenerateKey()
+	encoded := fmt.Sprintf("%x\n", key)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString(encoded)
+	w.Close()
+	// readKeyFD closes the descriptor it is given, r must not close it again
+	fd, err := syscall.Dup(int(r.Fd()))
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromFD, err := (&keyFlags{keyFD: fd}).cypher()
+	if err != nil {
+		t.Fatalf("Reading the key from a file descriptor failed: %v", err)
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte(encoded), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(keyFileEnv, keyFile)
+	fromEnv, err := (&keyFlags{keyFD: -1}).cypher()
+	if err != nil {
+		t.Fatalf("Reading the key file from %s failed: %v", keyFileEnv, err)
+	}
+
+	encrypted, err := fromFD.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	if decrypted, err := fromEnv.Decrypt(encrypted); err != nil || !bytes.Equal(decrypted, []byte("secret")) {
+		t.Errorf("Expected both sources to yield the same key, got error %v", err)
+	}
+
+	if _, err := (&keyFlags{keyFD: 3, passphrase: "p"}).cypher(); err == nil {
+		t.Error("Expected error combining -key-fd and -passphrase, got nil")
+	}
+}
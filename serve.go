@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher/daemon"
+	"github.com/nikola43/gocypher/cypher/keystore"
+	"github.com/nikola43/gocypher/cypher/scheduler"
+)
+
+func cmdServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	socket := fs.String("socket", "gocypher.sock", "Unix socket to listen on")
+	maxJobs := fs.Int("max-jobs", 2, "maximum number of concurrent jobs")
+	maxMemory := fs.Int64("max-memory", 1<<30, "memory budget for chunk buffers of running jobs, in bytes")
+	expiredDecrypt := fs.String("expired-decrypt", "warn", "decrypting with keys outside their validity window: allow, warn or deny")
+	fs.Parse(args)
+
+	policies := map[string]keystore.DecryptPolicy{"allow": keystore.DecryptAllow, "warn": keystore.DecryptWarn, "deny": keystore.DecryptDeny}
+	policy, ok := policies[*expiredDecrypt]
+	if !ok {
+		return fmt.Errorf("invalid -expired-decrypt %q", *expiredDecrypt)
+	}
+
+	// The API takes arbitrary paths and keys without authentication, so it is
+	// only served on a socket restricted to the current user
+	listener, err := daemon.ListenUnix(*socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	// Jobs always run with a Cypher from the keystore
+	s := scheduler.New(nil, scheduler.WithMaxJobs(*maxJobs), scheduler.WithMaxMemory(*maxMemory))
+	defer s.Close()
+
+	server := &http.Server{Handler: daemon.New(keystore.New(keystore.WithDecryptPolicy(policy), keystore.WithLogger(slog.Default())), s)}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("gocypher daemon listening on %s", listener.Addr())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nikola43/gocypher/cypher/minisign"
+	"golang.org/x/term"
+)
+
+const signatureExtension = ".minisig"
+
+func cmdSign(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	generate := fs.Bool("G", false, "generate a new key pair instead of signing")
+	secretPath := fs.String("s", "minisign.key", "secret key file")
+	publicPath := fs.String("p", "minisign.pub", "public key file created by -G")
+	unencrypted := fs.Bool("W", false, "don't encrypt the secret key created by -G")
+	comment := fs.String("t", "", "trusted comment (default: timestamp and file name)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gocypher sign [flags] <file>...")
+		fmt.Fprintln(fs.Output(), "       gocypher sign -G [-W] [-s key] [-p key.pub]")
+		fmt.Fprintln(fs.Output(), "Writes minisign signatures next to the files, verifiable with minisign -V.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *generate {
+		return generateSigningKey(*secretPath, *publicPath, *unencrypted)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(*secretPath)
+	if err != nil {
+		return err
+	}
+	key, err := minisign.ParsePrivateKey(data, nil)
+	if errors.Is(err, minisign.ErrPassphraseRequired) {
+		var passphrase []byte
+		if passphrase, err = readPassphrase("Enter passphrase for " + *secretPath + ": "); err != nil {
+			return err
+		}
+		key, err = minisign.ParsePrivateKey(data, passphrase)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", *secretPath, err)
+	}
+
+	for _, path := range fs.Args() {
+		trusted := *comment
+		if trusted == "" {
+			trusted = fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), filepath.Base(path))
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		signature, err := minisign.Sign(key, file, trusted)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := os.WriteFile(path+signatureExtension, signature, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("%s -> %s\n", path, path+signatureExtension)
+	}
+	return nil
+}
+
+func generateSigningKey(secretPath, publicPath string, unencrypted bool) error {
+	public, private, err := minisign.GenerateKey()
+	if err != nil {
+		return err
+	}
+	var passphrase []byte
+	if !unencrypted {
+		if passphrase, err = readPassphrase("Enter a passphrase for the new key: "); err != nil {
+			return err
+		}
+	}
+	secret, err := private.MarshalSecret(passphrase)
+	if err != nil {
+		return err
+	}
+	text, _ := public.MarshalText()
+	if err := writeNewFile(secretPath, secret, 0600); err != nil {
+		return err
+	}
+	if err := writeNewFile(publicPath, text, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Public key: %s\n", public)
+	return nil
+}
+
+func cmdVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	publicPath := fs.String("p", "minisign.pub", "public key file")
+	publicKey := fs.String("P", "", "public key, instead of -p")
+	signaturePath := fs.String("x", "", "signature file (default: <file>"+signatureExtension+")")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gocypher verify [flags] <file>")
+		fmt.Fprintln(fs.Output(), "Verifies a minisign signature and prints its trusted comment.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	text := []byte(*publicKey)
+	if *publicKey == "" {
+		var err error
+		if text, err = os.ReadFile(*publicPath); err != nil {
+			return err
+		}
+	}
+	key, err := minisign.ParsePublicKey(text)
+	if err != nil {
+		return err
+	}
+	path := fs.Arg(0)
+	if *signaturePath == "" {
+		*signaturePath = path + signatureExtension
+	}
+	signature, err := os.ReadFile(*signaturePath)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	trusted, err := minisign.Verify(key, file, signature)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	fmt.Printf("Signature and comment signature verified\nTrusted comment: %s\n", trusted)
+	return nil
+}
+
+// readPassphrase asks for a passphrase on the terminal
+func readPassphrase(prompt string) ([]byte, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, errors.New("a terminal is required to enter the passphrase")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return passphrase, err
+}
+
+// writeNewFile writes data to path, which must not exist
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
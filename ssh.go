@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nikola43/gocypher/cypher"
+	"github.com/nikola43/gocypher/cypher/sshkeys"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// listFlag collects the values of a repeatable flag
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// runSSHCommand encrypts input to the public keys in files, or decrypts it
+// with the private keys in files
+func runSSHCommand(name, input, output string, files []string) error {
+	in, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var stats *cypher.Stats
+	if name == "encrypt" {
+		var recipients []*sshkeys.Recipient
+		for _, path := range files {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			keys, err := sshkeys.ParseRecipients(file)
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			recipients = append(recipients, keys...)
+		}
+		stats, err = sshkeys.Encrypt(context.Background(), out, in, recipients)
+	} else {
+		var identities []*sshkeys.Identity
+		for _, path := range files {
+			id, err := readIdentity(path)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			identities = append(identities, id)
+		}
+		stats, err = sshkeys.Decrypt(context.Background(), out, in, identities)
+	}
+	if err != nil {
+		out.Close()
+		os.Remove(output)
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	fmt.Printf("%s -> %s (%v, %.2f MB/s)\n", input, output, stats.Duration, stats.Throughput())
+	return nil
+}
+
+// readIdentity reads an SSH private key, asking for its passphrase on the
+// terminal when it is encrypted
+func readIdentity(path string) (*sshkeys.Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	id, err := sshkeys.ParseIdentity(data)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return id, err
+	}
+	fmt.Fprintf(os.Stderr, "Enter passphrase for %s: ", path)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	return sshkeys.ParseIdentityWithPassphrase(data, passphrase)
+}